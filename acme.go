@@ -0,0 +1,417 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// renew the certificate when it has less than this left
+const acmeRenewBefore = 30 * 24 * time.Hour
+
+// acmeManager gets and renews a certificate from an ACME CA like Let's Encrypt using the
+// http-01 challenge, see RFC 8555. The account key and certificate are kept in the data directory.
+type acmeManager struct {
+	host      string
+	email     string
+	directory string
+
+	key   *ecdsa.PrivateKey
+	kid   string
+	nonce string
+	urls  struct {
+		NewNonce   string `json:"newNonce"`
+		NewAccount string `json:"newAccount"`
+		NewOrder   string `json:"newOrder"`
+	}
+
+	cert       *tls.Certificate
+	challenges map[string]string // token to key authorization
+	mutex      sync.RWMutex
+}
+
+func newACMEManager(host, email, directory string) *acmeManager {
+	return &acmeManager{
+		host:       host,
+		email:      email,
+		directory:  directory,
+		challenges: make(map[string]string),
+	}
+}
+
+func (m *acmeManager) dir() string {
+	return filepath.Join(*dataDir, "acme")
+}
+
+// start loads or gets the certificate and keeps renewing it
+func (m *acmeManager) start() error {
+	err := os.MkdirAll(m.dir(), 0700)
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(filepath.Join(m.dir(), m.host+".crt"), filepath.Join(m.dir(), m.host+".key"))
+	if err == nil {
+		m.cert = &cert
+	}
+	if m.needsRenewal() {
+		err = m.obtain()
+		if err != nil && m.cert == nil {
+			return err
+		}
+		check(err, "Cannot renew certificate:")
+	}
+	go func() {
+		for {
+			time.Sleep(24 * time.Hour)
+			if m.needsRenewal() {
+				check(m.obtain(), "Cannot renew certificate:")
+			}
+		}
+	}()
+	return nil
+}
+
+func (m *acmeManager) needsRenewal() bool {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	if m.cert == nil {
+		return true
+	}
+	leaf, err := x509.ParseCertificate(m.cert.Certificate[0])
+	return err != nil || time.Until(leaf.NotAfter) < acmeRenewBefore
+}
+
+// GetCertificate is used in the TLS configuration
+func (m *acmeManager) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	if m.cert == nil {
+		return nil, errors.New("no certificate yet")
+	}
+	return m.cert, nil
+}
+
+// HTTPHandler answers the http-01 challenges and redirects everything else to HTTPS
+func (m *acmeManager) HTTPHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/.well-known/acme-challenge/") {
+			m.mutex.RLock()
+			auth, ok := m.challenges[strings.TrimPrefix(r.URL.Path, "/.well-known/acme-challenge/")]
+			m.mutex.RUnlock()
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			w.Write([]byte(auth))
+			return
+		}
+		http.Redirect(w, r, "https://"+m.host+r.URL.RequestURI(), http.StatusMovedPermanently)
+	})
+}
+
+// obtain a new certificate
+func (m *acmeManager) obtain() error {
+	fmt.Println("Getting certificate for", m.host, "from", m.directory)
+	err := m.account()
+	if err != nil {
+		return err
+	}
+	var order struct {
+		Status         string   `json:"status"`
+		Authorizations []string `json:"authorizations"`
+		Finalize       string   `json:"finalize"`
+		Certificate    string   `json:"certificate"`
+	}
+	payload := map[string]interface{}{"identifiers": []map[string]string{{"type": "dns", "value": m.host}}}
+	resp, _, err := m.post(m.urls.NewOrder, payload, &order)
+	if err != nil {
+		return err
+	}
+	orderURL := resp.Header.Get("Location")
+	for _, authURL := range order.Authorizations {
+		err = m.authorize(authURL)
+		if err != nil {
+			return err
+		}
+	}
+
+	// finalize the order with a CSR for a new certificate key
+	certKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: m.host},
+		DNSNames: []string{m.host},
+	}, certKey)
+	if err != nil {
+		return err
+	}
+	_, _, err = m.post(order.Finalize, map[string]string{"csr": base64.RawURLEncoding.EncodeToString(csr)}, &order)
+	if err != nil {
+		return err
+	}
+	for i := 0; order.Status != "valid"; i++ {
+		if order.Status == "invalid" || i > 30 {
+			return errors.New("order for " + m.host + " failed with status " + order.Status)
+		}
+		time.Sleep(2 * time.Second)
+		_, _, err = m.post(orderURL, nil, &order)
+		if err != nil {
+			return err
+		}
+	}
+	_, chain, err := m.post(order.Certificate, nil, nil)
+	if err != nil {
+		return err
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(certKey)
+	if err != nil {
+		return err
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	cert, err := tls.X509KeyPair(chain, keyPEM)
+	if err != nil {
+		return err
+	}
+	err = ioutil.WriteFile(filepath.Join(m.dir(), m.host+".key"), keyPEM, 0600)
+	if err != nil {
+		return err
+	}
+	err = ioutil.WriteFile(filepath.Join(m.dir(), m.host+".crt"), chain, 0600)
+	if err != nil {
+		return err
+	}
+	m.mutex.Lock()
+	m.cert = &cert
+	m.mutex.Unlock()
+	fmt.Println("Got certificate for", m.host)
+	return nil
+}
+
+// complete the http-01 challenge of an authorization
+func (m *acmeManager) authorize(authURL string) error {
+	var authz struct {
+		Status     string `json:"status"`
+		Challenges []struct {
+			Type  string `json:"type"`
+			URL   string `json:"url"`
+			Token string `json:"token"`
+		} `json:"challenges"`
+	}
+	_, _, err := m.post(authURL, nil, &authz)
+	if err != nil {
+		return err
+	}
+	if authz.Status == "valid" {
+		return nil
+	}
+	for _, challenge := range authz.Challenges {
+		if challenge.Type != "http-01" {
+			continue
+		}
+		m.mutex.Lock()
+		m.challenges[challenge.Token] = challenge.Token + "." + m.thumbprint()
+		m.mutex.Unlock()
+		defer func(token string) {
+			m.mutex.Lock()
+			delete(m.challenges, token)
+			m.mutex.Unlock()
+		}(challenge.Token)
+		_, _, err = m.post(challenge.URL, struct{}{}, nil)
+		if err != nil {
+			return err
+		}
+		for i := 0; authz.Status != "valid"; i++ {
+			if authz.Status == "invalid" || i > 30 {
+				return errors.New("authorization for " + m.host + " failed with status " + authz.Status)
+			}
+			time.Sleep(2 * time.Second)
+			_, _, err = m.post(authURL, nil, &authz)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return errors.New("no http-01 challenge offered for " + m.host)
+}
+
+// load or create the account key and register the account
+func (m *acmeManager) account() error {
+	if m.kid != "" {
+		return nil
+	}
+	resp, err := http.Get(m.directory)
+	if err != nil {
+		return err
+	}
+	err = json.NewDecoder(resp.Body).Decode(&m.urls)
+	resp.Body.Close()
+	if err != nil {
+		return err
+	}
+
+	keyFile := filepath.Join(m.dir(), "account.key")
+	data, err := ioutil.ReadFile(keyFile)
+	if err == nil {
+		block, _ := pem.Decode(data)
+		if block == nil {
+			return errors.New("cannot decode " + keyFile)
+		}
+		m.key, err = x509.ParseECPrivateKey(block.Bytes)
+		if err != nil {
+			return err
+		}
+	} else {
+		m.key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return err
+		}
+		der, err := x509.MarshalECPrivateKey(m.key)
+		if err != nil {
+			return err
+		}
+		err = ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0600)
+		if err != nil {
+			return err
+		}
+	}
+
+	payload := map[string]interface{}{"termsOfServiceAgreed": true}
+	if m.email != "" {
+		payload["contact"] = []string{"mailto:" + m.email}
+	}
+	resp, _, err = m.post(m.urls.NewAccount, payload, nil)
+	if err != nil {
+		return err
+	}
+	m.kid = resp.Header.Get("Location")
+	return nil
+}
+
+func (m *acmeManager) jwk() map[string]string {
+	// the uncompressed point is 0x04 followed by the 32 byte X and Y coordinates
+	pub, err := m.key.PublicKey.ECDH()
+	if err != nil {
+		panic(err)
+	}
+	point := pub.Bytes()
+	return map[string]string{
+		"crv": "P-256",
+		"kty": "EC",
+		"x":   base64.RawURLEncoding.EncodeToString(point[1:33]),
+		"y":   base64.RawURLEncoding.EncodeToString(point[33:65]),
+	}
+}
+
+// JWK thumbprint of the account key, RFC 7638
+func (m *acmeManager) thumbprint() string {
+	jwk := m.jwk()
+	data := `{"crv":"` + jwk["crv"] + `","kty":"` + jwk["kty"] + `","x":"` + jwk["x"] + `","y":"` + jwk["y"] + `"}`
+	sum := sha256.Sum256([]byte(data))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func padBytes(n *big.Int, size int) []byte {
+	b := n.Bytes()
+	if len(b) >= size {
+		return b
+	}
+	return append(make([]byte, size-len(b)), b...)
+}
+
+// send a JWS signed request, a nil payload is a POST-as-GET, and decode the response into v
+func (m *acmeManager) post(url string, payload interface{}, v interface{}) (*http.Response, []byte, error) {
+	for retry := 0; ; retry++ {
+		if m.nonce == "" {
+			resp, err := http.Head(m.urls.NewNonce)
+			if err != nil {
+				return nil, nil, err
+			}
+			resp.Body.Close()
+			m.nonce = resp.Header.Get("Replay-Nonce")
+		}
+		protected := map[string]interface{}{"alg": "ES256", "nonce": m.nonce, "url": url}
+		if m.kid != "" {
+			protected["kid"] = m.kid
+		} else {
+			protected["jwk"] = m.jwk()
+		}
+		body, err := m.sign(protected, payload)
+		if err != nil {
+			return nil, nil, err
+		}
+		resp, err := http.Post(url, "application/jose+json", bytes.NewReader(body))
+		if err != nil {
+			return nil, nil, err
+		}
+		m.nonce = resp.Header.Get("Replay-Nonce")
+		data, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, nil, err
+		}
+		if resp.StatusCode >= 400 {
+			var problem struct {
+				Type   string `json:"type"`
+				Detail string `json:"detail"`
+			}
+			json.Unmarshal(data, &problem)
+			if problem.Type == "urn:ietf:params:acme:error:badNonce" && retry < 3 {
+				continue
+			}
+			return nil, nil, fmt.Errorf("ACME request to %s failed: %s %s", url, problem.Type, problem.Detail)
+		}
+		if v != nil {
+			err = json.Unmarshal(data, v)
+		}
+		return resp, data, err
+	}
+}
+
+func (m *acmeManager) sign(protected map[string]interface{}, payload interface{}) ([]byte, error) {
+	header, err := json.Marshal(protected)
+	if err != nil {
+		return nil, err
+	}
+	encodedPayload := ""
+	if payload != nil {
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return nil, err
+		}
+		encodedPayload = base64.RawURLEncoding.EncodeToString(data)
+	}
+	encodedHeader := base64.RawURLEncoding.EncodeToString(header)
+	hash := sha256.Sum256([]byte(encodedHeader + "." + encodedPayload))
+	r, s, err := ecdsa.Sign(rand.Reader, m.key, hash[:])
+	if err != nil {
+		return nil, err
+	}
+	signature := append(padBytes(r, 32), padBytes(s, 32)...)
+	return json.Marshal(map[string]string{
+		"protected": encodedHeader,
+		"payload":   encodedPayload,
+		"signature": base64.RawURLEncoding.EncodeToString(signature),
+	})
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// the airport utility that came with macOS up to 14.3
+const airportCommand = "/System/Library/PrivateFrameworks/Apple80211.framework/Versions/Current/Resources/airport"
+
+// BSSID, RSSI, channel, HT, country code and security after the right-aligned SSID
+var airportLine = regexp.MustCompile(`^(.*?)\s*([0-9a-fA-F]{2}(?::[0-9a-fA-F]{2}){5})\s+(-?\d+)\s+(\S+)\s+(\S+)\s+(\S+)\s+(.*)$`)
+
+// scan with CoreWLAN from JavaScript for Automation, which needs no cgo, and print the networks as JSON with the
+// CWSecurity values each supports
+const coreWLANScan = `ObjC.import('CoreWLAN')
+var iface = $.CWWiFiClient.sharedWiFiClient.interface
+if (iface.isNil()) throw new Error('no Wi-Fi interface')
+var found = iface.scanForNetworksWithNameError(null, null)
+if (found.isNil()) throw new Error('scan failed')
+var networks = found.allObjects, out = []
+for (var i = 0; i < networks.count; i++) {
+	var n = networks.objectAtIndex(i), security = []
+	for (var s = 0; s <= 15; s++) if (n.supportsSecurity(s)) security.push(s)
+	out.push({ssid: ObjC.unwrap(n.ssid) || '', bssid: ObjC.unwrap(n.bssid) || '', rssi: n.rssiValue,
+		channel: n.wlanChannel.channelNumber, security: security})
+}
+JSON.stringify(out)`
+
+// a network found by CoreWLAN
+type coreWLANNetwork struct {
+	SSID     string `json:"ssid"`
+	BSSID    string `json:"bssid"`
+	RSSI     int    `json:"rssi"`
+	Channel  int    `json:"channel"`
+	Security []int  `json:"security"` // CWSecurity values
+}
+
+// CWSecurity values as privacy and authentication the way airodump-ng names them, the strongest first
+var coreWLANSecurity = []struct {
+	security      int
+	privacy, auth string
+}{
+	{12, "WPA3", "MGT"}, {11, "WPA3", "SAE"}, {13, "WPA3 WPA2", "SAE"}, {10, "WPA2", "MGT"}, {9, "WPA2", "MGT"},
+	{8, "WPA2 WPA", "MGT"}, {7, "WPA", "MGT"}, {5, "WPA2", "PSK"}, {4, "WPA2", "PSK"}, {3, "WPA2 WPA", "PSK"},
+	{2, "WPA", "PSK"}, {15, "OPN", "OWE"}, {14, "OPN", "OWE"}, {6, "WEP", ""}, {1, "WEP", ""}, {0, "OPN", ""},
+}
+
+// collect the access points visible to a Mac with CoreWLAN, or with airport -s on the macOS versions that still
+// have it, for casual surveys on a MacBook
+func collectAirport() (aps []AccessPoint, clients []Client, problem string) {
+	output, err := exec.Command("osascript", "-l", "JavaScript", "-e", coreWLANScan).Output()
+	if err == nil {
+		return parseCoreWLAN(output, time.Now())
+	}
+	fmt.Println("Cannot scan with CoreWLAN:", err)
+	if _, statErr := os.Stat(airportCommand); statErr != nil {
+		return nil, nil, "cannot scan with CoreWLAN and there is no airport command"
+	}
+	output, err = exec.Command(airportCommand, "-s").Output()
+	if err != nil {
+		fmt.Println("Cannot run airport:", err)
+		return nil, nil, "cannot run airport"
+	}
+	aps = parseAirport(string(output), time.Now())
+	return
+}
+
+// the access points in the JSON the CoreWLAN scan prints, macOS 14 and later only give the BSSIDs to programs
+// allowed to use Location Services
+func parseCoreWLAN(output []byte, now time.Time) (aps []AccessPoint, clients []Client, problem string) {
+	var networks []coreWLANNetwork
+	if err := json.Unmarshal(output, &networks); err != nil {
+		return nil, nil, "cannot parse CoreWLAN scan"
+	}
+	for _, n := range networks {
+		mac, ok := parseMAC(n.BSSID)
+		if !ok {
+			continue
+		}
+		ap := AccessPoint{MAC: mac, FirstSeen: scanFirstSeen(mac, now), LastSeen: now, Channel: n.Channel, Power: n.RSSI,
+			Name: n.SSID}
+		supported := make(map[int]bool)
+		for _, s := range n.Security {
+			supported[s] = true
+		}
+		for _, s := range coreWLANSecurity {
+			if supported[s.security] {
+				ap.Privacy, ap.Authentication = s.privacy, s.auth
+				break
+			}
+		}
+		aps = append(aps, ap)
+	}
+	if len(aps) == 0 && len(networks) > 0 {
+		problem = "BSSIDs hidden, allow Location Services for osascript or the terminal running netnet"
+	}
+	return
+}
+
+// parse the output of airport -s
+func parseAirport(output string, now time.Time) (aps []AccessPoint) {
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		match := airportLine.FindStringSubmatch(scanner.Text())
+		if match == nil {
+			continue
+		}
+		mac := normalizeMAC(match[2])
+		power, _ := strconv.Atoi(match[3])
+		channel, _ := strconv.Atoi(strings.SplitN(match[4], ",", 2)[0])
+		privacy, auth := airportSecurity(match[7])
+		aps = append(aps, AccessPoint{
+			MAC:            mac,
+			FirstSeen:      scanFirstSeen(mac, now),
+			LastSeen:       now,
+			Channel:        channel,
+			Privacy:        privacy,
+			Authentication: auth,
+			Power:          power,
+			Name:           strings.TrimSpace(match[1]),
+		})
+	}
+	return
+}
+
+// map airport security like WPA2(PSK/AES/AES) to airodump-ng privacy (WPA2) and authentication (PSK)
+func airportSecurity(security string) (string, string) {
+	var privacy []string
+	auth := ""
+	for _, s := range strings.Fields(security) {
+		name := s
+		if i := strings.Index(s, "("); i >= 0 {
+			name = s[:i]
+			if auth == "" {
+				auth = strings.SplitN(strings.Trim(s[i:], "()"), "/", 2)[0]
+			}
+		}
+		if name == "NONE" {
+			name = "OPN"
+		}
+		privacy = append([]string{name}, privacy...)
+	}
+	return strings.Join(privacy, " "), auth
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// alert states
+const (
+	AlertOpen         = "open"
+	AlertAcknowledged = "acknowledged"
+	AlertResolved     = "resolved"
+)
+
+// events that need someone to look at them
+var alertTypes = []string{EventRogueAP, EventWatchlist, EventSensorDown, EventSSIDChange, EventBeaconChange, EventStaleDatabase, EventUnknownClient, EventExpiredGuest}
+
+// Alert is an event that needs triaging, the same event for the same device is tracked by one alert
+// until it is resolved
+type Alert struct {
+	ID       int64     `json:"id"`
+	Type     string    `json:"type"`
+	MAC      string    `json:"mac,omitempty"`
+	Message  string    `json:"message"`
+	State    string    `json:"state"`
+	Assignee string    `json:"assignee,omitempty"`
+	Comment  string    `json:"comment,omitempty"`
+	Created  time.Time `json:"created"`
+	Updated  time.Time `json:"updated"`
+	LastSeen time.Time `json:"last_seen"` // last time the event happened
+	Count    int       `json:"count"`     // number of times the event happened
+	EventID  int64     `json:"event_id"`  // first event in the event log
+}
+
+var alerts = make(map[int64]*Alert)
+var lastAlertID int64
+var alertsMutex sync.Mutex
+
+func loadAlerts() {
+	alertsMutex.Lock()
+	defer alertsMutex.Unlock()
+	var list []*Alert
+	check(loadJSON("alerts.json", &list), "Cannot load alerts:")
+	alerts = make(map[int64]*Alert)
+	lastAlertID = 0
+	for _, a := range list {
+		alerts[a.ID] = a
+		if a.ID > lastAlertID {
+			lastAlertID = a.ID
+		}
+	}
+}
+
+// save the alerts, oldest first
+func saveAlerts() error {
+	return saveJSON("alerts.json", sortedAlerts(""))
+}
+
+func sortedAlerts(state string) []*Alert {
+	list := []*Alert{}
+	for _, a := range alerts {
+		if state == "" || a.State == state {
+			list = append(list, a)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list
+}
+
+// the alert for the same event type and device that isn't resolved yet
+func findUnresolvedAlert(eventType, mac string) *Alert {
+	for _, a := range alerts {
+		if a.Type == eventType && a.MAC == mac && a.State != AlertResolved {
+			return a
+		}
+	}
+	return nil
+}
+
+// open an alert for the event or add it to the one already open, returns false if the alert
+// was acknowledged so the event shouldn't be sent out again
+func trackAlert(e Event) bool {
+	if e.Type == EventSensorUp {
+		resolveAlerts(EventSensorDown, e)
+		return true
+	}
+	if !containsString(alertTypes, e.Type) {
+		return true
+	}
+	alertsMutex.Lock()
+	defer alertsMutex.Unlock()
+	a := findUnresolvedAlert(e.Type, e.MAC)
+	if a == nil {
+		lastAlertID++
+		a = &Alert{
+			ID:      lastAlertID,
+			Type:    e.Type,
+			MAC:     e.MAC,
+			Message: e.Message,
+			State:   AlertOpen,
+			Created: e.Time,
+			Updated: e.Time,
+			EventID: e.ID,
+		}
+		alerts[a.ID] = a
+	}
+	a.LastSeen = e.Time
+	a.Count++
+	check(saveAlerts(), "Cannot save alerts:")
+	return a.State == AlertOpen
+}
+
+// resolve the alerts of a type when the event that clears them happens
+func resolveAlerts(eventType string, e Event) {
+	alertsMutex.Lock()
+	defer alertsMutex.Unlock()
+	changed := false
+	for _, a := range alerts {
+		if a.Type == eventType && a.State != AlertResolved {
+			a.State, a.Updated, a.Comment = AlertResolved, e.Time, e.Message
+			changed = true
+		}
+	}
+	if changed {
+		check(saveAlerts(), "Cannot save alerts:")
+	}
+}
+
+// GET /alerts lists the alerts, ?state=open for the ones in a state, GET /alerts/{id} gets one
+// and PATCH /alerts/{id} changes its state, assignee or comment
+func alertRoutes(w http.ResponseWriter, r *http.Request) {
+	alertsMutex.Lock()
+	defer alertsMutex.Unlock()
+	idParam := strings.Trim(strings.TrimPrefix(r.URL.Path, "/alerts"), "/")
+	if idParam == "" {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		writeJSON(w, sortedAlerts(r.URL.Query().Get("state")))
+		return
+	}
+	id, err := strconv.ParseInt(idParam, 10, 64)
+	a, ok := alerts[id]
+	if err != nil || !ok {
+		http.NotFound(w, r)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, a)
+	case http.MethodPatch:
+		var patch struct {
+			State    *string `json:"state"`
+			Assignee *string `json:"assignee"`
+			Comment  *string `json:"comment"`
+		}
+		err = json.NewDecoder(r.Body).Decode(&patch)
+		if err != nil {
+			http.Error(w, "Cannot parse alert: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if patch.State != nil {
+			switch *patch.State {
+			case AlertOpen, AlertAcknowledged, AlertResolved:
+				a.State = *patch.State
+			default:
+				http.Error(w, "Invalid state "+*patch.State, http.StatusBadRequest)
+				return
+			}
+		}
+		if patch.Assignee != nil {
+			a.Assignee = *patch.Assignee
+		}
+		if patch.Comment != nil {
+			a.Comment = *patch.Comment
+		}
+		a.Updated = time.Now()
+		err = saveAlerts()
+		if err != nil {
+			http.Error(w, "Cannot save alerts: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, a)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"image/png"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Credentials are what is needed to join an access point, for the APs we own
+type Credentials struct {
+	SSID     string `json:"ssid"`
+	Password string `json:"password"`
+	Security string `json:"security"` // WPA, WEP or nopass
+	Hidden   bool   `json:"hidden"`
+}
+
+var credentials = make(map[string]Credentials)
+var credentialsMutex sync.RWMutex
+
+func loadCredentials() {
+	credentialsMutex.Lock()
+	defer credentialsMutex.Unlock()
+	credentials = make(map[string]Credentials)
+	check(loadJSON("credentials.json", &credentials), "Cannot load AP credentials:")
+}
+
+// routes under /aps/{mac}
+func apRoutes(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/aps/"), "/"), "/")
+	mac := normalizeMAC(parts[0])
+	action := ""
+	if len(parts) > 1 {
+		action = parts[1]
+	}
+	switch action {
+	case "":
+		if r.Method != http.MethodPatch {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		patchDeviceMeta(w, r, mac, true)
+	case "credentials":
+		apCredentials(w, r, mac)
+	case "qr":
+		apQR(w, r, mac)
+	case "ssids":
+		apSSIDs(w, r, mac)
+	case "beacon":
+		apBeacon(w, r, mac)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// set or remove the credentials of an access point
+func apCredentials(w http.ResponseWriter, r *http.Request, mac string) {
+	credentialsMutex.Lock()
+	defer credentialsMutex.Unlock()
+	switch r.Method {
+	case http.MethodPut, http.MethodPost:
+		var c Credentials
+		err := json.NewDecoder(r.Body).Decode(&c)
+		if err != nil {
+			http.Error(w, "Cannot parse credentials: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		credentials[mac] = c
+	case http.MethodDelete:
+		delete(credentials, mac)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	err := saveJSON("credentials.json", credentials)
+	if err != nil {
+		http.Error(w, "Cannot save credentials: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Wi-Fi QR code for an access point, open APs don't need credentials
+func apQR(w http.ResponseWriter, r *http.Request, mac string) {
+	credentialsMutex.RLock()
+	c, ok := credentials[mac]
+	credentialsMutex.RUnlock()
+	ap := findAccessPoint(mac)
+	if !ok {
+		if ap == nil || strings.TrimSpace(ap.Privacy) != "OPN" {
+			http.Error(w, "No credentials for access point "+mac, http.StatusNotFound)
+			return
+		}
+		c = Credentials{Security: "nopass"}
+	}
+	if c.SSID == "" && ap != nil {
+		c.SSID = strings.TrimSpace(ap.Name)
+	}
+	qr, err := encodeQR([]byte(wifiConfig(c)))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	scale, err := strconv.Atoi(r.URL.Query().Get("scale"))
+	if err != nil || scale < 1 || scale > 20 {
+		scale = 8
+	}
+	w.Header().Set("Content-Type", "image/png")
+	png.Encode(w, qr.image(scale))
+}
+
+// the Wi-Fi network configuration string that phones understand, ie WIFI:T:WPA;S:ssid;P:password;;
+func wifiConfig(c Credentials) string {
+	escape := strings.NewReplacer(`\`, `\\`, `;`, `\;`, `,`, `\,`, `"`, `\"`, `:`, `\:`)
+	security := c.Security
+	if security == "" {
+		security = "WPA"
+	}
+	s := "WIFI:T:" + security + ";S:" + escape.Replace(c.SSID) + ";"
+	if security != "nopass" {
+		s += "P:" + escape.Replace(c.Password) + ";"
+	}
+	if c.Hidden {
+		s += "H:true;"
+	}
+	return s + ";"
+}
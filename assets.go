@@ -0,0 +1,178 @@
+package main
+
+import (
+	"embed"
+	"fmt"
+	"html/template"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// the HTML files are built into the binary so netnet runs without the public directory,
+// files in the public directory take precedence so they can still be customised
+//
+//go:embed public/*.html
+var embedded embed.FS
+
+// where to download the vendor databases from when they aren't there
+const (
+	ouiURL = "http://standards-oui.ieee.org/oui/oui.txt"
+	cidURL = "http://standards-oui.ieee.org/cid/cid.txt"
+	mamURL = "http://standards-oui.ieee.org/oui28/mam.txt"
+)
+
+// VendorsConfig points the vendor database downloads at mirrors, for networks that block the IEEE site
+type VendorsConfig struct {
+	OUIURL string `json:"oui_url"`
+	CIDURL string `json:"cid_url"`
+	MAMURL string `json:"mam_url"`
+	Nmap   string `json:"nmap"` // nmap-mac-prefixes file, looked for where nmap installs it if empty
+
+	// online service for the vendors none of the databases know, with {mac} for the address,
+	// ie https://api.macvendors.com/{mac}, off if empty
+	LookupURL     string  `json:"lookup_url"`
+	LookupRate    float64 `json:"lookup_rate"`    // lookups per second, defaults to 1
+	LookupWorkers int     `json:"lookup_workers"` // lookups at the same time, defaults to 1
+	LookupTimeout int     `json:"lookup_timeout"` // in seconds, defaults to 30
+
+	MaxAge     int  `json:"max_age"`     // days before the databases are stale and flagged in /status, defaults to 180, -1 never
+	StaleAlert bool `json:"stale_alert"` // raise an alert when they are stale too
+}
+
+// where nmap installs its nmap-mac-prefixes file
+var nmapPrefixesFiles = []string{
+	"/usr/share/nmap/nmap-mac-prefixes",
+	"/usr/local/share/nmap/nmap-mac-prefixes",
+	"/opt/homebrew/share/nmap/nmap-mac-prefixes",
+	`C:\Program Files (x86)\Nmap\nmap-mac-prefixes`,
+	`C:\Program Files\Nmap\nmap-mac-prefixes`,
+}
+
+// a vendor database file, where to download it from and the registry it is
+type vendorDatabase struct {
+	file, url, registry string
+}
+
+// the vendor databases, from the configured mirrors if there are any
+func vendorDatabases() []vendorDatabase {
+	or := func(configured, url string) string {
+		if configured != "" {
+			return configured
+		}
+		return url
+	}
+	return []vendorDatabase{
+		{"oui.txt", or(config.Vendors.OUIURL, ouiURL), "MA-L"},
+		{"mam.txt", or(config.Vendors.MAMURL, mamURL), "MA-M"},
+		{"cid.txt", or(config.Vendors.CIDURL, cidURL), "CID"},
+	}
+}
+
+// where to download a vendor database from
+func vendorURL(file string) string {
+	for _, db := range vendorDatabases() {
+		if db.file == file {
+			return db.url
+		}
+	}
+	return ""
+}
+
+// an HTTP client for downloads, through the proxy in the configuration if there is one,
+// otherwise the one in the HTTP_PROXY and HTTPS_PROXY environment variables
+func downloadClient(timeout time.Duration) (*http.Client, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if config.Proxy != "" {
+		proxy, err := url.Parse(config.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy: %v", err)
+		}
+		transport.Proxy = http.ProxyURL(proxy)
+	}
+	return &http.Client{Timeout: timeout, Transport: transport}, nil
+}
+
+// parse a template from the public directory, or the built-in one, with the helpers in templateFuncs
+func parseTemplate(name string) (*template.Template, error) {
+	t := template.New(name).Funcs(templateFuncs)
+	if _, err := os.Stat(publicFile(name)); err == nil {
+		return t.ParseFiles(publicFile(name))
+	}
+	return t.ParseFS(embedded, "public/"+name)
+}
+
+// the public directory if there is one, otherwise the built-in files
+func publicFS() http.FileSystem {
+	if info, err := os.Stat(filepath.Join(*dir, "public")); err == nil && info.IsDir() {
+		return http.Dir(filepath.Join(*dir, "public"))
+	}
+	sub, _ := fs.Sub(embedded, "public")
+	return http.FS(sub)
+}
+
+// open a vendor database from the public or data directory, downloading it into the data directory if it's in neither
+func openDatabase(name, url string) (*os.File, error) {
+	file, err := os.Open(publicFile(name))
+	if err == nil {
+		recordDatabase(name, file)
+		return file, nil
+	}
+	path := filepath.Join(*dataDir, name)
+	file, err = os.Open(path)
+	if err == nil {
+		recordDatabase(name, file)
+		return file, nil
+	}
+	if *offline {
+		return nil, fmt.Errorf("%s is not bundled and netnet is offline", name)
+	}
+	err = download(url, path)
+	if err != nil {
+		return nil, err
+	}
+	file, err = os.Open(path)
+	if err == nil {
+		recordDatabase(name, file)
+	}
+	return file, err
+}
+
+// longest a download can take, the OUI database is several megabytes
+const downloadTimeout = 10 * time.Minute
+
+// download a file, only replacing the existing one when the download is complete
+func download(url, path string) error {
+	fmt.Println("Downloading", url)
+	err := os.MkdirAll(filepath.Dir(path), 0700)
+	if err != nil {
+		return err
+	}
+	client, err := downloadClient(downloadTimeout)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("cannot download %s: %s", url, resp.Status)
+	}
+	file, err := os.Create(path + ".download")
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(file, resp.Body)
+	file.Close()
+	if err != nil {
+		os.Remove(path + ".download")
+		return err
+	}
+	return os.Rename(path+".download", path)
+}
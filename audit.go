@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// actions that change the airspace are appended to this file in the data directory, one JSON object per line
+const auditLogFile = "audit.jsonl"
+
+// AuditEntry is an action someone took through netnet
+type AuditEntry struct {
+	Time   time.Time   `json:"time"`
+	Action string      `json:"action"`
+	Who    string      `json:"who"`    // API key or user that asked for it
+	Remote string      `json:"remote"` // address the request came from
+	Target interface{} `json:"target"`
+	Result string      `json:"result"`
+}
+
+var auditMutex sync.Mutex
+
+// who made the request, from the API key or login session
+func requester(r *http.Request) string {
+	if key := findAPIKey(requestKey(r)); key != nil {
+		return "key " + key.Name + " (" + key.ID + ")"
+	}
+	if session := requestSession(r); session != nil {
+		return "user " + session.User
+	}
+	return "anonymous"
+}
+
+// append an entry to the audit log, it is printed too so it also ends up in the system log
+func audit(e AuditEntry) {
+	auditMutex.Lock()
+	defer auditMutex.Unlock()
+	data, err := json.Marshal(e)
+	if err != nil {
+		check(err, "Cannot write audit log:")
+		return
+	}
+	fmt.Println("Audit:", string(data))
+	err = os.MkdirAll(*dataDir, 0700)
+	if err != nil {
+		check(err, "Cannot write audit log:")
+		return
+	}
+	file, err := os.OpenFile(filepath.Join(*dataDir, auditLogFile), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		check(err, "Cannot write audit log:")
+		return
+	}
+	defer file.Close()
+	_, err = file.Write(append(data, '\n'))
+	check(err, "Cannot write audit log:")
+}
+
+// GET /admin/audit lists the audit log, oldest first
+func adminAudit(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	auditMutex.Lock()
+	defer auditMutex.Unlock()
+	entries := []AuditEntry{}
+	file, err := os.Open(filepath.Join(*dataDir, auditLogFile))
+	if err != nil && !os.IsNotExist(err) {
+		http.Error(w, "Cannot read audit log: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err == nil {
+		defer file.Close()
+		scanner := bufio.NewScanner(file)
+		scanner.Buffer(make([]byte, 64*1024), maxEventSize)
+		for scanner.Scan() {
+			var e AuditEntry
+			if json.Unmarshal(scanner.Bytes(), &e) == nil {
+				entries = append(entries, e)
+			}
+		}
+	}
+	writeJSON(w, entries)
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// API key scopes
+const (
+	ScopeRead   = "read"   // read-only access to the API
+	ScopeIngest = "ingest" // sensors sending in data
+	ScopeAdmin  = "admin"  // everything, including managing keys
+)
+
+// APIKey is an API key, only the hash of the key is kept
+type APIKey struct {
+	ID      string    `json:"id"`
+	Name    string    `json:"name"`
+	Scopes  []string  `json:"scopes"`
+	Hash    string    `json:"hash,omitempty"`
+	Created time.Time `json:"created"`
+}
+
+var apiKeys []APIKey
+var apiKeysMutex sync.RWMutex
+
+// load the API keys, and if there are none create an admin key so the API can be managed
+func loadAPIKeys() {
+	apiKeysMutex.Lock()
+	defer apiKeysMutex.Unlock()
+	apiKeys = nil
+	check(loadJSON("keys.json", &apiKeys), "Cannot load API keys:")
+	if len(apiKeys) == 0 && *authEnabled {
+		key, secret := newAPIKey("admin", []string{ScopeAdmin})
+		apiKeys = append(apiKeys, key)
+		check(saveJSON("keys.json", apiKeys), "Cannot save API keys:")
+		fmt.Println("Created admin API key (it won't be shown again):", secret)
+	}
+}
+
+// create a new API key, returns the key and the secret to give to the user
+func newAPIKey(name string, scopes []string) (APIKey, string) {
+	secret := "nn_" + randomHex(24)
+	key := APIKey{
+		ID:      randomHex(8),
+		Name:    name,
+		Scopes:  scopes,
+		Hash:    hashKey(secret),
+		Created: time.Now(),
+	}
+	return key, secret
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	_, err := rand.Read(b)
+	if err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+func hashKey(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// find the API key with the secret
+func findAPIKey(secret string) *APIKey {
+	if secret == "" {
+		return nil
+	}
+	hash := []byte(hashKey(secret))
+	apiKeysMutex.RLock()
+	defer apiKeysMutex.RUnlock()
+	for _, key := range apiKeys {
+		if subtle.ConstantTimeCompare(hash, []byte(key.Hash)) == 1 {
+			k := key
+			return &k
+		}
+	}
+	return nil
+}
+
+// check if the scopes allow doing something in the scope, admin can do everything
+func allows(scopes []string, scope string) bool {
+	return containsString(scopes, scope) || containsString(scopes, ScopeAdmin)
+}
+
+func validScope(scope string) bool {
+	return scope == ScopeRead || scope == ScopeIngest || scope == ScopeAdmin
+}
+
+// the API key from the Authorization header, the X-API-Key header or, only for WebSockets which browsers open
+// without a way to set headers, the key parameter, as a URL ends up in access logs and the browser history
+func requestKey(r *http.Request) string {
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimPrefix(auth, "Bearer ")
+	}
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return key
+	}
+	if headerHasToken(r.Header, "Upgrade", "websocket") {
+		return r.URL.Query().Get("key")
+	}
+	return ""
+}
+
+// the scope needed for a request
+func requiredScope(r *http.Request) string {
+	switch {
+	case strings.HasPrefix(r.URL.Path, "/admin/"):
+		return ScopeAdmin
+	case strings.HasPrefix(r.URL.Path, "/ingest"):
+		return ScopeIngest
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		return ScopeRead
+	}
+	return ScopeAdmin
+}
+
+// middleware that checks the API key or login session of every request, except for the static files and login
+func authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/public/") || r.URL.Path == "/login" || r.URL.Path == "/healthz" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		var scopes []string
+		if sensorCertID(r) != "" {
+			// sensors with a certificate can only send data
+			scopes = []string{ScopeIngest}
+		} else if key := findAPIKey(requestKey(r)); key != nil {
+			scopes = key.Scopes
+		} else if session := requestSession(r); session != nil {
+			if !session.checkCSRF(r) {
+				http.Error(w, "Invalid CSRF token", http.StatusForbidden)
+				return
+			}
+			scopes = session.Scopes
+		} else {
+			// send people using the web UI to the login page
+			if r.Method == http.MethodGet && strings.Contains(r.Header.Get("Accept"), "text/html") {
+				http.Redirect(w, r, "/login?next="+url.QueryEscape(r.URL.RequestURI()), http.StatusSeeOther)
+				return
+			}
+			w.Header().Set("WWW-Authenticate", `Bearer realm="netnet"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if !allows(scopes, requiredScope(r)) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// manage API keys at /admin/keys and /admin/keys/{id}
+func adminKeys(w http.ResponseWriter, r *http.Request) {
+	if !*authEnabled {
+		http.Error(w, "Managing API keys needs -auth", http.StatusForbidden)
+		return
+	}
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/admin/keys"), "/")
+	apiKeysMutex.Lock()
+	defer apiKeysMutex.Unlock()
+	switch {
+	case r.Method == http.MethodGet && id == "":
+		keys := make([]APIKey, len(apiKeys))
+		for i, key := range apiKeys {
+			key.Hash = ""
+			keys[i] = key
+		}
+		writeJSON(w, keys)
+	case r.Method == http.MethodPost && id == "":
+		var req struct {
+			Name   string   `json:"name"`
+			Scopes []string `json:"scopes"`
+		}
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			http.Error(w, "Cannot parse key request: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		for _, scope := range req.Scopes {
+			if !validScope(scope) {
+				http.Error(w, "Unknown scope "+scope, http.StatusBadRequest)
+				return
+			}
+		}
+		if len(req.Scopes) == 0 {
+			req.Scopes = []string{ScopeRead}
+		}
+		key, secret := newAPIKey(req.Name, req.Scopes)
+		apiKeys = append(apiKeys, key)
+		err = saveJSON("keys.json", apiKeys)
+		if err != nil {
+			http.Error(w, "Cannot save API keys: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		key.Hash = ""
+		writeJSONStatus(w, http.StatusCreated, struct {
+			APIKey
+			Key string `json:"key"`
+		}{key, secret})
+	case r.Method == http.MethodDelete && id != "":
+		for i, key := range apiKeys {
+			if key.ID == id {
+				apiKeys = append(apiKeys[:i], apiKeys[i+1:]...)
+				err := saveJSON("keys.json", apiKeys)
+				if err != nil {
+					http.Error(w, "Cannot save API keys: "+err.Error(), http.StatusInternalServerError)
+					return
+				}
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+		}
+		http.NotFound(w, r)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
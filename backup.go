@@ -0,0 +1,330 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// prefix of the directory a backup is extracted into before it is restored
+const restorePrefix = ".restore-"
+
+// largest backup /admin/restore takes, and most it extracts from one
+const maxRestoreSize = 1 << 30
+const maxRestoreExtracted = 4 << 30
+
+// the first bytes of an SQLite database
+var sqliteHeader = []byte("SQLite format 3\x00")
+
+// download a backup of the data directory as a gzipped tar at /admin/backup, or only a copy of the -db
+// database with /admin/backup?db
+func adminBackup(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !*authEnabled {
+		http.Error(w, "Backups need -auth", http.StatusForbidden)
+		return
+	}
+	stamp := time.Now().Format("20060102-150405")
+	if _, ok := r.URL.Query()["db"]; ok {
+		if !observationsEnabled() {
+			http.Error(w, "No observations are kept, start netnet with -db", http.StatusNotFound)
+			return
+		}
+		snapshot, err := snapshotDatabase()
+		if err != nil {
+			http.Error(w, "Cannot copy database: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer os.Remove(snapshot)
+		w.Header().Set("Content-Type", "application/vnd.sqlite3")
+		w.Header().Set("Content-Disposition", `attachment; filename="netnet-backup-`+stamp+`.db"`)
+		http.ServeFile(w, r, snapshot)
+		return
+	}
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", `attachment; filename="netnet-backup-`+stamp+`.tar.gz"`)
+	err := writeBackup(w)
+	check(err, "Cannot write backup:")
+}
+
+// copy the -db database while it is written to with VACUUM INTO, the path of the copy
+func snapshotDatabase() (string, error) {
+	file, err := ioutil.TempFile(*dataDir, restorePrefix+"*.tmp")
+	if err != nil {
+		return "", err
+	}
+	// VACUUM INTO only writes a file that isn't there
+	snapshot := file.Name()
+	file.Close()
+	os.Remove(snapshot)
+	err = sqlite(observationsPath(), "VACUUM INTO "+sqlQuote(snapshot)+";", nil)
+	if err != nil {
+		os.Remove(snapshot)
+		return "", err
+	}
+	return snapshot, nil
+}
+
+// whether a file in the data directory is the -db database or one of the files sqlite3 keeps next to it
+func isDatabaseFile(name string) bool {
+	if !observationsEnabled() {
+		return false
+	}
+	db := filepath.Clean(*dbFile)
+	return name == db || name == db+"-wal" || name == db+"-shm" || name == db+"-journal"
+}
+
+func writeBackup(w io.Writer) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	// the database is copied rather than read while it is written
+	if observationsEnabled() {
+		snapshot, err := snapshotDatabase()
+		if err != nil {
+			return err
+		}
+		defer os.Remove(snapshot)
+		info, err := os.Stat(snapshot)
+		if err != nil {
+			return err
+		}
+		if err = addBackupFile(tw, snapshot, filepath.Clean(*dbFile), info); err != nil {
+			return err
+		}
+	}
+	err := filepath.Walk(*dataDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		// nor a restore being extracted
+		if info.IsDir() && strings.HasPrefix(info.Name(), restorePrefix) {
+			return filepath.SkipDir
+		}
+		// skip directories and half-written files
+		if !info.Mode().IsRegular() || strings.HasSuffix(path, ".tmp") || strings.HasSuffix(path, ".download") {
+			return nil
+		}
+		name, err := filepath.Rel(*dataDir, path)
+		if err != nil || isDatabaseFile(name) {
+			return err
+		}
+		return addBackupFile(tw, path, name, info)
+	})
+	if err != nil {
+		return err
+	}
+	err = tw.Close()
+	if err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+func addBackupFile(tw *tar.Writer, path, name string, info os.FileInfo) error {
+	header, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return err
+	}
+	header.Name = filepath.ToSlash(name)
+	err = tw.WriteHeader(header)
+	if err != nil {
+		return err
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = io.CopyN(tw, file, info.Size())
+	return err
+}
+
+// restore a backup made with /admin/backup, or a copy of the -db database, by posting it to /admin/restore
+func adminRestore(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !*authEnabled {
+		http.Error(w, "Restoring backups needs -auth", http.StatusForbidden)
+		return
+	}
+	body := bufio.NewReader(http.MaxBytesReader(w, r.Body, maxRestoreSize))
+	// no parse writes to the data directory or reads it back halfway through
+	ingestMutex.Lock()
+	defer ingestMutex.Unlock()
+	var err error
+	if header, _ := body.Peek(len(sqliteHeader)); bytes.Equal(header, sqliteHeader) {
+		if !observationsEnabled() {
+			http.Error(w, "No observations are kept, start netnet with -db", http.StatusNotFound)
+			return
+		}
+		err = restoreDatabase(body)
+	} else {
+		err = restoreBackup(body)
+	}
+	if err != nil {
+		http.Error(w, "Cannot restore backup: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	loadState()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// extract a backup into a directory in the data directory, and only if all of it could be move its files
+// into place
+func restoreBackup(r io.Reader) error {
+	err := os.MkdirAll(*dataDir, 0700)
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempDir(*dataDir, restorePrefix)
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+	names, err := extractBackup(r, tmp)
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if name == filepath.Clean(*dbFile) && observationsEnabled() {
+			if err = checkDatabase(filepath.Join(tmp, name)); err != nil {
+				return err
+			}
+		}
+	}
+	// sqlite3 isn't run while the database is replaced
+	dbMutex.Lock()
+	defer dbMutex.Unlock()
+	for _, name := range names {
+		if isDatabaseFile(name) && name != filepath.Clean(*dbFile) {
+			continue
+		}
+		if name == filepath.Clean(*dbFile) {
+			removeDatabaseLogs()
+		}
+		path := filepath.Join(*dataDir, name)
+		if err = os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			return err
+		}
+		if err = os.Rename(filepath.Join(tmp, name), path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// replace the -db database with a copy of it
+func restoreDatabase(r io.Reader) error {
+	file, err := ioutil.TempFile(*dataDir, restorePrefix+"*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	_, err = io.Copy(file, r)
+	file.Close()
+	if err != nil {
+		return err
+	}
+	if err = checkDatabase(file.Name()); err != nil {
+		return err
+	}
+	dbMutex.Lock()
+	defer dbMutex.Unlock()
+	removeDatabaseLogs()
+	return os.Rename(file.Name(), observationsPath())
+}
+
+// check a database to restore is one and has the observations table
+func checkDatabase(path string) error {
+	err := sqlite(path, observationsSchema, nil)
+	if err != nil {
+		return err
+	}
+	var result []map[string]string
+	err = sqlite(path, "PRAGMA quick_check;", func(dec *json.Decoder) error {
+		var row map[string]string
+		err := dec.Decode(&row)
+		result = append(result, row)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if len(result) != 1 || result[0]["quick_check"] != "ok" {
+		return fmt.Errorf("database is damaged")
+	}
+	return nil
+}
+
+// remove the write-ahead log of the -db database, it belongs to the database being replaced
+func removeDatabaseLogs() {
+	for _, suffix := range []string{"-wal", "-shm", "-journal"} {
+		os.Remove(observationsPath() + suffix)
+	}
+}
+
+// extract the files of a backup into a directory, their names
+func extractBackup(r io.Reader, dir string) ([]string, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	tr := tar.NewReader(gz)
+	var names []string
+	extracted := make(map[string]bool)
+	var total int64
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return names, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		name := filepath.Clean(filepath.FromSlash(header.Name))
+		if filepath.IsAbs(name) || name == ".." || strings.HasPrefix(name, ".."+string(filepath.Separator)) {
+			return nil, fmt.Errorf("invalid file name %s in backup", header.Name)
+		}
+		path := filepath.Join(dir, name)
+		err = os.MkdirAll(filepath.Dir(path), 0700)
+		if err != nil {
+			return nil, err
+		}
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+		if err != nil {
+			return nil, err
+		}
+		n, err := io.CopyN(file, tr, maxRestoreExtracted-total+1)
+		file.Close()
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		total += n
+		if total > maxRestoreExtracted {
+			return nil, fmt.Errorf("backup is larger than %d bytes extracted", int64(maxRestoreExtracted))
+		}
+		// the last copy of a file in the backup wins
+		if !extracted[name] {
+			extracted[name] = true
+			names = append(names, name)
+		}
+	}
+}
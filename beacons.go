@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// EventBeaconChange is raised when the beacons of a known access point don't look like they used to,
+// which is a stronger sign of an evil twin than a matching SSID
+const EventBeaconChange = "beacon_change"
+
+// BeaconFingerprint is what the beacons of an access point look like, it only changes with the firmware or settings
+type BeaconFingerprint struct {
+	Interval   int      `json:"interval"`   // in time units of 1024 microseconds
+	Capability string   `json:"capability"` // capability information field in hex
+	Rates      []string `json:"rates"`      // supported rates in Mbps, basic rates end with *
+	VendorIEs  []string `json:"vendor_ies"` // OUI and type of the vendor specific elements, in order
+}
+
+// BeaconRecord is the fingerprint of an access point with the one it had before it last changed
+type BeaconRecord struct {
+	Fingerprint BeaconFingerprint  `json:"fingerprint"`
+	FirstSeen   time.Time          `json:"first_seen"`
+	LastSeen    time.Time          `json:"last_seen"`
+	Previous    *BeaconFingerprint `json:"previous,omitempty"`
+	Changed     time.Time          `json:"changed"`
+	Changes     int                `json:"changes"`
+}
+
+var beacons = make(map[string]*BeaconRecord)
+var beaconsMutex sync.RWMutex
+var beaconsSaved time.Time
+
+func loadBeacons() {
+	beaconsMutex.Lock()
+	defer beaconsMutex.Unlock()
+	beacons = make(map[string]*BeaconRecord)
+	check(loadJSON("beacons.json", &beacons), "Cannot load beacon fingerprints:")
+}
+
+// get the fingerprint from the body of a beacon frame
+func beaconFingerprint(body []byte) (fp BeaconFingerprint, ok bool) {
+	// timestamp, beacon interval and capability come before the elements
+	if len(body) < 12 {
+		return fp, false
+	}
+	fp.Interval = int(binary.LittleEndian.Uint16(body[8:10]))
+	fp.Capability = fmt.Sprintf("%04x", binary.LittleEndian.Uint16(body[10:12]))
+	fp.Rates, fp.VendorIEs = []string{}, []string{}
+	for _, e := range parseElements(body[12:]) {
+		switch e.ID {
+		case elementRates, elementExtRates:
+			for _, rate := range e.Data {
+				// 0x80 marks a basic rate, values from 121 up are BSS membership selectors, not rates
+				if rate&0x7f >= 121 {
+					continue
+				}
+				r := strconv.FormatFloat(float64(rate&0x7f)/2, 'f', -1, 64)
+				if rate&0x80 != 0 {
+					r += "*"
+				}
+				fp.Rates = append(fp.Rates, r)
+			}
+		case elementVendor:
+			if len(e.Data) >= 4 {
+				fp.VendorIEs = append(fp.VendorIEs, fmt.Sprintf("%02X-%02X-%02X:%d", e.Data[0], e.Data[1], e.Data[2], e.Data[3]))
+			}
+		}
+	}
+	return fp, true
+}
+
+// the parts of the fingerprint that are different
+func fingerprintChanges(old, fp BeaconFingerprint) (changes []string) {
+	if old.Interval != fp.Interval {
+		changes = append(changes, fmt.Sprintf("interval %d to %d", old.Interval, fp.Interval))
+	}
+	if old.Capability != fp.Capability {
+		changes = append(changes, "capability "+old.Capability+" to "+fp.Capability)
+	}
+	if listOf(old.Rates) != listOf(fp.Rates) {
+		changes = append(changes, "rates "+listOf(old.Rates)+" to "+listOf(fp.Rates))
+	}
+	if listOf(old.VendorIEs) != listOf(fp.VendorIEs) {
+		changes = append(changes, "vendor elements "+listOf(old.VendorIEs)+" to "+listOf(fp.VendorIEs))
+	}
+	return
+}
+
+func listOf(items []string) string {
+	if len(items) == 0 {
+		return "none"
+	}
+	return strings.Join(items, " ")
+}
+
+// record the beacon fingerprints from the captured frames, with an event when the beacons of a known
+// access point change, at most one for each access point per parse
+func recordBeacons(frames []Frame) (events []Event) {
+	beaconsMutex.Lock()
+	defer beaconsMutex.Unlock()
+	changed := make(map[string][]string)
+	added := false
+	for _, frame := range frames {
+		if frame.Type != frameManagement || frame.Subtype != subtypeBeacon || frame.Partial {
+			continue
+		}
+		fp, ok := beaconFingerprint(frame.Body)
+		if !ok {
+			continue
+		}
+		record := beacons[frame.Addr3]
+		if record == nil {
+			beacons[frame.Addr3] = &BeaconRecord{Fingerprint: fp, FirstSeen: frame.Time, LastSeen: frame.Time}
+			added = true
+			continue
+		}
+		if frame.Time.After(record.LastSeen) {
+			record.LastSeen = frame.Time
+		}
+		if diff := fingerprintChanges(record.Fingerprint, fp); len(diff) > 0 {
+			previous := record.Fingerprint
+			record.Previous, record.Fingerprint = &previous, fp
+			record.Changed = frame.Time
+			record.Changes++
+			if _, ok := changed[frame.Addr3]; !ok {
+				changed[frame.Addr3] = diff
+			}
+		}
+	}
+	for mac, diff := range changed {
+		record := *beacons[mac]
+		events = append(events, Event{Type: EventBeaconChange, Time: time.Now(), MAC: mac, Message: "Beacons of access point " + formatMAC(mac) + " changed: " + strings.Join(diff, ", "), Data: record})
+	}
+	if added || len(changed) > 0 || time.Since(beaconsSaved) >= metaSaveInterval {
+		beaconsSaved = time.Now()
+		check(saveJSON("beacons.json", beacons), "Cannot save beacon fingerprints:")
+	}
+	return
+}
+
+// get a copy of the beacon fingerprint of an access point
+func getBeacon(mac string) (BeaconRecord, bool) {
+	beaconsMutex.RLock()
+	defer beaconsMutex.RUnlock()
+	record, ok := beacons[mac]
+	if !ok {
+		return BeaconRecord{}, false
+	}
+	return *record, true
+}
+
+// beacon fingerprint of an access point at /aps/{mac}/beacon, only known with a capture file
+func apBeacon(w http.ResponseWriter, r *http.Request, mac string) {
+	record, ok := getBeacon(mac)
+	if !ok {
+		http.Error(w, "No beacons captured from "+formatMAC(mac), http.StatusNotFound)
+		return
+	}
+	writeJSON(w, record)
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"runtime"
+	"time"
+)
+
+// write an airodump-ng CSV file of about size bytes, mostly clients like a busy place
+func writeBenchCSV(name string, size int64) error {
+	file, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(file)
+	seen := time.Now().Format("2006-01-02 15:04:05")
+	var written int64
+	write := func(s string) {
+		n, _ := w.WriteString(s)
+		written += int64(n)
+	}
+	write("\r\nBSSID, First time seen, Last time seen, channel, Speed, Privacy, Cipher, Authentication, Power, # beacons, # IV, LAN IP, ID-length, ESSID, Key\r\n")
+	for i := 0; i < 2000; i++ {
+		write(fmt.Sprintf("00:22:72:%02X:%02X:%02X, %s, %s, %2d,  54, WPA2, CCMP, PSK, -%d,      120,       10,   0.  0.  0.  0,   8, AP-%05d, \r\n",
+			i>>16&0xff, i>>8&0xff, i&0xff, seen, seen, 1+i%11, 30+i%60, i))
+	}
+	write("\r\nStation MAC, First time seen, Last time seen, Power, # packets, BSSID, Probed ESSIDs\r\n")
+	for i := 0; written < size; i++ {
+		bssid := "(not associated) "
+		if i%3 != 0 {
+			bssid = fmt.Sprintf("00:22:72:%02X:%02X:%02X", i%2000>>16&0xff, i%2000>>8&0xff, i%2000&0xff)
+		}
+		write(fmt.Sprintf("08:61:%02X:%02X:%02X:%02X, %s, %s, -%d, %8d, %s,HomeNet,Office\r\n",
+			i>>24&0xff, i>>16&0xff, i>>8&0xff, i&0xff, seen, seen, 30+i%60, i%5000, bssid))
+	}
+	if err = w.Flush(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// parse an airodump-ng CSV file a few times and report how long it takes and how much it allocates,
+// with no file a CSV file of -size MB is made up, ie to check a Pi keeps up with a busy place
+func bench(args []string) {
+	flags := flag.NewFlagSet("bench", flag.ExitOnError)
+	size := flags.Int("size", 100, "size in MB of the CSV file to make up if none is given")
+	runs := flags.Int("runs", 3, "number of times to parse the file")
+	flags.Parse(args)
+	file := flags.Arg(0)
+	if file == "" {
+		tmp, err := os.CreateTemp("", "netnet-bench-*.csv")
+		if err != nil {
+			fmt.Println("Cannot create CSV file:", err)
+			os.Exit(1)
+		}
+		tmp.Close()
+		file = tmp.Name()
+		defer os.Remove(file)
+		fmt.Printf("Making up a %d MB CSV file\n", *size)
+		if err = writeBenchCSV(file, int64(*size)<<20); err != nil {
+			fmt.Println("Cannot write CSV file:", err)
+			os.Remove(file)
+			os.Exit(1)
+		}
+	}
+	info, err := os.Stat(file)
+	if err != nil {
+		fmt.Println("Cannot read CSV file:", err)
+		os.Exit(1)
+	}
+	mb := float64(info.Size()) / (1 << 20)
+
+	var slowest time.Duration
+	for i := 0; i < *runs; i++ {
+		runtime.GC()
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		start := time.Now()
+		aps, clients, problem := parseAirodumpCsv(file, airodumpLayout)
+		took := time.Since(start)
+		runtime.ReadMemStats(&after)
+		if problem != "" {
+			fmt.Println("Cannot parse CSV file:", problem)
+			os.Exit(1)
+		}
+		records := len(aps) + len(clients)
+		if records == 0 {
+			records = 1
+		}
+		fmt.Printf("Parsed %.1f MB, %d access points and %d clients in %v, %.1f MB/s, %d allocations and %d bytes a record\n",
+			mb, len(aps), len(clients), took.Round(time.Millisecond), mb/took.Seconds(),
+			(after.Mallocs-before.Mallocs)/uint64(records), (after.TotalAlloc-before.TotalAlloc)/uint64(records))
+		if took > slowest {
+			slowest = took
+		}
+	}
+	if slowest > defaultRefreshInterval {
+		fmt.Printf("Too slow: parsing takes longer than the refresh interval of %v\n", defaultRefreshInterval)
+		os.Exit(1)
+	}
+	fmt.Printf("Keeps up: parsing takes at most %v of the refresh interval of %v\n", slowest.Round(time.Millisecond), defaultRefreshInterval)
+}
+
+// parse airodump-ng CSV files once without starting the server, ie to check files in a pipeline, the exit code is 1
+// if a file can't be parsed or with -strict has a malformed record
+func parseFiles(args []string) {
+	flags := flag.NewFlagSet("parse", flag.ExitOnError)
+	asJSON := flags.Bool("json", false, "write the access points and clients of every file as JSON")
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		fmt.Println("Usage: netnet [-strict] [-profile name -config file] parse [-json] file.csv...")
+		os.Exit(2)
+	}
+	loadConfig(*configFile)
+	layout, ok := csvLayoutFor(*csvProfile)
+	if !ok {
+		fmt.Println("Unknown CSV profile:", *csvProfile)
+		os.Exit(2)
+	}
+	failed := false
+	for _, file := range flags.Args() {
+		aps, clients, problem := parseAirodumpCsv(file, layout)
+		if problem != "" {
+			fmt.Fprintln(os.Stderr, file+":", problem)
+			failed = true
+			continue
+		}
+		if *asJSON {
+			json.NewEncoder(os.Stdout).Encode(Batch{Source: file, APs: aps, Clients: clients})
+		} else {
+			fmt.Printf("%s: %d access points, %d clients\n", file, len(aps), len(clients))
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
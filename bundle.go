@@ -0,0 +1,153 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// the Leaflet release the map uses, and the files of it the map needs
+const leafletURL = "https://unpkg.com/leaflet@1.9.4/dist/"
+
+var leafletFiles = []string{
+	"leaflet.js",
+	"leaflet.css",
+	"images/layers.png",
+	"images/layers-2x.png",
+	"images/marker-icon.png",
+	"images/marker-icon-2x.png",
+	"images/marker-shadow.png",
+}
+
+// most tiles a bundle downloads, tile servers don't like bulk downloads
+const maxBundleTiles = 10000
+
+// where Leaflet is kept in the data directory once it's bundled
+func leafletDir() string {
+	return filepath.Join(*dataDir, "leaflet")
+}
+
+// where the map page loads Leaflet from, the bundled copy if there is one
+func leafletBase() string {
+	if _, err := os.Stat(filepath.Join(leafletDir(), "leaflet.js")); err == nil {
+		return "/map/leaflet/"
+	}
+	return leafletURL
+}
+
+// the bundled Leaflet at /map/leaflet/
+func mapLeaflet(w http.ResponseWriter, r *http.Request) {
+	http.StripPrefix("/map/leaflet/", http.FileServer(http.Dir(leafletDir()))).ServeHTTP(w, r)
+}
+
+// the tiles covering a bounding box at a zoom level
+func tileRange(west, south, east, north float64, zoom int) (minX, minY, maxX, maxY int) {
+	x1, y1 := mapPixel(north, west, zoom)
+	x2, y2 := mapPixel(south, east, zoom)
+	last := 1<<uint(zoom) - 1
+	clamp := func(n float64) int {
+		return int(math.Max(0, math.Min(float64(last), n/256)))
+	}
+	return clamp(x1), clamp(y1), clamp(x2), clamp(y2)
+}
+
+// download everything the UI needs into the data directory so it works without internet in the field:
+// the vendor databases, Leaflet and the map tiles of an area, ie
+// netnet bundle -bbox 103.6,1.2,104.1,1.5 -zoom 10-16
+func bundle(args []string) {
+	flags := flag.NewFlagSet("bundle", flag.ExitOnError)
+	bbox := flags.String("bbox", "", "area to download map tiles for: west,south,east,north")
+	zooms := flags.String("zoom", "10-16", "zoom levels to download map tiles for, ie 10-16")
+	flags.Parse(args)
+	loadConfig(*configFile)
+	failed := false
+
+	for _, db := range vendorDatabases() {
+		err := download(db.url, filepath.Join(*dataDir, db.file))
+		if err != nil {
+			fmt.Println("Cannot download", db.file+":", err)
+			failed = true
+		}
+	}
+
+	for _, file := range leafletFiles {
+		err := download(leafletURL+file, filepath.Join(leafletDir(), filepath.FromSlash(file)))
+		if err != nil {
+			fmt.Println("Cannot download Leaflet:", err)
+			failed = true
+		}
+	}
+
+	if *bbox != "" {
+		var box [4]float64
+		parts := strings.Split(*bbox, ",")
+		if len(parts) != 4 {
+			fmt.Println("Invalid bbox, use west,south,east,north")
+			os.Exit(1)
+		}
+		for i, part := range parts {
+			n, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+			if err != nil {
+				fmt.Println("Invalid bbox, use west,south,east,north")
+				os.Exit(1)
+			}
+			box[i] = n
+		}
+		if box[0] >= box[2] || box[1] >= box[3] {
+			fmt.Println("Invalid bbox, west must be less than east and south less than north")
+			os.Exit(1)
+		}
+		from, to := 0, 0
+		_, err := fmt.Sscanf(*zooms, "%d-%d", &from, &to)
+		if err != nil {
+			from, err = strconv.Atoi(*zooms)
+			to = from
+		}
+		if err != nil || from < 0 || to > maxTileZoom || from > to {
+			fmt.Println("Invalid zoom, use ie 10-16 with levels up to", maxTileZoom)
+			os.Exit(1)
+		}
+		total := 0
+		for z := from; z <= to; z++ {
+			minX, minY, maxX, maxY := tileRange(box[0], box[1], box[2], box[3], z)
+			total += (maxX - minX + 1) * (maxY - minY + 1)
+		}
+		if total > maxBundleTiles {
+			fmt.Println("That is", total, "tiles, more than", maxBundleTiles, "- use a smaller area or fewer zoom levels")
+			os.Exit(1)
+		}
+		fmt.Println("Downloading", total, "map tiles")
+		done := 0
+		for z := from; z <= to; z++ {
+			minX, minY, maxX, maxY := tileRange(box[0], box[1], box[2], box[3], z)
+			for x := minX; x <= maxX; x++ {
+				for y := minY; y <= maxY; y++ {
+					path := tilePath(z, x, y)
+					if info, err := os.Stat(path); err == nil && time.Since(info.ModTime()) < tileMaxAge {
+						done++
+						continue
+					}
+					err := fetchTile(z, x, y, path)
+					if err != nil {
+						fmt.Println("Cannot download tile", z, x, y, err)
+						failed = true
+						continue
+					}
+					done++
+				}
+			}
+		}
+		fmt.Println("Downloaded", done, "of", total, "map tiles")
+	}
+
+	if failed {
+		os.Exit(1)
+	}
+	fmt.Println("Bundled into", *dataDir+", run netnet with -offline in the field")
+}
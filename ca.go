@@ -0,0 +1,244 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// the CA netnet ca makes is kept in the data directory, with the certificates it issued
+func caDir() string {
+	return filepath.Join(*dataDir, "ca")
+}
+
+// make a CA for the sensors and issue certificates with it, so every sensor has its own certificate the central
+// server can check instead of sharing an API key
+//
+//	netnet ca init                       make the CA
+//	netnet ca sensor <name>              certificate for a sensor to send data with, the name is its sensor ID
+//	netnet ca server <host> [<host>...]  certificate for the central server, for sensors to check with the CA
+func certificateAuthority(args []string) {
+	flags := flag.NewFlagSet("ca", flag.ExitOnError)
+	days := flags.Int("days", 0, "days the certificate is valid, defaults to 10 years for the CA and 1 year for the others")
+	flags.Usage = func() {
+		fmt.Println("Usage: netnet ca [-days n] init | sensor <name> | server <host>...")
+		flags.PrintDefaults()
+	}
+	if len(args) == 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	command := args[0]
+	flags.Parse(args[1:])
+	var err error
+	switch {
+	case command == "init" && flags.NArg() == 0:
+		err = caInit(*days)
+	case command == "sensor" && flags.NArg() == 1:
+		err = caIssue(flags.Arg(0), nil, *days)
+	case command == "server" && flags.NArg() > 0:
+		err = caIssue(flags.Arg(0), flags.Args(), *days)
+	default:
+		flags.Usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Println("Cannot", command, "CA:", err)
+		os.Exit(1)
+	}
+}
+
+// make the CA key and self-signed certificate, an existing CA is never replaced as every certificate it issued
+// would stop working
+func caInit(days int) error {
+	if days == 0 {
+		days = 10 * 365
+	}
+	certFile := filepath.Join(caDir(), "ca.crt")
+	if _, err := os.Stat(certFile); err == nil {
+		return errors.New(certFile + " already exists")
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	template := &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "netnet sensor CA"},
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+	der, err := signCertificate(template, &key.PublicKey, template, key, days)
+	if err != nil {
+		return err
+	}
+	if err = writeKeyPair("ca", der, key); err != nil {
+		return err
+	}
+	fmt.Println("Created CA", certFile)
+	fmt.Println("Start the central server with -sensor-ca", certFile)
+	return nil
+}
+
+// issue a certificate signed by the CA, for a server when there are hosts and for a sensor when there are none
+func caIssue(name string, hosts []string, days int) error {
+	if days == 0 {
+		days = 365
+	}
+	if name == "" || name == "ca" || strings.ContainsAny(name, `/\`) {
+		return fmt.Errorf("invalid name %q", name)
+	}
+	caCert, caKey, err := loadCA()
+	if err != nil {
+		return err
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	template := &x509.Certificate{
+		Subject:     pkix.Name{CommonName: name},
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	if len(hosts) > 0 {
+		template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+		for _, host := range hosts {
+			if ip := net.ParseIP(host); ip != nil {
+				template.IPAddresses = append(template.IPAddresses, ip)
+			} else {
+				template.DNSNames = append(template.DNSNames, host)
+			}
+		}
+	}
+	der, err := signCertificate(template, &key.PublicKey, caCert, caKey, days)
+	if err != nil {
+		return err
+	}
+	if err = writeKeyPair(name, der, key); err != nil {
+		return err
+	}
+	cert := filepath.Join(caDir(), name+".crt")
+	keyFile := filepath.Join(caDir(), name+".key")
+	if len(hosts) > 0 {
+		fmt.Printf("Issued server certificate %s with key %s, serial %x\n", cert, keyFile, template.SerialNumber)
+		fmt.Println("Start the central server with -tls-cert", cert, "-tls-key", keyFile)
+		return nil
+	}
+	fmt.Printf("Issued sensor certificate %s with key %s, serial %x\n", cert, keyFile, template.SerialNumber)
+	fmt.Println("Copy them to the sensor with", filepath.Join(caDir(), "ca.crt"), "and start it with -central https://<server>",
+		"-sensor-cert", name+".crt", "-sensor-key", name+".key", "-central-ca ca.crt")
+	return nil
+}
+
+func signCertificate(template *x509.Certificate, pub *ecdsa.PublicKey, parent *x509.Certificate, signer *ecdsa.PrivateKey, days int) ([]byte, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+	template.SerialNumber = serial
+	// a bit in the past for clocks that are a bit behind
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().AddDate(0, 0, days)
+	return x509.CreateCertificate(rand.Reader, template, parent, pub, signer)
+}
+
+// write the certificate and its key into the CA directory as name.crt and name.key
+func writeKeyPair(name string, der []byte, key *ecdsa.PrivateKey) error {
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(caDir(), 0700); err != nil {
+		return err
+	}
+	err = ioutil.WriteFile(filepath.Join(caDir(), name+".key"), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(caDir(), name+".crt"), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644)
+}
+
+func loadCA() (*x509.Certificate, *ecdsa.PrivateKey, error) {
+	pair, err := tls.LoadX509KeyPair(filepath.Join(caDir(), "ca.crt"), filepath.Join(caDir(), "ca.key"))
+	if err != nil {
+		return nil, nil, fmt.Errorf("%v, make the CA with netnet ca init", err)
+	}
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return nil, nil, err
+	}
+	key, ok := pair.PrivateKey.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, nil, errors.New("CA key is not an ECDSA key")
+	}
+	return cert, key, nil
+}
+
+// read a file of PEM certificates into a pool
+func loadCertPool(file string) (*x509.CertPool, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, errors.New("no certificates in " + file)
+	}
+	return pool, nil
+}
+
+// the TLS settings of the server, asking for the certificates of sensors when there is a sensor CA. Browsers
+// and API clients without a certificate can still connect and log in or use an API key.
+func serverTLSConfig() *tls.Config {
+	tlsConfig := &tls.Config{}
+	if *sensorCA == "" {
+		return tlsConfig
+	}
+	pool, err := loadCertPool(*sensorCA)
+	if err != nil {
+		fmt.Println("Cannot load sensor CA:", err)
+		os.Exit(1)
+	}
+	tlsConfig.ClientCAs = pool
+	tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	return tlsConfig
+}
+
+// the sensor ID in the certificate the sensor connected with, empty if it has no certificate the sensor CA
+// issued or the certificate was revoked
+func sensorCertID(r *http.Request) string {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+		return ""
+	}
+	cert := r.TLS.VerifiedChains[0][0]
+	for _, usage := range cert.ExtKeyUsage {
+		if usage != x509.ExtKeyUsageClientAuth {
+			continue
+		}
+		serial := fmt.Sprintf("%x", cert.SerialNumber)
+		for _, revoked := range config.RevokedCerts {
+			if strings.EqualFold(strings.ReplaceAll(revoked, ":", ""), serial) {
+				return ""
+			}
+		}
+		return cert.Subject.CommonName
+	}
+	return ""
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"time"
+)
+
+// iCalendar times are in UTC
+const icsTime = "20060102T150405Z"
+
+// escape text for an iCalendar property value
+func icsEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\n", `\n`).Replace(s)
+}
+
+// fold iCalendar lines longer than 75 octets and end them with CRLF
+func icsLine(b *strings.Builder, line string) {
+	for len(line) > 75 {
+		// don't cut a UTF-8 character in half
+		cut := 75
+		for cut > 0 && line[cut]&0xC0 == 0x80 {
+			cut--
+		}
+		b.WriteString(line[:cut] + "\r\n ")
+		line = line[cut:]
+	}
+	b.WriteString(line + "\r\n")
+}
+
+// the presence sessions of a device as an iCalendar feed, one event per session
+func sessionsCalendar(mac, name string, sessions []Session) string {
+	var b strings.Builder
+	now := time.Now().UTC().Format(icsTime)
+	icsLine(&b, "BEGIN:VCALENDAR")
+	icsLine(&b, "VERSION:2.0")
+	icsLine(&b, "PRODID:-//netnet//presence sessions//EN")
+	icsLine(&b, "X-WR-CALNAME:"+icsEscape(name+" presence"))
+	for _, s := range sessions {
+		icsLine(&b, "BEGIN:VEVENT")
+		icsLine(&b, "UID:"+mac+"-"+s.Start.UTC().Format(icsTime)+"@netnet")
+		icsLine(&b, "DTSTAMP:"+now)
+		icsLine(&b, "DTSTART:"+s.Start.UTC().Format(icsTime))
+		icsLine(&b, "DTEND:"+s.End.UTC().Format(icsTime))
+		icsLine(&b, "SUMMARY:"+icsEscape(name+" present"))
+		icsLine(&b, "END:VEVENT")
+	}
+	icsLine(&b, "END:VCALENDAR")
+	return b.String()
+}
+
+// presence sessions of a device at /device/{mac}/sessions.ics, to subscribe to in a calendar app
+func deviceCalendar(w http.ResponseWriter, r *http.Request, mac string) {
+	history := getHistory(mac)
+	if len(history) == 0 {
+		http.Error(w, "Device "+mac+" not found", http.StatusNotFound)
+		return
+	}
+	name := formatMAC(mac)
+	if c := findClient(mac); c != nil && c.Alias != "" {
+		name = c.Alias
+	} else if ap := findAccessPoint(mac); ap != nil && ap.Alias != "" {
+		name = ap.Alias
+	}
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.Write([]byte(sessionsCalendar(mac, name, getSessions(history))))
+}
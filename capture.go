@@ -0,0 +1,201 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// how long to wait before starting airodump-ng again after it stopped
+const captureRestart = 10 * time.Second
+
+// CaptureConfig is an airodump-ng netnet runs itself, ie one for 2.4GHz and one for 5GHz, or one per adapter
+type CaptureConfig struct {
+	Name      string   `json:"name"`      // also the sensor ID, defaults to the interface
+	Interface string   `json:"interface"` // monitor mode interface
+	Band      string   `json:"band"`      // airodump-ng --band, bg for 2.4GHz and a for 5GHz, every band the adapter has if empty
+	Channels  string   `json:"channels"`  // airodump-ng --channel, ie for 6GHz channels, instead of the band
+	Command   string   `json:"command"`   // defaults to airodump-ng
+	Args      []string `json:"args"`      // more arguments for airodump-ng
+	Profile   string   `json:"profile"`   // CSV profile of the files the command writes, ie for an airodump-ng fork
+}
+
+// CaptureStatus is the health of an airodump-ng netnet runs, shown in /status
+type CaptureStatus struct {
+	Name      string    `json:"name"`
+	Interface string    `json:"interface"`
+	Band      string    `json:"band,omitempty"`
+	Channels  string    `json:"channels,omitempty"`
+	Running   bool      `json:"running"`
+	Started   time.Time `json:"started"`
+	Restarts  int       `json:"restarts"`
+	Error     string    `json:"error,omitempty"` // why it last stopped
+	File      string    `json:"file"`
+	Written   time.Time `json:"written"`
+	Problem   string    `json:"problem,omitempty"`
+	APs       int       `json:"aps"`
+	Clients   int       `json:"clients"`
+}
+
+var captureStatus = make(map[string]*CaptureStatus)
+var captureStatusMutex sync.RWMutex
+
+// the running airodump-ng processes, to restart them when the channels change
+var captureProcesses = make(map[string]*os.Process)
+
+// band and channels for every airodump-ng from the central server, instead of those in the configuration
+var captureBand, captureChannels string
+
+func (c CaptureConfig) name() string {
+	if c.Name != "" {
+		return c.Name
+	}
+	return c.Interface
+}
+
+// airodump-ng writes prefix-01.csv, prefix-02.csv and so on, a new one every time it starts
+func (c CaptureConfig) prefix() string {
+	return filepath.Join(*dataDir, "capture", c.name())
+}
+
+// the newest file airodump-ng wrote with the extension, empty if there is none
+func (c CaptureConfig) latestFile(ext string) string {
+	prefix := c.prefix()
+	files, _ := filepath.Glob(prefix + "-*" + ext)
+	var latest []string
+	for _, file := range files {
+		// only the files of this capture, not those of another one with a longer name
+		number := strings.TrimSuffix(strings.TrimPrefix(file, prefix+"-"), ext)
+		if number != "" && strings.Trim(number, "0123456789") == "" {
+			latest = append(latest, file)
+		}
+	}
+	if len(latest) == 0 {
+		return ""
+	}
+	sort.Slice(latest, func(i, j int) bool {
+		return len(latest[i]) < len(latest[j]) || (len(latest[i]) == len(latest[j]) && latest[i] < latest[j])
+	})
+	return latest[len(latest)-1]
+}
+
+// start every airodump-ng in the configuration
+func startCaptures() {
+	for _, c := range config.Capture {
+		captureStatusMutex.Lock()
+		captureStatus[c.name()] = &CaptureStatus{Name: c.name(), Interface: c.Interface, Band: c.Band, Channels: c.Channels}
+		captureStatusMutex.Unlock()
+		go runCapture(c)
+	}
+}
+
+// run airodump-ng, starting it again whenever it stops
+func runCapture(c CaptureConfig) {
+	command := c.Command
+	if command == "" {
+		command = "airodump-ng"
+	}
+	check(os.MkdirAll(filepath.Dir(c.prefix()), 0700), "Cannot create capture directory:")
+	for {
+		captureStatusMutex.Lock()
+		band, channels := c.Band, c.Channels
+		if captureBand != "" || captureChannels != "" {
+			band, channels = captureBand, captureChannels
+		}
+		captureStatusMutex.Unlock()
+		args := []string{"--write", c.prefix(), "--output-format", "csv,pcap", "--write-interval", "5", "--background", "1"}
+		if channels != "" {
+			args = append(args, "--channel", channels)
+		} else if band != "" {
+			args = append(args, "--band", band)
+		}
+		args = append(append(args, c.Args...), c.Interface)
+		cmd := exec.Command(command, args...)
+		err := cmd.Start()
+		captureStatusMutex.Lock()
+		status := captureStatus[c.name()]
+		status.Started, status.Running = time.Now(), err == nil
+		status.Band, status.Channels = band, channels
+		if err == nil {
+			captureProcesses[c.name()] = cmd.Process
+		}
+		captureStatusMutex.Unlock()
+		if err == nil {
+			err = cmd.Wait()
+		}
+		fmt.Println("airodump-ng", c.name(), "stopped:", err)
+		captureStatusMutex.Lock()
+		delete(captureProcesses, c.name())
+		status.Running, status.Restarts = false, status.Restarts+1
+		if err != nil {
+			status.Error = err.Error()
+		}
+		captureStatusMutex.Unlock()
+		time.Sleep(captureRestart)
+	}
+}
+
+// get the access points and clients from the newest files of every airodump-ng, each is a sensor of its own
+func collectCaptures() (aps []AccessPoint, clients []Client) {
+	var problems []string
+	for _, c := range config.Capture {
+		file := c.latestFile(".csv")
+		batch, _ := airodumpSource(c.name(), FormatCSV, c.Profile, func() string { return file }).Poll()
+		written, problem, a, cl := batch.Written, batch.Problem, batch.APs, batch.Clients
+		observedBy(c.name(), written, problem, a, cl)
+		if problem != "" {
+			problems = append(problems, c.name()+" "+problem)
+		}
+		captureStatusMutex.Lock()
+		if status, ok := captureStatus[c.name()]; ok {
+			status.File, status.Written, status.Problem = file, written, problem
+			status.APs, status.Clients = len(a), len(cl)
+		}
+		captureStatusMutex.Unlock()
+		aps, clients = append(aps, a...), append(clients, cl...)
+	}
+	// the sensor problem of the whole parse
+	setSensorProblem("", strings.Join(problems, ", "))
+	return mergeAPs(aps), mergeClients(clients)
+}
+
+// the newest .cap file of every airodump-ng
+func managedCaptureFiles() (files []string) {
+	for _, c := range config.Capture {
+		if file := c.latestFile(".cap"); file != "" {
+			files = append(files, file)
+		}
+	}
+	return
+}
+
+// get the health of every airodump-ng netnet runs
+func getCaptureStatus() []CaptureStatus {
+	captureStatusMutex.RLock()
+	defer captureStatusMutex.RUnlock()
+	var results []CaptureStatus
+	for _, c := range config.Capture {
+		if status, ok := captureStatus[c.name()]; ok {
+			results = append(results, *status)
+		}
+	}
+	return results
+}
+
+// capture on other channels, or the ones in the configuration again when both are empty, restarting every airodump-ng
+func setCaptureChannels(band, channels string) {
+	captureStatusMutex.Lock()
+	defer captureStatusMutex.Unlock()
+	if band == captureBand && channels == captureChannels {
+		return
+	}
+	captureBand, captureChannels = band, channels
+	for _, p := range captureProcesses {
+		p.Kill()
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ClockConfig is how the clocks of the sensors are checked and corrected
+type ClockConfig struct {
+	MaxSkew     float64            `json:"max_skew"`     // seconds a sensor clock can be off before it is flagged, defaults to 60
+	AutoCorrect bool               `json:"auto_correct"` // shift the timestamps of flagged sensors by the skew
+	Offsets     map[string]float64 `json:"offsets"`      // seconds to add to the timestamps of a sensor, by sensor ID
+}
+
+// Sensor is where observations come from, ie an airodump-ng CSV file or a scanner
+type Sensor struct {
+	ID         string    `json:"id"`
+	Collector  string    `json:"collector"`
+	LastParsed time.Time `json:"last_parsed"`
+	Written    time.Time `json:"written"` // when the sensor wrote the data, by the server clock
+	Newest     time.Time `json:"newest"`  // newest timestamp in the data, by the sensor clock
+	Skew       float64   `json:"skew"`    // seconds the sensor clock is ahead of the server, negative if behind
+	Skewed     bool      `json:"skewed"`
+	Problem    string    `json:"problem,omitempty"` // why the sensor isn't capturing, ie file missing
+	Offset     float64   `json:"offset"`            // seconds added to the timestamps of the sensor
+	APs        int       `json:"aps"`
+	Clients    int       `json:"clients"`
+}
+
+var sensors = make(map[string]*Sensor)
+var sensorsMutex sync.RWMutex
+
+// what was wrong with the sensor in the last parse, empty if it was capturing
+var lastSensorProblem string
+
+// set the problem of a sensor, which is also the problem of the whole parse, a parse with several sensors
+// sets it again with an empty ID once every sensor was collected
+func setSensorProblem(id, problem string) {
+	sensorsMutex.Lock()
+	defer sensorsMutex.Unlock()
+	if s, ok := sensors[id]; ok {
+		s.Problem = problem
+	}
+	lastSensorProblem = problem
+}
+
+func sensorProblem() string {
+	sensorsMutex.RLock()
+	defer sensorsMutex.RUnlock()
+	return lastSensorProblem
+}
+
+// measure how far off the clock of a sensor is and shift its timestamps by the offset
+//
+// A sensor writes down when it last saw each device, so while it is capturing the newest of those
+// is close to when the data was written. A sensor that hasn't seen anything for a while looks
+// like its clock is behind.
+func correctClock(id string, written time.Time, aps []AccessPoint, clients []Client) {
+	settings := config.Clock
+	if settings.MaxSkew <= 0 {
+		settings.MaxSkew = 60
+	}
+	var newest time.Time
+	for _, ap := range aps {
+		if ap.LastSeen.After(newest) {
+			newest = ap.LastSeen
+		}
+	}
+	for _, c := range clients {
+		if c.LastSeen.After(newest) {
+			newest = c.LastSeen
+		}
+	}
+	s := &Sensor{ID: id, Collector: *collector, LastParsed: time.Now(), Written: written, Newest: newest, APs: len(aps), Clients: len(clients)}
+	if !newest.IsZero() {
+		s.Skew = math.Round(newest.Sub(written).Seconds())
+		s.Skewed = math.Abs(s.Skew) > settings.MaxSkew
+	}
+	if offset, ok := settings.Offsets[id]; ok {
+		s.Offset = offset
+	} else if settings.AutoCorrect && s.Skewed {
+		s.Offset = -s.Skew
+	}
+	sensorsMutex.Lock()
+	sensors[id] = s
+	sensorsMutex.Unlock()
+	if s.Offset == 0 {
+		return
+	}
+	shift := time.Duration(s.Offset * float64(time.Second))
+	for i := range aps {
+		aps[i].FirstSeen = aps[i].FirstSeen.Add(shift)
+		aps[i].LastSeen = aps[i].LastSeen.Add(shift)
+	}
+	for i := range clients {
+		clients[i].FirstSeen = clients[i].FirstSeen.Add(shift)
+		clients[i].LastSeen = clients[i].LastSeen.Add(shift)
+	}
+}
+
+func listSensors() []Sensor {
+	sensorsMutex.RLock()
+	defer sensorsMutex.RUnlock()
+	list := []Sensor{}
+	for _, s := range sensors {
+		list = append(list, *s)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list
+}
+
+// the sensors netnet gets data from at /sensors, with their clock skew
+func sensorsHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, listSensors())
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// a sensor that hasn't written its data for this long is down, airodump-ng writes every 5 seconds by default
+const sensorStale = time.Minute
+
+// get the access points and clients from the configured collector and the data sources, with the sensor clocks corrected,
+// the frames read from the capture file since the last parse are used by the collectors that capture frames
+func collect(frames []Frame) (aps []AccessPoint, clients []Client) {
+	sensor, written, problem := *collector, time.Now(), ""
+	switch *collector {
+	case "netsh":
+		aps, clients = collectNetsh()
+	case "airport":
+		aps, clients, problem = collectAirport()
+	case "airodump":
+		if len(config.Capture) > 0 {
+			aps, clients = collectCaptures()
+			return collectSources(aps, clients, sensorProblem())
+		}
+		batch, _ := airodumpSource(filepath.Base(*csvFile), *inputFormat, *csvProfile, func() string { return *csvFile }).Poll()
+		sensor, written, problem = batch.Source, batch.Written, batch.Problem
+		aps, clients = batch.APs, batch.Clients
+	case "hcxdumptool":
+		sensor = filepath.Base(hcxdumptoolFile())
+		written, problem = fileProblem(hcxdumptoolFile())
+		aps, clients = collectHcxdumptool(frames)
+	case "pipe":
+		problem = pipeProblem()
+		aps, clients = collectFrames(frames)
+	case "live":
+		sensor, problem = *liveInterface, pipeProblem()
+		aps, clients = collectFrames(frames)
+	default:
+		fmt.Println("Unknown collector:", *collector)
+		setSensorProblem(sensor, "unknown collector")
+		return
+	}
+	// a scan always finds at least the access point the computer is connected to
+	if (*collector == "netsh" || *collector == "airport") && len(aps) == 0 && problem == "" {
+		problem = "nothing found"
+	}
+	observedBy(sensor, written, problem, aps, clients)
+	return collectSources(aps, clients, problem)
+}
+
+// check that a sensor is still writing its file, returns when it was last written
+func fileProblem(file string) (written time.Time, problem string) {
+	info, err := os.Stat(file)
+	if file == "" || err != nil {
+		return time.Now(), "file missing"
+	}
+	if written = info.ModTime(); time.Since(written) > sensorStale {
+		problem = "file not updated"
+	}
+	return
+}
+
+// mark the access points and clients as observed by the sensor, with its clock corrected
+func observedBy(sensor string, written time.Time, problem string, aps []AccessPoint, clients []Client) {
+	correctClock(sensor, written, aps, clients)
+	setSensorProblem(sensor, problem)
+	for i := range aps {
+		aps[i].Source = sensor
+	}
+	for i := range clients {
+		clients[i].Source = sensor
+	}
+}
+
+// first time each access point was seen by a scanning collector, scans only show what is visible now
+var firstSeen = make(map[string]time.Time)
+
+func scanFirstSeen(mac string, now time.Time) time.Time {
+	t, ok := firstSeen[mac]
+	if !ok {
+		t = now
+		firstSeen[mac] = now
+	}
+	return t
+}
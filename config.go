@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+)
+
+// Config is the optional JSON configuration file for the settings that don't fit into flags
+type Config struct {
+	Watchlist    []string          `json:"watchlist"`  // MAC addresses to look out for
+	MySSIDs      []string          `json:"my_ssids"`   // SSIDs we own, any other BSSID broadcasting them is a rogue AP
+	MyBSSIDs     []string          `json:"my_bssids"`  // BSSIDs of the APs we own
+	MyClients    []string          `json:"my_clients"` // clients allowed on our APs, others are reported the first time they join
+	Hooks        []Hook            `json:"hooks"`
+	Webhooks     []Webhook         `json:"webhooks"`
+	Enrichers    []Plugin          `json:"enrichers"`
+	Notifiers    []Plugin          `json:"notifiers"`
+	Occupancy    OccupancyConfig   `json:"occupancy"`
+	Heatmap      HeatmapConfig     `json:"heatmap"`
+	Ghosts       GhostConfig       `json:"ghosts"`
+	Clock        ClockConfig       `json:"clock"`
+	Karma        KarmaConfig       `json:"karma"`
+	Deauth       DeauthConfig      `json:"deauth"`
+	Hcxdumptool  HcxdumptoolConfig `json:"hcxdumptool"`
+	Capture      []CaptureConfig   `json:"capture"`      // airodump-ng instances netnet runs itself
+	Sources      []SourceConfig    `json:"sources"`      // more data sources merged with what the collector finds
+	CSVProfiles  []CSVProfile      `json:"csv_profiles"` // columns of CSV files airodump-ng versions and forks write elsewhere
+	Pipeline     PipelineConfig    `json:"pipeline"`
+	Map          MapConfig         `json:"map"`
+	Vendors      VendorsConfig     `json:"vendors"`
+	HTTP         HTTPConfig        `json:"http"`
+	Directory    DirectoryConfig   `json:"directory"`     // LDAP or Active Directory server the users of devices are looked up in
+	People       PeopleConfig      `json:"people"`        // how the users of devices are shown
+	NetBox       NetBoxConfig      `json:"netbox"`        // NetBox server to pull the inventory from and push the devices present to
+	RADIUS       RADIUSConfig      `json:"radius"`        // RADIUS accounting telling who uses which client
+	Risk         RiskConfig        `json:"risk"`          // how the risk scores of the devices are worked out
+	Fleet        FleetConfig       `json:"fleet"`         // settings this server gives the sensors sending it data
+	RevokedCerts []string          `json:"revoked_certs"` // serial numbers of sensor certificates that aren't accepted any more
+	Proxy        string            `json:"proxy"`         // HTTP(S) proxy for downloading the vendor databases, Leaflet and map tiles
+}
+
+var config Config
+
+// load the configuration file, a missing file just means an empty configuration
+func loadConfig(file string) {
+	if file == "" {
+		return
+	}
+	data, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return
+	}
+	if err != nil {
+		fmt.Println("Cannot read config file:", err)
+		return
+	}
+	err = json.Unmarshal(data, &config)
+	if err != nil {
+		fmt.Println("Cannot parse config file:", err)
+	}
+}
+
+// check if a MAC address is in a list of MAC addresses
+func containsMAC(macs []string, mac string) bool {
+	for _, m := range macs {
+		if normalizeMAC(m) == mac {
+			return true
+		}
+	}
+	return false
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// number of days of coverage kept
+const coverageDays = 90
+
+// Gap is a period of time when nothing was captured
+type Gap struct {
+	Start  time.Time `json:"start"`
+	End    time.Time `json:"end"`
+	Reason string    `json:"reason"` // ie paused, file missing or netnet not running
+}
+
+// CoverageDay is how much of a day was monitored
+type CoverageDay struct {
+	Date      string  `json:"date"`
+	Monitored float64 `json:"monitored"` // seconds
+	Coverage  float64 `json:"coverage"`  // percent of the day, or of the day so far for today
+	Gaps      []Gap   `json:"gaps"`
+}
+
+var coverage = struct {
+	LastTick time.Time               `json:"last_tick"`
+	Days     map[string]*CoverageDay `json:"days"`
+}{Days: make(map[string]*CoverageDay)}
+var coverageMutex sync.Mutex
+var coverageSaved time.Time
+
+func loadCoverage() {
+	coverageMutex.Lock()
+	defer coverageMutex.Unlock()
+	coverage.LastTick = time.Time{}
+	coverage.Days = make(map[string]*CoverageDay)
+	check(loadJSON("coverage.json", &coverage), "Cannot load coverage:")
+}
+
+// account for the time since the last parse as monitored, or as a gap if there was a problem,
+// which is the time netnet wasn't running if it was too long ago
+func recordCoverage(problem string) {
+	coverageMutex.Lock()
+	defer coverageMutex.Unlock()
+	now := time.Now()
+	if !coverage.LastTick.IsZero() && now.After(coverage.LastTick) {
+		if now.Sub(coverage.LastTick) > 3*refreshInterval() {
+			problem = "netnet not running"
+		}
+		addCoverage(coverage.LastTick, now, problem)
+	}
+	coverage.LastTick = now
+	if time.Since(coverageSaved) >= metaSaveInterval {
+		coverageSaved = now
+		check(saveJSON("coverage.json", coverage), "Cannot save coverage:")
+	}
+}
+
+// add a period of time to the days it falls in
+func addCoverage(start, end time.Time, problem string) {
+	for start.Before(end) {
+		y, m, d := start.Date()
+		midnight := time.Date(y, m, d+1, 0, 0, 0, 0, start.Location())
+		until := end
+		if midnight.Before(end) {
+			until = midnight
+		}
+		date := start.Format("2006-01-02")
+		day, ok := coverage.Days[date]
+		if !ok {
+			day = &CoverageDay{Date: date, Gaps: []Gap{}}
+			coverage.Days[date] = day
+		}
+		if problem == "" {
+			day.Monitored += until.Sub(start).Seconds()
+		} else if n := len(day.Gaps); n > 0 && day.Gaps[n-1].Reason == problem && day.Gaps[n-1].End.Equal(start) {
+			day.Gaps[n-1].End = until
+		} else {
+			day.Gaps = append(day.Gaps, Gap{Start: start, End: until, Reason: problem})
+		}
+		start = until
+	}
+	// forget the oldest days
+	cutoff := time.Now().AddDate(0, 0, -coverageDays).Format("2006-01-02")
+	for date := range coverage.Days {
+		if date < cutoff {
+			delete(coverage.Days, date)
+		}
+	}
+}
+
+// coverage of the last 7 days, or ?days=, at /coverage
+func coverageHandler(w http.ResponseWriter, r *http.Request) {
+	days := 7
+	if n, err := strconv.Atoi(r.URL.Query().Get("days")); err == nil && n > 0 {
+		days = n
+	}
+	now := time.Now()
+	since := now.AddDate(0, 0, -days+1).Format("2006-01-02")
+	coverageMutex.Lock()
+	list := []CoverageDay{}
+	for date, day := range coverage.Days {
+		if date < since {
+			continue
+		}
+		c := *day
+		c.Gaps = append([]Gap{}, day.Gaps...)
+		list = append(list, c)
+	}
+	coverageMutex.Unlock()
+	for i := range list {
+		start, _ := time.ParseInLocation("2006-01-02", list[i].Date, now.Location())
+		end := start.AddDate(0, 0, 1)
+		if end.After(now) {
+			end = now
+		}
+		if length := end.Sub(start).Seconds(); length > 0 {
+			list[i].Coverage = math.Min(100, math.Round(list[i].Monitored/length*1000)/10)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Date < list[j].Date })
+	writeJSON(w, list)
+}
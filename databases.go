@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// EventStaleDatabase is raised when the vendor databases get too old to trust the vendors of new devices
+const EventStaleDatabase = "stale_database"
+
+// vendor databases older than this many days are stale, unless the configuration says otherwise
+const defaultDatabaseMaxAge = 180
+
+// how often the age of the vendor databases is checked
+const databaseCheckInterval = time.Hour
+
+// DatabaseStatus is the age of a vendor database, shown in /status
+type DatabaseStatus struct {
+	Name    string    `json:"name"`
+	Updated time.Time `json:"updated"` // when the file was last written
+	Age     int       `json:"age_days"`
+	Stale   bool      `json:"stale"`
+}
+
+var databaseUpdated = make(map[string]time.Time)
+var databaseMutex sync.Mutex
+
+// remember when a vendor database that was loaded was last written
+func recordDatabase(name string, file *os.File) {
+	info, err := file.Stat()
+	if err != nil {
+		return
+	}
+	databaseMutex.Lock()
+	databaseUpdated[name] = info.ModTime()
+	databaseMutex.Unlock()
+}
+
+func databaseMaxAge() int {
+	if config.Vendors.MaxAge != 0 {
+		return config.Vendors.MaxAge
+	}
+	return defaultDatabaseMaxAge
+}
+
+// the age of every vendor database that was loaded, by name
+func getDatabaseStatus() []DatabaseStatus {
+	databaseMutex.Lock()
+	defer databaseMutex.Unlock()
+	maxAge := databaseMaxAge()
+	var list []DatabaseStatus
+	for name, updated := range databaseUpdated {
+		age := int(time.Since(updated).Hours() / 24)
+		list = append(list, DatabaseStatus{Name: name, Updated: updated, Age: age, Stale: maxAge > 0 && age > maxAge})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// the warnings about stale vendor databases, the vendors of new devices are missing or wrong with them
+func databaseWarnings() (warnings []string) {
+	for _, db := range getDatabaseStatus() {
+		if db.Stale {
+			warnings = append(warnings, fmt.Sprintf("%s is %d days old, the vendors of newer devices can be missing", db.Name, db.Age))
+		}
+	}
+	return
+}
+
+// raise an event when the vendor databases become stale, which is an alert with stale_alert in the configuration
+func watchDatabases() {
+	stale := ""
+	for {
+		warnings := databaseWarnings()
+		now := strings.Join(warnings, ", ")
+		if now != stale && now != "" {
+			fmt.Println("Stale vendor databases:", now)
+			if config.Vendors.StaleAlert {
+				emit([]Event{{Type: EventStaleDatabase, Time: time.Now(), Message: "Stale vendor databases: " + now, Data: getDatabaseStatus()}})
+			}
+		}
+		stale = now
+		time.Sleep(databaseCheckInterval)
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// most deauthentication frames sent in one go, aireplay-ng sends them forever with 0
+const maxDeauthCount = 64
+
+// how long aireplay-ng gets to send the frames
+const deauthTimeout = time.Minute
+
+// DeauthConfig is how aireplay-ng is run for deauthentication, which also needs -allow-deauth
+type DeauthConfig struct {
+	Interface string `json:"interface"` // monitor mode interface, ie the one airodump-ng captures on
+	Command   string `json:"command"`   // defaults to aireplay-ng
+}
+
+// DeauthRequest is the deauthentication asked for, Confirm has to repeat the BSSID
+type DeauthRequest struct {
+	BSSID   string `json:"bssid"`
+	Client  string `json:"client,omitempty"` // every client of the AP if empty
+	Count   int    `json:"count"`            // defaults to 5
+	Confirm string `json:"confirm,omitempty"`
+}
+
+// only one deauthentication at a time
+var deauthMutex sync.Mutex
+
+// POST /admin/deauth sends deauthentication frames with aireplay-ng, for authorized penetration tests only,
+// it needs -allow-deauth and -auth, and every deauthentication run is written to the audit log
+func adminDeauth(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !*allowDeauth || !*authEnabled {
+		http.Error(w, "Deauthentication needs -allow-deauth and -auth", http.StatusForbidden)
+		return
+	}
+	settings := config.Deauth
+	if settings.Interface == "" {
+		http.Error(w, "No deauth interface configured", http.StatusConflict)
+		return
+	}
+	if settings.Command == "" {
+		settings.Command = "aireplay-ng"
+	}
+	var req DeauthRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, "Cannot parse deauth request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	bssid, ok := parseMAC(req.BSSID)
+	if !ok {
+		http.Error(w, "Invalid BSSID "+req.BSSID, http.StatusBadRequest)
+		return
+	}
+	client := ""
+	if req.Client != "" {
+		if client, ok = parseMAC(req.Client); !ok {
+			http.Error(w, "Invalid client "+req.Client, http.StatusBadRequest)
+			return
+		}
+	}
+	if confirm, _ := parseMAC(req.Confirm); confirm != bssid {
+		http.Error(w, "Confirm the deauthentication by repeating the BSSID in confirm", http.StatusBadRequest)
+		return
+	}
+	if req.Count == 0 {
+		req.Count = 5
+	}
+	if req.Count < 0 || req.Count > maxDeauthCount {
+		http.Error(w, "Count has to be between 1 and "+strconv.Itoa(maxDeauthCount), http.StatusBadRequest)
+		return
+	}
+	if !deauthMutex.TryLock() {
+		http.Error(w, "Another deauthentication is running", http.StatusConflict)
+		return
+	}
+	defer deauthMutex.Unlock()
+
+	// aireplay-ng wants MAC addresses with colons
+	args := []string{"--deauth", strconv.Itoa(req.Count), "-a", strings.Replace(bssid, "-", ":", -1)}
+	if client != "" {
+		args = append(args, "-c", strings.Replace(client, "-", ":", -1))
+	}
+	args = append(args, settings.Interface)
+	ctx, cancel := context.WithTimeout(r.Context(), deauthTimeout)
+	defer cancel()
+	var output bytes.Buffer
+	cmd := exec.CommandContext(ctx, settings.Command, args...)
+	cmd.Stdout, cmd.Stderr = &output, &output
+	err = cmd.Run()
+	result := "ok"
+	if err != nil {
+		result = err.Error()
+	}
+	audit(AuditEntry{
+		Time:   time.Now(),
+		Action: "deauth",
+		Who:    requester(r),
+		Remote: r.RemoteAddr,
+		Target: DeauthRequest{BSSID: bssid, Client: client, Count: req.Count},
+		Result: result,
+	})
+	status := http.StatusOK
+	if err != nil {
+		status = http.StatusBadGateway
+	}
+	writeJSONStatus(w, status, struct {
+		Result string `json:"result"`
+		Output string `json:"output"`
+	}{result, output.String()})
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// Device is what is shown on the device detail page
+type Device struct {
+	MAC          string
+	Kind         string
+	Organization string
+	Client       *Client
+	AccessPoint  *AccessPoint
+	Associated   *AccessPoint
+	Probes       []string
+	Sessions     []Session
+	History      []Sample
+}
+
+// look up the organization of a MAC address from the OUI, MA-M and CID databases, then nmap's prefixes,
+// then the online lookup
+func lookupOrganization(mac string) string {
+	if len(mac) < 8 {
+		return ""
+	}
+	if isLocalMAC(mac) {
+		cid := strings.TrimSpace(ciddb[mac[:8]])
+		if cid != "" {
+			return cid
+		}
+		return "LOCAL"
+	}
+	if len(mac) >= 10 {
+		if org := strings.TrimSpace(mamdb[mac[:10]]); org != "" {
+			return org
+		}
+	}
+	if org := strings.TrimSpace(ouidb[mac[:8]]); org != "" {
+		return org
+	}
+	if org := lookupNmap(mac); org != "" {
+		return org
+	}
+	return lookupOnlineVendor(mac)
+}
+
+func findClient(mac string) *Client {
+	for _, client := range store.Clients() {
+		if client.MAC == mac {
+			c := client
+			return &c
+		}
+	}
+	return nil
+}
+
+func findAccessPoint(mac string) *AccessPoint {
+	for _, ap := range store.APs() {
+		if ap.MAC == mac {
+			a := ap
+			return &a
+		}
+	}
+	return nil
+}
+
+// device detail page at /device/{mac}
+func device(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/device/")
+	if strings.HasSuffix(path, "/sessions.ics") {
+		deviceCalendar(w, r, normalizeMAC(strings.TrimSuffix(path, "/sessions.ics")))
+		return
+	}
+	mac := normalizeMAC(path)
+	d := Device{
+		MAC:          mac,
+		Organization: lookupOrganization(mac),
+		Client:       findClient(mac),
+		AccessPoint:  findAccessPoint(mac),
+		History:      getHistory(mac),
+	}
+	if d.Client == nil && d.AccessPoint == nil {
+		d.Client, d.AccessPoint = findSpilled(mac)
+	}
+	if d.Client == nil && d.AccessPoint == nil && len(d.History) == 0 {
+		w.WriteHeader(http.StatusNotFound)
+		t, _ := parseTemplate("error.html")
+		t.Execute(w, "Device "+mac+" not found")
+		return
+	}
+	if d.Client != nil {
+		d.Kind = "Client"
+		d.Organization = d.Client.Organization
+		if d.Client.Associated {
+			d.Associated = findAccessPoint(d.Client.BSSID)
+		}
+		d.Probes = probedSSIDs(*d.Client)
+	}
+	if d.AccessPoint != nil {
+		d.Kind = "Access point"
+	}
+	d.Sessions = getSessions(d.History)
+	t, err := parseTemplate("device.html")
+	if err != nil {
+		t, _ = parseTemplate("error.html")
+		t.Execute(w, err)
+		return
+	}
+	t.Execute(w, d)
+}
+
+// routes under /clients/{mac}
+func clientRoutes(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/clients/"), "/"), "/")
+	mac := normalizeMAC(parts[0])
+	action := ""
+	if len(parts) > 1 {
+		action = parts[1]
+	}
+	switch action {
+	case "":
+		if r.Method != http.MethodPatch {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		patchDeviceMeta(w, r, mac, false)
+	case "probes":
+		clientProbes(w, r, mac)
+	case "packets-series":
+		clientPacketSeries(w, r, mac)
+	default:
+		http.NotFound(w, r)
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"net/http"
+	"time"
+)
+
+// a change in signal strength smaller than this in dB isn't sent, readings move around by a few dB all the time
+const rssiChangeThreshold = 5
+
+// changes of devices sent at /ws
+const (
+	ChangeNew  = "new"  // seen for the first time
+	ChangeBack = "back" // seen again after it was gone
+	ChangeGone = "gone" // not seen for 5 minutes
+	ChangeRSSI = "rssi" // signal strength changed
+)
+
+// DeviceChange is a change of an access point or client
+type DeviceChange struct {
+	Change    string      `json:"change"` // new, back, gone or rssi
+	Kind      string      `json:"kind"`   // ap or client
+	MAC       string      `json:"mac"`
+	Power     int         `json:"power"`
+	LastPower int         `json:"last_power,omitempty"` // before an rssi change
+	Device    interface{} `json:"device"`               // the access point or client as at /aps or /clients
+}
+
+// DeviceUpdates is the changes found in a parse, sent at /ws
+type DeviceUpdates struct {
+	Time    time.Time      `json:"time"`
+	Changes []DeviceChange `json:"changes"`
+}
+
+var devicesHub = newHub("devices")
+
+// the change of a device from before a parse to after it, if there is one
+func deviceChange(kind, mac string, old *deviceState, now deviceState, device interface{}) (DeviceChange, bool) {
+	c := DeviceChange{Kind: kind, MAC: formatMAC(mac), Power: now.power, Device: device}
+	switch {
+	case old == nil:
+		c.Change = ChangeNew
+	case !isActive(old.lastSeen) && isActive(now.lastSeen):
+		c.Change = ChangeBack
+	case isActive(old.lastSeen) && !isActive(now.lastSeen):
+		c.Change = ChangeGone
+	case isActive(now.lastSeen) && old.power != -1 && now.power != -1 &&
+		(now.power-old.power >= rssiChangeThreshold || old.power-now.power >= rssiChangeThreshold):
+		c.Change, c.LastPower = ChangeRSSI, old.power
+	default:
+		return c, false
+	}
+	return c, true
+}
+
+type deviceState struct {
+	lastSeen time.Time
+	power    int
+}
+
+// the changes of the access points and clients in a parse
+func deviceChanges(oldAPs, aps []AccessPoint, oldClients, clients []Client) []DeviceChange {
+	changes := []DeviceChange{}
+	before := make(map[string]deviceState, len(oldAPs)+len(oldClients))
+	for _, ap := range oldAPs {
+		before[ap.MAC] = deviceState{ap.LastSeen, ap.Power}
+	}
+	for _, c := range oldClients {
+		before[c.MAC] = deviceState{c.LastSeen, c.Power}
+	}
+	state := func(mac string) *deviceState {
+		if s, ok := before[mac]; ok {
+			return &s
+		}
+		return nil
+	}
+	for _, ap := range aps {
+		if c, ok := deviceChange("ap", ap.MAC, state(ap.MAC), deviceState{ap.LastSeen, ap.Power}, ap); ok {
+			changes = append(changes, c)
+		}
+	}
+	for _, client := range clients {
+		if c, ok := deviceChange("client", client.MAC, state(client.MAC), deviceState{client.LastSeen, client.Power}, client); ok {
+			changes = append(changes, c)
+		}
+	}
+	return changes
+}
+
+// send what changed in a parse to the browsers connected to /ws, nothing for the first parse as every device is new
+func sendDeviceUpdates(oldAPs, aps []AccessPoint, oldClients, clients []Client, first bool) {
+	if first || devicesHub.count() == 0 {
+		return
+	}
+	if changes := deviceChanges(oldAPs, aps, oldClients, clients); len(changes) > 0 {
+		devicesHub.broadcastJSON(DeviceUpdates{Time: time.Now(), Changes: changes})
+	}
+}
+
+// the access points and clients that are new, came back, are gone or changed signal strength after every parse at
+// /ws, to keep a dashboard up to date without polling /aps and /clients
+func devicesWebSocket(w http.ResponseWriter, r *http.Request) {
+	devicesHub.serve(w, r)
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// how often the device metadata is saved at most, the counters change on every parse
+const metaSaveInterval = time.Minute
+
+// DeviceMeta is what netnet keeps about a device on top of what is observed, across parses and restarts
+type DeviceMeta struct {
+	Alias         string     `json:"alias,omitempty"`
+	Tags          []string   `json:"tags,omitempty"`
+	FirstSeenEver time.Time  `json:"first_seen_ever"`
+	Sightings     int        `json:"sightings"`     // parses the device was seen anew in
+	TotalPackets  int        `json:"total_packets"` // across airodump-ng restarts, clients only
+	Notes         string     `json:"notes,omitempty"`
+	Ownership     string     `json:"ownership,omitempty"`     // access points only, mine or neighbor, unknown if empty
+	GuestExpires  *time.Time `json:"guest_expires,omitempty"` // when the access of a device tagged guest ends
+}
+
+// ownership of access points
+const (
+	OwnershipMine     = "mine"
+	OwnershipNeighbor = "neighbor"
+	OwnershipUnknown  = "unknown"
+)
+
+var deviceMeta = make(map[string]DeviceMeta)
+var deviceMetaMutex sync.Mutex
+var metaSaved time.Time
+
+func loadDeviceMeta() {
+	deviceMetaMutex.Lock()
+	defer deviceMetaMutex.Unlock()
+	deviceMeta = make(map[string]DeviceMeta)
+	check(loadJSON("devices.json", &deviceMeta), "Cannot load device metadata:")
+}
+
+// save the device metadata if it wasn't saved recently, or right away if forced
+func saveDeviceMeta(force bool) error {
+	deviceMetaMutex.Lock()
+	defer deviceMetaMutex.Unlock()
+	if !force && time.Since(metaSaved) < metaSaveInterval {
+		return nil
+	}
+	metaSaved = time.Now()
+	return saveJSON("devices.json", deviceMeta)
+}
+
+// update the metadata of a device in this parse, fresh if it was seen since the last one
+func observeMeta(mac string, firstSeen time.Time, fresh bool) DeviceMeta {
+	deviceMetaMutex.Lock()
+	defer deviceMetaMutex.Unlock()
+	m := deviceMeta[mac]
+	if m.FirstSeenEver.IsZero() || firstSeen.Before(m.FirstSeenEver) {
+		m.FirstSeenEver = firstSeen
+	}
+	if fresh {
+		m.Sightings++
+	}
+	deviceMeta[mac] = m
+	return m
+}
+
+// add the packets a client sent since the last parse to its total
+func addPackets(c *Client, packets int) {
+	deviceMetaMutex.Lock()
+	defer deviceMetaMutex.Unlock()
+	m := deviceMeta[c.MAC]
+	m.TotalPackets += packets
+	deviceMeta[c.MAC] = m
+	c.TotalPackets = m.TotalPackets
+}
+
+// set the alias, tags and notes of a device with PATCH, ie {"alias": "Printer", "tags": ["office"]},
+// the ownership of an access point, and when the access of a guest ends with {"guest_for": "8h"} or
+// {"guest_expires": "2024-05-01T18:00:00Z"}, which tag it as a guest, "" clears it
+func patchDeviceMeta(w http.ResponseWriter, r *http.Request, mac string, isAP bool) {
+	var patch struct {
+		Alias        *string   `json:"alias"`
+		Tags         *[]string `json:"tags"`
+		Notes        *string   `json:"notes"`
+		Ownership    *string   `json:"ownership"`
+		GuestExpires *string   `json:"guest_expires"`
+		GuestFor     *string   `json:"guest_for"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&patch)
+	if err != nil {
+		http.Error(w, "Cannot parse metadata: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	var guestExpires *time.Time
+	switch {
+	case patch.GuestFor != nil && *patch.GuestFor != "":
+		d, err := time.ParseDuration(*patch.GuestFor)
+		if err != nil || d <= 0 {
+			http.Error(w, "Invalid guest_for duration: "+*patch.GuestFor, http.StatusBadRequest)
+			return
+		}
+		t := time.Now().Add(d)
+		guestExpires = &t
+	case patch.GuestExpires != nil && *patch.GuestExpires != "":
+		t, err := time.Parse(time.RFC3339, *patch.GuestExpires)
+		if err != nil {
+			http.Error(w, "Invalid guest_expires time: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		guestExpires = &t
+	}
+	if patch.Ownership != nil {
+		switch {
+		case !isAP:
+			http.Error(w, "Only access points have an ownership", http.StatusBadRequest)
+			return
+		case *patch.Ownership == OwnershipUnknown:
+			*patch.Ownership = ""
+		case *patch.Ownership != OwnershipMine && *patch.Ownership != OwnershipNeighbor && *patch.Ownership != "":
+			http.Error(w, "Ownership must be mine, neighbor or unknown", http.StatusBadRequest)
+			return
+		}
+	}
+	deviceMetaMutex.Lock()
+	m := deviceMeta[mac]
+	if patch.Alias != nil {
+		m.Alias = strings.TrimSpace(*patch.Alias)
+	}
+	if patch.Tags != nil {
+		m.Tags = *patch.Tags
+	}
+	if patch.Notes != nil {
+		m.Notes = *patch.Notes
+	}
+	if patch.Ownership != nil {
+		m.Ownership = *patch.Ownership
+	}
+	if patch.GuestFor != nil || patch.GuestExpires != nil {
+		m.GuestExpires = guestExpires
+		if guestExpires != nil && !containsString(m.Tags, TagGuest) {
+			m.Tags = append(m.Tags, TagGuest)
+		}
+	}
+	deviceMeta[mac] = m
+	deviceMetaMutex.Unlock()
+	err = saveDeviceMeta(true)
+	if err != nil {
+		http.Error(w, "Cannot save metadata: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	// the lists pick up the change on the next parse
+	requestRefresh()
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"archive/zip"
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// number of lines of the CSV file put into the diagnostics bundle
+const csvSampleLines = 50
+
+// the configuration without the secrets in it
+func redactedConfig() Config {
+	c := config
+	c.Webhooks = make([]Webhook, len(config.Webhooks))
+	for i, hook := range config.Webhooks {
+		c.Webhooks[i] = hook
+		c.Webhooks[i].Secret = redact(hook.Secret)
+		c.Webhooks[i].URL = redactURL(hook.URL)
+	}
+	// API keys of the vendor lookup service and tile servers go in their URLs
+	c.Vendors.LookupURL = redactURL(config.Vendors.LookupURL)
+	c.Map.TileURL = redactURL(config.Map.TileURL)
+	c.NetBox.Token = redact(config.NetBox.Token)
+	c.Directory.BindPassword = redact(config.Directory.BindPassword)
+	c.RADIUS.Secret = redact(config.RADIUS.Secret)
+	c.Sources = make([]SourceConfig, len(config.Sources))
+	for i, source := range config.Sources {
+		c.Sources[i] = source
+		c.Sources[i].Community = redact(source.Community)
+		c.Sources[i].Password = redact(source.Password)
+		c.Sources[i].URL = redactURL(source.URL)
+	}
+	return c
+}
+
+// a secret as it is shown, only whether there is one
+func redact(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	return "REDACTED"
+}
+
+// a URL without the user and query, where credentials go
+func redactURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.User == nil && u.RawQuery == "") {
+		return rawURL
+	}
+	u.User = nil
+	if u.RawQuery != "" {
+		u.RawQuery = "REDACTED"
+	}
+	return u.String()
+}
+
+// the first lines of the CSV file being parsed
+func csvSample() string {
+	file, err := os.Open(*csvFile)
+	if err != nil {
+		return err.Error() + "\n"
+	}
+	defer file.Close()
+	var b strings.Builder
+	scanner := bufio.NewScanner(file)
+	for i := 0; i < csvSampleLines && scanner.Scan(); i++ {
+		b.WriteString(scanner.Text() + "\n")
+	}
+	return b.String()
+}
+
+// the files in the data directory with their sizes
+func dataListing() string {
+	var b strings.Builder
+	filepath.Walk(*dataDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			fmt.Fprintln(&b, path, err)
+			return nil
+		}
+		name, _ := filepath.Rel(*dataDir, path)
+		fmt.Fprintf(&b, "%s\t%d\t%s\n", name, info.Size(), info.ModTime().Format(time.RFC3339))
+		return nil
+	})
+	return b.String()
+}
+
+// write the diagnostics bundle as a zip, live adds what only the running server knows
+func writeDiag(w io.Writer, live bool) error {
+	z := zip.NewWriter(w)
+	now := time.Now()
+	add := func(name string, content []byte) error {
+		f, err := z.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: now})
+		if err != nil {
+			return err
+		}
+		_, err = f.Write(content)
+		return err
+	}
+	addJSON := func(name string, v interface{}) error {
+		data, err := json.MarshalIndent(v, "", "  ")
+		if err != nil {
+			data = []byte(err.Error())
+		}
+		return add(name, data)
+	}
+	var flags strings.Builder
+	flag.VisitAll(func(f *flag.Flag) {
+		fmt.Fprintf(&flags, "-%s=%s\n", f.Name, f.Value.String())
+	})
+	files := []struct {
+		name    string
+		content []byte
+	}{
+		{"flags.txt", []byte(flags.String())},
+		{"csv-sample.csv", []byte(csvSample())},
+		{"data.txt", []byte(dataListing())},
+	}
+	for _, file := range files {
+		if err := add(file.name, file.content); err != nil {
+			return err
+		}
+	}
+	if err := addJSON("version.json", buildInfo()); err != nil {
+		return err
+	}
+	if err := addJSON("config.json", redactedConfig()); err != nil {
+		return err
+	}
+	if live {
+		if err := addJSON("status.json", currentStatus()); err != nil {
+			return err
+		}
+		if err := addJSON("sensors.json", listSensors()); err != nil {
+			return err
+		}
+		if err := add("netnet.log", []byte(getRecentLogs())); err != nil {
+			return err
+		}
+	} else {
+		// the server writes its output to the journal when run as a service
+		logs, err := exec.Command("journalctl", "-u", "netnet", "-n", "1000", "--no-pager").CombinedOutput()
+		if err != nil {
+			logs = append(logs, []byte("\n"+err.Error()+"\n")...)
+		}
+		if err := add("netnet.log", logs); err != nil {
+			return err
+		}
+	}
+	return z.Close()
+}
+
+// the diag subcommand writes the diagnostics bundle to the file in the argument, or netnet-diag.zip
+func diag(args []string) {
+	name := "netnet-diag.zip"
+	if len(args) > 0 {
+		name = args[0]
+	}
+	loadConfig(*configFile)
+	file, err := os.Create(name)
+	if err != nil {
+		fmt.Println("Cannot create diagnostics bundle:", err)
+		os.Exit(1)
+	}
+	err = writeDiag(file, false)
+	if err == nil {
+		err = file.Close()
+	}
+	if err != nil {
+		fmt.Println("Cannot write diagnostics bundle:", err)
+		os.Exit(1)
+	}
+	fmt.Println("Wrote", name)
+}
+
+// download the diagnostics bundle of the running server at /admin/diag
+func adminDiag(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="netnet-diag-`+time.Now().Format("20060102-150405")+`.zip"`)
+	check(writeDiag(w, true), "Cannot write diagnostics bundle:")
+}
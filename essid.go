@@ -0,0 +1,36 @@
+package main
+
+import (
+	"encoding/hex"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// replace invalid UTF-8 and control characters in a name so it doesn't break JSON consumers
+func sanitizeName(name string) string {
+	if utf8.ValidString(name) && strings.IndexFunc(name, unicode.IsControl) < 0 {
+		return name
+	}
+	return strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return utf8.RuneError
+		}
+		return r
+	}, strings.ToValidUTF8(name, string(utf8.RuneError)))
+}
+
+// sanitize the names of the access points and the probes of the clients, the raw bytes of
+// a name that had to be changed are kept in hex
+func sanitize(aps []AccessPoint, clients []Client) ([]AccessPoint, []Client) {
+	for i := range aps {
+		if name := sanitizeName(aps[i].Name); name != aps[i].Name {
+			aps[i].NameHex = hex.EncodeToString([]byte(aps[i].Name))
+			aps[i].Name = name
+		}
+	}
+	for i := range clients {
+		clients[i].Probes = sanitizeName(clients[i].Probes)
+	}
+	return aps, clients
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// every event is appended to this file in the data directory, one JSON object per line
+const eventLogFile = "events.jsonl"
+
+// largest event line read back from the log
+const maxEventSize = 1 << 20
+
+var lastEventID int64
+var eventLogMutex sync.Mutex
+
+// EventPage is a page of events from the log, newest first
+type EventPage struct {
+	Total  int     `json:"total"` // events matching the filters
+	Offset int     `json:"offset"`
+	Limit  int     `json:"limit"`
+	Events []Event `json:"events"`
+}
+
+// EventFilter picks the events to return from the log
+type EventFilter struct {
+	Types []string
+	MAC   string
+	From  time.Time
+	To    time.Time
+}
+
+func (f EventFilter) match(e Event) bool {
+	if len(f.Types) > 0 && !containsString(f.Types, e.Type) {
+		return false
+	}
+	if f.MAC != "" && e.MAC != f.MAC {
+		return false
+	}
+	if !f.From.IsZero() && e.Time.Before(f.From) {
+		return false
+	}
+	if !f.To.IsZero() && e.Time.After(f.To) {
+		return false
+	}
+	return true
+}
+
+// carry on numbering the events from the last one in the log
+func loadEventLog() {
+	eventLogMutex.Lock()
+	defer eventLogMutex.Unlock()
+	lastEventID = 0
+	err := scanEventLog(func(e Event) {
+		if e.ID > lastEventID {
+			lastEventID = e.ID
+		}
+	})
+	check(err, "Cannot read event log:")
+}
+
+// go through every event in the log, oldest first
+func scanEventLog(fn func(Event)) error {
+	file, err := os.Open(filepath.Join(*dataDir, eventLogFile))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), maxEventSize)
+	for scanner.Scan() {
+		var e Event
+		// skip a line cut short by a crash
+		if json.Unmarshal(scanner.Bytes(), &e) == nil {
+			fn(e)
+		}
+	}
+	return scanner.Err()
+}
+
+// number the events and append them to the log
+func logEvents(events []Event) {
+	if len(events) == 0 {
+		return
+	}
+	eventLogMutex.Lock()
+	defer eventLogMutex.Unlock()
+	err := os.MkdirAll(*dataDir, 0700)
+	if err != nil {
+		check(err, "Cannot write event log:")
+		return
+	}
+	file, err := os.OpenFile(filepath.Join(*dataDir, eventLogFile), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		check(err, "Cannot write event log:")
+		return
+	}
+	defer file.Close()
+	w := bufio.NewWriter(file)
+	encoder := json.NewEncoder(w)
+	for i := range events {
+		lastEventID++
+		events[i].ID = lastEventID
+		check(encoder.Encode(events[i]), "Cannot write event log:")
+	}
+	check(w.Flush(), "Cannot write event log:")
+}
+
+// get a page of the events matching the filter, newest first
+func queryEvents(filter EventFilter, offset, limit int) (EventPage, error) {
+	page := EventPage{Offset: offset, Limit: limit, Events: []Event{}}
+	// only the newest offset+limit matches are needed
+	var newest []Event
+	eventLogMutex.Lock()
+	err := scanEventLog(func(e Event) {
+		if !filter.match(e) {
+			return
+		}
+		page.Total++
+		newest = append(newest, e)
+		if len(newest) > offset+limit {
+			newest = newest[1:]
+		}
+	})
+	eventLogMutex.Unlock()
+	for i := len(newest) - 1 - offset; i >= 0; i-- {
+		page.Events = append(page.Events, newest[i])
+	}
+	return page, err
+}
+
+// the event log at /events, filtered with ?type=new_client,new_ap&mac=&from=&to= (RFC 3339 times)
+// and paged with ?offset=&limit=
+func eventsHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	var filter EventFilter
+	var err error
+	if types := q.Get("type"); types != "" {
+		filter.Types = strings.Split(types, ",")
+	}
+	if mac := q.Get("mac"); mac != "" {
+		filter.MAC = normalizeMAC(mac)
+	}
+	if from := q.Get("from"); from != "" {
+		filter.From, err = time.Parse(time.RFC3339, from)
+		if err != nil {
+			http.Error(w, "Invalid from time: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if to := q.Get("to"); to != "" {
+		filter.To, err = time.Parse(time.RFC3339, to)
+		if err != nil {
+			http.Error(w, "Invalid to time: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	offset, limit := 0, 100
+	if n, err := strconv.Atoi(q.Get("offset")); err == nil && n >= 0 {
+		offset = n
+	}
+	if n, err := strconv.Atoi(q.Get("limit")); err == nil && n > 0 && n <= 1000 {
+		limit = n
+	}
+	page, err := queryEvents(filter, offset, limit)
+	if err != nil {
+		http.Error(w, "Cannot read event log: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, page)
+}
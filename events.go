@@ -0,0 +1,120 @@
+package main
+
+import (
+	"strings"
+	"time"
+)
+
+// event types
+const (
+	EventNewClient  = "new_client"
+	EventNewAP      = "new_ap"
+	EventRogueAP    = "rogue_ap"
+	EventWatchlist  = "watchlist"
+	EventClientLeft = "client_left"
+	EventAPLeft     = "ap_left"
+	EventSensorDown = "sensor_down"
+	EventSensorUp   = "sensor_up"
+)
+
+// Event is something that happened that is worth reacting to
+type Event struct {
+	ID      int64       `json:"id,omitempty"` // position in the event log
+	Type    string      `json:"type"`
+	Time    time.Time   `json:"time"`
+	MAC     string      `json:"mac"`
+	Message string      `json:"message"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+// a device is active if it was seen recently
+func isActive(lastSeen time.Time) bool {
+	return time.Since(lastSeen) <= sessionGap
+}
+
+// an access point is rogue if it broadcasts one of our SSIDs, or the SSID of an AP marked as ours,
+// without being one of ours or a known neighbor
+func isRogue(ap AccessPoint, aps []AccessPoint) bool {
+	if isMine(ap) || ap.Ownership == OwnershipNeighbor {
+		return false
+	}
+	name := strings.TrimSpace(ap.Name)
+	if name == "" {
+		return false
+	}
+	if containsString(config.MySSIDs, name) {
+		return true
+	}
+	for _, other := range aps {
+		if other.Ownership == OwnershipMine && strings.TrimSpace(other.Name) == name {
+			return true
+		}
+	}
+	return false
+}
+
+// compare the previous and current parse to find out what happened in between,
+// the first parse doesn't report new devices since everything is new
+func detectEvents(oldAPs, aps []AccessPoint, oldClients, clients []Client, first bool) (events []Event) {
+	now := time.Now()
+	activeAPs := make(map[string]bool)
+	for _, ap := range oldAPs {
+		activeAPs[ap.MAC] = isActive(ap.LastSeen)
+	}
+	for _, ap := range aps {
+		wasActive, known := activeAPs[ap.MAC]
+		if !known && !first {
+			events = append(events, Event{Type: EventNewAP, Time: now, MAC: ap.MAC, Message: "New access point " + ap.Name, Data: ap})
+		}
+		if wasActive && !isActive(ap.LastSeen) {
+			events = append(events, Event{Type: EventAPLeft, Time: now, MAC: ap.MAC, Message: "Access point " + ap.Name + " left", Data: ap})
+		}
+		if wasActive || !isActive(ap.LastSeen) {
+			continue
+		}
+		if isRogue(ap, aps) {
+			events = append(events, Event{Type: EventRogueAP, Time: now, MAC: ap.MAC, Message: "Rogue access point broadcasting " + ap.Name, Data: ap})
+		}
+		if containsMAC(config.Watchlist, ap.MAC) {
+			events = append(events, Event{Type: EventWatchlist, Time: now, MAC: ap.MAC, Message: "Watchlisted access point " + ap.Name + " appeared", Data: ap})
+		}
+		if e, ok := guestEvent(ap.MAC, "access point", ap.Name, ap.DeviceMeta, ap); ok {
+			events = append(events, e)
+		}
+	}
+
+	activeClients := make(map[string]bool)
+	for _, client := range oldClients {
+		activeClients[client.MAC] = isActive(client.LastSeen)
+	}
+	for _, client := range clients {
+		wasActive, known := activeClients[client.MAC]
+		if !known && !first {
+			events = append(events, Event{Type: EventNewClient, Time: now, MAC: client.MAC, Message: "New client " + client.Organization, Data: client})
+		}
+		if wasActive && !isActive(client.LastSeen) {
+			events = append(events, Event{Type: EventClientLeft, Time: now, MAC: client.MAC, Message: "Client " + formatMAC(client.MAC) + " left", Data: client})
+		}
+		if wasActive || !isActive(client.LastSeen) {
+			continue
+		}
+		if containsMAC(config.Watchlist, client.MAC) {
+			events = append(events, Event{Type: EventWatchlist, Time: now, MAC: client.MAC, Message: "Watchlisted client " + formatMAC(client.MAC) + " appeared", Data: client})
+		}
+		if e, ok := guestEvent(client.MAC, "client", formatMAC(client.MAC), client.DeviceMeta, client); ok {
+			events = append(events, e)
+		}
+	}
+	return
+}
+
+// log the events and send them out to everything that reacts to them, except for acknowledged alerts
+func emit(events []Event) {
+	logEvents(events)
+	for _, e := range events {
+		eventsHub.broadcastJSON(e)
+		if trackAlert(e) {
+			notify(e)
+		}
+	}
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// OUIs of vendors that make a lot of access points and clients, so the made-up devices get real vendors
+var fakeAPOUIs = []string{"F0:9F:C2", "74:AC:B9", "00:1A:1E", "C4:E9:84", "B0:BE:76", "3C:37:86", "00:1D:7E", "E0:63:DA"}
+var fakeClientOUIs = []string{"F0:18:98", "3C:22:FB", "8C:F5:A3", "A4:34:D9", "7C:B2:7D", "F4:F5:D8", "64:09:80", "DC:A6:32"}
+
+var fakeSSIDs = []string{"HomeNet", "Office", "Office-Guest", "CoffeeShop", "NETGEAR42", "TP-Link_5G_A1B2", "xfinitywifi",
+	"eduroam", "Linksys", "AndroidAP", "iPhone", "Printer-Setup", "Library", "DIRECT-roku-123"}
+
+var fakeChannels = []int{1, 6, 11, 1, 6, 11, 36, 40, 44, 48, 149, 153, 157, 161}
+
+type fakeAP struct {
+	AccessPoint
+	cipher string
+	idle   bool // only beacons
+}
+
+type fakeClient struct {
+	Client
+	home    int  // the access point it connects to, -1 if it only probes
+	present bool // in range now
+	seen    bool // was ever in range
+}
+
+// fakeWorld is a made-up place with access points and clients coming and going, for demos and load tests
+// without a Wi-Fi adapter
+type fakeWorld struct {
+	rand    *rand.Rand
+	aps     []fakeAP
+	clients []fakeClient
+	last    time.Time
+	mutex   sync.Mutex
+}
+
+func newFakeWorld(aps, clients int, seed int64) *fakeWorld {
+	w := &fakeWorld{rand: rand.New(rand.NewSource(seed)), last: time.Now()}
+	for i := 0; i < aps; i++ {
+		ap := fakeAP{cipher: "CCMP"}
+		ap.MAC = w.mac(fakeAPOUIs[w.rand.Intn(len(fakeAPOUIs))], false)
+		ap.FirstSeen, ap.LastSeen = w.last, w.last
+		ap.Channel = fakeChannels[w.rand.Intn(len(fakeChannels))]
+		ap.Speed = []string{"54", "130", "270", "540", "866", "1200"}[w.rand.Intn(6)]
+		switch n := w.rand.Intn(20); {
+		case n < 2:
+			ap.Privacy, ap.cipher, ap.Authentication = "OPN", "", ""
+		case n < 3:
+			ap.Privacy, ap.cipher, ap.Authentication = "WEP", "WEP", ""
+		case n < 6:
+			ap.Privacy, ap.Authentication = "WPA3 WPA2", "SAE PSK"
+		case n < 8:
+			ap.Privacy, ap.Authentication = "WPA2", "MGT"
+		default:
+			ap.Privacy, ap.Authentication = "WPA2", "PSK"
+		}
+		ap.Power = -30 - w.rand.Intn(60)
+		ap.idle = w.rand.Intn(4) == 0
+		// a few hidden networks
+		if w.rand.Intn(15) != 0 {
+			ap.Name = fakeSSIDs[w.rand.Intn(len(fakeSSIDs))]
+			if w.rand.Intn(3) == 0 {
+				ap.Name += fmt.Sprintf("-%d", i)
+			}
+		}
+		w.aps = append(w.aps, ap)
+	}
+	for i := 0; i < clients; i++ {
+		c := fakeClient{home: -1}
+		// phones probing with a randomized MAC, the rest with their own
+		if w.rand.Intn(3) == 0 {
+			c.MAC = w.mac("", true)
+		} else {
+			c.MAC = w.mac(fakeClientOUIs[w.rand.Intn(len(fakeClientOUIs))], false)
+			if aps > 0 && w.rand.Intn(4) != 0 {
+				c.home = w.rand.Intn(aps)
+			}
+		}
+		var probes []string
+		for n := w.rand.Intn(4); n > 0; n-- {
+			probes = append(probes, fakeSSIDs[w.rand.Intn(len(fakeSSIDs))])
+		}
+		c.Probes = strings.Join(probes, ",")
+		c.Power = -35 - w.rand.Intn(55)
+		// about half are around from the start
+		c.present = w.rand.Intn(2) == 0
+		w.clients = append(w.clients, c)
+	}
+	w.step(w.last)
+	return w
+}
+
+// a MAC address with the OUI, or a locally administered one
+func (w *fakeWorld) mac(oui string, random bool) string {
+	b := make([]byte, 6)
+	w.rand.Read(b)
+	if random {
+		b[0] = b[0]&0xFC | 0x02
+		return normalizeMAC(fmt.Sprintf("%02X:%02X:%02X:%02X:%02X:%02X", b[0], b[1], b[2], b[3], b[4], b[5]))
+	}
+	return normalizeMAC(fmt.Sprintf("%s:%02X:%02X:%02X", oui, b[3], b[4], b[5]))
+}
+
+// move the world on to now: clients arrive and leave, signals drift and packets are sent
+func (w *fakeWorld) step(now time.Time) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	elapsed := now.Sub(w.last).Seconds()
+	w.last = now
+	// the chance a client arrives or leaves in this step, most stay for tens of minutes
+	change := elapsed / 1800
+	for i := range w.aps {
+		ap := &w.aps[i]
+		ap.LastSeen = now
+		ap.Power = drift(w.rand, ap.Power, -25, -92)
+		ap.Beacons += int(elapsed*10) + w.rand.Intn(5)
+		if !ap.idle {
+			ap.DataPackets += w.rand.Intn(int(elapsed*20) + 1)
+		}
+	}
+	for i := range w.clients {
+		c := &w.clients[i]
+		if w.rand.Float64() < change {
+			c.present = !c.present
+		}
+		if !c.present {
+			continue
+		}
+		if !c.seen {
+			c.seen, c.FirstSeen = true, now
+		}
+		c.LastSeen = now
+		c.Power = drift(w.rand, c.Power, -30, -95)
+		if c.home >= 0 {
+			c.Packets += w.rand.Intn(int(elapsed*30) + 1)
+			c.setBSSID(w.aps[c.home].MAC)
+		} else {
+			c.Packets += w.rand.Intn(int(elapsed) + 1)
+			c.setBSSID("(not associated)")
+		}
+	}
+}
+
+// a signal a few dB stronger or weaker, between max and min
+func drift(r *rand.Rand, power, max, min int) int {
+	power += r.Intn(7) - 3
+	if power > max {
+		return max
+	}
+	if power < min {
+		return min
+	}
+	return power
+}
+
+// the access points and clients seen so far
+func (w *fakeWorld) snapshot() (aps []AccessPoint, clients []Client) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	for _, ap := range w.aps {
+		aps = append(aps, ap.AccessPoint)
+	}
+	for _, c := range w.clients {
+		if c.seen {
+			clients = append(clients, c.Client)
+		}
+	}
+	return
+}
+
+// write the world as airodump-ng writes its CSV file, replacing the file at once so it is never read half written
+func (w *fakeWorld) writeCSV(name string) error {
+	tmp := filepath.Join(filepath.Dir(name), "."+filepath.Base(name)+".tmp")
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	out := bufio.NewWriter(file)
+	format := func(t time.Time) string { return t.Format(airodumpTimeLayout) }
+	w.mutex.Lock()
+	fmt.Fprint(out, "\r\n"+apHeader+", channel, Speed, Privacy, Cipher, Authentication, Power, # beacons, # IV, LAN IP, ID-length, ESSID, Key\r\n")
+	for _, ap := range w.aps {
+		fmt.Fprintf(out, "%s, %s, %s, %2d, %4s, %s, %s, %s, %3d, %8d, %8d,   0.  0.  0.  0, %3d, %s, \r\n",
+			strings.ReplaceAll(ap.MAC, "-", ":"), format(ap.FirstSeen), format(ap.LastSeen), ap.Channel, ap.Speed, ap.Privacy, ap.cipher,
+			ap.Authentication, ap.Power, ap.Beacons, ap.DataPackets, len(ap.Name), ap.Name)
+	}
+	fmt.Fprint(out, "\r\n"+clientHeader+"\r\n")
+	for _, c := range w.clients {
+		if !c.seen {
+			continue
+		}
+		bssid := "(not associated) "
+		if c.Associated {
+			bssid = strings.ReplaceAll(c.BSSID, "-", ":")
+		}
+		fmt.Fprintf(out, "%s, %s, %s, %3d, %8d, %s,%s\r\n",
+			strings.ReplaceAll(c.MAC, "-", ":"), format(c.FirstSeen), format(c.LastSeen), c.Power, c.Packets, bssid, c.Probes)
+	}
+	fmt.Fprint(out, "\r\n")
+	w.mutex.Unlock()
+	if err = out.Flush(); err != nil {
+		file.Close()
+		return err
+	}
+	if err = file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, name)
+}
+
+// make up an airodump-ng CSV file that changes like a real one, ie for working on the web UI without a capture
+func fake(args []string) {
+	flags := flag.NewFlagSet("fake", flag.ExitOnError)
+	aps := flags.Int("aps", 50, "number of access points")
+	clients := flags.Int("clients", 500, "number of clients, they come and go")
+	duration := flags.Duration("duration", time.Hour, "how long to keep changing the file, 0 to write it once")
+	interval := flags.Duration("interval", 5*time.Second, "how often the file is written, airodump-ng writes every 5 seconds")
+	seed := flags.Int64("seed", time.Now().UnixNano(), "seed of the made-up world, the same seed makes the same devices")
+	flags.Usage = func() {
+		fmt.Println("Usage: netnet fake [-aps n] [-clients n] [-duration 1h] [-interval 5s] [file.csv]")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	file := flags.Arg(0)
+	if file == "" {
+		file = *csvFile
+	}
+	w := newFakeWorld(*aps, *clients, *seed)
+	end := time.Now().Add(*duration)
+	if *duration > 0 {
+		fmt.Printf("Writing %d access points and %d clients to %s every %v until %s\n", *aps, *clients, file, *interval, end.Format(time.Kitchen))
+	}
+	for {
+		if err := w.writeCSV(file); err != nil {
+			fmt.Println("Cannot write CSV file:", err)
+			os.Exit(1)
+		}
+		if !time.Now().Add(*interval).Before(end) {
+			return
+		}
+		time.Sleep(*interval)
+		w.step(time.Now())
+	}
+}
+
+// fakeSource is a made-up world as a data source, the devices go straight into the store without a CSV file
+type fakeSource struct {
+	name  string
+	world *fakeWorld
+}
+
+func (s fakeSource) Name() string {
+	return s.name
+}
+
+func (s fakeSource) Poll() (Batch, error) {
+	now := time.Now()
+	s.world.step(now)
+	aps, clients := s.world.snapshot()
+	return Batch{Source: s.name, Written: now, APs: aps, Clients: clients}, nil
+}
+
+func (s fakeSource) Watch(stop <-chan struct{}) <-chan Batch {
+	return nil
+}
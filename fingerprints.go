@@ -0,0 +1,181 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// information elements that describe what the radio can do
+const (
+	elementHTCapabilities   = 45
+	elementVHTCapabilities  = 191
+	elementExtension        = 255
+	extensionHECapabilities = 35
+)
+
+// IEFingerprint is the information elements a device sends, which depend on its chipset and driver and
+// so stay the same when the device randomizes its MAC address
+type IEFingerprint struct {
+	Kind      string   `json:"kind"`          // probe for the probe requests of a client, beacon for an access point
+	Tags      []string `json:"tags"`          // element IDs in order, with the OUI and type of vendor elements and the extension ID of extension elements
+	HT        string   `json:"ht,omitempty"`  // HT capabilities information in hex
+	VHT       string   `json:"vht,omitempty"` // VHT capabilities information in hex
+	HE        string   `json:"he,omitempty"`  // HE MAC and PHY capabilities in hex
+	Signature string   `json:"signature"`     // hash of all of the above, the same for devices of the same model
+}
+
+// IERecord is the fingerprint a device was last seen with
+type IERecord struct {
+	IEFingerprint
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+}
+
+// FingerprintGroup is the devices sharing a fingerprint
+type FingerprintGroup struct {
+	IEFingerprint
+	MACs []string `json:"macs"`
+}
+
+var fingerprints = make(map[string]*IERecord)
+var fingerprintsMutex sync.RWMutex
+var fingerprintsSaved time.Time
+
+func loadFingerprints() {
+	fingerprintsMutex.Lock()
+	defer fingerprintsMutex.Unlock()
+	fingerprints = make(map[string]*IERecord)
+	check(loadJSON("fingerprints.json", &fingerprints), "Cannot load IE fingerprints:")
+}
+
+// get the fingerprint from the information elements of a frame
+func ieFingerprint(kind string, elements []Element) (fp IEFingerprint) {
+	fp.Kind, fp.Tags = kind, []string{}
+	for _, e := range elements {
+		tag := strconv.Itoa(e.ID)
+		switch e.ID {
+		case elementVendor:
+			if len(e.Data) >= 4 {
+				tag += fmt.Sprintf(":%02X-%02X-%02X:%d", e.Data[0], e.Data[1], e.Data[2], e.Data[3])
+			}
+		case elementExtension:
+			if len(e.Data) >= 1 {
+				tag += ":" + strconv.Itoa(int(e.Data[0]))
+			}
+			if len(e.Data) >= 18 && e.Data[0] == extensionHECapabilities {
+				fp.HE = hex.EncodeToString(e.Data[1:18])
+			}
+		case elementHTCapabilities:
+			if len(e.Data) >= 2 {
+				fp.HT = hex.EncodeToString(e.Data[:2])
+			}
+		case elementVHTCapabilities:
+			if len(e.Data) >= 4 {
+				fp.VHT = hex.EncodeToString(e.Data[:4])
+			}
+		}
+		fp.Tags = append(fp.Tags, tag)
+	}
+	sum := sha256.Sum256([]byte(kind + "|" + strings.Join(fp.Tags, ",") + "|" + fp.HT + "|" + fp.VHT + "|" + fp.HE))
+	fp.Signature = hex.EncodeToString(sum[:8])
+	return
+}
+
+// record the fingerprints of the clients from their probe requests and of the access points from their beacons
+func recordFingerprints(frames []Frame) {
+	fingerprintsMutex.Lock()
+	defer fingerprintsMutex.Unlock()
+	changed := false
+	for _, frame := range frames {
+		if frame.Partial {
+			continue
+		}
+		var mac string
+		var fp IEFingerprint
+		switch {
+		case frame.Type == frameManagement && frame.Subtype == subtypeProbeReq:
+			mac, fp = frame.Addr2, ieFingerprint("probe", parseElements(frame.Body))
+		case frame.Type == frameManagement && frame.Subtype == subtypeBeacon && len(frame.Body) >= 12:
+			mac, fp = frame.Addr3, ieFingerprint("beacon", parseElements(frame.Body[12:]))
+		default:
+			continue
+		}
+		record := fingerprints[mac]
+		if record == nil || record.Signature != fp.Signature {
+			record = &IERecord{IEFingerprint: fp, FirstSeen: frame.Time}
+			fingerprints[mac] = record
+			changed = true
+		}
+		if frame.Time.After(record.LastSeen) {
+			record.LastSeen = frame.Time
+		}
+	}
+	if changed || time.Since(fingerprintsSaved) >= metaSaveInterval {
+		fingerprintsSaved = time.Now()
+		check(saveJSON("fingerprints.json", fingerprints), "Cannot save IE fingerprints:")
+	}
+}
+
+// group the devices by fingerprint, the ones shared by most devices first
+func groupFingerprints() []FingerprintGroup {
+	fingerprintsMutex.RLock()
+	defer fingerprintsMutex.RUnlock()
+	groups := make(map[string]*FingerprintGroup)
+	for mac, record := range fingerprints {
+		group := groups[record.Signature]
+		if group == nil {
+			group = &FingerprintGroup{IEFingerprint: record.IEFingerprint}
+			groups[record.Signature] = group
+		}
+		group.MACs = append(group.MACs, formatMAC(mac))
+	}
+	results := []FingerprintGroup{}
+	for _, group := range groups {
+		sort.Strings(group.MACs)
+		results = append(results, *group)
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if len(results[i].MACs) != len(results[j].MACs) {
+			return len(results[i].MACs) > len(results[j].MACs)
+		}
+		return results[i].Signature < results[j].Signature
+	})
+	return results
+}
+
+// fingerprints at /fingerprints grouped by signature, so the MACs of one device or model can be correlated,
+// and the fingerprint of a single device at /fingerprints/{mac} with the other MACs that share it
+func fingerprintsHandler(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/fingerprints"), "/")
+	if path == "" {
+		writeJSON(w, groupFingerprints())
+		return
+	}
+	mac := normalizeMAC(path)
+	fingerprintsMutex.RLock()
+	defer fingerprintsMutex.RUnlock()
+	record, ok := fingerprints[mac]
+	if !ok {
+		http.Error(w, "No fingerprint captured from "+formatMAC(mac), http.StatusNotFound)
+		return
+	}
+	result := struct {
+		IERecord
+		MAC    string   `json:"mac"`
+		SameAs []string `json:"same_as"` // other devices with the same fingerprint
+	}{IERecord: *record, MAC: formatMAC(mac), SameAs: []string{}}
+	for other, o := range fingerprints {
+		if other != mac && o.Signature == record.Signature {
+			result.SameAs = append(result.SameAs, formatMAC(other))
+		}
+	}
+	sort.Strings(result.SameAs)
+	writeJSON(w, result)
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// SensorSettings is the configuration the central server gives a sensor every time it checks in, empty settings
+// leave the sensor's own configuration alone
+type SensorSettings struct {
+	Band            string       `json:"band,omitempty"`     // airodump-ng --band for the airodump-ng instances the sensor runs
+	Channels        string       `json:"channels,omitempty"` // airodump-ng --channel, instead of the band
+	RefreshInterval int          `json:"refresh_interval,omitempty"`
+	Watchlist       []string     `json:"watchlist,omitempty"`
+	Ghosts          *GhostConfig `json:"ghosts,omitempty"` // how ghost clients are filtered out
+}
+
+// FleetConfig is the configuration of the sensors sending data to this server
+type FleetConfig struct {
+	Defaults SensorSettings            `json:"defaults"` // for every sensor
+	Sensors  map[string]SensorSettings `json:"sensors"`  // by sensor ID, on top of the defaults
+}
+
+// the settings of a sensor, the defaults with the sensor's own settings on top
+func settingsFor(id string) SensorSettings {
+	s := config.Fleet.Defaults
+	own, ok := config.Fleet.Sensors[id]
+	if !ok {
+		return s
+	}
+	if own.Band != "" || own.Channels != "" {
+		s.Band, s.Channels = own.Band, own.Channels
+	}
+	if own.RefreshInterval != 0 {
+		s.RefreshInterval = own.RefreshInterval
+	}
+	if own.Watchlist != nil {
+		s.Watchlist = own.Watchlist
+	}
+	if own.Ghosts != nil {
+		s.Ghosts = own.Ghosts
+	}
+	return s
+}
+
+// the file a sensor keeps the central server's settings in, so they apply before it can reach the server
+const sensorSettingsFile = "sensor_settings.json"
+
+// settings from the central server, applied by the next parse
+var receivedSettings []byte
+var appliedSettings []byte
+var sensorSettingsMutex sync.Mutex
+
+// the sensor's own configuration, for what the central server doesn't set
+var localWatchlist []string
+var localGhosts GhostConfig
+
+// keep the sensor's own settings and apply the ones the central server gave it last time
+func loadSensorSettings() {
+	localWatchlist, localGhosts = config.Watchlist, config.Ghosts
+	var s SensorSettings
+	check(loadJSON(sensorSettingsFile, &s), "Cannot load sensor settings:")
+	appliedSettings, _ = json.Marshal(SensorSettings{})
+	receivedSettings, _ = json.Marshal(s)
+	applySensorSettings()
+}
+
+// the settings the central server answered a batch with
+func receiveSettings(data []byte) {
+	var s SensorSettings
+	if err := json.Unmarshal(data, &s); err != nil {
+		fmt.Println("Cannot parse settings from the central server:", err)
+		return
+	}
+	data, _ = json.Marshal(s)
+	sensorSettingsMutex.Lock()
+	defer sensorSettingsMutex.Unlock()
+	receivedSettings = data
+}
+
+// apply the settings from the central server if they changed, only called by the parse so it doesn't change
+// the configuration in the middle of one
+func applySensorSettings() {
+	sensorSettingsMutex.Lock()
+	data := receivedSettings
+	changed := !bytes.Equal(data, appliedSettings)
+	appliedSettings = data
+	sensorSettingsMutex.Unlock()
+	if !changed {
+		return
+	}
+	var s SensorSettings
+	json.Unmarshal(data, &s)
+	check(saveJSON(sensorSettingsFile, s), "Cannot save sensor settings:")
+	fmt.Println("Applying settings from the central server:", string(data))
+
+	setCaptureChannels(s.Band, s.Channels)
+	refreshSetting.Store(int64(time.Duration(s.RefreshInterval) * time.Second))
+	config.Watchlist = localWatchlist
+	if s.Watchlist != nil {
+		config.Watchlist = s.Watchlist
+	}
+	config.Ghosts = localGhosts
+	if s.Ghosts != nil {
+		config.Ghosts = *s.Ghosts
+	}
+}
+
+// the ingest rate of a sensor is over this long
+const ingestRateWindow = 10 * time.Minute
+
+// how a remote sensor has been checking in
+type checkin struct {
+	version string
+	first   time.Time
+	last    time.Time
+	batches int64
+	bytes   int64
+	recent  []checkinSize // in the ingest rate window
+}
+
+type checkinSize struct {
+	time  time.Time
+	bytes int
+}
+
+var checkins = make(map[string]*checkin)
+var checkinsMutex sync.Mutex
+
+func recordCheckin(id, version string, bytes int) {
+	checkinsMutex.Lock()
+	defer checkinsMutex.Unlock()
+	now := time.Now()
+	c, ok := checkins[id]
+	if !ok {
+		c = &checkin{first: now}
+		checkins[id] = c
+	}
+	c.version, c.last = version, now
+	c.batches++
+	c.bytes += int64(bytes)
+	c.recent = append(c.recent, checkinSize{now, bytes})
+	for len(c.recent) > 0 && now.Sub(c.recent[0].time) > ingestRateWindow {
+		c.recent = c.recent[1:]
+	}
+}
+
+// FleetSensor is a sensor in /fleet
+type FleetSensor struct {
+	ID               string     `json:"id"`
+	Remote           bool       `json:"remote"`            // sends its data to /ingest, otherwise a source of this server
+	Version          string     `json:"version,omitempty"` // of netnet on a remote sensor
+	Healthy          bool       `json:"healthy"`
+	Problem          string     `json:"problem,omitempty"`
+	LastSeen         time.Time  `json:"last_seen"`            // last check-in of a remote sensor, or last parse of a source
+	FirstSeen        *time.Time `json:"first_seen,omitempty"` // first check-in of a remote sensor
+	Skew             float64    `json:"skew"`                 // seconds the sensor clock is ahead
+	Batches          int64      `json:"batches"`
+	BatchesPerMinute float64    `json:"batches_per_minute"` // over the last 10 minutes
+	BytesPerMinute   float64    `json:"bytes_per_minute"`
+	APs              int        `json:"aps"` // devices known that the sensor saw last
+	Clients          int        `json:"clients"`
+	Alerts           int        `json:"alerts"` // alerts not resolved yet about devices the sensor saw last
+}
+
+// Fleet is every sensor at /fleet
+type Fleet struct {
+	Sensors   []FleetSensor `json:"sensors"`
+	Healthy   int           `json:"healthy"`
+	Unhealthy int           `json:"unhealthy"`
+}
+
+// a remote sensor is healthy while it checks in, it has a few refresh intervals to do so
+func checkinHealthy(id string, last time.Time) bool {
+	interval := refreshInterval()
+	if s := settingsFor(id); s.RefreshInterval > 0 {
+		interval = time.Duration(s.RefreshInterval) * time.Second
+	}
+	return time.Since(last) < 3*interval
+}
+
+func getFleet() Fleet {
+	aps, clients := make(map[string]int), make(map[string]int)
+	macSource := make(map[string]string)
+	foundAPs, foundClients := store.Snapshot()
+	for _, ap := range foundAPs {
+		aps[ap.Source]++
+		macSource[ap.MAC] = ap.Source
+	}
+	for _, c := range foundClients {
+		clients[c.Source]++
+		macSource[c.MAC] = c.Source
+	}
+	alertCount := make(map[string]int)
+	alertsMutex.Lock()
+	for _, a := range alerts {
+		if a.State != AlertResolved && a.MAC != "" {
+			if source, ok := macSource[a.MAC]; ok {
+				alertCount[source]++
+			}
+		}
+	}
+	alertsMutex.Unlock()
+
+	fleet := Fleet{Sensors: []FleetSensor{}}
+	checkinsMutex.Lock()
+	defer checkinsMutex.Unlock()
+	for _, s := range listSensors() {
+		f := FleetSensor{ID: s.ID, Problem: s.Problem, Skew: s.Skew, LastSeen: s.LastParsed, APs: aps[s.ID], Clients: clients[s.ID], Alerts: alertCount[s.ID]}
+		if c, ok := checkins[s.ID]; ok {
+			f.Remote, f.Version, f.LastSeen, f.FirstSeen, f.Batches = true, c.version, c.last, &c.first, c.batches
+			var bytes int
+			for _, r := range c.recent {
+				if time.Since(r.time) <= ingestRateWindow {
+					f.BatchesPerMinute++
+					bytes += r.bytes
+				}
+			}
+			f.BatchesPerMinute /= ingestRateWindow.Minutes()
+			f.BytesPerMinute = float64(bytes) / ingestRateWindow.Minutes()
+			f.Healthy = checkinHealthy(s.ID, c.last) && s.Problem == ""
+		} else {
+			f.Healthy = parsingHealthy() && halted() == "" && s.Problem == ""
+		}
+		if f.Healthy {
+			fleet.Healthy++
+		} else {
+			fleet.Unhealthy++
+		}
+		fleet.Sensors = append(fleet.Sensors, f)
+	}
+	return fleet
+}
+
+// every sensor with its health, ingest rate, devices and alerts at /fleet
+func fleetHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, getFleet())
+}
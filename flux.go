@@ -0,0 +1,58 @@
+package main
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// time constant of the smoothed new MAC rate
+const fluxSmoothing = 5 * time.Minute
+
+// Flux is the rate of never-before-seen client MACs, a proxy for foot traffic
+type Flux struct {
+	NewMACs         int     `json:"new_macs"`                     // new MACs in the last parse
+	PerMinute       float64 `json:"new_macs_per_minute"`          // rate over the last parse
+	PerMinuteSmooth float64 `json:"new_macs_per_minute_smoothed"` // exponentially smoothed rate
+	TotalMACs       int     `json:"total_macs"`                   // distinct client MACs seen since netnet started
+	lastUpdate      time.Time
+}
+
+var flux Flux
+var seenMACs = make(map[string]bool)
+var seenSpilled int // of the MACs seen, the ones spilled with their device
+var fluxMutex sync.RWMutex
+
+// count the client MACs never seen before, the first parse only fills in what was already there
+func updateFlux(clients []Client, first bool) {
+	fluxMutex.Lock()
+	defer fluxMutex.Unlock()
+	now := time.Now()
+	count := 0
+	for _, client := range clients {
+		if !seenMACs[client.MAC] {
+			seenMACs[client.MAC] = true
+			count++
+		}
+	}
+	flux.TotalMACs = len(seenMACs) + seenSpilled
+	if first || flux.lastUpdate.IsZero() {
+		flux.lastUpdate = now
+		return
+	}
+	elapsed := now.Sub(flux.lastUpdate)
+	flux.lastUpdate = now
+	if elapsed <= 0 {
+		return
+	}
+	flux.NewMACs = count
+	flux.PerMinute = float64(count) / elapsed.Minutes()
+	alpha := 1 - math.Exp(-float64(elapsed)/float64(fluxSmoothing))
+	flux.PerMinuteSmooth += alpha * (flux.PerMinute - flux.PerMinuteSmooth)
+}
+
+func getFlux() Flux {
+	fluxMutex.RLock()
+	defer fluxMutex.RUnlock()
+	return flux
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// more management frame subtypes and information elements the collectors of frames look at
+const (
+	subtypeAssocReq   = 0
+	subtypeReassocReq = 2
+	subtypeProbeResp  = 5
+	elementSSID       = 0
+	elementDSParams   = 3
+	elementRSN        = 48
+)
+
+// the access points and clients seen in the captured frames so far, every frame is only read once
+var frameAPs = make(map[string]*AccessPoint)
+var frameClients = make(map[string]*Client)
+
+// get the access points and clients from captured frames, adding to what the frames before showed, only the
+// ones in these frames as the store keeps the others and can spill them
+func collectFrames(frames []Frame) (aps []AccessPoint, clients []Client) {
+	touched := make(map[string]bool)
+	for _, frame := range frames {
+		switch {
+		case frame.Type == frameManagement && (frame.Subtype == subtypeBeacon || frame.Subtype == subtypeProbeResp):
+			if len(frame.Body) >= 12 {
+				observeFrameAP(frame)
+				touched[frame.Addr3] = true
+			}
+		case frame.Type == frameManagement && frame.Subtype == subtypeProbeReq:
+			c := observeFrameClient(frame.Addr2, frame)
+			touched[c.MAC] = true
+			for _, e := range parseElements(frame.Body) {
+				if ssid := string(e.Data); e.ID == elementSSID && ssid != "" && !containsString(strings.Split(c.Probes, ","), ssid) {
+					c.Probes = strings.TrimPrefix(c.Probes+","+ssid, ",")
+				}
+			}
+		case frame.Type == frameManagement && (frame.Subtype == subtypeAssocReq || frame.Subtype == subtypeReassocReq):
+			observeFrameClient(frame.Addr2, frame).setBSSID(frame.Addr3)
+			touched[frame.Addr2] = true
+		case frame.Type == frameData:
+			ap, client := frame.Addr2, frame.Addr1
+			if frame.ToDS {
+				ap, client = frame.Addr1, frame.Addr2
+			}
+			observeFrameClient(client, frame).setBSSID(ap)
+			touched[client] = true
+			if a := frameAPs[ap]; a != nil {
+				a.DataPackets++
+				touched[ap] = true
+			}
+		}
+	}
+	for mac := range touched {
+		if ap := frameAPs[mac]; ap != nil {
+			aps = append(aps, *ap)
+		}
+		if c := frameClients[mac]; c != nil {
+			clients = append(clients, *c)
+		}
+	}
+	sort.Slice(aps, func(i, j int) bool { return aps[i].MAC < aps[j].MAC })
+	sort.Slice(clients, func(i, j int) bool { return clients[i].MAC < clients[j].MAC })
+	return
+}
+
+// update an access point from its beacon or probe response
+func observeFrameAP(frame Frame) {
+	ap := frameAPs[frame.Addr3]
+	if ap == nil {
+		ap = &AccessPoint{MAC: frame.Addr3, FirstSeen: frame.Time, Power: -1}
+		frameAPs[frame.Addr3] = ap
+	}
+	if frame.Time.After(ap.LastSeen) {
+		ap.LastSeen = frame.Time
+	}
+	if frame.Subtype == subtypeBeacon {
+		ap.Beacons++
+	}
+	if frame.Power != 0 {
+		ap.Power = frame.Power
+	}
+	if frame.Channel != 0 {
+		ap.Channel = frame.Channel
+	}
+	capability := binary.LittleEndian.Uint16(frame.Body[10:12])
+	ap.Privacy, ap.Authentication = "OPN", ""
+	if capability&0x0010 != 0 {
+		ap.Privacy = "WEP"
+	}
+	maxRate := 0
+	for _, e := range parseElements(frame.Body[12:]) {
+		switch e.ID {
+		case elementSSID:
+			// hidden networks send an empty or zeroed SSID
+			if ssid := string(e.Data); strings.Trim(ssid, "\x00") != "" {
+				ap.Name = ssid
+			}
+		case elementDSParams:
+			if len(e.Data) >= 1 {
+				ap.Channel = int(e.Data[0])
+			}
+		case elementRates, elementExtRates:
+			for _, rate := range e.Data {
+				if r := int(rate & 0x7f); r < 121 && r/2 > maxRate {
+					maxRate = r / 2
+				}
+			}
+		case elementRSN:
+			ap.Privacy, ap.Authentication = "WPA2", rsnAuthentication(e.Data)
+			if ap.Authentication == "SAE" {
+				ap.Privacy = "WPA3"
+			}
+		case elementVendor:
+			if ap.Privacy != "WPA2" && ap.Privacy != "WPA3" && bytes.HasPrefix(e.Data, []byte{0x00, 0x50, 0xf2, 0x01}) {
+				ap.Privacy = "WPA"
+			}
+		}
+	}
+	ap.Speed = strconv.Itoa(maxRate)
+}
+
+// the authentication of an RSN element, named the way airodump-ng does
+func rsnAuthentication(data []byte) string {
+	// version, group cipher, then the pairwise ciphers and the AKM suites, each with a count
+	if len(data) < 8 {
+		return ""
+	}
+	pairwise := int(binary.LittleEndian.Uint16(data[6:8]))
+	offset := 8 + 4*pairwise
+	if len(data) < offset+2 {
+		return ""
+	}
+	count := int(binary.LittleEndian.Uint16(data[offset : offset+2]))
+	var names []string
+	for i := 0; i < count && len(data) >= offset+2+4*(i+1); i++ {
+		suite := data[offset+2+4*i : offset+2+4*(i+1)]
+		switch suite[3] {
+		case 1, 5:
+			names = append(names, "MGT")
+		case 2, 6:
+			names = append(names, "PSK")
+		case 8:
+			names = append(names, "SAE")
+		}
+	}
+	return strings.Join(names, " ")
+}
+
+// get the client a frame is from or to, counting the frame as one of its packets
+func observeFrameClient(mac string, frame Frame) *Client {
+	c := frameClients[mac]
+	if c == nil {
+		c = &Client{MAC: mac, FirstSeen: frame.Time, Power: -1}
+		frameClients[mac] = c
+	}
+	if frame.Time.After(c.LastSeen) {
+		c.LastSeen = frame.Time
+	}
+	if frame.Addr2 == mac {
+		c.Packets++
+		if frame.Power != 0 {
+			c.Power = frame.Power
+		}
+	}
+	return c
+}
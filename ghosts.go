@@ -0,0 +1,36 @@
+package main
+
+import (
+	"sync/atomic"
+)
+
+// GhostConfig is the policy for clients that are only scan artifacts
+type GhostConfig struct {
+	Prune      bool `json:"prune"`       // collapse ghost clients into a counter instead of listing them
+	MaxPackets int  `json:"max_packets"` // clients seen once with at most this many packets are ghosts, defaults to 0
+}
+
+// number of ghost clients pruned from the last parse
+var ghostClients atomic.Int64
+
+// a ghost client was seen only once and sent next to nothing, typically a randomized MAC doing a scan
+func isGhost(c Client, settings GhostConfig) bool {
+	return c.FirstSeen.Equal(c.LastSeen) && c.Packets <= settings.MaxPackets
+}
+
+// remove the ghost clients if pruning is on, counting them instead
+func pruneGhosts(clients []Client) []Client {
+	settings := config.Ghosts
+	if !settings.Prune {
+		ghostClients.Store(0)
+		return clients
+	}
+	kept := make([]Client, 0, len(clients))
+	for _, client := range clients {
+		if !isGhost(client, settings) {
+			kept = append(kept, client)
+		}
+	}
+	ghostClients.Store(int64(len(clients) - len(kept)))
+	return kept
+}
@@ -0,0 +1,467 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// the sensor's track and the positions access points were seen at are appended to these files
+// in the data directory, one JSON object per line
+const gpsTrackFile = "gps-track.jsonl"
+const gpsSightingsFile = "gps-sightings.jsonl"
+
+// most track points and sightings kept in memory, the files keep all of them
+const maxTrackPoints = 100000
+const maxSightings = 200000
+
+// how long to wait before connecting to gpsd again after the connection broke
+const gpsdReconnect = 10 * time.Second
+
+// a fix older than this doesn't say where the sensor is anymore
+const gpsStale = 10 * time.Second
+
+// a new track point or sighting is recorded when the sensor moved this far, or after trackInterval standing still
+const trackDistance = 10.0 // meters
+const trackInterval = time.Minute
+
+// GPSFix is a position reported by gpsd
+type GPSFix struct {
+	Time  time.Time `json:"time"`
+	Lat   float64   `json:"lat"`
+	Lon   float64   `json:"lon"`
+	Alt   float64   `json:"alt,omitempty"`   // meters
+	Speed float64   `json:"speed,omitempty"` // meters per second
+	Mode  int       `json:"mode"`            // 2 for a 2D fix, 3 for 3D
+}
+
+// Sighting is where the sensor was when it saw an access point
+type Sighting struct {
+	Time    time.Time `json:"time"`
+	MAC     string    `json:"mac"`
+	Name    string    `json:"name"`
+	Privacy string    `json:"privacy"`
+	Channel int       `json:"channel"`
+	Power   int       `json:"power"`
+	Lat     float64   `json:"lat"`
+	Lon     float64   `json:"lon"`
+}
+
+// GPSStatus is what /gps shows
+type GPSStatus struct {
+	Gpsd      string  `json:"gpsd"`
+	Connected bool    `json:"connected"`
+	Fix       *GPSFix `json:"fix"` // empty if there is no current fix
+	Points    int     `json:"points"`
+	Sightings int     `json:"sightings"`
+}
+
+var gpsConnected bool
+var gpsRecent []GPSFix // the fixes of the last few minutes, to find where the sensor was when something was seen
+var gpsTrack []GPSFix
+var gpsSightings []Sighting
+var lastSighting = make(map[string]Sighting)
+var gpsMutex sync.RWMutex
+
+// load the track and sightings recorded before
+func loadGPS() {
+	gpsMutex.Lock()
+	defer gpsMutex.Unlock()
+	gpsTrack, gpsSightings = nil, nil
+	lastSighting = make(map[string]Sighting)
+	check(scanJSONLines(gpsTrackFile, func(data []byte) {
+		var fix GPSFix
+		if json.Unmarshal(data, &fix) == nil {
+			gpsTrack = appendTrack(gpsTrack, fix)
+		}
+	}), "Cannot load GPS track:")
+	check(scanJSONLines(gpsSightingsFile, func(data []byte) {
+		var s Sighting
+		if json.Unmarshal(data, &s) == nil {
+			gpsSightings = appendSighting(gpsSightings, s)
+			lastSighting[s.MAC] = s
+		}
+	}), "Cannot load GPS sightings:")
+}
+
+// go through every line of a JSON lines file in the data directory, skipping any line cut short by a crash
+func scanJSONLines(name string, fn func([]byte)) error {
+	file, err := os.Open(filepath.Join(*dataDir, name))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), maxEventSize)
+	for scanner.Scan() {
+		fn(scanner.Bytes())
+	}
+	return scanner.Err()
+}
+
+// append values to a JSON lines file in the data directory
+func appendJSONLines(name string, values ...interface{}) error {
+	err := os.MkdirAll(*dataDir, 0700)
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(filepath.Join(*dataDir, name), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	w := bufio.NewWriter(file)
+	encoder := json.NewEncoder(w)
+	for _, v := range values {
+		err = encoder.Encode(v)
+		if err != nil {
+			return err
+		}
+	}
+	return w.Flush()
+}
+
+func appendTrack(track []GPSFix, fix GPSFix) []GPSFix {
+	track = append(track, fix)
+	if len(track) > maxTrackPoints {
+		track = track[len(track)-maxTrackPoints:]
+	}
+	return track
+}
+
+func appendSighting(sightings []Sighting, s Sighting) []Sighting {
+	sightings = append(sightings, s)
+	if len(sightings) > maxSightings {
+		sightings = sightings[len(sightings)-maxSightings:]
+	}
+	return sightings
+}
+
+// distance in meters between two positions
+func distance(lat1, lon1, lat2, lon2 float64) float64 {
+	const earthRadius = 6371000
+	rad := math.Pi / 180
+	dLat, dLon := (lat2-lat1)*rad, (lon2-lon1)*rad
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1*rad)*math.Cos(lat2*rad)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadius * math.Asin(math.Sqrt(a))
+}
+
+// stay connected to gpsd, connecting again whenever the connection breaks
+func runGpsd() {
+	for {
+		err := watchGpsd(*gpsdAddr)
+		gpsMutex.Lock()
+		gpsConnected = false
+		gpsMutex.Unlock()
+		fmt.Println("Lost gpsd:", err)
+		time.Sleep(gpsdReconnect)
+	}
+}
+
+// ask gpsd for its reports and record the position reports as they come
+func watchGpsd(addr string) error {
+	conn, err := net.DialTimeout("tcp", addr, 10*time.Second)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte(`?WATCH={"enable":true,"json":true};` + "\n"))
+	if err != nil {
+		return err
+	}
+	gpsMutex.Lock()
+	gpsConnected = true
+	gpsMutex.Unlock()
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 64*1024), maxEventSize)
+	for {
+		// gpsd reports at least once a second while it has a receiver
+		conn.SetReadDeadline(time.Now().Add(time.Minute))
+		if !scanner.Scan() {
+			break
+		}
+		var report struct {
+			Class  string    `json:"class"`
+			Mode   int       `json:"mode"`
+			Time   time.Time `json:"time"`
+			Lat    float64   `json:"lat"`
+			Lon    float64   `json:"lon"`
+			Alt    float64   `json:"alt"`
+			AltHAE float64   `json:"altHAE"` // newer versions of gpsd write the altitude here
+			Speed  float64   `json:"speed"`
+		}
+		if json.Unmarshal(scanner.Bytes(), &report) != nil || report.Class != "TPV" || report.Mode < 2 {
+			continue
+		}
+		fix := GPSFix{Time: report.Time, Lat: report.Lat, Lon: report.Lon, Alt: report.Alt, Speed: report.Speed, Mode: report.Mode}
+		if fix.Alt == 0 {
+			fix.Alt = report.AltHAE
+		}
+		if fix.Time.IsZero() {
+			fix.Time = time.Now()
+		}
+		recordFix(fix)
+	}
+	if scanner.Err() != nil {
+		return scanner.Err()
+	}
+	return fmt.Errorf("gpsd closed the connection")
+}
+
+// keep a fix, and add it to the track if the sensor moved or stood still long enough
+func recordFix(fix GPSFix) {
+	gpsMutex.Lock()
+	defer gpsMutex.Unlock()
+	gpsRecent = append(gpsRecent, fix)
+	for len(gpsRecent) > 0 && fix.Time.Sub(gpsRecent[0].Time) > 3*refreshInterval()+gpsStale {
+		gpsRecent = gpsRecent[1:]
+	}
+	if n := len(gpsTrack); n > 0 {
+		last := gpsTrack[n-1]
+		if distance(last.Lat, last.Lon, fix.Lat, fix.Lon) < trackDistance && fix.Time.Sub(last.Time) < trackInterval {
+			return
+		}
+	}
+	gpsTrack = appendTrack(gpsTrack, fix)
+	check(appendJSONLines(gpsTrackFile, fix), "Cannot write GPS track:")
+}
+
+// where the sensor was at a time, from the fix closest to it
+func positionAt(t time.Time) (fix GPSFix, ok bool) {
+	best := gpsStale
+	for _, f := range gpsRecent {
+		d := f.Time.Sub(t)
+		if d < 0 {
+			d = -d
+		}
+		if d <= best {
+			fix, ok, best = f, true, d
+		}
+	}
+	return
+}
+
+// the current position of the sensor
+func currentFix() (GPSFix, bool) {
+	gpsMutex.RLock()
+	defer gpsMutex.RUnlock()
+	return positionAt(time.Now())
+}
+
+// record where the sensor was when it last saw each access point, only when the access point was seen
+// again since the last sighting and the sensor moved or stood still long enough
+func recordSightings(aps []AccessPoint) {
+	if *gpsdAddr == "" {
+		return
+	}
+	gpsMutex.Lock()
+	defer gpsMutex.Unlock()
+	var sightings []interface{}
+	for _, ap := range aps {
+		fix, ok := positionAt(ap.LastSeen)
+		if !ok {
+			continue
+		}
+		if last, ok := lastSighting[ap.MAC]; ok {
+			if !ap.LastSeen.After(last.Time) {
+				continue
+			}
+			if distance(last.Lat, last.Lon, fix.Lat, fix.Lon) < trackDistance && ap.LastSeen.Sub(last.Time) < trackInterval {
+				continue
+			}
+		}
+		s := Sighting{Time: ap.LastSeen, MAC: ap.MAC, Name: ap.Name, Privacy: ap.Privacy, Channel: ap.Channel, Power: ap.Power, Lat: fix.Lat, Lon: fix.Lon}
+		gpsSightings = appendSighting(gpsSightings, s)
+		lastSighting[ap.MAC] = s
+		sightings = append(sightings, s)
+	}
+	if len(sightings) > 0 {
+		check(appendJSONLines(gpsSightingsFile, sightings...), "Cannot write GPS sightings:")
+	}
+}
+
+// record where access points were seen by a sensor that knows its own position, ie Kismet with a GPS, only the
+// sightings newer than the last one of each access point
+func addSightings(sightings []Sighting) {
+	gpsMutex.Lock()
+	defer gpsMutex.Unlock()
+	var out []interface{}
+	for _, s := range sightings {
+		if last, ok := lastSighting[s.MAC]; ok && !s.Time.After(last.Time) {
+			continue
+		}
+		gpsSightings = appendSighting(gpsSightings, s)
+		lastSighting[s.MAC] = s
+		out = append(out, s)
+	}
+	if len(out) > 0 {
+		check(appendJSONLines(gpsSightingsFile, out...), "Cannot write GPS sightings:")
+	}
+}
+
+// the track points and sightings between two times, either can be zero
+func gpsBetween(from, to time.Time) (track []GPSFix, sightings []Sighting) {
+	gpsMutex.RLock()
+	defer gpsMutex.RUnlock()
+	in := func(t time.Time) bool {
+		return (from.IsZero() || !t.Before(from)) && (to.IsZero() || !t.After(to))
+	}
+	track, sightings = []GPSFix{}, []Sighting{}
+	for _, fix := range gpsTrack {
+		if in(fix.Time) {
+			track = append(track, fix)
+		}
+	}
+	for _, s := range gpsSightings {
+		if in(s.Time) {
+			sightings = append(sightings, s)
+		}
+	}
+	return
+}
+
+// the GPX file of a track with a waypoint for every access point, where it had the strongest signal
+type gpx struct {
+	XMLName   xml.Name      `xml:"gpx"`
+	Version   string        `xml:"version,attr"`
+	Creator   string        `xml:"creator,attr"`
+	Namespace string        `xml:"xmlns,attr"`
+	Waypoints []gpxWaypoint `xml:"wpt"`
+	Track     gpxTrack      `xml:"trk"`
+}
+
+type gpxWaypoint struct {
+	Lat         float64   `xml:"lat,attr"`
+	Lon         float64   `xml:"lon,attr"`
+	Time        time.Time `xml:"time"`
+	Name        string    `xml:"name"`
+	Description string    `xml:"desc"`
+}
+
+type gpxTrack struct {
+	Name     string       `xml:"name"`
+	Segments []gpxSegment `xml:"trkseg"`
+}
+
+type gpxSegment struct {
+	Points []gpxPoint `xml:"trkpt"`
+}
+
+type gpxPoint struct {
+	Lat  float64   `xml:"lat,attr"`
+	Lon  float64   `xml:"lon,attr"`
+	Alt  float64   `xml:"ele,omitempty"`
+	Time time.Time `xml:"time"`
+}
+
+// make the GPX file, a gap longer than sessionGap in the track starts a new segment
+func makeGPX(track []GPSFix, sightings []Sighting) gpx {
+	g := gpx{Version: "1.1", Creator: "netnet", Namespace: "http://www.topografix.com/GPX/1/1", Track: gpxTrack{Name: "netnet"}}
+	var segment gpxSegment
+	for i, fix := range track {
+		if i > 0 && fix.Time.Sub(track[i-1].Time) > sessionGap {
+			g.Track.Segments = append(g.Track.Segments, segment)
+			segment = gpxSegment{}
+		}
+		segment.Points = append(segment.Points, gpxPoint{Lat: fix.Lat, Lon: fix.Lon, Alt: fix.Alt, Time: fix.Time.UTC()})
+	}
+	if len(segment.Points) > 0 {
+		g.Track.Segments = append(g.Track.Segments, segment)
+	}
+	strongest := make(map[string]Sighting)
+	for _, s := range sightings {
+		if best, ok := strongest[s.MAC]; !ok || bestPower(best.Power, s.Power) != best.Power {
+			strongest[s.MAC] = s
+		}
+	}
+	for _, s := range strongest {
+		name := s.Name
+		if name == "" {
+			name = formatMAC(s.MAC)
+		}
+		g.Waypoints = append(g.Waypoints, gpxWaypoint{
+			Lat:         s.Lat,
+			Lon:         s.Lon,
+			Time:        s.Time.UTC(),
+			Name:        name,
+			Description: fmt.Sprintf("%s %s channel %d %d dBm", formatMAC(s.MAC), s.Privacy, s.Channel, s.Power),
+		})
+	}
+	sort.Slice(g.Waypoints, func(i, j int) bool { return g.Waypoints[i].Time.Before(g.Waypoints[j].Time) })
+	return g
+}
+
+// the current position at /gps, the track at /gps/track and the sightings at /gps/sightings,
+// filtered with ?from=&to= (RFC 3339 times), the track as GPX with ?format=gpx
+func gpsRoutes(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/gps" {
+		status := GPSStatus{Gpsd: *gpsdAddr}
+		if fix, ok := currentFix(); ok {
+			status.Fix = &fix
+		}
+		gpsMutex.RLock()
+		status.Connected, status.Points, status.Sightings = gpsConnected, len(gpsTrack), len(gpsSightings)
+		gpsMutex.RUnlock()
+		writeJSON(w, status)
+		return
+	}
+	q := r.URL.Query()
+	var from, to time.Time
+	var err error
+	if s := q.Get("from"); s != "" {
+		from, err = time.Parse(time.RFC3339, s)
+		if err != nil {
+			http.Error(w, "Invalid from time: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if s := q.Get("to"); s != "" {
+		to, err = time.Parse(time.RFC3339, s)
+		if err != nil {
+			http.Error(w, "Invalid to time: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	track, sightings := gpsBetween(from, to)
+	switch r.URL.Path {
+	case "/gps/track":
+		switch q.Get("format") {
+		case "", "json":
+			writeJSON(w, track)
+		case "gpx":
+			w.Header().Set("Content-Type", "application/gpx+xml")
+			w.Header().Set("Content-Disposition", `attachment; filename="netnet-`+time.Now().Format("20060102-150405")+`.gpx"`)
+			w.Write([]byte(xml.Header))
+			encoder := xml.NewEncoder(w)
+			encoder.Indent("", "  ")
+			check(encoder.Encode(makeGPX(track, sightings)), "Cannot write GPX:")
+		default:
+			http.Error(w, "Unknown format, use json or gpx", http.StatusBadRequest)
+		}
+	case "/gps/sightings":
+		if mac := q.Get("mac"); mac != "" {
+			var results []Sighting
+			for _, s := range sightings {
+				if s.MAC == normalizeMAC(mac) {
+					results = append(results, s)
+				}
+			}
+			sightings = append([]Sighting{}, results...)
+		}
+		writeJSON(w, sightings)
+	default:
+		http.NotFound(w, r)
+	}
+}
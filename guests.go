@@ -0,0 +1,72 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"time"
+)
+
+// TagGuest marks a device as a guest, its access ends at its guest expiry
+const TagGuest = "guest"
+
+// EventExpiredGuest is raised when a guest device comes back after its access expired
+const EventExpiredGuest = "expired_guest"
+
+// a guest device whose access expired
+func expiredGuest(m DeviceMeta) bool {
+	return containsString(m.Tags, TagGuest) && m.GuestExpires != nil && time.Now().After(*m.GuestExpires)
+}
+
+// the event for a device that came back, if it is a guest whose access expired
+func guestEvent(mac, kind, name string, m DeviceMeta, data interface{}) (Event, bool) {
+	if !expiredGuest(m) {
+		return Event{}, false
+	}
+	if m.Alias != "" {
+		name = m.Alias
+	}
+	message := "Guest " + kind + " " + name + " came back after its access expired on " + m.GuestExpires.Format("2 Jan 2006 15:04")
+	return Event{Type: EventExpiredGuest, Time: time.Now(), MAC: mac, Message: message, Data: data}, true
+}
+
+// Guest is a device tagged as a guest at /guests
+type Guest struct {
+	MAC      string     `json:"mac"`
+	Alias    string     `json:"alias,omitempty"`
+	Kind     string     `json:"kind"` // client or access point
+	Expires  *time.Time `json:"expires,omitempty"`
+	Expired  bool       `json:"expired"`
+	LastSeen time.Time  `json:"last_seen"`
+	Present  bool       `json:"present"` // seen in the last 5 minutes
+}
+
+func getGuests() []Guest {
+	guests := []Guest{}
+	add := func(mac, kind string, lastSeen time.Time, m DeviceMeta) {
+		if containsString(m.Tags, TagGuest) {
+			guests = append(guests, Guest{MAC: formatMAC(mac), Alias: m.Alias, Kind: kind, Expires: m.GuestExpires,
+				Expired: expiredGuest(m), LastSeen: lastSeen, Present: isActive(lastSeen)})
+		}
+	}
+	for _, c := range store.Clients() {
+		add(c.MAC, "client", c.LastSeen, c.DeviceMeta)
+	}
+	for _, ap := range store.APs() {
+		add(ap.MAC, "access point", ap.LastSeen, ap.DeviceMeta)
+	}
+	// expiring soonest first, the ones without an expiry last
+	sort.Slice(guests, func(i, j int) bool {
+		a, b := guests[i].Expires, guests[j].Expires
+		if a == nil || b == nil {
+			return a != nil
+		}
+		return a.Before(*b)
+	})
+	return guests
+}
+
+// the guest devices with when their access expires at /guests, set with PATCH /clients/{mac}
+// {"guest_for": "8h"} or {"guest_expires": "2024-05-01T18:00:00Z"}
+func guestsHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, getGuests())
+}
@@ -0,0 +1,313 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// key information bits of an EAPOL-Key frame
+const (
+	keyInfoVersion = 0x0007
+	keyInfoInstall = 0x0040
+	keyInfoAck     = 0x0080
+	keyInfoMIC     = 0x0100
+)
+
+// RSN key data element carrying the PMKID in the first message of the handshake
+var pmkidKDE = []byte{0xdd, 0x14, 0x00, 0x0f, 0xac, 0x04}
+
+// Handshake is what is needed to crack the passphrase of an access point, either a PMKID or
+// a pair of messages of the 4-way handshake, the byte fields are in hex
+type Handshake struct {
+	AP          string    `json:"ap"`
+	Client      string    `json:"client"`
+	Time        time.Time `json:"time"`
+	PMKID       string    `json:"pmkid,omitempty"`
+	MIC         string    `json:"mic,omitempty"`
+	ANonce      string    `json:"anonce,omitempty"`
+	SNonce      string    `json:"snonce,omitempty"`
+	EAPOL       string    `json:"eapol,omitempty"`        // the second message with its MIC zeroed
+	KeyVersion  int       `json:"key_version,omitempty"`  // 1 for WPA, 2 for WPA2, 3 for AES-CMAC
+	MessagePair int       `json:"message_pair,omitempty"` // 0 for messages 1 and 2, 2 for messages 2 and 3
+}
+
+// eapolKey is an EAPOL-Key frame of a 4-way handshake
+type eapolKey struct {
+	info    uint16
+	replay  uint64
+	nonce   []byte
+	mic     []byte
+	keyData []byte
+	frame   []byte // the whole 802.1X frame
+	time    time.Time
+}
+
+var handshakes = make(map[string][]Handshake)
+var handshakesMutex sync.RWMutex
+
+// a handshake that didn't finish in this long is given up on
+const handshakeTimeout = time.Minute
+
+// the messages of the handshakes still going on, by AP and client
+var pendingM1 = make(map[string]eapolKey)
+var pendingM2 = make(map[string]eapolKey)
+
+func loadHandshakes() {
+	handshakesMutex.Lock()
+	defer handshakesMutex.Unlock()
+	handshakes = make(map[string][]Handshake)
+	check(loadJSON("handshakes.json", &handshakes), "Cannot load handshakes:")
+}
+
+// parse the EAPOL-Key frame after the LLC/SNAP header of a data frame
+func parseEAPOLKey(t time.Time, body []byte) (key eapolKey, ok bool) {
+	if len(body) < len(eapolSNAP) {
+		return key, false
+	}
+	body = body[len(eapolSNAP):]
+	// version, type 3 for key, length, then the key descriptor up to the key data length
+	if len(body) < 99 || body[1] != 3 {
+		return key, false
+	}
+	length := 4 + int(binary.BigEndian.Uint16(body[2:4]))
+	if length > len(body) || length < 99 {
+		return key, false
+	}
+	body = body[:length]
+	dataLength := int(binary.BigEndian.Uint16(body[97:99]))
+	if 99+dataLength > length {
+		return key, false
+	}
+	return eapolKey{
+		info:    binary.BigEndian.Uint16(body[5:7]),
+		replay:  binary.BigEndian.Uint64(body[9:17]),
+		nonce:   body[17:49],
+		mic:     body[81:97],
+		keyData: body[99 : 99+dataLength],
+		frame:   body,
+		time:    t,
+	}, true
+}
+
+// the PMKID in the key data of the first message, if the AP sent one
+func findPMKID(keyData []byte) []byte {
+	i := bytes.Index(keyData, pmkidKDE)
+	if i < 0 || i+len(pmkidKDE)+16 > len(keyData) {
+		return nil
+	}
+	pmkid := keyData[i+len(pmkidKDE) : i+len(pmkidKDE)+16]
+	if bytes.Equal(pmkid, make([]byte, 16)) {
+		return nil
+	}
+	return pmkid
+}
+
+// make a handshake from the second message of the handshake and the nonce of the AP
+func pairHandshake(ap, client string, m2 eapolKey, anonce []byte, pair int) Handshake {
+	eapol := append([]byte{}, m2.frame...)
+	copy(eapol[81:97], make([]byte, 16))
+	return Handshake{
+		AP:          ap,
+		Client:      client,
+		Time:        m2.time,
+		MIC:         hex.EncodeToString(m2.mic),
+		ANonce:      hex.EncodeToString(anonce),
+		SNonce:      hex.EncodeToString(m2.nonce),
+		EAPOL:       hex.EncodeToString(eapol),
+		KeyVersion:  int(m2.info & keyInfoVersion),
+		MessagePair: pair,
+	}
+}
+
+// find the PMKIDs and handshakes in the captured EAPOL frames
+func recordHandshakes(frames []Frame) {
+	var found []Handshake
+	for _, frame := range frames {
+		if frame.Type != frameData || !bytes.HasPrefix(frame.Body, eapolSNAP) {
+			continue
+		}
+		key, ok := parseEAPOLKey(frame.Time, frame.Body)
+		if !ok {
+			continue
+		}
+		ap, client := frame.Addr2, frame.Addr1
+		if frame.ToDS {
+			ap, client = frame.Addr1, frame.Addr2
+		}
+		id := ap + "|" + client
+		switch ack, mic := key.info&keyInfoAck != 0, key.info&keyInfoMIC != 0; {
+		case ack && !mic:
+			pendingM1[id] = key
+			if pmkid := findPMKID(key.keyData); pmkid != nil {
+				found = append(found, Handshake{AP: ap, Client: client, Time: key.time, PMKID: hex.EncodeToString(pmkid)})
+			}
+		case !ack && mic && key.info&keyInfoInstall == 0 && !bytes.Equal(key.nonce, make([]byte, 32)):
+			// the fourth message has no nonce
+			if m1, ok := pendingM1[id]; ok && m1.replay == key.replay {
+				found = append(found, pairHandshake(ap, client, key, m1.nonce, 0))
+				delete(pendingM1, id)
+			} else {
+				pendingM2[id] = key
+			}
+		case ack && mic && key.info&keyInfoInstall != 0:
+			if m2, ok := pendingM2[id]; ok && m2.replay+1 == key.replay {
+				found = append(found, pairHandshake(ap, client, m2, key.nonce, 2))
+				delete(pendingM2, id)
+			}
+		}
+	}
+	for _, pending := range []map[string]eapolKey{pendingM1, pendingM2} {
+		for id, key := range pending {
+			if len(frames) > 0 && frames[len(frames)-1].Time.Sub(key.time) > handshakeTimeout {
+				delete(pending, id)
+			}
+		}
+	}
+	addHandshakes(found)
+}
+
+// keep the handshakes that weren't found before
+func addHandshakes(found []Handshake) {
+	if len(found) == 0 {
+		return
+	}
+	handshakesMutex.Lock()
+	defer handshakesMutex.Unlock()
+	for _, h := range found {
+		if !containsHandshake(handshakes[h.AP], h) {
+			handshakes[h.AP] = append(handshakes[h.AP], h)
+		}
+	}
+	check(saveJSON("handshakes.json", handshakes), "Cannot save handshakes:")
+}
+
+func containsHandshake(list []Handshake, h Handshake) bool {
+	for _, other := range list {
+		if other.Client == h.Client && other.PMKID == h.PMKID && other.MIC == h.MIC {
+			return true
+		}
+	}
+	return false
+}
+
+// write a handshake as a hashcat 22000 line, WPA*01 for a PMKID and WPA*02 for a message pair
+func hashcatLine(h Handshake, essid string) string {
+	ap, client := strings.ToLower(strings.Replace(h.AP, "-", "", -1)), strings.ToLower(strings.Replace(h.Client, "-", "", -1))
+	essidHex := hex.EncodeToString([]byte(essid))
+	if h.PMKID != "" {
+		return fmt.Sprintf("WPA*01*%s*%s*%s*%s***", h.PMKID, ap, client, essidHex)
+	}
+	return fmt.Sprintf("WPA*02*%s*%s*%s*%s*%s*%s*%02x", h.MIC, ap, client, essidHex, h.ANonce, h.EAPOL, h.MessagePair)
+}
+
+// write a message pair as a hashcat hccapx record, PMKIDs can't be written in this format
+func hccapxRecord(h Handshake, essid string) ([]byte, bool) {
+	eapol, _ := hex.DecodeString(h.EAPOL)
+	if h.PMKID != "" || len(eapol) > 256 || len(essid) > 32 {
+		return nil, false
+	}
+	mic, _ := hex.DecodeString(h.MIC)
+	anonce, _ := hex.DecodeString(h.ANonce)
+	snonce, _ := hex.DecodeString(h.SNonce)
+	ap, _ := hex.DecodeString(strings.Replace(h.AP, "-", "", -1))
+	client, _ := hex.DecodeString(strings.Replace(h.Client, "-", "", -1))
+	record := make([]byte, 393)
+	copy(record[0:4], "HCPX")
+	binary.LittleEndian.PutUint32(record[4:8], 4)
+	record[8] = byte(h.MessagePair)
+	record[9] = byte(len(essid))
+	copy(record[10:42], essid)
+	record[42] = byte(h.KeyVersion)
+	copy(record[43:59], mic)
+	copy(record[59:65], ap)
+	copy(record[65:97], anonce)
+	copy(record[97:103], client)
+	copy(record[103:135], snonce)
+	binary.LittleEndian.PutUint16(record[135:137], uint16(len(eapol)))
+	copy(record[137:393], eapol)
+	return record, true
+}
+
+// handshakes captured at /admin/handshakes by access point, exported for hashcat at
+// /admin/handshakes/{mac}?format=22000 or format=hccapx, only with -auth since they can be cracked
+func handshakesHandler(w http.ResponseWriter, r *http.Request) {
+	if !*authEnabled {
+		http.Error(w, "Exporting handshakes needs -auth", http.StatusForbidden)
+		return
+	}
+	handshakesMutex.RLock()
+	defer handshakesMutex.RUnlock()
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/admin/handshakes"), "/")
+	if path == "" {
+		type Summary struct {
+			AP         string `json:"ap"`
+			Name       string `json:"name"`
+			PMKIDs     int    `json:"pmkids"`
+			Handshakes int    `json:"handshakes"`
+		}
+		summaries := []Summary{}
+		for mac, list := range handshakes {
+			s := Summary{AP: formatMAC(mac)}
+			if ap := findAccessPoint(mac); ap != nil {
+				s.Name = ap.Name
+			}
+			for _, h := range list {
+				if h.PMKID != "" {
+					s.PMKIDs++
+				} else {
+					s.Handshakes++
+				}
+			}
+			summaries = append(summaries, s)
+		}
+		sort.Slice(summaries, func(i, j int) bool { return summaries[i].AP < summaries[j].AP })
+		writeJSON(w, summaries)
+		return
+	}
+	mac := normalizeMAC(path)
+	list := handshakes[mac]
+	if len(list) == 0 {
+		http.Error(w, "No handshakes captured from "+formatMAC(mac), http.StatusNotFound)
+		return
+	}
+	// the ESSID is salted into the key, so it has to be known
+	ap := findAccessPoint(mac)
+	if ap == nil || strings.TrimSpace(ap.Name) == "" {
+		http.Error(w, "ESSID of "+formatMAC(mac)+" is not known", http.StatusConflict)
+		return
+	}
+	name := strings.Replace(formatMAC(mac), ":", "", -1)
+	switch r.URL.Query().Get("format") {
+	case "", "22000":
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("Content-Disposition", "attachment; filename="+name+".22000")
+		for _, h := range list {
+			fmt.Fprintln(w, hashcatLine(h, ap.Name))
+		}
+	case "hccapx":
+		var records []byte
+		for _, h := range list {
+			if record, ok := hccapxRecord(h, ap.Name); ok {
+				records = append(records, record...)
+			}
+		}
+		if len(records) == 0 {
+			http.Error(w, "Only PMKIDs captured from "+formatMAC(mac)+", which hccapx can't hold", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Disposition", "attachment; filename="+name+".hccapx")
+		w.Write(records)
+	case "json":
+		writeJSON(w, list)
+	default:
+		http.Error(w, "Unknown format, use 22000, hccapx or json", http.StatusBadRequest)
+	}
+}
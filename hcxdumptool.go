@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// how often hcxpcapngtool is run on the capture, it goes through the whole file every time
+const hcxpcapngtoolInterval = time.Minute
+
+// how long to wait before starting hcxdumptool again after it stopped
+const hcxdumptoolRestart = 10 * time.Second
+
+// HcxdumptoolConfig is how the hcxdumptool collector runs hcxdumptool and hcxpcapngtool
+type HcxdumptoolConfig struct {
+	Interface     string   `json:"interface"`     // netnet starts hcxdumptool on this interface, if empty it reads a capture started some other way
+	File          string   `json:"file"`          // pcapng file hcxdumptool writes, defaults to hcxdumptool.pcapng in the data directory
+	Command       string   `json:"command"`       // defaults to hcxdumptool
+	Args          []string `json:"args"`          // more arguments for hcxdumptool
+	Hcxpcapngtool string   `json:"hcxpcapngtool"` // hcxpcapngtool command to get the hashes with as well, netnet only finds them itself if empty
+}
+
+// size of the capture when hcxpcapngtool last ran
+var hcxpcapngtoolSize int64
+var hcxpcapngtoolRun time.Time
+
+// the pcapng file hcxdumptool writes
+func hcxdumptoolFile() string {
+	if config.Hcxdumptool.File != "" {
+		return config.Hcxdumptool.File
+	}
+	return filepath.Join(*dataDir, "hcxdumptool.pcapng")
+}
+
+// run hcxdumptool on the configured interface, starting it again whenever it stops
+func runHcxdumptool() {
+	settings := config.Hcxdumptool
+	if settings.Command == "" {
+		settings.Command = "hcxdumptool"
+	}
+	file := hcxdumptoolFile()
+	check(os.MkdirAll(filepath.Dir(file), 0700), "Cannot create capture directory:")
+	for {
+		// hcxdumptool won't write to a file that is already there
+		if _, err := os.Stat(file); err == nil {
+			check(os.Rename(file, strings.TrimSuffix(file, ".pcapng")+"-"+time.Now().Format("20060102150405")+".pcapng"), "Cannot move old capture:")
+		}
+		args := append([]string{"-i", settings.Interface, "-w", file}, settings.Args...)
+		output, err := exec.Command(settings.Command, args...).CombinedOutput()
+		fmt.Println("hcxdumptool stopped:", err, strings.TrimSpace(string(output)))
+		time.Sleep(hcxdumptoolRestart)
+	}
+}
+
+// get the access points and clients from the frames hcxdumptool captured, with the hashes of hcxpcapngtool
+func collectHcxdumptool(frames []Frame) (aps []AccessPoint, clients []Client) {
+	aps, clients = collectFrames(frames)
+	importHcxpcapngtool()
+	return
+}
+
+// get the hashes hcxpcapngtool finds in the capture, it knows about more corner cases than netnet
+func importHcxpcapngtool() {
+	command := config.Hcxdumptool.Hcxpcapngtool
+	if command == "" || time.Since(hcxpcapngtoolRun) < hcxpcapngtoolInterval {
+		return
+	}
+	info, err := os.Stat(hcxdumptoolFile())
+	if err != nil || info.Size() == hcxpcapngtoolSize {
+		return
+	}
+	hcxpcapngtoolRun, hcxpcapngtoolSize = time.Now(), info.Size()
+	out, err := ioutil.TempFile("", "netnet-*.22000")
+	if err != nil {
+		check(err, "Cannot run hcxpcapngtool:")
+		return
+	}
+	out.Close()
+	defer os.Remove(out.Name())
+	output, err := exec.Command(command, "-o", out.Name(), hcxdumptoolFile()).CombinedOutput()
+	if err != nil {
+		fmt.Println("Cannot run hcxpcapngtool:", err, strings.TrimSpace(string(output)))
+		return
+	}
+	file, err := os.Open(out.Name())
+	if err != nil {
+		check(err, "Cannot read hcxpcapngtool hashes:")
+		return
+	}
+	defer file.Close()
+	var found []Handshake
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if h, ok := parseHashcatLine(scanner.Text()); ok {
+			found = append(found, h)
+		}
+	}
+	addHandshakes(found)
+}
+
+// read a hashcat 22000 line back into a handshake
+func parseHashcatLine(line string) (h Handshake, ok bool) {
+	fields := strings.Split(strings.TrimSpace(line), "*")
+	if len(fields) != 9 || fields[0] != "WPA" {
+		return h, false
+	}
+	ap, ok1 := parseMAC(fields[3])
+	client, ok2 := parseMAC(fields[4])
+	if !ok1 || !ok2 {
+		return h, false
+	}
+	h = Handshake{AP: ap, Client: client, Time: time.Now()}
+	switch fields[1] {
+	case "01":
+		h.PMKID = fields[2]
+	case "02":
+		eapol, err := hex.DecodeString(fields[7])
+		if err != nil || len(eapol) < 49 {
+			return h, false
+		}
+		pair, _ := strconv.ParseUint(fields[8], 16, 8)
+		h.MIC, h.ANonce, h.EAPOL = fields[2], fields[6], fields[7]
+		h.SNonce = hex.EncodeToString(eapol[17:49])
+		h.KeyVersion = int(binary.BigEndian.Uint16(eapol[5:7]) & keyInfoVersion)
+		h.MessagePair = int(pair)
+	default:
+		return h, false
+	}
+	return h, true
+}
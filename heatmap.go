@@ -0,0 +1,154 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+)
+
+// cap on the grid size so a tiny cell doesn't take forever
+const maxHeatmapCells = 250000
+
+// HeatmapConfig is the area covered by the heatmap and where the capture points are, in meters
+type HeatmapConfig struct {
+	Width         float64        `json:"width"`
+	Height        float64        `json:"height"`
+	Cell          float64        `json:"cell"`      // size of a grid cell, defaults to 1 meter
+	TxPower       float64        `json:"tx_power"`  // power received 1 meter away from a device, defaults to -40 dBm
+	PathLoss      float64        `json:"path_loss"` // path loss exponent, 2 in free space and up to 4 indoors, defaults to 3
+	CapturePoints []CapturePoint `json:"capture_points"`
+}
+
+// CapturePoint is where a sensor is on the floorplan
+type CapturePoint struct {
+	Name   string  `json:"name"`
+	X      float64 `json:"x"`
+	Y      float64 `json:"y"`
+	Source string  `json:"source,omitempty"` // sensor at the capture point, it sees every device if empty
+}
+
+// Heatmap is the gridded intensity, rows from the top of the floorplan
+type Heatmap struct {
+	Width         float64        `json:"width"`
+	Height        float64        `json:"height"`
+	Cell          float64        `json:"cell"`
+	Rows          int            `json:"rows"`
+	Cols          int            `json:"cols"`
+	CapturePoints []CapturePoint `json:"capture_points"`
+	Occupancy     [][]float64    `json:"occupancy"` // expected number of devices in each cell
+	Signal        [][]float64    `json:"signal"`    // average power of the devices in each cell, 0 if none
+	MaxOccupancy  float64        `json:"max_occupancy"`
+}
+
+func heatmapSettings() HeatmapConfig {
+	c := config.Heatmap
+	if c.Cell <= 0 {
+		c.Cell = 1
+	}
+	if c.TxPower == 0 {
+		c.TxPower = -40
+	}
+	if c.PathLoss <= 0 {
+		c.PathLoss = 3
+	}
+	return c
+}
+
+// estimate the distance to a device from its power with the log-distance path loss model
+func estimateDistance(power int, settings HeatmapConfig) float64 {
+	return math.Pow(10, (settings.TxPower-float64(power))/(10*settings.PathLoss))
+}
+
+// spread each device over a ring around the capture points at its estimated distance
+func buildHeatmap(clients []Client, settings HeatmapConfig) Heatmap {
+	h := Heatmap{
+		Width:         settings.Width,
+		Height:        settings.Height,
+		Cell:          settings.Cell,
+		Rows:          int(math.Ceil(settings.Height / settings.Cell)),
+		Cols:          int(math.Ceil(settings.Width / settings.Cell)),
+		CapturePoints: settings.CapturePoints,
+	}
+	signalSum := make([][]float64, h.Rows)
+	h.Occupancy = make([][]float64, h.Rows)
+	h.Signal = make([][]float64, h.Rows)
+	for i := range h.Occupancy {
+		signalSum[i] = make([]float64, h.Cols)
+		h.Occupancy[i] = make([]float64, h.Cols)
+		h.Signal[i] = make([]float64, h.Cols)
+	}
+	if len(settings.CapturePoints) == 0 {
+		return h
+	}
+	for _, client := range clients {
+		if client.Power >= 0 || client.Power == -1 {
+			continue
+		}
+		d := estimateDistance(client.Power, settings)
+		sigma := math.Max(settings.Cell, 0.3*d)
+		// the device is shared between the capture points that see it
+		seenBy := 0
+		for _, p := range settings.CapturePoints {
+			if p.Source == "" || p.Source == client.Source {
+				seenBy++
+			}
+		}
+		if seenBy == 0 {
+			continue
+		}
+		share := 1 / float64(seenBy)
+		for _, p := range settings.CapturePoints {
+			if p.Source != "" && p.Source != client.Source {
+				continue
+			}
+			weights := make([][]float64, h.Rows)
+			total := 0.0
+			for row := 0; row < h.Rows; row++ {
+				weights[row] = make([]float64, h.Cols)
+				for col := 0; col < h.Cols; col++ {
+					x, y := (float64(col)+0.5)*h.Cell, (float64(row)+0.5)*h.Cell
+					off := math.Hypot(x-p.X, y-p.Y) - d
+					weights[row][col] = math.Exp(-off * off / (2 * sigma * sigma))
+					total += weights[row][col]
+				}
+			}
+			if total == 0 {
+				continue
+			}
+			for row := range weights {
+				for col, weight := range weights[row] {
+					weight = weight / total * share
+					h.Occupancy[row][col] += weight
+					signalSum[row][col] += weight * float64(client.Power)
+				}
+			}
+		}
+	}
+	for row := range h.Occupancy {
+		for col, occupancy := range h.Occupancy[row] {
+			if occupancy > 0 {
+				h.Signal[row][col] = signalSum[row][col] / occupancy
+			}
+			h.MaxOccupancy = math.Max(h.MaxOccupancy, occupancy)
+		}
+	}
+	return h
+}
+
+// heatmap of the clients seen in the last few minutes at /heatmap, the window can be changed with ?window=minutes
+func heatmap(w http.ResponseWriter, r *http.Request) {
+	settings := heatmapSettings()
+	if settings.Width <= 0 || settings.Height <= 0 {
+		http.Error(w, "No heatmap area configured", http.StatusNotFound)
+		return
+	}
+	if math.Ceil(settings.Width/settings.Cell)*math.Ceil(settings.Height/settings.Cell) > maxHeatmapCells {
+		http.Error(w, "Heatmap grid is too large, use a bigger cell", http.StatusInternalServerError)
+		return
+	}
+	window := 5
+	if n, err := strconv.Atoi(r.URL.Query().Get("window")); err == nil && n > 0 {
+		window = n
+	}
+	writeJSON(w, buildHeatmap(filterByLastSeen(store.Clients(), window), settings))
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// maximum number of samples kept per device (about 4 hours at one parse every 10 seconds)
+const maxSamples = 1440
+
+// a gap in sightings longer than this starts a new session
+const sessionGap = 5 * time.Minute
+
+// Sample is a single observation of a device taken at every parse
+type Sample struct {
+	Time     time.Time `json:"time"`
+	LastSeen time.Time `json:"last_seen"`
+	Power    int       `json:"power"`
+	Packets  int       `json:"packets"`
+}
+
+// Session is a continuous period of time where a device is seen
+type Session struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+}
+
+var history = make(map[string][]Sample)
+var historyMutex sync.RWMutex
+var historyPruned time.Time
+
+// how far back the samples of a device go, maxSamples at the refresh interval
+func historyRetention() time.Duration {
+	return maxSamples * refreshInterval()
+}
+
+// record the latest observations of the access points and clients
+func recordHistory(aps []AccessPoint, clients []Client) {
+	now := time.Now()
+	historyMutex.Lock()
+	defer historyMutex.Unlock()
+	for _, ap := range aps {
+		addSample(ap.MAC, Sample{Time: now, LastSeen: ap.LastSeen, Power: ap.Power})
+	}
+	for _, client := range clients {
+		addSample(client.MAC, Sample{Time: now, LastSeen: client.LastSeen, Power: client.Power, Packets: client.Packets})
+	}
+	// forget the devices that have had no sample for longer than the history goes back, ie since they were spilled
+	if now.Sub(historyPruned) >= time.Minute {
+		historyPruned = now
+		since := now.Add(-historyRetention())
+		for mac, samples := range history {
+			if len(samples) == 0 || samples[len(samples)-1].Time.Before(since) {
+				delete(history, mac)
+			}
+		}
+	}
+}
+
+func addSample(mac string, sample Sample) {
+	samples := append(history[mac], sample)
+	if len(samples) > maxSamples {
+		samples = samples[len(samples)-maxSamples:]
+	}
+	history[mac] = samples
+}
+
+// get a copy of the samples recorded for a device
+func getHistory(mac string) (samples []Sample) {
+	historyMutex.RLock()
+	defer historyMutex.RUnlock()
+	samples = append(samples, history[mac]...)
+	return
+}
+
+// work out the sessions of a device from its samples
+func getSessions(samples []Sample) (sessions []Session) {
+	for _, sample := range samples {
+		n := len(sessions)
+		if n > 0 && sample.LastSeen.Sub(sessions[n-1].End) <= sessionGap {
+			if sample.LastSeen.After(sessions[n-1].End) {
+				sessions[n-1].End = sample.LastSeen
+			}
+			continue
+		}
+		sessions = append(sessions, Session{Start: sample.LastSeen, End: sample.LastSeen})
+	}
+	return
+}
+
+// get a copy of the samples of every device taken after a time
+func getHistorySince(t time.Time) map[string][]Sample {
+	historyMutex.RLock()
+	defer historyMutex.RUnlock()
+	results := make(map[string][]Sample)
+	for mac, samples := range history {
+		for _, sample := range samples {
+			if sample.Time.After(t) {
+				results[mac] = append(results[mac], sample)
+			}
+		}
+	}
+	return results
+}
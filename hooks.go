@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// how long a hook or plugin command can run before it is killed
+const hookTimeout = 30 * time.Second
+
+// EventBurst is the type of the event a hook gets for a group of events, with the events in its data
+const EventBurst = "burst"
+
+// Hook is an external command that is run when an event happens, the event JSON is passed on stdin
+type Hook struct {
+	Event      string `json:"event"`       // event type, or * for all events
+	Throttle   int    `json:"throttle"`    // minutes before the same device can run the hook again for the same event type
+	Group      int    `json:"group"`       // seconds to collect a burst of events for, the hook is then run once for all of them
+	QuietHours string `json:"quiet_hours"` // ie 22:00-07:00, events in between don't run the hook
+	Plugin
+
+	mutex   sync.Mutex
+	last    map[string]time.Time // last time the hook ran for an event type and device
+	pending []Event              // events collected in the current group
+}
+
+// parse quiet hours like 22:00-07:00 into minutes since midnight
+func parseQuietHours(s string) (start, end int, err error) {
+	var h1, m1, h2, m2 int
+	_, err = fmt.Sscanf(strings.TrimSpace(s), "%d:%d-%d:%d", &h1, &m1, &h2, &m2)
+	if err != nil || h1 > 23 || h2 > 23 || m1 > 59 || m2 > 59 || h1 < 0 || h2 < 0 || m1 < 0 || m2 < 0 {
+		return 0, 0, fmt.Errorf("invalid quiet hours %q, use ie 22:00-07:00", s)
+	}
+	return h1*60 + m1, h2*60 + m2, nil
+}
+
+// check if the time is within the quiet hours, which can go past midnight
+func (hook *Hook) quiet(t time.Time) bool {
+	if hook.QuietHours == "" {
+		return false
+	}
+	start, end, err := parseQuietHours(hook.QuietHours)
+	if err != nil {
+		return false
+	}
+	now := t.Hour()*60 + t.Minute()
+	if start <= end {
+		return now >= start && now < end
+	}
+	return now >= start || now < end
+}
+
+// Notify runs the hook command if the event is the one the hook is for, unless it is quiet hours,
+// throttled or waiting to be sent with its group
+func (hook *Hook) Notify(e Event) error {
+	if hook.Event != "*" && hook.Event != e.Type {
+		return nil
+	}
+	if hook.quiet(e.Time) {
+		return nil
+	}
+	hook.mutex.Lock()
+	if hook.Throttle > 0 {
+		if hook.last == nil {
+			hook.last = make(map[string]time.Time)
+		}
+		key := e.Type + " " + e.MAC
+		if last, ok := hook.last[key]; ok && e.Time.Sub(last) < time.Duration(hook.Throttle)*time.Minute {
+			hook.mutex.Unlock()
+			return nil
+		}
+		hook.last[key] = e.Time
+	}
+	if hook.Group > 0 {
+		hook.pending = append(hook.pending, e)
+		if len(hook.pending) == 1 {
+			time.AfterFunc(time.Duration(hook.Group)*time.Second, hook.flush)
+		}
+		hook.mutex.Unlock()
+		return nil
+	}
+	hook.mutex.Unlock()
+	return hook.Plugin.Notify(e)
+}
+
+// run the hook for the events collected in the group, a single event is sent as it is
+func (hook *Hook) flush() {
+	hook.mutex.Lock()
+	events := hook.pending
+	hook.pending = nil
+	hook.mutex.Unlock()
+	e := events[0]
+	if len(events) > 1 {
+		e = Event{Type: EventBurst, Time: time.Now(), Message: fmt.Sprintf("%d events", len(events)), Data: events}
+	}
+	err := hook.Plugin.Notify(e)
+	if err != nil {
+		fmt.Println("Notifier", hook.Name(), "failed:", err)
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// HTTPConfig tunes the web server, ie for many small clients polling the API
+type HTTPConfig struct {
+	ReadTimeout       int  `json:"read_timeout"`        // seconds to read a whole request, no limit if 0
+	ReadHeaderTimeout int  `json:"read_header_timeout"` // seconds to read the headers of a request, defaults to 10
+	WriteTimeout      int  `json:"write_timeout"`       // seconds to write a response, no limit if 0
+	IdleTimeout       int  `json:"idle_timeout"`        // seconds a keep-alive connection waits for the next request, defaults to the read timeout
+	MaxHeaderBytes    int  `json:"max_header_bytes"`    // defaults to 1 MB
+	DisableKeepAlives bool `json:"disable_keep_alives"` // a new connection for every request
+	TCPKeepAlive      int  `json:"tcp_keep_alive"`      // seconds between TCP keep-alive probes, defaults to 15, -1 turns them off
+
+	DisableHTTP2         bool `json:"disable_http2"`          // HTTP/2 is on for HTTPS
+	H2C                  bool `json:"h2c"`                    // HTTP/2 without TLS too, ie behind a proxy that speaks it
+	MaxConcurrentStreams int  `json:"max_concurrent_streams"` // HTTP/2 requests at the same time on a connection, defaults to 250
+
+	SocketMode string `json:"socket_mode"` // permissions of the Unix socket with -listen unix:, defaults to 0660
+}
+
+// the slowloris guard, clients have this long to send the headers
+const defaultReadHeaderTimeout = 10 * time.Second
+
+func seconds(n int) time.Duration {
+	return time.Duration(n) * time.Second
+}
+
+// the web server for the handler, set up as in the configuration
+func newServer(addr string, handler http.Handler) *http.Server {
+	settings := config.HTTP
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadTimeout:       seconds(settings.ReadTimeout),
+		ReadHeaderTimeout: seconds(settings.ReadHeaderTimeout),
+		WriteTimeout:      seconds(settings.WriteTimeout),
+		IdleTimeout:       seconds(settings.IdleTimeout),
+		MaxHeaderBytes:    settings.MaxHeaderBytes,
+	}
+	if server.ReadHeaderTimeout == 0 {
+		server.ReadHeaderTimeout = defaultReadHeaderTimeout
+	}
+	server.SetKeepAlivesEnabled(!settings.DisableKeepAlives)
+
+	server.Protocols = new(http.Protocols)
+	server.Protocols.SetHTTP1(true)
+	server.Protocols.SetHTTP2(!settings.DisableHTTP2)
+	server.Protocols.SetUnencryptedHTTP2(settings.H2C && !settings.DisableHTTP2)
+	if settings.MaxConcurrentStreams > 0 {
+		server.HTTP2 = &http.HTTP2Config{MaxConcurrentStreams: settings.MaxConcurrentStreams}
+	}
+	return server
+}
+
+// listen where -listen says, or on the server's address with the TCP keep-alive in the configuration
+func listen(server *http.Server) (net.Listener, error) {
+	switch {
+	case *listenAddr == "systemd":
+		return sdListener()
+	case strings.HasPrefix(*listenAddr, "unix:"):
+		return listenUnix(strings.TrimPrefix(*listenAddr, "unix:"))
+	case *listenAddr != "":
+		server.Addr = *listenAddr
+	}
+	lc := net.ListenConfig{KeepAlive: seconds(config.HTTP.TCPKeepAlive)}
+	return lc.Listen(context.Background(), "tcp", server.Addr)
+}
+
+// listen on a Unix socket, replacing the one left behind by the last run
+func listenUnix(path string) (net.Listener, error) {
+	if info, err := os.Stat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		os.Remove(path)
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	mode := os.FileMode(0660)
+	if config.HTTP.SocketMode != "" {
+		m, err := strconv.ParseUint(config.HTTP.SocketMode, 8, 32)
+		if err != nil {
+			listener.Close()
+			return nil, fmt.Errorf("invalid socket mode %q", config.HTTP.SocketMode)
+		}
+		mode = os.FileMode(m)
+	}
+	if err = os.Chmod(path, mode); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return listener, nil
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"net/http"
+)
+
+// WirelessInterface is a wireless network interface and what its hardware can do
+type WirelessInterface struct {
+	Name    string     `json:"name"`
+	MAC     string     `json:"mac"`
+	Phy     string     `json:"phy"`
+	Driver  string     `json:"driver"`
+	Mode    string     `json:"mode"`    // what the interface is now, ie managed or monitor
+	Monitor bool       `json:"monitor"` // the hardware can capture in monitor mode
+	Modes   []string   `json:"modes"`   // every mode the hardware supports
+	Bands   []WiFiBand `json:"bands"`
+}
+
+// WiFiBand is a band the hardware supports with its usable channels
+type WiFiBand struct {
+	Name     string `json:"name"`
+	Channels []int  `json:"channels"`
+}
+
+// names of the nl80211 interface types
+var interfaceModes = map[int]string{
+	1:  "adhoc",
+	2:  "managed",
+	3:  "ap",
+	4:  "ap_vlan",
+	5:  "wds",
+	6:  "monitor",
+	7:  "mesh",
+	8:  "p2p_client",
+	9:  "p2p_go",
+	10: "p2p_device",
+	11: "ocb",
+	12: "nan",
+}
+
+// names of the nl80211 bands
+var bandNames = map[int]string{
+	0: "2.4GHz",
+	1: "5GHz",
+	2: "60GHz",
+	3: "6GHz",
+	4: "900MHz",
+	5: "LC",
+}
+
+// the wireless interfaces on this computer at /capture/interfaces
+func captureInterfaces(w http.ResponseWriter, r *http.Request) {
+	interfaces, err := listWirelessInterfaces()
+	if err != nil {
+		http.Error(w, "Cannot list wireless interfaces: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, interfaces)
+}
@@ -0,0 +1,296 @@
+//go:build linux
+
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+)
+
+// generic netlink and nl80211 messages and attributes, from linux/genetlink.h and linux/nl80211.h
+const (
+	genlIDCtrl            = 0x10
+	ctrlCmdGetFamily      = 3
+	ctrlAttrFamilyID      = 1
+	ctrlAttrFamilyName    = 2
+	nl80211CmdGetWiphy    = 1
+	nl80211CmdGetIface    = 5
+	nl80211AttrWiphy      = 1
+	nl80211AttrWiphyName  = 2
+	nl80211AttrIfname     = 4
+	nl80211AttrIftype     = 5
+	nl80211AttrMAC        = 6
+	nl80211AttrBands      = 22
+	nl80211AttrIftypes    = 32
+	nl80211AttrSplitDump  = 174
+	nl80211BandAttrFreqs  = 1
+	nl80211FreqAttrFreq   = 1
+	nl80211FreqAttrOff    = 2
+	nl80211IftypeMonitor  = 6
+	netlinkReceiveTimeout = 5
+)
+
+// netlink attribute with its type and payload
+type netlinkAttr struct {
+	typ  uint16
+	data []byte
+}
+
+// what nl80211 says about a wiphy, it can come in several messages
+type wiphy struct {
+	name  string
+	modes map[int]bool
+	bands map[int]map[int]bool // frequencies by band, true if usable
+}
+
+// netlink socket talking generic netlink
+type genetlink struct {
+	fd  int
+	seq uint32
+}
+
+// list the wireless interfaces with what their hardware can do, from nl80211
+func listWirelessInterfaces() ([]WirelessInterface, error) {
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC, syscall.NETLINK_GENERIC)
+	if err != nil {
+		return nil, err
+	}
+	nl := &genetlink{fd: fd}
+	defer syscall.Close(fd)
+	err = syscall.Bind(fd, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK})
+	if err != nil {
+		return nil, err
+	}
+	err = syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &syscall.Timeval{Sec: netlinkReceiveTimeout})
+	if err != nil {
+		return nil, err
+	}
+	family, err := nl.family("nl80211")
+	if err != nil {
+		return nil, err
+	}
+
+	// the hardware
+	wiphys := make(map[uint32]*wiphy)
+	messages, err := nl.request(family, nl80211CmdGetWiphy, syscall.NLM_F_DUMP, encodeAttr(nl80211AttrSplitDump, nil))
+	if err != nil {
+		return nil, err
+	}
+	for _, message := range messages {
+		attrs := parseAttrs(message)
+		index, ok := attrUint32(attrs, nl80211AttrWiphy)
+		if !ok {
+			continue
+		}
+		phy := wiphys[index]
+		if phy == nil {
+			phy = &wiphy{modes: make(map[int]bool), bands: make(map[int]map[int]bool)}
+			wiphys[index] = phy
+		}
+		for _, attr := range attrs {
+			switch attr.typ {
+			case nl80211AttrWiphyName:
+				phy.name = strings.TrimRight(string(attr.data), "\x00")
+			case nl80211AttrIftypes:
+				for _, mode := range parseAttrs(attr.data) {
+					phy.modes[int(mode.typ)] = true
+				}
+			case nl80211AttrBands:
+				for _, band := range parseAttrs(attr.data) {
+					if phy.bands[int(band.typ)] == nil {
+						phy.bands[int(band.typ)] = make(map[int]bool)
+					}
+					for _, bandAttr := range parseAttrs(band.data) {
+						if bandAttr.typ != nl80211BandAttrFreqs {
+							continue
+						}
+						for _, freq := range parseAttrs(bandAttr.data) {
+							freqAttrs := parseAttrs(freq.data)
+							mhz, ok := attrUint32(freqAttrs, nl80211FreqAttrFreq)
+							if !ok {
+								continue
+							}
+							_, disabled := findAttr(freqAttrs, nl80211FreqAttrOff)
+							phy.bands[int(band.typ)][int(mhz)] = !disabled
+						}
+					}
+				}
+			}
+		}
+	}
+
+	// the interfaces on the hardware
+	messages, err = nl.request(family, nl80211CmdGetIface, syscall.NLM_F_DUMP, nil)
+	if err != nil {
+		return nil, err
+	}
+	interfaces := []WirelessInterface{}
+	for _, message := range messages {
+		attrs := parseAttrs(message)
+		name, _ := findAttr(attrs, nl80211AttrIfname)
+		index, _ := attrUint32(attrs, nl80211AttrWiphy)
+		mode, _ := attrUint32(attrs, nl80211AttrIftype)
+		mac, _ := findAttr(attrs, nl80211AttrMAC)
+		wi := WirelessInterface{Name: strings.TrimRight(string(name), "\x00"), Mode: interfaceModes[int(mode)], Modes: []string{}, Bands: []WiFiBand{}}
+		if len(mac) == 6 {
+			wi.MAC = formatMAC(macString(mac))
+		}
+		if phy := wiphys[index]; phy != nil {
+			wi.Phy = phy.name
+			wi.Monitor = phy.modes[nl80211IftypeMonitor]
+			for mode := range phy.modes {
+				if name, ok := interfaceModes[mode]; ok {
+					wi.Modes = append(wi.Modes, name)
+				}
+			}
+			sort.Strings(wi.Modes)
+			for band, freqs := range phy.bands {
+				b := WiFiBand{Name: bandNames[band], Channels: []int{}}
+				if b.Name == "" {
+					b.Name = fmt.Sprintf("band %d", band)
+				}
+				for mhz, usable := range freqs {
+					if channel := frequencyChannel(mhz); usable && channel > 0 {
+						b.Channels = append(b.Channels, channel)
+					}
+				}
+				sort.Ints(b.Channels)
+				wi.Bands = append(wi.Bands, b)
+			}
+			sort.Slice(wi.Bands, func(i, j int) bool { return wi.Bands[i].Name < wi.Bands[j].Name })
+		}
+		wi.Driver = interfaceDriver(wi.Name)
+		interfaces = append(interfaces, wi)
+	}
+	sort.Slice(interfaces, func(i, j int) bool { return interfaces[i].Name < interfaces[j].Name })
+	return interfaces, nil
+}
+
+// the kernel driver of a network interface, from sysfs
+func interfaceDriver(name string) string {
+	path, err := filepath.EvalSymlinks(filepath.Join("/sys/class/net", name, "device", "driver"))
+	if err != nil {
+		return ""
+	}
+	return filepath.Base(path)
+}
+
+// look up the ID of a generic netlink family
+func (nl *genetlink) family(name string) (uint16, error) {
+	messages, err := nl.request(genlIDCtrl, ctrlCmdGetFamily, 0, encodeAttr(ctrlAttrFamilyName, append([]byte(name), 0)))
+	if err != nil {
+		if err == syscall.ENOENT {
+			return 0, errors.New("no nl80211, the kernel has no wireless support")
+		}
+		return 0, err
+	}
+	for _, message := range messages {
+		if id, ok := findAttr(parseAttrs(message), ctrlAttrFamilyID); ok && len(id) >= 2 {
+			return binary.LittleEndian.Uint16(id), nil
+		}
+	}
+	return 0, errors.New("no nl80211 family")
+}
+
+// send a generic netlink request and get the attributes of every message in the reply
+func (nl *genetlink) request(family uint16, cmd uint8, flags uint16, attrs []byte) ([][]byte, error) {
+	nl.seq++
+	msg := make([]byte, 20, 20+len(attrs))
+	binary.LittleEndian.PutUint32(msg[0:4], uint32(20+len(attrs)))
+	binary.LittleEndian.PutUint16(msg[4:6], family)
+	binary.LittleEndian.PutUint16(msg[6:8], syscall.NLM_F_REQUEST|syscall.NLM_F_ACK|flags)
+	binary.LittleEndian.PutUint32(msg[8:12], nl.seq)
+	msg[16], msg[17] = cmd, 1
+	msg = append(msg, attrs...)
+	err := syscall.Sendto(nl.fd, msg, 0, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK})
+	if err != nil {
+		return nil, err
+	}
+	var replies [][]byte
+	buf := make([]byte, os.Getpagesize()*16)
+	for {
+		n, _, err := syscall.Recvfrom(nl.fd, buf, 0)
+		if err != nil {
+			return nil, err
+		}
+		messages, err := syscall.ParseNetlinkMessage(buf[:n])
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range messages {
+			if m.Header.Seq != nl.seq {
+				continue
+			}
+			switch m.Header.Type {
+			case syscall.NLMSG_DONE:
+				return replies, nil
+			case syscall.NLMSG_ERROR:
+				if len(m.Data) < 4 {
+					return nil, errors.New("short netlink error")
+				}
+				// an error of 0 is the acknowledgement that ends a request that isn't a dump
+				if errno := int32(binary.LittleEndian.Uint32(m.Data[0:4])); errno != 0 {
+					return nil, syscall.Errno(-errno)
+				}
+				return replies, nil
+			default:
+				// skip the generic netlink header
+				if len(m.Data) >= 4 {
+					replies = append(replies, m.Data[4:])
+				}
+			}
+		}
+	}
+}
+
+// encode a netlink attribute, padded to 4 bytes
+func encodeAttr(typ uint16, data []byte) []byte {
+	attr := make([]byte, 4, 4+len(data)+3)
+	binary.LittleEndian.PutUint16(attr[0:2], uint16(4+len(data)))
+	binary.LittleEndian.PutUint16(attr[2:4], typ)
+	attr = append(attr, data...)
+	for len(attr)%4 != 0 {
+		attr = append(attr, 0)
+	}
+	return attr
+}
+
+// split netlink attributes, without the nested and byte order flags in their types
+func parseAttrs(data []byte) (attrs []netlinkAttr) {
+	for len(data) >= 4 {
+		length := int(binary.LittleEndian.Uint16(data[0:2]))
+		if length < 4 || length > len(data) {
+			break
+		}
+		attrs = append(attrs, netlinkAttr{typ: binary.LittleEndian.Uint16(data[2:4]) & 0x3fff, data: data[4:length]})
+		length = (length + 3) &^ 3
+		if length > len(data) {
+			break
+		}
+		data = data[length:]
+	}
+	return
+}
+
+func findAttr(attrs []netlinkAttr, typ uint16) ([]byte, bool) {
+	for _, attr := range attrs {
+		if attr.typ == typ {
+			return attr.data, true
+		}
+	}
+	return nil, false
+}
+
+func attrUint32(attrs []netlinkAttr, typ uint16) (uint32, bool) {
+	data, ok := findAttr(attrs, typ)
+	if !ok || len(data) < 4 {
+		return 0, false
+	}
+	return binary.LittleEndian.Uint32(data), true
+}
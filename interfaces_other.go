@@ -0,0 +1,10 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+// nl80211 is only on Linux
+func listWirelessInterfaces() ([]WirelessInterface, error) {
+	return nil, errors.New("only supported on Linux")
+}
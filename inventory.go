@@ -0,0 +1,228 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// the file the inventory of expected devices is kept in
+const inventoryFile = "inventory.json"
+
+// InventoryItem is a device the organization owns and expects to see
+type InventoryItem struct {
+	MAC      string `json:"mac"`
+	Owner    string `json:"owner,omitempty"`
+	AssetTag string `json:"asset_tag,omitempty"`
+	Source   string `json:"source,omitempty"` // netbox if it was pulled from NetBox, empty if from a CSV file
+}
+
+var inventory = make(map[string]InventoryItem)
+var inventoryMutex sync.RWMutex
+
+func loadInventory() {
+	inventoryMutex.Lock()
+	defer inventoryMutex.Unlock()
+	inventory = make(map[string]InventoryItem)
+	check(loadJSON(inventoryFile, &inventory), "Cannot load inventory:")
+}
+
+// read an inventory CSV file of MAC address, owner and asset tag, the columns are found by a header line if
+// there is one, otherwise they are in that order
+func parseInventory(r io.Reader) (map[string]InventoryItem, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	reader.Comment = '#'
+	macCol, ownerCol, tagCol := 0, 1, 2
+	items := make(map[string]InventoryItem)
+	for line := 1; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		field := func(i int) string {
+			if i < 0 || i >= len(record) {
+				return ""
+			}
+			return strings.TrimSpace(record[i])
+		}
+		mac, ok := parseMAC(field(macCol))
+		if !ok && len(items) == 0 && line == 1 {
+			// a header line
+			macCol, ownerCol, tagCol = -1, -1, -1
+			for i, name := range record {
+				name = strings.ToLower(name)
+				switch {
+				case strings.Contains(name, "mac"):
+					macCol = i
+				case strings.Contains(name, "owner") || strings.Contains(name, "user"):
+					ownerCol = i
+				case strings.Contains(name, "asset") || strings.Contains(name, "tag"):
+					tagCol = i
+				}
+			}
+			if macCol < 0 {
+				return nil, fmt.Errorf("line 1: no MAC address column")
+			}
+			continue
+		}
+		if !ok {
+			if field(macCol) == "" {
+				continue
+			}
+			return nil, fmt.Errorf("line %d: invalid MAC address %q", line, field(macCol))
+		}
+		items[mac] = InventoryItem{MAC: mac, Owner: field(ownerCol), AssetTag: field(tagCol)}
+	}
+	return items, nil
+}
+
+// the inventory at /admin/inventory, PUT or POST an inventory CSV file to replace it and DELETE to clear it, the
+// devices pulled from NetBox stay until the next sync
+func adminInventory(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		inventoryMutex.RLock()
+		items := []InventoryItem{}
+		for _, item := range inventory {
+			item.MAC = formatMAC(item.MAC)
+			items = append(items, item)
+		}
+		inventoryMutex.RUnlock()
+		sort.Slice(items, func(i, j int) bool { return items[i].MAC < items[j].MAC })
+		writeJSON(w, items)
+	case http.MethodPut, http.MethodPost, http.MethodDelete:
+		items := make(map[string]InventoryItem)
+		if r.Method != http.MethodDelete {
+			var err error
+			items, err = parseInventory(http.MaxBytesReader(w, r.Body, 16<<20))
+			if err != nil {
+				http.Error(w, "Cannot parse inventory: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		inventoryMutex.Lock()
+		for mac, item := range inventory {
+			if _, ok := items[mac]; !ok && item.Source == sourceNetBox {
+				items[mac] = item
+			}
+		}
+		inventory = items
+		err := saveJSON(inventoryFile, inventory)
+		inventoryMutex.Unlock()
+		if err != nil {
+			http.Error(w, "Cannot save inventory: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, map[string]int{"devices": len(items)})
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// ReconciledDevice is a device of the inventory, or one present that isn't in it
+type ReconciledDevice struct {
+	MAC          string    `json:"mac"`
+	Kind         string    `json:"kind,omitempty"` // client or access point, empty if never seen
+	Owner        string    `json:"owner,omitempty"`
+	AssetTag     string    `json:"asset_tag,omitempty"`
+	Alias        string    `json:"alias,omitempty"`
+	Organization string    `json:"organization,omitempty"`
+	BSSID        string    `json:"bssid,omitempty"` // access point a client is associated with
+	LastSeen     time.Time `json:"last_seen"`
+	Present      bool      `json:"present"` // seen in the last 5 minutes
+}
+
+// Reconciliation compares the inventory with the devices seen
+type Reconciliation struct {
+	Inventory  int                `json:"inventory"`
+	Present    []ReconciledDevice `json:"present"`    // in the inventory and here now
+	Absent     []ReconciledDevice `json:"absent"`     // in the inventory, seen before but not now
+	NeverSeen  []ReconciledDevice `json:"never_seen"` // in the inventory and never seen
+	Unexpected []ReconciledDevice `json:"unexpected"` // here now and not in the inventory
+}
+
+// compare the inventory with the devices seen, the unexpected ones are our access points and the clients present,
+// only the clients on our access points if mine is set
+func reconcile(aps []AccessPoint, clients []Client, mine bool) Reconciliation {
+	inventoryMutex.RLock()
+	defer inventoryMutex.RUnlock()
+	rec := Reconciliation{Inventory: len(inventory), Present: []ReconciledDevice{}, Absent: []ReconciledDevice{},
+		NeverSeen: []ReconciledDevice{}, Unexpected: []ReconciledDevice{}}
+	seen := make(map[string]ReconciledDevice)
+	myAPs := make(map[string]bool)
+	for _, ap := range aps {
+		seen[ap.MAC] = ReconciledDevice{MAC: formatMAC(ap.MAC), Kind: "access point", Alias: ap.Alias, LastSeen: ap.LastSeen,
+			Present: isActive(ap.LastSeen)}
+		if isMine(ap) {
+			myAPs[ap.MAC] = true
+		}
+	}
+	for _, c := range clients {
+		d := ReconciledDevice{MAC: formatMAC(c.MAC), Kind: "client", Alias: c.Alias, Organization: c.Organization,
+			LastSeen: c.LastSeen, Present: isActive(c.LastSeen)}
+		if c.Associated {
+			d.BSSID = formatMAC(c.BSSID)
+		}
+		seen[c.MAC] = d
+	}
+	for mac, item := range inventory {
+		d, ok := seen[mac]
+		if !ok {
+			d = ReconciledDevice{MAC: formatMAC(mac)}
+			if c, ap := findSpilled(mac); c != nil {
+				d.Kind, d.LastSeen = "client", c.LastSeen
+			} else if ap != nil {
+				d.Kind, d.LastSeen = "access point", ap.LastSeen
+			}
+		}
+		d.Owner, d.AssetTag = item.Owner, item.AssetTag
+		switch {
+		case d.Present:
+			rec.Present = append(rec.Present, d)
+		case d.Kind != "":
+			rec.Absent = append(rec.Absent, d)
+		default:
+			rec.NeverSeen = append(rec.NeverSeen, d)
+		}
+	}
+	for _, ap := range aps {
+		if myAPs[ap.MAC] && seen[ap.MAC].Present && !hasInventory(ap.MAC) {
+			rec.Unexpected = append(rec.Unexpected, seen[ap.MAC])
+		}
+	}
+	for _, c := range clients {
+		if mine && !(c.Associated && myAPs[c.BSSID]) {
+			continue
+		}
+		if seen[c.MAC].Present && !hasInventory(c.MAC) {
+			rec.Unexpected = append(rec.Unexpected, seen[c.MAC])
+		}
+	}
+	for _, list := range [][]ReconciledDevice{rec.Present, rec.Absent, rec.NeverSeen, rec.Unexpected} {
+		sort.Slice(list, func(i, j int) bool { return list[i].MAC < list[j].MAC })
+	}
+	return rec
+}
+
+// the caller holds the inventory lock
+func hasInventory(mac string) bool {
+	_, ok := inventory[mac]
+	return ok
+}
+
+// the inventory compared with the devices seen at /reconciliation, ?mine=true only counts the clients on our
+// access points as unexpected
+func reconciliationHandler(w http.ResponseWriter, r *http.Request) {
+	aps, clients := store.Snapshot()
+	writeJSON(w, reconcile(aps, clients, r.URL.Query().Get("mine") == "true"))
+}
@@ -0,0 +1,399 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// formats of the files netnet parses
+const (
+	FormatCSV      = "csv"      // airodump-ng CSV
+	FormatNetxml   = "netxml"   // Kismet netxml, also written by airodump-ng
+	FormatKismetDB = "kismetdb" // Kismet's SQLite log
+)
+
+// the format of a file, the one given or else by its extension
+func fileFormat(format, file string) string {
+	if format != "" {
+		return format
+	}
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".netxml":
+		return FormatNetxml
+	case ".kismet":
+		return FormatKismetDB
+	}
+	return FormatCSV
+}
+
+// Kismet netxml times, in local time
+const netxmlTimeLayout = time.ANSIC
+
+type netxmlFile struct {
+	Networks []netxmlNetwork `xml:"wireless-network"`
+}
+
+type netxmlNetwork struct {
+	Type      string         `xml:"type,attr"` // infrastructure, ad-hoc, probe or data
+	FirstTime string         `xml:"first-time,attr"`
+	LastTime  string         `xml:"last-time,attr"`
+	SSIDs     []netxmlSSID   `xml:"SSID"`
+	BSSID     string         `xml:"BSSID"`
+	Channel   string         `xml:"channel"`
+	Packets   netxmlPackets  `xml:"packets"`
+	SNR       netxmlSNR      `xml:"snr-info"`
+	GPS       netxmlGPS      `xml:"gps-info"`
+	Clients   []netxmlClient `xml:"wireless-client"`
+}
+
+type netxmlSSID struct {
+	FirstTime  string   `xml:"first-time,attr"`
+	LastTime   string   `xml:"last-time,attr"`
+	Type       string   `xml:"type"` // Beacon, Probe Response or Probe Request
+	MaxRate    float64  `xml:"max-rate"`
+	Packets    int      `xml:"packets"`
+	Encryption []string `xml:"encryption"`
+	ESSID      string   `xml:"essid"` // of an access point
+	SSID       string   `xml:"ssid"`  // a client probed for
+}
+
+type netxmlPackets struct {
+	Data  int `xml:"data"`
+	Total int `xml:"total"`
+}
+
+type netxmlSNR struct {
+	LastSignal int `xml:"last_signal_dbm"`
+}
+
+type netxmlGPS struct {
+	AvgLat  float64 `xml:"avg-lat"`
+	AvgLon  float64 `xml:"avg-lon"`
+	PeakLat float64 `xml:"peak-lat"`
+	PeakLon float64 `xml:"peak-lon"`
+}
+
+type netxmlClient struct {
+	Type      string        `xml:"type,attr"`
+	FirstTime string        `xml:"first-time,attr"`
+	LastTime  string        `xml:"last-time,attr"`
+	MAC       string        `xml:"client-mac"`
+	SSIDs     []netxmlSSID  `xml:"SSID"`
+	Packets   netxmlPackets `xml:"packets"`
+	SNR       netxmlSNR     `xml:"snr-info"`
+}
+
+func parseNetxmlTime(s string) time.Time {
+	t, _ := time.ParseInLocation(netxmlTimeLayout, strings.TrimSpace(s), time.Local)
+	return t
+}
+
+// the privacy and authentication airodump-ng would write for Kismet's encryption, ie WPA+PSK and WPA+AES-CCM in
+// netxml files or WPA2-PSK in kismetdb logs
+func kismetPrivacy(encryption ...string) (privacy, authentication string) {
+	text := strings.ToUpper(strings.Join(encryption, " "))
+	has := func(words ...string) bool {
+		for _, w := range words {
+			if strings.Contains(text, w) {
+				return true
+			}
+		}
+		return false
+	}
+	var p, a []string
+	if has("WPA3", "SAE") {
+		p = append(p, "WPA3")
+	}
+	if has("WPA2", "AES-CCM", "CCMP") {
+		p = append(p, "WPA2")
+	}
+	if len(p) == 0 && has("WPA") {
+		p = append(p, "WPA")
+	}
+	if len(p) == 0 && has("WEP") {
+		p = append(p, "WEP")
+	}
+	if len(p) == 0 {
+		p = append(p, "OPN")
+	}
+	if has("SAE") {
+		a = append(a, "SAE")
+	}
+	if has("PSK") {
+		a = append(a, "PSK")
+	}
+	if has("MGT", "EAP", "TLS", "LEAP") {
+		a = append(a, "MGT")
+	}
+	if has("OWE") {
+		a = append(a, "OWE")
+	}
+	return strings.Join(p, " "), strings.Join(a, " ")
+}
+
+// the number at the start of a channel, Kismet adds the width like 36HT40+
+func kismetChannel(s string) int {
+	s = strings.TrimSpace(s)
+	end := 0
+	for end < len(s) && s[end] >= '0' && s[end] <= '9' {
+		end++
+	}
+	n, _ := strconv.Atoi(s[:end])
+	return n
+}
+
+// -1 like airodump-ng when there is no signal reading
+func kismetPower(dbm int) int {
+	if dbm == 0 {
+		return -1
+	}
+	return dbm
+}
+
+// netxml files say they are ISO-8859-1 but airodump-ng writes the SSIDs as they are, which is mostly UTF-8
+func netxmlCharset(charset string, input io.Reader) (io.Reader, error) {
+	data, err := ioutil.ReadAll(input)
+	if err != nil || utf8.Valid(data) {
+		return bytes.NewReader(data), err
+	}
+	runes := make([]rune, len(data))
+	for i, b := range data {
+		runes[i] = rune(b)
+	}
+	return strings.NewReader(string(runes)), nil
+}
+
+// parse a Kismet netxml file, problem says what is wrong with the file if it isn't one
+func parseNetxml(file string) (aps []AccessPoint, clients []Client, problem string) {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		fmt.Println("File not found:", err)
+		return nil, nil, "file missing"
+	}
+	var x netxmlFile
+	decoder := xml.NewDecoder(bytes.NewReader(content))
+	decoder.CharsetReader = netxmlCharset
+	if err = decoder.Decode(&x); err != nil {
+		fmt.Println("Cannot parse", file+":", err)
+		return nil, nil, "not a Kismet netxml file"
+	}
+	ssids := make(map[string][]SSIDRecord)
+	var sightings []Sighting
+	for _, n := range x.Networks {
+		bssid, ok := parseMAC(n.BSSID)
+		// clients only probing are in networks of their own
+		if ok && n.Type != "probe" {
+			ap := AccessPoint{MAC: bssid, FirstSeen: parseNetxmlTime(n.FirstTime), LastSeen: parseNetxmlTime(n.LastTime),
+				Channel: kismetChannel(n.Channel), Power: kismetPower(n.SNR.LastSignal), DataPackets: n.Packets.Data}
+			for _, s := range n.SSIDs {
+				if s.Type == "Probe Request" {
+					continue
+				}
+				privacy, auth := kismetPrivacy(s.Encryption...)
+				if ap.Name == "" || s.Type == "Beacon" {
+					ap.Name, ap.Privacy, ap.Authentication = s.ESSID, privacy, auth
+					if s.MaxRate > 0 {
+						ap.Speed = strconv.Itoa(int(s.MaxRate))
+					}
+				}
+				if s.Type == "Beacon" {
+					ap.Beacons += s.Packets
+				}
+				if s.ESSID != "" {
+					ssids[bssid] = append(ssids[bssid], SSIDRecord{SSID: s.ESSID, FirstSeen: parseNetxmlTime(s.FirstTime),
+						LastSeen: parseNetxmlTime(s.LastTime), Privacy: []string{privacy}})
+				}
+			}
+			lat, lon := n.GPS.AvgLat, n.GPS.AvgLon
+			if lat == 0 && lon == 0 {
+				lat, lon = n.GPS.PeakLat, n.GPS.PeakLon
+			}
+			if lat != 0 || lon != 0 {
+				sightings = append(sightings, Sighting{Time: ap.LastSeen, MAC: bssid, Name: ap.Name, Privacy: ap.Privacy,
+					Channel: ap.Channel, Power: ap.Power, Lat: lat, Lon: lon})
+			}
+			aps = append(aps, ap)
+		}
+		for _, nc := range n.Clients {
+			mac, ok := parseMAC(nc.MAC)
+			if !ok {
+				continue
+			}
+			c := Client{MAC: mac, FirstSeen: parseNetxmlTime(nc.FirstTime), LastSeen: parseNetxmlTime(nc.LastTime),
+				Power: kismetPower(nc.SNR.LastSignal), Packets: nc.Packets.Total}
+			if bssid != "" && bssid != mac && n.Type != "probe" {
+				c.BSSID, c.Associated = bssid, true
+			}
+			var probes []string
+			for _, s := range nc.SSIDs {
+				if s.SSID != "" && !containsString(probes, s.SSID) {
+					probes = append(probes, s.SSID)
+				}
+			}
+			c.Probes = strings.Join(probes, ",")
+			clients = append(clients, c)
+		}
+	}
+	importKismetHistory(ssids, sightings)
+	return mergeAPs(aps), mergeClients(clients), ""
+}
+
+// the parts of a device in a kismetdb log netnet uses
+type kismetDevice struct {
+	Type        string          `json:"kismet.device.base.type"` // Wi-Fi AP, Wi-Fi Client, Wi-Fi Bridged, ...
+	Crypt       string          `json:"kismet.device.base.crypt"`
+	Channel     string          `json:"kismet.device.base.channel"`
+	Packets     int             `json:"kismet.device.base.packets.total"`
+	DataPackets int             `json:"kismet.device.base.packets.data"`
+	Signal      json.RawMessage `json:"kismet.device.base.signal"`
+	Dot11       struct {
+		LastBSSID  string          `json:"dot11.device.last_bssid"`
+		Probed     json.RawMessage `json:"dot11.device.probed_ssid_map"`
+		Advertised json.RawMessage `json:"dot11.device.advertised_ssid_map"`
+	} `json:"dot11.device"`
+}
+
+type kismetAdvertisedSSID struct {
+	SSID      string `json:"dot11.advertisedssid.ssid"`
+	FirstTime int64  `json:"dot11.advertisedssid.first_time"`
+	LastTime  int64  `json:"dot11.advertisedssid.last_time"`
+	Crypt     string `json:"dot11.advertisedssid.crypt_string"`
+	Beacon    int    `json:"dot11.advertisedssid.beacon"` // 1 if it was in beacons rather than only probe responses
+	MaxRate   int    `json:"dot11.advertisedssid.maxrate"`
+}
+
+type kismetProbedSSID struct {
+	SSID string `json:"dot11.probedssid.ssid"`
+}
+
+// Kismet writes some lists as arrays and older versions as objects keyed by a hash
+func kismetEntries(raw json.RawMessage) []json.RawMessage {
+	var list []json.RawMessage
+	if json.Unmarshal(raw, &list) == nil {
+		return list
+	}
+	var entries map[string]json.RawMessage
+	if json.Unmarshal(raw, &entries) == nil {
+		for _, v := range entries {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
+// the number in a column of a kismetdb row
+func kismetNumber(v interface{}) float64 {
+	switch n := v.(type) {
+	case int64:
+		return float64(n)
+	case float64:
+		return n
+	}
+	return 0
+}
+
+// parse the Wi-Fi devices in a kismetdb log, problem says what is wrong with the file if it isn't one
+func parseKismetDB(file string) (aps []AccessPoint, clients []Client, problem string) {
+	db, err := openSQLite(file)
+	if err != nil {
+		fmt.Println("Cannot open", file+":", err)
+		if strings.Contains(err.Error(), "no such file") {
+			return nil, nil, "file missing"
+		}
+		return nil, nil, "not a kismetdb log"
+	}
+	defer db.close()
+	ssids := make(map[string][]SSIDRecord)
+	var sightings []Sighting
+	err = db.table("devices", func(row map[string]interface{}) {
+		phy, _ := row["phyname"].(string)
+		mac, ok := "", false
+		if s, _ := row["devmac"].(string); phy == "IEEE802.11" {
+			mac, ok = parseMAC(s)
+		}
+		blob, _ := row["device"].([]byte)
+		var d kismetDevice
+		if !ok || json.Unmarshal(blob, &d) != nil {
+			return
+		}
+		first := time.Unix(int64(kismetNumber(row["first_time"])), 0)
+		last := time.Unix(int64(kismetNumber(row["last_time"])), 0)
+		var signal struct {
+			Last int `json:"kismet.common.signal.last_signal"`
+		}
+		json.Unmarshal(d.Signal, &signal)
+		power := kismetPower(signal.Last)
+		if power == -1 {
+			power = kismetPower(int(kismetNumber(row["strongest_signal"])))
+		}
+		if strings.HasSuffix(d.Type, "AP") {
+			ap := AccessPoint{MAC: mac, FirstSeen: first, LastSeen: last, Channel: kismetChannel(d.Channel), Power: power,
+				DataPackets: d.DataPackets}
+			ap.Privacy, ap.Authentication = kismetPrivacy(d.Crypt)
+			for _, raw := range kismetEntries(d.Dot11.Advertised) {
+				var s kismetAdvertisedSSID
+				if json.Unmarshal(raw, &s) != nil {
+					continue
+				}
+				privacy := ap.Privacy
+				if s.Crypt != "" {
+					privacy, _ = kismetPrivacy(s.Crypt)
+				}
+				if s.LastTime > 0 && (ap.Name == "" || s.Beacon == 1) {
+					ap.Name = s.SSID
+					if s.MaxRate > 0 {
+						ap.Speed = strconv.Itoa(s.MaxRate)
+					}
+				}
+				if s.SSID != "" {
+					ssids[mac] = append(ssids[mac], SSIDRecord{SSID: s.SSID, FirstSeen: time.Unix(s.FirstTime, 0),
+						LastSeen: time.Unix(s.LastTime, 0), Privacy: []string{privacy}})
+				}
+			}
+			lat, lon := kismetNumber(row["avg_lat"]), kismetNumber(row["avg_lon"])
+			if lat != 0 || lon != 0 {
+				sightings = append(sightings, Sighting{Time: last, MAC: mac, Name: ap.Name, Privacy: ap.Privacy,
+					Channel: ap.Channel, Power: ap.Power, Lat: lat, Lon: lon})
+			}
+			aps = append(aps, ap)
+			return
+		}
+		c := Client{MAC: mac, FirstSeen: first, LastSeen: last, Power: power, Packets: d.Packets}
+		if bssid, ok := parseMAC(d.Dot11.LastBSSID); ok && bssid != mac && bssid != "00-00-00-00-00-00" {
+			c.BSSID, c.Associated = bssid, true
+		}
+		var probes []string
+		for _, raw := range kismetEntries(d.Dot11.Probed) {
+			var s kismetProbedSSID
+			if json.Unmarshal(raw, &s) == nil && s.SSID != "" && !containsString(probes, s.SSID) {
+				probes = append(probes, s.SSID)
+			}
+		}
+		c.Probes = strings.Join(probes, ",")
+		clients = append(clients, c)
+	})
+	if err != nil {
+		fmt.Println("Cannot read", file+":", err)
+		return nil, nil, "not a kismetdb log"
+	}
+	importKismetHistory(ssids, sightings)
+	return mergeAPs(aps), mergeClients(clients), ""
+}
+
+// Kismet keeps every SSID an access point advertised and where it was seen, which a single airodump-ng CSV file can't
+// tell, add them to the SSID history and GPS sightings
+func importKismetHistory(ssids map[string][]SSIDRecord, sightings []Sighting) {
+	for mac, records := range ssids {
+		importSSIDHistory(mac, records)
+	}
+	addSightings(sightings)
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// https://datatracker.ietf.org/doc/html/rfc4511, only a simple bind and a search for one user
+
+// BER tags of the LDAP messages used
+const (
+	berInteger     = 0x02
+	berOctetString = 0x04
+	berEnumerated  = 0x0A
+	berBoolean     = 0x01
+	berSequence    = 0x30
+
+	ldapBindRequest   = 0x60
+	ldapBindResponse  = 0x61
+	ldapUnbindRequest = 0x42
+	ldapSearchRequest = 0x63
+	ldapSearchEntry   = 0x64
+	ldapSearchDone    = 0x65
+	ldapSearchRef     = 0x73
+	ldapSimpleAuth    = 0x80
+	ldapEqualityMatch = 0xA3
+)
+
+// how long talking to the directory can take
+const ldapTimeout = 10 * time.Second
+
+// a BER element
+type ber struct {
+	tag     byte
+	content []byte
+}
+
+// encode a BER element, with the length in the short form if it fits
+func berEncode(tag byte, content []byte) []byte {
+	n := len(content)
+	var length []byte
+	switch {
+	case n < 0x80:
+		length = []byte{byte(n)}
+	case n < 0x100:
+		length = []byte{0x81, byte(n)}
+	case n < 0x10000:
+		length = []byte{0x82, byte(n >> 8), byte(n)}
+	default:
+		length = []byte{0x83, byte(n >> 16), byte(n >> 8), byte(n)}
+	}
+	return append(append([]byte{tag}, length...), content...)
+}
+
+func berInt(tag byte, n int) []byte {
+	// the numbers here are small and never negative
+	b := []byte{byte(n)}
+	for n >>= 8; n > 0; n >>= 8 {
+		b = append([]byte{byte(n)}, b...)
+	}
+	if b[0]&0x80 != 0 {
+		b = append([]byte{0}, b...)
+	}
+	return berEncode(tag, b)
+}
+
+func berString(s string) []byte {
+	return berEncode(berOctetString, []byte(s))
+}
+
+func berConcat(tag byte, elements ...[]byte) []byte {
+	var content []byte
+	for _, e := range elements {
+		content = append(content, e...)
+	}
+	return berEncode(tag, content)
+}
+
+// read one BER element
+func berRead(r *bufio.Reader) (ber, error) {
+	tag, err := r.ReadByte()
+	if err != nil {
+		return ber{}, err
+	}
+	// io.EOF is only for the end between elements
+	first, err := r.ReadByte()
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return ber{}, err
+	}
+	n := int(first)
+	if first&0x80 != 0 {
+		count := int(first & 0x7f)
+		if count == 0 || count > 4 {
+			return ber{}, errors.New("unsupported BER length")
+		}
+		n = 0
+		for i := 0; i < count; i++ {
+			b, err := r.ReadByte()
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			if err != nil {
+				return ber{}, err
+			}
+			n = n<<8 | int(b)
+		}
+	}
+	// a 4 byte length overflows an int in 32 bits
+	if n < 0 || n > 16<<20 {
+		return ber{}, errors.New("LDAP message too big")
+	}
+	content := make([]byte, n)
+	_, err = io.ReadFull(r, content)
+	return ber{tag, content}, err
+}
+
+// split the content of a constructed BER element into its elements
+func berChildren(data []byte) ([]ber, error) {
+	r := bufio.NewReader(bytes.NewReader(data))
+	var children []ber
+	for {
+		e, err := berRead(r)
+		if err == io.EOF {
+			return children, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		children = append(children, e)
+	}
+}
+
+// ldapConn is a connection to an LDAP server
+type ldapConn struct {
+	conn      net.Conn
+	r         *bufio.Reader
+	messageID int
+}
+
+// connect to an ldap:// or ldaps:// URL
+func ldapDial(rawURL string) (*ldapConn, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	host := u.Host
+	var conn net.Conn
+	dialer := &net.Dialer{Timeout: ldapTimeout}
+	switch u.Scheme {
+	case "ldaps":
+		if u.Port() == "" {
+			host += ":636"
+		}
+		conn, err = tls.DialWithDialer(dialer, "tcp", host, &tls.Config{ServerName: u.Hostname()})
+	case "ldap":
+		if u.Port() == "" {
+			host += ":389"
+		}
+		conn, err = dialer.Dial("tcp", host)
+	default:
+		return nil, fmt.Errorf("not an ldap:// or ldaps:// URL: %s", rawURL)
+	}
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Now().Add(ldapTimeout))
+	return &ldapConn{conn: conn, r: bufio.NewReader(conn)}, nil
+}
+
+func (l *ldapConn) send(op []byte) error {
+	l.messageID++
+	_, err := l.conn.Write(berConcat(berSequence, berInt(berInteger, l.messageID), op))
+	return err
+}
+
+// read the next message, its protocol operation
+func (l *ldapConn) receive() (ber, error) {
+	msg, err := berRead(l.r)
+	if err != nil {
+		return ber{}, err
+	}
+	parts, err := berChildren(msg.content)
+	if err != nil || len(parts) < 2 {
+		return ber{}, errors.New("malformed LDAP message")
+	}
+	return parts[1], nil
+}
+
+// the result code and message of an LDAPResult, an error if it isn't success
+func ldapResult(op ber) error {
+	parts, err := berChildren(op.content)
+	if err != nil || len(parts) < 3 {
+		return errors.New("malformed LDAP result")
+	}
+	code := 0
+	for _, b := range parts[0].content {
+		code = code<<8 | int(b)
+	}
+	if code != 0 {
+		return fmt.Errorf("LDAP error %d: %s", code, parts[2].content)
+	}
+	return nil
+}
+
+func (l *ldapConn) bind(dn, password string) error {
+	err := l.send(berConcat(ldapBindRequest, berInt(berInteger, 3), berString(dn), berEncode(ldapSimpleAuth, []byte(password))))
+	if err != nil {
+		return err
+	}
+	op, err := l.receive()
+	if err != nil {
+		return err
+	}
+	if op.tag != ldapBindResponse {
+		return errors.New("unexpected answer to bind")
+	}
+	return ldapResult(op)
+}
+
+// the attributes of the first entry under base where attribute is value, nil if there is none
+func (l *ldapConn) searchOne(base, attribute, value string, attributes []string) (map[string]string, error) {
+	var list [][]byte
+	for _, a := range attributes {
+		list = append(list, berString(a))
+	}
+	err := l.send(berConcat(ldapSearchRequest,
+		berString(base),
+		berInt(berEnumerated, 2), // whole subtree
+		berInt(berEnumerated, 0), // never dereference aliases
+		berInt(berInteger, 1),    // one entry at most
+		berInt(berInteger, int(ldapTimeout/time.Second)),
+		berEncode(berBoolean, []byte{0}),
+		berConcat(ldapEqualityMatch, berString(attribute), berString(value)),
+		berConcat(berSequence, list...)))
+	if err != nil {
+		return nil, err
+	}
+	var found map[string]string
+	for {
+		op, err := l.receive()
+		if err != nil {
+			return nil, err
+		}
+		switch op.tag {
+		case ldapSearchEntry:
+			parts, err := berChildren(op.content)
+			if err != nil || len(parts) < 2 {
+				return nil, errors.New("malformed LDAP search entry")
+			}
+			attrs, err := berChildren(parts[1].content)
+			if err != nil {
+				return nil, err
+			}
+			found = make(map[string]string)
+			for _, a := range attrs {
+				pair, err := berChildren(a.content)
+				if err != nil || len(pair) < 2 {
+					continue
+				}
+				values, err := berChildren(pair[1].content)
+				if err == nil && len(values) > 0 {
+					found[strings.ToLower(string(pair[0].content))] = string(values[0].content)
+				}
+			}
+		case ldapSearchDone:
+			// more than one entry is a size limit exceeded error, the first one is enough
+			if err := ldapResult(op); err != nil && found == nil {
+				return nil, err
+			}
+			return found, nil
+		case ldapSearchRef:
+		default:
+			return nil, errors.New("unexpected answer to search")
+		}
+	}
+}
+
+func (l *ldapConn) close() {
+	l.send(berEncode(ldapUnbindRequest, nil))
+	l.conn.Close()
+}
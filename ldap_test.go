@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestBERRead(t *testing.T) {
+	long := append([]byte{berOctetString, 0x82, 0x01, 0x00}, bytes.Repeat([]byte{'a'}, 256)...)
+	for _, test := range []struct {
+		data    []byte
+		tag     byte
+		content []byte
+	}{
+		{[]byte{berInteger, 0x01, 0x05}, berInteger, []byte{5}},
+		{[]byte{berSequence, 0x00}, berSequence, []byte{}},
+		{[]byte{berOctetString, 0x81, 0x02, 'h', 'i'}, berOctetString, []byte("hi")}, // long form for a short length
+		{long, berOctetString, long[4:]},
+		{berString("cn=admin"), berOctetString, []byte("cn=admin")},
+	} {
+		e, err := berRead(bufio.NewReader(bytes.NewReader(test.data)))
+		if err != nil || e.tag != test.tag || !bytes.Equal(e.content, test.content) {
+			t.Errorf("%x read as %x %x %v", test.data, e.tag, e.content, err)
+		}
+	}
+	// an LDAP bind request, a message with an ID and the request
+	msg := berConcat(berSequence, berInt(berInteger, 1), berConcat(0x60, berInt(berInteger, 3), berString("cn=admin"),
+		berEncode(0x80, []byte("secret"))))
+	e, err := berRead(bufio.NewReader(bytes.NewReader(msg)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts, err := berChildren(e.content)
+	if err != nil || len(parts) != 2 || parts[1].tag != 0x60 {
+		t.Fatalf("message %v %v", parts, err)
+	}
+	bind, err := berChildren(parts[1].content)
+	if err != nil || len(bind) != 3 || string(bind[1].content) != "cn=admin" || string(bind[2].content) != "secret" {
+		t.Errorf("bind request %v %v", bind, err)
+	}
+}
+
+// cut short, too long or with lengths BER allows but LDAP doesn't use has to give an error, never a panic
+func TestBERMalformed(t *testing.T) {
+	for _, data := range [][]byte{
+		{},                                    // nothing
+		{berInteger},                          // no length
+		{berOctetString, 0x80},                // indefinite length
+		{berOctetString, 0x85, 0, 0, 0, 0, 1}, // length of 5 bytes
+		{berOctetString, 0x84, 0x7f, 0xff, 0xff, 0xff}, // 2 GB
+		{berOctetString, 0x84, 0xff, 0xff, 0xff, 0xff}, // 4 GB, negative in 32 bits
+		{berOctetString, 0x82, 0x01},                   // length cut short
+		{berOctetString, 0x05, 'a', 'b'},               // content cut short
+	} {
+		if e, err := berRead(bufio.NewReader(bytes.NewReader(data))); err == nil {
+			t.Errorf("%x read as %x %x", data, e.tag, e.content)
+		}
+	}
+	for _, data := range [][]byte{
+		{berInteger, 0x01, 0x05, berInteger, 0x02, 0x01}, // content cut short
+		{berInteger, 0x01, 0x05, berInteger},             // only the tag
+		{berInteger, 0x01, 0x05, berInteger, 0x82, 0x01}, // length cut short
+	} {
+		if _, err := berChildren(data); err != io.ErrUnexpectedEOF {
+			t.Errorf("children %x gave %v", data, err)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// the largest frame read from a live capture
+const maxLiveFrame = 65536
+
+// start capturing 802.11 frames on the -iface interface, the frames go where the pipe collector's do, netnet falls
+// back to reading airodump-ng's CSV file if the interface can't be captured on
+func startLive() {
+	if *liveInterface == "" {
+		fmt.Println("The live collector needs a monitor mode interface, ie -iface wlan0mon")
+		*collector = "airodump"
+		return
+	}
+	capture, err := openLive(*liveInterface)
+	if err != nil {
+		fmt.Println("Cannot capture on "+*liveInterface+":", err, "- reading", *csvFile, "instead")
+		*collector = "airodump"
+		return
+	}
+	fmt.Println("Capturing on", *liveInterface)
+	go readLive(capture)
+}
+
+// read frames from a live capture until it fails, then open it again
+func readLive(capture *liveCapture) {
+	buf := make([]byte, maxLiveFrame)
+	for {
+		n, err := capture.read(buf)
+		if err == nil {
+			if frame, ok := parseFrame(time.Now(), buf[:n], capture.linkType); ok {
+				// only the body is kept, not the whole buffer
+				frame.Body = append([]byte(nil), frame.Body...)
+				addPipeFrame(frame)
+			}
+			continue
+		}
+		fmt.Println("Cannot read from "+*liveInterface+":", err)
+		capture.close()
+		for {
+			time.Sleep(pipeReopen)
+			if capture, err = openLive(*liveInterface); err == nil {
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,73 @@
+//go:build linux
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// ARPHRD_IEEE80211 and ARPHRD_IEEE80211_RADIOTAP from linux/if_arp.h, the hardware types of interfaces in monitor mode
+const (
+	arphrdIEEE80211         = "801"
+	arphrdIEEE80211Radiotap = "803"
+)
+
+// a raw packet socket bound to a monitor mode interface
+type liveCapture struct {
+	fd       int
+	linkType uint32
+}
+
+func htons(n uint16) uint16 {
+	return n<<8 | n>>8
+}
+
+// open a raw packet socket on a monitor mode interface, the frames come with a radiotap header or without any
+// depending on the driver
+func openLive(name string) (*liveCapture, error) {
+	hardware, err := ioutil.ReadFile(filepath.Join("/sys/class/net", name, "type"))
+	if err != nil {
+		return nil, fmt.Errorf("no interface %s", name)
+	}
+	capture := &liveCapture{}
+	switch strings.TrimSpace(string(hardware)) {
+	case arphrdIEEE80211Radiotap:
+		capture.linkType = linkTypeRadiotap
+	case arphrdIEEE80211:
+		capture.linkType = linkTypeIEEE80211
+	default:
+		return nil, fmt.Errorf("%s is not in monitor mode, ie iw dev %s set type monitor", name, name)
+	}
+	ifi, err := net.InterfaceByName(name)
+	if err != nil {
+		return nil, err
+	}
+	protocol := htons(syscall.ETH_P_ALL)
+	capture.fd, err = syscall.Socket(syscall.AF_PACKET, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC, int(protocol))
+	if err != nil {
+		return nil, fmt.Errorf("cannot open a packet socket, netnet needs CAP_NET_RAW: %v", err)
+	}
+	if err = syscall.Bind(capture.fd, &syscall.SockaddrLinklayer{Protocol: protocol, Ifindex: ifi.Index}); err != nil {
+		syscall.Close(capture.fd)
+		return nil, err
+	}
+	return capture, nil
+}
+
+func (c *liveCapture) read(buf []byte) (int, error) {
+	for {
+		n, _, err := syscall.Recvfrom(c.fd, buf, 0)
+		if err != syscall.EINTR {
+			return n, err
+		}
+	}
+}
+
+func (c *liveCapture) close() {
+	syscall.Close(c.fd)
+}
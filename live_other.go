@@ -0,0 +1,20 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+// packet sockets are only on Linux
+type liveCapture struct {
+	linkType uint32
+}
+
+func openLive(name string) (*liveCapture, error) {
+	return nil, errors.New("live capture is only supported on Linux")
+}
+
+func (c *liveCapture) read(buf []byte) (int, error) {
+	return 0, errors.New("live capture is only supported on Linux")
+}
+
+func (c *liveCapture) close() {}
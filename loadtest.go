@@ -0,0 +1,255 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// bodies bigger than this are recorded without them, ie large batches from sensors
+const maxRecordedBody = 1 << 20
+
+// RecordedRequest is a request to the API written down by -record to be replayed by netnet replay
+type RecordedRequest struct {
+	Time        time.Time `json:"time"`
+	Method      string    `json:"method"`
+	URI         string    `json:"uri"`
+	ContentType string    `json:"content_type,omitempty"`
+	Body        string    `json:"body,omitempty"`
+}
+
+// the requests replayed when no recording is given, what the web UI and dashboards ask for most
+var defaultReplay = []RecordedRequest{
+	{Method: "GET", URI: "/status"},
+	{Method: "GET", URI: "/clients"},
+	{Method: "GET", URI: "/aps"},
+	{Method: "GET", URI: "/stats/vendors"},
+	{Method: "GET", URI: "/events"},
+	{Method: "GET", URI: "/sensors"},
+	{Method: "GET", URI: "/fleet"},
+	{Method: "GET", URI: "/metrics"},
+}
+
+// requestRecorder appends every request to a JSON lines file, the API keys and cookies are left out
+type requestRecorder struct {
+	file  *os.File
+	mutex sync.Mutex
+}
+
+func newRequestRecorder(name string) (*requestRecorder, error) {
+	file, err := os.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &requestRecorder{file: file}, nil
+}
+
+// middleware that records the requests before handling them
+func (rec *requestRecorder) handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u := *r.URL
+		if q := u.Query(); q.Get("key") != "" {
+			q.Del("key")
+			u.RawQuery = q.Encode()
+		}
+		req := RecordedRequest{Time: time.Now(), Method: r.Method, URI: u.RequestURI(), ContentType: r.Header.Get("Content-Type")}
+		if r.Body != nil && r.ContentLength != 0 {
+			body, err := ioutil.ReadAll(io.LimitReader(r.Body, maxRecordedBody+1))
+			if err == nil && len(body) <= maxRecordedBody {
+				req.Body = string(body)
+			}
+			// the handler still gets the whole body
+			r.Body = struct {
+				io.Reader
+				io.Closer
+			}{io.MultiReader(bytes.NewReader(body), r.Body), r.Body}
+		}
+		data, _ := json.Marshal(req)
+		rec.mutex.Lock()
+		rec.file.Write(append(data, '\n'))
+		rec.mutex.Unlock()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// read requests recorded with -record
+func loadRecording(name string) ([]RecordedRequest, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var requests []RecordedRequest
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 2*maxRecordedBody)
+	for scanner.Scan() {
+		var req RecordedRequest
+		if json.Unmarshal(scanner.Bytes(), &req) == nil && req.Method != "" {
+			requests = append(requests, req)
+		}
+	}
+	return requests, scanner.Err()
+}
+
+// nearest-rank percentile of sorted latencies
+func latencyPercentile(sorted []time.Duration, p float64) time.Duration {
+	i := int(math.Ceil(p/100*float64(len(sorted)))) - 1
+	if i < 0 {
+		i = 0
+	}
+	return sorted[i].Round(time.Microsecond)
+}
+
+// send recorded requests to a running netnet at a rate and report the latencies, ie to check a Pi can serve the
+// dashboards of a site before it is deployed there
+func replay(args []string) {
+	flags := flag.NewFlagSet("replay", flag.ExitOnError)
+	target := flags.String("url", "http://localhost:12121", "netnet to send the requests to")
+	rate := flags.Float64("rate", 10, "requests per second, 0 to send them as fast as they were recorded")
+	speed := flags.Float64("speed", 1, "with -rate 0, how many times faster than recorded")
+	duration := flags.Duration("duration", time.Minute, "how long to keep sending, the requests are sent again from the start when they run out")
+	workers := flags.Int("concurrency", 8, "requests at the same time at most")
+	key := flags.String("key", "", "API key to send with every request")
+	flags.Usage = func() {
+		fmt.Println("Usage: netnet replay [-url http://host:port] [-rate n] [-duration 1m] [recorded.jsonl]")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	requests := defaultReplay
+	if flags.NArg() > 0 {
+		var err error
+		if requests, err = loadRecording(flags.Arg(0)); err != nil {
+			fmt.Println("Cannot read recorded requests:", err)
+			os.Exit(1)
+		}
+		if len(requests) == 0 {
+			fmt.Println("No requests in", flags.Arg(0))
+			os.Exit(1)
+		}
+	}
+	if *workers < 1 {
+		*workers = 1
+	}
+
+	type result struct {
+		uri     string
+		status  int
+		latency time.Duration
+		err     error
+	}
+	queue := make(chan RecordedRequest, *workers)
+	results := make(chan result, *workers)
+	client := &http.Client{Timeout: 30 * time.Second}
+	base := strings.TrimSuffix(*target, "/")
+	var wg sync.WaitGroup
+	for i := 0; i < *workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for req := range queue {
+				r, err := http.NewRequest(req.Method, base+req.URI, strings.NewReader(req.Body))
+				if err != nil {
+					results <- result{uri: req.URI, err: err}
+					continue
+				}
+				if req.ContentType != "" {
+					r.Header.Set("Content-Type", req.ContentType)
+				}
+				if *key != "" {
+					r.Header.Set("X-API-Key", *key)
+				}
+				start := time.Now()
+				resp, err := client.Do(r)
+				if err != nil {
+					results <- result{uri: req.URI, latency: time.Since(start), err: err}
+					continue
+				}
+				io.Copy(ioutil.Discard, resp.Body)
+				resp.Body.Close()
+				results <- result{uri: req.URI, status: resp.StatusCode, latency: time.Since(start)}
+			}
+		}()
+	}
+
+	// send the requests on time, a request waits for a free worker so a slow server lowers the rate
+	start := time.Now()
+	go func() {
+		defer close(queue)
+		next := start
+		for i := 0; time.Since(start) < *duration; i++ {
+			req := requests[i%len(requests)]
+			if *rate > 0 {
+				next = next.Add(time.Duration(float64(time.Second) / *rate))
+			} else if i%len(requests) > 0 {
+				gap := req.Time.Sub(requests[i%len(requests)-1].Time)
+				next = next.Add(time.Duration(float64(gap) / *speed))
+			}
+			time.Sleep(time.Until(next))
+			queue <- req
+		}
+	}()
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	fmt.Printf("Sending %d recorded requests to %s for %v\n", len(requests), base, *duration)
+	var latencies []time.Duration
+	statuses := make(map[int]int)
+	byURI := make(map[string][]time.Duration)
+	errors := 0
+	for r := range results {
+		if r.err != nil {
+			errors++
+			continue
+		}
+		statuses[r.status]++
+		latencies = append(latencies, r.latency)
+		path := r.uri
+		if i := strings.IndexByte(path, '?'); i >= 0 {
+			path = path[:i]
+		}
+		byURI[path] = append(byURI[path], r.latency)
+	}
+	took := time.Since(start)
+
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	fmt.Printf("%d requests in %v, %.1f a second, %d failed\n", len(latencies)+errors, took.Round(time.Millisecond),
+		float64(len(latencies)+errors)/took.Seconds(), errors)
+	var codes []int
+	for code := range statuses {
+		codes = append(codes, code)
+	}
+	sort.Ints(codes)
+	for _, code := range codes {
+		fmt.Printf("  %d: %d\n", code, statuses[code])
+	}
+	if len(latencies) == 0 {
+		os.Exit(1)
+	}
+	fmt.Printf("Latency p50 %v, p90 %v, p95 %v, p99 %v, max %v\n", latencyPercentile(latencies, 50),
+		latencyPercentile(latencies, 90), latencyPercentile(latencies, 95),
+		latencyPercentile(latencies, 99), latencies[len(latencies)-1].Round(time.Microsecond))
+	var paths []string
+	for path := range byURI {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		l := byURI[path]
+		sort.Slice(l, func(i, j int) bool { return l[i] < l[j] })
+		fmt.Printf("  %-30s %6d requests, p50 %v, p99 %v\n", path, len(l), latencyPercentile(l, 50),
+			latencyPercentile(l, 99))
+	}
+}
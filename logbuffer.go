@@ -0,0 +1,50 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+)
+
+// number of output lines kept for the diagnostics bundle
+const logLines = 1000
+
+var recentLogs []string
+var recentLogsMutex sync.Mutex
+
+// keep the last lines netnet wrote to stdout and the log, while still writing them out
+func captureOutput() {
+	r, w, err := os.Pipe()
+	if err != nil {
+		check(err, "Cannot capture output:")
+		return
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	log.SetOutput(io.MultiWriter(os.Stderr, w))
+	go func() {
+		scanner := bufio.NewScanner(r)
+		for scanner.Scan() {
+			line := scanner.Text()
+			stdout.WriteString(line + "\n")
+			recentLogsMutex.Lock()
+			recentLogs = append(recentLogs, line)
+			if len(recentLogs) > logLines {
+				recentLogs = recentLogs[len(recentLogs)-logLines:]
+			}
+			recentLogsMutex.Unlock()
+		}
+	}()
+}
+
+func getRecentLogs() string {
+	recentLogsMutex.Lock()
+	defer recentLogsMutex.Unlock()
+	if len(recentLogs) == 0 {
+		return ""
+	}
+	return strings.Join(recentLogs, "\n") + "\n"
+}
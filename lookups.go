@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// LookupResult is the cached answer of an external service, empty if the service didn't know
+type LookupResult struct {
+	Value string    `json:"value"`
+	Time  time.Time `json:"time"`
+}
+
+// LookupStats is how a lookup pool has been doing, at /metrics
+type LookupStats struct {
+	Hits     int64 `json:"hits"`     // answered from the cache
+	Queued   int64 `json:"queued"`   // not cached yet, looked up by the workers
+	Dropped  int64 `json:"dropped"`  // not cached and the queue was full, tried again in a later parse
+	Lookups  int64 `json:"lookups"`  // answered by the service
+	Failures int64 `json:"failures"` // failed or timed out, tried again in a later parse
+}
+
+// lookupPool looks things up with a slow external service, ie the vendor of an OUI, with a few workers so
+// the parse never waits for it: lookup answers from the cache right away and queues what isn't cached,
+// the answer shows up in a later parse
+type lookupPool struct {
+	name    string
+	workers int
+	timeout time.Duration // for each lookup
+	rate    float64       // most lookups a second across the workers, no limit if 0
+	retry   time.Duration // an empty answer is looked up again after this
+	fetch   func(ctx context.Context, key string) (string, error)
+	saved   func(cache map[string]LookupResult) // called with a copy of the cache after new answers, to save it
+
+	cache     map[string]LookupResult
+	queued    map[string]bool
+	queue     chan string
+	stats     LookupStats
+	lastSaved time.Time
+	mutex     sync.Mutex
+	saveMutex sync.Mutex
+}
+
+// most keys waiting to be looked up by a pool, the others wait for the next parse
+const maxLookupQueue = 100
+
+var lookupPools []*lookupPool
+var lookupPoolsMutex sync.Mutex
+
+func newLookupPool(name string, workers int, timeout time.Duration, rate float64, retry time.Duration,
+	fetch func(ctx context.Context, key string) (string, error)) *lookupPool {
+	if workers <= 0 {
+		workers = 1
+	}
+	p := &lookupPool{
+		name:    name,
+		workers: workers,
+		timeout: timeout,
+		rate:    rate,
+		retry:   retry,
+		fetch:   fetch,
+		cache:   make(map[string]LookupResult),
+		queued:  make(map[string]bool),
+		queue:   make(chan string, maxLookupQueue),
+	}
+	lookupPoolsMutex.Lock()
+	lookupPools = append(lookupPools, p)
+	lookupPoolsMutex.Unlock()
+	return p
+}
+
+// replace the cache, ie with the one saved before
+func (p *lookupPool) load(cache map[string]LookupResult) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.cache = cache
+}
+
+// the cached answer for the key, queueing it to be looked up if it isn't cached or was empty long enough ago
+func (p *lookupPool) lookup(key string) string {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	cached, ok := p.cache[key]
+	if ok && (cached.Value != "" || time.Since(cached.Time) < p.retry) {
+		p.stats.Hits++
+		return cached.Value
+	}
+	if !p.queued[key] {
+		select {
+		case p.queue <- key:
+			p.queued[key] = true
+			p.stats.Queued++
+		default:
+			p.stats.Dropped++
+		}
+	}
+	return cached.Value
+}
+
+// start the workers, they look up the queued keys no faster than the rate between them
+func (p *lookupPool) start() {
+	var limiter <-chan time.Time
+	if p.rate > 0 {
+		limiter = time.NewTicker(time.Duration(float64(time.Second) / p.rate)).C
+	}
+	for i := 0; i < p.workers; i++ {
+		go func() {
+			for key := range p.queue {
+				if limiter != nil {
+					<-limiter
+				}
+				p.work(key)
+			}
+		}()
+	}
+}
+
+func (p *lookupPool) work(key string) {
+	ctx, cancel := context.WithTimeout(context.Background(), p.timeout)
+	value, err := p.fetch(ctx, key)
+	cancel()
+	p.mutex.Lock()
+	delete(p.queued, key)
+	if err != nil {
+		p.stats.Failures++
+		p.mutex.Unlock()
+		fmt.Println("Cannot look up", p.name, "of", key+":", err)
+		return
+	}
+	p.stats.Lookups++
+	p.cache[key] = LookupResult{Value: value, Time: time.Now()}
+	var cache map[string]LookupResult
+	if p.saved != nil && (time.Since(p.lastSaved) >= metaSaveInterval || len(p.queue) == 0) {
+		p.lastSaved = time.Now()
+		cache = make(map[string]LookupResult, len(p.cache))
+		for k, v := range p.cache {
+			cache[k] = v
+		}
+	}
+	p.mutex.Unlock()
+	// saved outside the lock so lookups don't wait for the disk, one save at a time
+	if cache != nil {
+		p.saveMutex.Lock()
+		p.saved(cache)
+		p.saveMutex.Unlock()
+	}
+}
+
+func (p *lookupPool) getStats() LookupStats {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.stats
+}
+
+// the lookup pools with how they have been doing, by name
+func getLookupStats() map[string]LookupStats {
+	lookupPoolsMutex.Lock()
+	defer lookupPoolsMutex.Unlock()
+	stats := make(map[string]LookupStats)
+	for _, p := range lookupPools {
+		stats[p.name] = p.getStats()
+	}
+	return stats
+}
@@ -0,0 +1,1520 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// A small interpreter for the user scripts, a subset of Lua 5.1 without metatables, coroutines or goto and
+// with only floating point numbers. Scripts are parsed into a tree that is walked to run them, they can't
+// run more than a number of steps or nest calls too deep so a broken script can't hang a parse.
+
+// most statements, loop iterations, calls and pattern matches a script runs in one call from netnet
+const maxScriptSteps = 10000000
+
+// how deep calls and the syntax of a script nest
+const maxScriptDepth = 200
+
+// longest string a script can make
+const maxScriptString = 16 << 20
+
+// an error raised by a script, or by the interpreter while running it
+type luaError struct {
+	value interface{} // what error() was called with
+	limit bool        // ran out of steps, pcall doesn't catch it
+}
+
+func (e *luaError) Error() string {
+	return luaToString(e.value)
+}
+
+// a function of netnet called by a script
+type luaGoFunc struct {
+	name string
+	fn   func(L *luaState, args []interface{}) []interface{}
+}
+
+// a function of a script with the scope it was made in
+type luaClosure struct {
+	fn    *luaFuncExpr
+	scope *luaScope
+}
+
+type luaScope struct {
+	vars   map[string]*interface{}
+	parent *luaScope
+}
+
+func (s *luaScope) lookup(name string) *interface{} {
+	for ; s != nil; s = s.parent {
+		if cell, ok := s.vars[name]; ok {
+			return cell
+		}
+	}
+	return nil
+}
+
+func (s *luaScope) declare(name string, v interface{}) *interface{} {
+	if s.vars == nil {
+		s.vars = make(map[string]*interface{})
+	}
+	cell := &v
+	s.vars[name] = cell
+	return cell
+}
+
+// lexer
+
+type luaToken struct {
+	kind string // name, number, string, eof or the keyword or symbol itself
+	text string
+	num  float64
+	line int
+}
+
+var luaKeywords = map[string]bool{
+	"and": true, "break": true, "do": true, "else": true, "elseif": true, "end": true, "false": true, "for": true,
+	"function": true, "if": true, "in": true, "local": true, "nil": true, "not": true, "or": true, "repeat": true,
+	"return": true, "then": true, "true": true, "until": true, "while": true,
+}
+
+// symbols longest first so .. isn't read as two dots
+var luaSymbols = []string{"...", "..", "==", "~=", "<=", ">=", "//", "+", "-", "*", "/", "%", "^", "#", "<", ">", "=",
+	"(", ")", "{", "}", "[", "]", ";", ":", ",", "."}
+
+func luaLex(name, src string) ([]luaToken, error) {
+	var tokens []luaToken
+	line := 1
+	i := 0
+	fail := func(format string, args ...interface{}) ([]luaToken, error) {
+		return nil, fmt.Errorf("%s:%d: %s", name, line, fmt.Sprintf(format, args...))
+	}
+	for {
+		// whitespace and comments
+		for i < len(src) {
+			c := src[i]
+			if c == '\n' {
+				line++
+				i++
+			} else if c == ' ' || c == '\t' || c == '\r' || c == '\f' || c == '\v' {
+				i++
+			} else if strings.HasPrefix(src[i:], "--") {
+				i += 2
+				if level := luaLongBracket(src[i:]); level >= 0 {
+					end := strings.Index(src[i:], "]"+strings.Repeat("=", level)+"]")
+					if end < 0 {
+						return fail("unfinished long comment")
+					}
+					line += strings.Count(src[i:i+end], "\n")
+					i += end + level + 2
+				} else {
+					for i < len(src) && src[i] != '\n' {
+						i++
+					}
+				}
+			} else if i == 0 && strings.HasPrefix(src, "#!") {
+				for i < len(src) && src[i] != '\n' {
+					i++
+				}
+			} else {
+				break
+			}
+		}
+		if i >= len(src) {
+			return append(tokens, luaToken{kind: "eof", line: line}), nil
+		}
+		c := src[i]
+		switch {
+		case c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z':
+			start := i
+			for i < len(src) && (src[i] == '_' || src[i] >= 'a' && src[i] <= 'z' || src[i] >= 'A' && src[i] <= 'Z' || src[i] >= '0' && src[i] <= '9') {
+				i++
+			}
+			word := src[start:i]
+			if luaKeywords[word] {
+				tokens = append(tokens, luaToken{kind: word, text: word, line: line})
+			} else {
+				tokens = append(tokens, luaToken{kind: "name", text: word, line: line})
+			}
+		case c >= '0' && c <= '9' || c == '.' && i+1 < len(src) && src[i+1] >= '0' && src[i+1] <= '9':
+			start := i
+			if strings.HasPrefix(src[i:], "0x") || strings.HasPrefix(src[i:], "0X") {
+				i += 2
+				for i < len(src) && isHexDigit(src[i]) {
+					i++
+				}
+			} else {
+				for i < len(src) && (src[i] >= '0' && src[i] <= '9' || src[i] == '.') {
+					i++
+				}
+				if i < len(src) && (src[i] == 'e' || src[i] == 'E') {
+					i++
+					if i < len(src) && (src[i] == '+' || src[i] == '-') {
+						i++
+					}
+					for i < len(src) && src[i] >= '0' && src[i] <= '9' {
+						i++
+					}
+				}
+			}
+			n, ok := luaParseNumber(src[start:i])
+			if !ok {
+				return fail("malformed number near '%s'", src[start:i])
+			}
+			tokens = append(tokens, luaToken{kind: "number", num: n, line: line})
+		case c == '"' || c == '\'':
+			s, n, err := luaLexString(src[i:])
+			if err != nil {
+				return fail("%v", err)
+			}
+			tokens = append(tokens, luaToken{kind: "string", text: s, line: line})
+			i += n
+		case c == '[' && luaLongBracket(src[i:]) >= 0:
+			level := luaLongBracket(src[i:])
+			i += level + 2
+			end := strings.Index(src[i:], "]"+strings.Repeat("=", level)+"]")
+			if end < 0 {
+				return fail("unfinished long string")
+			}
+			s := src[i : i+end]
+			// a newline right after the opening bracket isn't part of the string
+			if strings.HasPrefix(s, "\r\n") {
+				s = s[2:]
+			} else if strings.HasPrefix(s, "\n") {
+				s = s[1:]
+			}
+			tokens = append(tokens, luaToken{kind: "string", text: s, line: line})
+			line += strings.Count(src[i:i+end], "\n")
+			i += end + level + 2
+		default:
+			found := false
+			for _, symbol := range luaSymbols {
+				if strings.HasPrefix(src[i:], symbol) {
+					tokens = append(tokens, luaToken{kind: symbol, text: symbol, line: line})
+					i += len(symbol)
+					found = true
+					break
+				}
+			}
+			if !found {
+				return fail("unexpected symbol near '%c'", c)
+			}
+		}
+	}
+}
+
+// the level of a long bracket like [==[ at the start of s, -1 if there is none
+func luaLongBracket(s string) int {
+	if !strings.HasPrefix(s, "[") {
+		return -1
+	}
+	level := 1
+	for level < len(s) && s[level] == '=' {
+		level++
+	}
+	if level < len(s) && s[level] == '[' {
+		return level - 1
+	}
+	return -1
+}
+
+// read a quoted string, its value and how long it is in the source
+func luaLexString(src string) (string, int, error) {
+	quote := src[0]
+	var b strings.Builder
+	i := 1
+	for {
+		if i >= len(src) || src[i] == '\n' {
+			return "", 0, fmt.Errorf("unfinished string")
+		}
+		c := src[i]
+		i++
+		if c == quote {
+			return b.String(), i, nil
+		}
+		if c != '\\' {
+			b.WriteByte(c)
+			continue
+		}
+		if i >= len(src) {
+			return "", 0, fmt.Errorf("unfinished string")
+		}
+		c = src[i]
+		i++
+		switch c {
+		case 'n':
+			b.WriteByte('\n')
+		case 't':
+			b.WriteByte('\t')
+		case 'r':
+			b.WriteByte('\r')
+		case 'a':
+			b.WriteByte('\a')
+		case 'b':
+			b.WriteByte('\b')
+		case 'f':
+			b.WriteByte('\f')
+		case 'v':
+			b.WriteByte('\v')
+		case '\\', '"', '\'', '\n':
+			b.WriteByte(c)
+		case 'x':
+			if i+2 > len(src) || !isHexDigit(src[i]) || !isHexDigit(src[i+1]) {
+				return "", 0, fmt.Errorf("hexadecimal digit expected")
+			}
+			n, _ := strconv.ParseUint(src[i:i+2], 16, 8)
+			b.WriteByte(byte(n))
+			i += 2
+		default:
+			if c < '0' || c > '9' {
+				return "", 0, fmt.Errorf("invalid escape sequence '\\%c'", c)
+			}
+			n := int(c - '0')
+			for j := 0; j < 2 && i < len(src) && src[i] >= '0' && src[i] <= '9'; j++ {
+				n = n*10 + int(src[i]-'0')
+				i++
+			}
+			if n > 255 {
+				return "", 0, fmt.Errorf("decimal escape too large")
+			}
+			b.WriteByte(byte(n))
+		}
+	}
+}
+
+func isHexDigit(c byte) bool {
+	return c >= '0' && c <= '9' || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F'
+}
+
+// a number in Lua syntax, decimal or hexadecimal
+func luaParseNumber(s string) (float64, bool) {
+	s = strings.TrimSpace(s)
+	negative := false
+	if strings.HasPrefix(s, "-") {
+		negative, s = true, s[1:]
+	}
+	var n float64
+	if strings.HasPrefix(s, "0x") || strings.HasPrefix(s, "0X") {
+		u, err := strconv.ParseUint(s[2:], 16, 64)
+		if err != nil {
+			return 0, false
+		}
+		n = float64(u)
+	} else {
+		if s == "" || strings.ContainsAny(s, "xXnN_") {
+			return 0, false
+		}
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil && !strings.Contains(err.Error(), "range") {
+			return 0, false
+		}
+		n = f
+	}
+	if negative {
+		n = -n
+	}
+	return n, true
+}
+
+// syntax tree
+
+type luaExpr interface{}
+type luaStmt interface{}
+
+type luaBlock struct {
+	stmts []luaStmt
+}
+
+type (
+	luaConstExpr  struct{ value interface{} }
+	luaNameExpr   struct{ name string }
+	luaIndexExpr  struct{ obj, key luaExpr }
+	luaVarargExpr struct{}
+	luaParenExpr  struct{ expr luaExpr }
+	luaBinExpr    struct {
+		op   string
+		a, b luaExpr
+	}
+	luaUnExpr struct {
+		op   string
+		expr luaExpr
+	}
+	luaCallExpr struct {
+		fn     luaExpr
+		method string // a:method(args)
+		args   []luaExpr
+	}
+	luaFuncExpr struct {
+		params []string
+		vararg bool
+		body   *luaBlock
+	}
+	luaTableExpr struct {
+		keys   []luaExpr // nil for the items without a key
+		values []luaExpr
+	}
+)
+
+type (
+	luaLocalStmt struct {
+		line  int
+		names []string
+		exprs []luaExpr
+	}
+	luaAssignStmt struct {
+		line    int
+		targets []luaExpr
+		exprs   []luaExpr
+	}
+	luaCallStmt struct {
+		line int
+		call *luaCallExpr
+	}
+	luaIfStmt struct {
+		line     int
+		conds    []luaExpr
+		blocks   []*luaBlock
+		elseBody *luaBlock
+	}
+	luaWhileStmt struct {
+		line int
+		cond luaExpr
+		body *luaBlock
+	}
+	luaRepeatStmt struct {
+		line int
+		body *luaBlock
+		cond luaExpr
+	}
+	luaNumForStmt struct {
+		line               int
+		name               string
+		start, limit, step luaExpr
+		body               *luaBlock
+	}
+	luaGenForStmt struct {
+		line  int
+		names []string
+		exprs []luaExpr
+		body  *luaBlock
+	}
+	luaDoStmt struct {
+		line int
+		body *luaBlock
+	}
+	luaReturnStmt struct {
+		line  int
+		exprs []luaExpr
+	}
+	luaBreakStmt     struct{ line int }
+	luaLocalFuncStmt struct {
+		line int
+		name string
+		fn   *luaFuncExpr
+	}
+)
+
+// parser
+
+type luaParser struct {
+	name   string
+	tokens []luaToken
+	pos    int
+	depth  int
+}
+
+type luaSyntaxError struct{ msg string }
+
+// parse a script into the function that runs it
+func luaParse(name, src string) (fn *luaFuncExpr, err error) {
+	tokens, err := luaLex(name, src)
+	if err != nil {
+		return nil, err
+	}
+	p := &luaParser{name: name, tokens: tokens}
+	defer func() {
+		if r := recover(); r != nil {
+			e, ok := r.(luaSyntaxError)
+			if !ok {
+				panic(r)
+			}
+			err = fmt.Errorf("%s", e.msg)
+		}
+	}()
+	body := p.block()
+	if p.peek().kind != "eof" {
+		p.fail("'<eof>' expected")
+	}
+	return &luaFuncExpr{vararg: true, body: body}, nil
+}
+
+func (p *luaParser) peek() luaToken {
+	return p.tokens[p.pos]
+}
+
+func (p *luaParser) next() luaToken {
+	t := p.tokens[p.pos]
+	if t.kind != "eof" {
+		p.pos++
+	}
+	return t
+}
+
+func (p *luaParser) accept(kind string) bool {
+	if p.peek().kind == kind {
+		p.next()
+		return true
+	}
+	return false
+}
+
+func (p *luaParser) expect(kind string) luaToken {
+	if p.peek().kind != kind {
+		p.fail("'%s' expected", kind)
+	}
+	return p.next()
+}
+
+func (p *luaParser) fail(format string, args ...interface{}) {
+	t := p.peek()
+	near := t.text
+	switch t.kind {
+	case "eof":
+		near = "<eof>"
+	case "number":
+		near = luaNumberString(t.num)
+	}
+	panic(luaSyntaxError{fmt.Sprintf("%s:%d: %s near '%s'", p.name, t.line, fmt.Sprintf(format, args...), near)})
+}
+
+func (p *luaParser) enter() {
+	p.depth++
+	if p.depth > maxScriptDepth {
+		p.fail("chunk has too many syntax levels")
+	}
+}
+
+func (p *luaParser) block() *luaBlock {
+	p.enter()
+	defer func() { p.depth-- }()
+	b := &luaBlock{}
+	for {
+		switch p.peek().kind {
+		case "eof", "end", "else", "elseif", "until":
+			return b
+		case "return":
+			line := p.next().line
+			s := &luaReturnStmt{line: line}
+			if k := p.peek().kind; k != "eof" && k != "end" && k != "else" && k != "elseif" && k != "until" && k != ";" {
+				s.exprs = p.exprList()
+			}
+			p.accept(";")
+			b.stmts = append(b.stmts, s)
+			return b
+		}
+		if s := p.statement(); s != nil {
+			b.stmts = append(b.stmts, s)
+		}
+	}
+}
+
+func (p *luaParser) statement() luaStmt {
+	t := p.peek()
+	line := t.line
+	switch t.kind {
+	case ";":
+		p.next()
+		return nil
+	case "if":
+		p.next()
+		s := &luaIfStmt{line: line}
+		s.conds = append(s.conds, p.expr())
+		p.expect("then")
+		s.blocks = append(s.blocks, p.block())
+		for p.accept("elseif") {
+			s.conds = append(s.conds, p.expr())
+			p.expect("then")
+			s.blocks = append(s.blocks, p.block())
+		}
+		if p.accept("else") {
+			s.elseBody = p.block()
+		}
+		p.expect("end")
+		return s
+	case "while":
+		p.next()
+		cond := p.expr()
+		p.expect("do")
+		body := p.block()
+		p.expect("end")
+		return &luaWhileStmt{line: line, cond: cond, body: body}
+	case "do":
+		p.next()
+		body := p.block()
+		p.expect("end")
+		return &luaDoStmt{line: line, body: body}
+	case "for":
+		p.next()
+		name := p.expect("name").text
+		if p.accept("=") {
+			s := &luaNumForStmt{line: line, name: name, start: p.expr()}
+			p.expect(",")
+			s.limit = p.expr()
+			if p.accept(",") {
+				s.step = p.expr()
+			}
+			p.expect("do")
+			s.body = p.block()
+			p.expect("end")
+			return s
+		}
+		s := &luaGenForStmt{line: line, names: []string{name}}
+		for p.accept(",") {
+			s.names = append(s.names, p.expect("name").text)
+		}
+		p.expect("in")
+		s.exprs = p.exprList()
+		p.expect("do")
+		s.body = p.block()
+		p.expect("end")
+		return s
+	case "repeat":
+		p.next()
+		body := p.block()
+		p.expect("until")
+		return &luaRepeatStmt{line: line, body: body, cond: p.expr()}
+	case "function":
+		p.next()
+		var target luaExpr = &luaNameExpr{p.expect("name").text}
+		for p.accept(".") {
+			target = &luaIndexExpr{target, &luaConstExpr{p.expect("name").text}}
+		}
+		method := false
+		if p.accept(":") {
+			target = &luaIndexExpr{target, &luaConstExpr{p.expect("name").text}}
+			method = true
+		}
+		fn := p.funcBody(method)
+		return &luaAssignStmt{line: line, targets: []luaExpr{target}, exprs: []luaExpr{fn}}
+	case "local":
+		p.next()
+		if p.accept("function") {
+			name := p.expect("name").text
+			return &luaLocalFuncStmt{line: line, name: name, fn: p.funcBody(false)}
+		}
+		s := &luaLocalStmt{line: line, names: []string{p.expect("name").text}}
+		for p.accept(",") {
+			s.names = append(s.names, p.expect("name").text)
+		}
+		if p.accept("=") {
+			s.exprs = p.exprList()
+		}
+		return s
+	case "break":
+		p.next()
+		return &luaBreakStmt{line: line}
+	}
+	e := p.suffixedExpr()
+	if p.peek().kind == "=" || p.peek().kind == "," {
+		s := &luaAssignStmt{line: line, targets: []luaExpr{e}}
+		for p.accept(",") {
+			s.targets = append(s.targets, p.suffixedExpr())
+		}
+		p.expect("=")
+		s.exprs = p.exprList()
+		for _, target := range s.targets {
+			switch target.(type) {
+			case *luaNameExpr, *luaIndexExpr:
+			default:
+				p.fail("syntax error")
+			}
+		}
+		return s
+	}
+	call, ok := e.(*luaCallExpr)
+	if !ok {
+		p.fail("syntax error")
+	}
+	return &luaCallStmt{line: line, call: call}
+}
+
+func (p *luaParser) funcBody(method bool) *luaFuncExpr {
+	fn := &luaFuncExpr{}
+	if method {
+		fn.params = append(fn.params, "self")
+	}
+	p.expect("(")
+	if !p.accept(")") {
+		for {
+			if p.accept("...") {
+				fn.vararg = true
+				break
+			}
+			fn.params = append(fn.params, p.expect("name").text)
+			if !p.accept(",") {
+				break
+			}
+		}
+		p.expect(")")
+	}
+	fn.body = p.block()
+	p.expect("end")
+	return fn
+}
+
+func (p *luaParser) exprList() []luaExpr {
+	list := []luaExpr{p.expr()}
+	for p.accept(",") {
+		list = append(list, p.expr())
+	}
+	return list
+}
+
+// left and right priorities of the binary operators, as in Lua
+var luaBinaryPriority = map[string][2]int{
+	"or": {1, 1}, "and": {2, 2},
+	"<": {3, 3}, ">": {3, 3}, "<=": {3, 3}, ">=": {3, 3}, "~=": {3, 3}, "==": {3, 3},
+	"..": {9, 8}, "+": {10, 10}, "-": {10, 10},
+	"*": {11, 11}, "/": {11, 11}, "//": {11, 11}, "%": {11, 11},
+	"^": {14, 13},
+}
+
+const luaUnaryPriority = 12
+
+func (p *luaParser) expr() luaExpr {
+	return p.subExpr(0)
+}
+
+func (p *luaParser) subExpr(limit int) luaExpr {
+	p.enter()
+	defer func() { p.depth-- }()
+	var e luaExpr
+	if k := p.peek().kind; k == "not" || k == "-" || k == "#" {
+		p.next()
+		e = &luaUnExpr{k, p.subExpr(luaUnaryPriority)}
+	} else {
+		e = p.simpleExpr()
+	}
+	for {
+		op := p.peek().kind
+		priority, ok := luaBinaryPriority[op]
+		if !ok || priority[0] <= limit {
+			return e
+		}
+		p.next()
+		e = &luaBinExpr{op, e, p.subExpr(priority[1])}
+	}
+}
+
+func (p *luaParser) simpleExpr() luaExpr {
+	t := p.peek()
+	switch t.kind {
+	case "number":
+		p.next()
+		return &luaConstExpr{t.num}
+	case "string":
+		p.next()
+		return &luaConstExpr{t.text}
+	case "nil":
+		p.next()
+		return &luaConstExpr{nil}
+	case "true":
+		p.next()
+		return &luaConstExpr{true}
+	case "false":
+		p.next()
+		return &luaConstExpr{false}
+	case "...":
+		p.next()
+		return &luaVarargExpr{}
+	case "{":
+		return p.tableConstructor()
+	case "function":
+		p.next()
+		return p.funcBody(false)
+	}
+	return p.suffixedExpr()
+}
+
+func (p *luaParser) primaryExpr() luaExpr {
+	t := p.peek()
+	switch t.kind {
+	case "name":
+		p.next()
+		return &luaNameExpr{t.text}
+	case "(":
+		p.next()
+		e := p.expr()
+		p.expect(")")
+		return &luaParenExpr{e}
+	}
+	p.fail("unexpected symbol")
+	return nil
+}
+
+func (p *luaParser) suffixedExpr() luaExpr {
+	e := p.primaryExpr()
+	for {
+		switch p.peek().kind {
+		case ".":
+			p.next()
+			e = &luaIndexExpr{e, &luaConstExpr{p.expect("name").text}}
+		case "[":
+			p.next()
+			key := p.expr()
+			p.expect("]")
+			e = &luaIndexExpr{e, key}
+		case ":":
+			p.next()
+			method := p.expect("name").text
+			e = &luaCallExpr{fn: e, method: method, args: p.callArgs()}
+		case "(", "string", "{":
+			e = &luaCallExpr{fn: e, args: p.callArgs()}
+		default:
+			return e
+		}
+	}
+}
+
+func (p *luaParser) callArgs() []luaExpr {
+	t := p.peek()
+	switch t.kind {
+	case "string":
+		p.next()
+		return []luaExpr{&luaConstExpr{t.text}}
+	case "{":
+		return []luaExpr{p.tableConstructor()}
+	}
+	p.expect("(")
+	if p.accept(")") {
+		return nil
+	}
+	args := p.exprList()
+	p.expect(")")
+	return args
+}
+
+func (p *luaParser) tableConstructor() luaExpr {
+	p.expect("{")
+	t := &luaTableExpr{}
+	for !p.accept("}") {
+		var key luaExpr
+		if p.peek().kind == "[" {
+			p.next()
+			key = p.expr()
+			p.expect("]")
+			p.expect("=")
+		} else if p.peek().kind == "name" && p.tokens[p.pos+1].kind == "=" {
+			key = &luaConstExpr{p.next().text}
+			p.next()
+		}
+		t.keys = append(t.keys, key)
+		t.values = append(t.values, p.expr())
+		if !p.accept(",") && !p.accept(";") {
+			p.expect("}")
+			break
+		}
+	}
+	return t
+}
+
+// evaluator
+
+// how a statement ends the block it is in
+const (
+	luaNext = iota
+	luaBreak
+	luaReturn
+)
+
+type luaState struct {
+	name    string // of the script, for errors
+	line    int    // being run
+	globals *luaTable
+	strings *luaTable // the string library, for s:method()
+	steps   int
+	depth   int
+}
+
+// count a step of the script, and stop it when it ran too many
+func (L *luaState) step() {
+	L.steps++
+	if L.steps > maxScriptSteps {
+		panic(&luaError{value: fmt.Sprintf("%s:%d: script ran more than %d steps", L.name, L.line, maxScriptSteps), limit: true})
+	}
+}
+
+func (L *luaState) errorf(format string, args ...interface{}) {
+	panic(&luaError{value: fmt.Sprintf("%s:%d: %s", L.name, L.line, fmt.Sprintf(format, args...))})
+}
+
+// run a function of the script and catch what it raised
+func (L *luaState) pcall(fn interface{}, args ...interface{}) (results []interface{}, err error) {
+	depth := L.depth
+	defer func() {
+		if r := recover(); r != nil {
+			L.depth = depth
+			if e, ok := r.(*luaError); ok {
+				err = e
+			} else {
+				err = fmt.Errorf("%s:%d: %v", L.name, L.line, r)
+			}
+		}
+	}()
+	return L.call(fn, args), nil
+}
+
+func (L *luaState) call(fn interface{}, args []interface{}) []interface{} {
+	switch f := fn.(type) {
+	case *luaGoFunc:
+		return f.fn(L, args)
+	case *luaClosure:
+		L.step()
+		L.depth++
+		if L.depth > maxScriptDepth {
+			L.errorf("stack overflow")
+		}
+		line := L.line
+		scope := &luaScope{parent: f.scope, vars: make(map[string]*interface{}, len(f.fn.params)+1)}
+		for i, name := range f.fn.params {
+			var v interface{}
+			if i < len(args) {
+				v = args[i]
+			}
+			scope.declare(name, v)
+		}
+		if f.fn.vararg {
+			var rest []interface{}
+			if len(args) > len(f.fn.params) {
+				rest = append(rest, args[len(f.fn.params):]...)
+			}
+			scope.declare("...", rest)
+		}
+		ctrl, results := L.execBlock(f.fn.body, scope)
+		L.depth--
+		L.line = line
+		if ctrl == luaReturn {
+			return results
+		}
+		return nil
+	}
+	L.errorf("attempt to call a %s value", luaType(fn))
+	return nil
+}
+
+func (L *luaState) execBlock(b *luaBlock, scope *luaScope) (int, []interface{}) {
+	for _, s := range b.stmts {
+		if ctrl, results := L.exec(s, scope); ctrl != luaNext {
+			return ctrl, results
+		}
+	}
+	return luaNext, nil
+}
+
+func (L *luaState) exec(stmt luaStmt, scope *luaScope) (int, []interface{}) {
+	switch s := stmt.(type) {
+	case *luaLocalStmt:
+		L.line = s.line
+		L.step()
+		values := L.evalList(s.exprs, len(s.names), scope)
+		for i, name := range s.names {
+			scope.declare(name, values[i])
+		}
+	case *luaAssignStmt:
+		L.line = s.line
+		L.step()
+		values := L.evalList(s.exprs, len(s.targets), scope)
+		for i, target := range s.targets {
+			L.assign(target, values[i], scope)
+		}
+	case *luaCallStmt:
+		L.line = s.line
+		L.step()
+		L.callExpr(s.call, scope)
+	case *luaIfStmt:
+		L.line = s.line
+		L.step()
+		for i, cond := range s.conds {
+			if luaTruthy(L.eval(cond, scope)) {
+				return L.execBlock(s.blocks[i], &luaScope{parent: scope})
+			}
+		}
+		if s.elseBody != nil {
+			return L.execBlock(s.elseBody, &luaScope{parent: scope})
+		}
+	case *luaWhileStmt:
+		for {
+			L.line = s.line
+			L.step()
+			if !luaTruthy(L.eval(s.cond, scope)) {
+				break
+			}
+			ctrl, results := L.execBlock(s.body, &luaScope{parent: scope})
+			if ctrl == luaBreak {
+				break
+			}
+			if ctrl == luaReturn {
+				return ctrl, results
+			}
+		}
+	case *luaRepeatStmt:
+		for {
+			L.line = s.line
+			L.step()
+			// the condition sees the locals of the body
+			inner := &luaScope{parent: scope}
+			ctrl, results := L.execBlock(s.body, inner)
+			if ctrl == luaBreak {
+				break
+			}
+			if ctrl == luaReturn {
+				return ctrl, results
+			}
+			if luaTruthy(L.eval(s.cond, inner)) {
+				break
+			}
+		}
+	case *luaNumForStmt:
+		L.line = s.line
+		start, ok1 := luaToNumber(L.eval(s.start, scope))
+		limit, ok2 := luaToNumber(L.eval(s.limit, scope))
+		step, ok3 := 1.0, true
+		if s.step != nil {
+			step, ok3 = luaToNumber(L.eval(s.step, scope))
+		}
+		if !ok1 || !ok2 || !ok3 {
+			L.errorf("'for' initial value, limit and step must be numbers")
+		}
+		if step == 0 {
+			L.errorf("'for' step is zero")
+		}
+		for v := start; step > 0 && v <= limit || step < 0 && v >= limit; v += step {
+			L.line = s.line
+			L.step()
+			inner := &luaScope{parent: scope}
+			inner.declare(s.name, v)
+			ctrl, results := L.execBlock(s.body, inner)
+			if ctrl == luaBreak {
+				break
+			}
+			if ctrl == luaReturn {
+				return ctrl, results
+			}
+		}
+	case *luaGenForStmt:
+		L.line = s.line
+		values := L.evalList(s.exprs, 3, scope)
+		fn, state, control := values[0], values[1], values[2]
+		for {
+			L.line = s.line
+			L.step()
+			results := L.call(fn, []interface{}{state, control})
+			if len(results) == 0 || results[0] == nil {
+				break
+			}
+			control = results[0]
+			inner := &luaScope{parent: scope}
+			for i, name := range s.names {
+				var v interface{}
+				if i < len(results) {
+					v = results[i]
+				}
+				inner.declare(name, v)
+			}
+			ctrl, results := L.execBlock(s.body, inner)
+			if ctrl == luaBreak {
+				break
+			}
+			if ctrl == luaReturn {
+				return ctrl, results
+			}
+		}
+	case *luaDoStmt:
+		return L.execBlock(s.body, &luaScope{parent: scope})
+	case *luaReturnStmt:
+		L.line = s.line
+		L.step()
+		return luaReturn, L.evalList(s.exprs, -1, scope)
+	case *luaBreakStmt:
+		return luaBreak, nil
+	case *luaLocalFuncStmt:
+		L.line = s.line
+		L.step()
+		// declared first so the function can call itself
+		cell := scope.declare(s.name, nil)
+		*cell = &luaClosure{s.fn, scope}
+	}
+	return luaNext, nil
+}
+
+func (L *luaState) assign(target luaExpr, v interface{}, scope *luaScope) {
+	switch t := target.(type) {
+	case *luaNameExpr:
+		if cell := scope.lookup(t.name); cell != nil {
+			*cell = v
+		} else {
+			L.globals.set(t.name, v)
+		}
+	case *luaIndexExpr:
+		obj := L.eval(t.obj, scope)
+		table, ok := obj.(*luaTable)
+		if !ok {
+			L.errorf("attempt to index a %s value%s", luaType(obj), luaDescribe(t.obj))
+		}
+		key := L.eval(t.key, scope)
+		if key == nil {
+			L.errorf("table index is nil")
+		}
+		if f, ok := key.(float64); ok && math.IsNaN(f) {
+			L.errorf("table index is NaN")
+		}
+		table.set(key, v)
+	}
+}
+
+// the values of a list of expressions, the last one gives all its values, padded or cut to want unless it is -1
+func (L *luaState) evalList(exprs []luaExpr, want int, scope *luaScope) []interface{} {
+	var values []interface{}
+	for i, e := range exprs {
+		if i == len(exprs)-1 {
+			values = append(values, L.evalMulti(e, scope)...)
+		} else {
+			values = append(values, L.eval(e, scope))
+		}
+	}
+	if want < 0 {
+		return values
+	}
+	for len(values) < want {
+		values = append(values, nil)
+	}
+	return values[:want]
+}
+
+// all the values of a call or ..., the one value of anything else
+func (L *luaState) evalMulti(e luaExpr, scope *luaScope) []interface{} {
+	switch x := e.(type) {
+	case *luaCallExpr:
+		return L.callExpr(x, scope)
+	case *luaVarargExpr:
+		return L.varargs(scope)
+	}
+	return []interface{}{L.eval(e, scope)}
+}
+
+func (L *luaState) varargs(scope *luaScope) []interface{} {
+	cell := scope.lookup("...")
+	if cell == nil {
+		L.errorf("cannot use '...' outside a vararg function")
+	}
+	rest, _ := (*cell).([]interface{})
+	return rest
+}
+
+func (L *luaState) callExpr(c *luaCallExpr, scope *luaScope) []interface{} {
+	obj := L.eval(c.fn, scope)
+	fn := obj
+	var args []interface{}
+	if c.method != "" {
+		fn = L.index(obj, c.method, c.fn)
+		args = append(args, obj)
+	}
+	args = append(args, L.evalList(c.args, -1, scope)...)
+	switch fn.(type) {
+	case *luaGoFunc, *luaClosure:
+	default:
+		if c.method != "" {
+			L.errorf("attempt to call a %s value (method '%s')", luaType(fn), c.method)
+		}
+		L.errorf("attempt to call a %s value%s", luaType(fn), luaDescribe(c.fn))
+	}
+	return L.call(fn, args)
+}
+
+func (L *luaState) index(obj, key interface{}, e luaExpr) interface{} {
+	switch o := obj.(type) {
+	case *luaTable:
+		return o.get(key)
+	case string:
+		return L.strings.get(key)
+	}
+	L.errorf("attempt to index a %s value%s", luaType(obj), luaDescribe(e))
+	return nil
+}
+
+// how an expression is named in errors
+func luaDescribe(e luaExpr) string {
+	switch x := e.(type) {
+	case *luaNameExpr:
+		return " ('" + x.name + "')"
+	case *luaIndexExpr:
+		if k, ok := x.key.(*luaConstExpr); ok {
+			if s, ok := k.value.(string); ok {
+				return " (field '" + s + "')"
+			}
+		}
+	}
+	return ""
+}
+
+func (L *luaState) eval(e luaExpr, scope *luaScope) interface{} {
+	switch x := e.(type) {
+	case *luaConstExpr:
+		return x.value
+	case *luaNameExpr:
+		if cell := scope.lookup(x.name); cell != nil {
+			return *cell
+		}
+		return L.globals.get(x.name)
+	case *luaIndexExpr:
+		return L.index(L.eval(x.obj, scope), L.eval(x.key, scope), x.obj)
+	case *luaCallExpr:
+		if results := L.callExpr(x, scope); len(results) > 0 {
+			return results[0]
+		}
+		return nil
+	case *luaVarargExpr:
+		if rest := L.varargs(scope); len(rest) > 0 {
+			return rest[0]
+		}
+		return nil
+	case *luaParenExpr:
+		return L.eval(x.expr, scope)
+	case *luaFuncExpr:
+		return &luaClosure{x, scope}
+	case *luaUnExpr:
+		v := L.eval(x.expr, scope)
+		switch x.op {
+		case "not":
+			return !luaTruthy(v)
+		case "-":
+			n, ok := luaToNumber(v)
+			if !ok {
+				L.errorf("attempt to perform arithmetic on a %s value%s", luaType(v), luaDescribe(x.expr))
+			}
+			return -n
+		default:
+			switch o := v.(type) {
+			case string:
+				return float64(len(o))
+			case *luaTable:
+				return float64(o.length())
+			}
+			L.errorf("attempt to get length of a %s value%s", luaType(v), luaDescribe(x.expr))
+		}
+	case *luaBinExpr:
+		switch x.op {
+		case "and":
+			a := L.eval(x.a, scope)
+			if !luaTruthy(a) {
+				return a
+			}
+			return L.eval(x.b, scope)
+		case "or":
+			a := L.eval(x.a, scope)
+			if luaTruthy(a) {
+				return a
+			}
+			return L.eval(x.b, scope)
+		}
+		return L.binary(x, L.eval(x.a, scope), L.eval(x.b, scope))
+	case *luaTableExpr:
+		t := newLuaTable()
+		n := 1.0
+		for i, key := range x.keys {
+			if key != nil {
+				k := L.eval(key, scope)
+				if k == nil {
+					L.errorf("table index is nil")
+				}
+				t.set(k, L.eval(x.values[i], scope))
+				continue
+			}
+			if i == len(x.keys)-1 {
+				for _, v := range L.evalMulti(x.values[i], scope) {
+					t.set(n, v)
+					n++
+				}
+				continue
+			}
+			t.set(n, L.eval(x.values[i], scope))
+			n++
+		}
+		return t
+	}
+	return nil
+}
+
+func (L *luaState) binary(x *luaBinExpr, a, b interface{}) interface{} {
+	switch x.op {
+	case "==":
+		return a == b
+	case "~=":
+		return a != b
+	case "<":
+		return L.less(a, b, false)
+	case "<=":
+		return L.less(a, b, true)
+	case ">":
+		return L.less(b, a, false)
+	case ">=":
+		return L.less(b, a, true)
+	case "..":
+		as, ok1 := luaToStringCoerce(a)
+		bs, ok2 := luaToStringCoerce(b)
+		if !ok1 {
+			L.errorf("attempt to concatenate a %s value%s", luaType(a), luaDescribe(x.a))
+		}
+		if !ok2 {
+			L.errorf("attempt to concatenate a %s value%s", luaType(b), luaDescribe(x.b))
+		}
+		if len(as)+len(bs) > maxScriptString {
+			L.errorf("string is too long")
+		}
+		return as + bs
+	}
+	an, ok := luaToNumber(a)
+	if !ok {
+		L.errorf("attempt to perform arithmetic on a %s value%s", luaType(a), luaDescribe(x.a))
+	}
+	bn, ok := luaToNumber(b)
+	if !ok {
+		L.errorf("attempt to perform arithmetic on a %s value%s", luaType(b), luaDescribe(x.b))
+	}
+	switch x.op {
+	case "+":
+		return an + bn
+	case "-":
+		return an - bn
+	case "*":
+		return an * bn
+	case "/":
+		return an / bn
+	case "//":
+		return math.Floor(an / bn)
+	case "%":
+		return an - math.Floor(an/bn)*bn
+	default:
+		return math.Pow(an, bn)
+	}
+}
+
+func (L *luaState) less(a, b interface{}, orEqual bool) bool {
+	switch x := a.(type) {
+	case float64:
+		if y, ok := b.(float64); ok {
+			return x < y || orEqual && x == y
+		}
+	case string:
+		if y, ok := b.(string); ok {
+			return x < y || orEqual && x == y
+		}
+	}
+	L.errorf("attempt to compare %s with %s", luaType(a), luaType(b))
+	return false
+}
+
+func luaTruthy(v interface{}) bool {
+	return v != nil && v != false
+}
+
+func luaType(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "nil"
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case *luaTable:
+		return "table"
+	}
+	return "function"
+}
+
+// a number, or a string that reads as one
+func luaToNumber(v interface{}) (float64, bool) {
+	switch x := v.(type) {
+	case float64:
+		return x, true
+	case string:
+		return luaParseNumber(x)
+	}
+	return 0, false
+}
+
+// a string, or a number written as one
+func luaToStringCoerce(v interface{}) (string, bool) {
+	switch x := v.(type) {
+	case string:
+		return x, true
+	case float64:
+		return luaNumberString(x), true
+	}
+	return "", false
+}
+
+func luaNumberString(n float64) string {
+	if n == math.Trunc(n) && math.Abs(n) < 1e15 {
+		return strconv.FormatInt(int64(n), 10)
+	}
+	switch {
+	case math.IsNaN(n):
+		return "nan"
+	case math.IsInf(n, 1):
+		return "inf"
+	case math.IsInf(n, -1):
+		return "-inf"
+	}
+	return strconv.FormatFloat(n, 'g', 14, 64)
+}
+
+func luaToString(v interface{}) string {
+	switch x := v.(type) {
+	case nil:
+		return "nil"
+	case bool:
+		return strconv.FormatBool(x)
+	case float64:
+		return luaNumberString(x)
+	case string:
+		return x
+	case *luaTable:
+		return fmt.Sprintf("table: %p", x)
+	case *luaGoFunc:
+		return "function: builtin: " + x.name
+	}
+	return fmt.Sprintf("function: %p", v)
+}
+
+// tables
+
+// a Lua table, the keys 1 to n are kept in order in the array and the others in the order they were added
+type luaTable struct {
+	array   []interface{}
+	index   map[interface{}]int
+	entries []luaEntry
+	dead    int
+}
+
+type luaEntry struct {
+	key, value interface{}
+}
+
+func newLuaTable() *luaTable {
+	return &luaTable{index: make(map[interface{}]int)}
+}
+
+// the position of a key in the array, -1 if it isn't one
+func (t *luaTable) arrayIndex(key interface{}, extra int) int {
+	f, ok := key.(float64)
+	if !ok || f < 1 || f > float64(len(t.array)+extra) || f != math.Trunc(f) {
+		return -1
+	}
+	return int(f) - 1
+}
+
+func (t *luaTable) get(key interface{}) interface{} {
+	if i := t.arrayIndex(key, 0); i >= 0 {
+		return t.array[i]
+	}
+	if i, ok := t.index[key]; ok {
+		return t.entries[i].value
+	}
+	return nil
+}
+
+func (t *luaTable) set(key, value interface{}) {
+	if i := t.arrayIndex(key, 1); i >= 0 {
+		if i < len(t.array) {
+			t.array[i] = value
+			for len(t.array) > 0 && t.array[len(t.array)-1] == nil {
+				t.array = t.array[:len(t.array)-1]
+			}
+			return
+		}
+		if value != nil {
+			t.array = append(t.array, value)
+			t.remove(key)
+			// the keys that follow move over from the hash
+			for {
+				next := float64(len(t.array) + 1)
+				i, ok := t.index[next]
+				if !ok {
+					break
+				}
+				t.array = append(t.array, t.entries[i].value)
+				t.remove(next)
+			}
+			return
+		}
+	}
+	if i, ok := t.index[key]; ok {
+		if value == nil {
+			t.remove(key)
+		} else {
+			t.entries[i].value = value
+		}
+		return
+	}
+	if value == nil {
+		return
+	}
+	// only when a key is added, removing keys while going through them with next is fine
+	if t.dead > 8 && t.dead > len(t.entries)/2 {
+		entries := make([]luaEntry, 0, len(t.index)+1)
+		for _, e := range t.entries {
+			if e.value != nil {
+				t.index[e.key] = len(entries)
+				entries = append(entries, e)
+			}
+		}
+		t.entries, t.dead = entries, 0
+	}
+	t.index[key] = len(t.entries)
+	t.entries = append(t.entries, luaEntry{key, value})
+}
+
+func (t *luaTable) remove(key interface{}) {
+	if i, ok := t.index[key]; ok {
+		delete(t.index, key)
+		t.entries[i] = luaEntry{}
+		t.dead++
+	}
+}
+
+func (t *luaTable) length() int {
+	return len(t.array)
+}
+
+// the key and value after a key, the first ones for nil, nil when there are no more
+func (t *luaTable) next(key interface{}) (interface{}, interface{}, bool) {
+	start := 0
+	if key != nil {
+		if i := t.arrayIndex(key, 0); i >= 0 {
+			start = i + 1
+		} else if i, ok := t.index[key]; ok {
+			start = len(t.array) + i + 1
+		} else {
+			return nil, nil, false
+		}
+	}
+	for i := start; i < len(t.array); i++ {
+		if t.array[i] != nil {
+			return float64(i + 1), t.array[i], true
+		}
+	}
+	if start < len(t.array) {
+		start = len(t.array)
+	}
+	for i := start - len(t.array); i < len(t.entries); i++ {
+		if e := t.entries[i]; e.value != nil {
+			return e.key, e.value, true
+		}
+	}
+	return nil, nil, true
+}
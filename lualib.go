@@ -0,0 +1,1035 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// the functions of netnet a script can call, the basic ones of Lua and parts of its string, table, math and os
+// libraries
+
+// a new interpreter for a script, print writes to the output of netnet
+func newLuaState(name string) *luaState {
+	L := &luaState{name: name, globals: newLuaTable()}
+	g := L.globals
+	register := func(t *luaTable, name string, fn func(L *luaState, args []interface{}) []interface{}) {
+		t.set(name, &luaGoFunc{name, fn})
+	}
+	g.set("_G", g)
+	register(g, "print", func(L *luaState, args []interface{}) []interface{} {
+		parts := make([]string, len(args))
+		for i, v := range args {
+			parts[i] = luaToString(v)
+		}
+		fmt.Println("Script "+L.name+":", strings.Join(parts, "\t"))
+		return nil
+	})
+	register(g, "type", func(L *luaState, args []interface{}) []interface{} {
+		luaArg(L, args, 0, "type")
+		return []interface{}{luaType(args[0])}
+	})
+	register(g, "tostring", func(L *luaState, args []interface{}) []interface{} {
+		return []interface{}{luaToString(luaArg(L, args, 0, "tostring"))}
+	})
+	register(g, "tonumber", func(L *luaState, args []interface{}) []interface{} {
+		v := luaArg(L, args, 0, "tonumber")
+		if len(args) > 1 && args[1] != nil {
+			base := int(luaCheckNumber(L, args, 1, "tonumber"))
+			s, ok := v.(string)
+			if !ok || base < 2 || base > 36 {
+				return []interface{}{nil}
+			}
+			n, err := strconv.ParseInt(strings.ToLower(strings.TrimSpace(s)), base, 64)
+			if err != nil {
+				return []interface{}{nil}
+			}
+			return []interface{}{float64(n)}
+		}
+		if n, ok := luaToNumber(v); ok {
+			return []interface{}{n}
+		}
+		return []interface{}{nil}
+	})
+	next := &luaGoFunc{"next", func(L *luaState, args []interface{}) []interface{} {
+		t := luaCheckTable(L, args, 0, "next")
+		var key interface{}
+		if len(args) > 1 {
+			key = args[1]
+		}
+		k, v, ok := t.next(key)
+		if !ok {
+			L.errorf("invalid key to 'next'")
+		}
+		if k == nil {
+			return []interface{}{nil}
+		}
+		return []interface{}{k, v}
+	}}
+	g.set("next", next)
+	register(g, "pairs", func(L *luaState, args []interface{}) []interface{} {
+		return []interface{}{next, luaCheckTable(L, args, 0, "pairs"), nil}
+	})
+	ipairsNext := &luaGoFunc{"ipairs", func(L *luaState, args []interface{}) []interface{} {
+		t := luaCheckTable(L, args, 0, "ipairs")
+		i := luaCheckNumber(L, args, 1, "ipairs") + 1
+		v := t.get(i)
+		if v == nil {
+			return nil
+		}
+		return []interface{}{i, v}
+	}}
+	register(g, "ipairs", func(L *luaState, args []interface{}) []interface{} {
+		return []interface{}{ipairsNext, luaCheckTable(L, args, 0, "ipairs"), 0.0}
+	})
+	register(g, "select", func(L *luaState, args []interface{}) []interface{} {
+		if len(args) > 0 && args[0] == "#" {
+			return []interface{}{float64(len(args) - 1)}
+		}
+		n := int(luaCheckNumber(L, args, 0, "select"))
+		if n < 0 {
+			n += len(args)
+		}
+		if n < 1 {
+			L.errorf("bad argument #1 to 'select' (index out of range)")
+		}
+		if n >= len(args) {
+			return nil
+		}
+		return args[n:]
+	})
+	register(g, "error", func(L *luaState, args []interface{}) []interface{} {
+		var v interface{}
+		if len(args) > 0 {
+			v = args[0]
+		}
+		if s, ok := v.(string); ok && (len(args) < 2 || args[1] != 0.0) {
+			v = fmt.Sprintf("%s:%d: %s", L.name, L.line, s)
+		}
+		panic(&luaError{value: v})
+	})
+	register(g, "assert", func(L *luaState, args []interface{}) []interface{} {
+		if len(args) == 0 || !luaTruthy(args[0]) {
+			if len(args) > 1 {
+				panic(&luaError{value: args[1]})
+			}
+			L.errorf("assertion failed!")
+		}
+		return args
+	})
+	register(g, "pcall", func(L *luaState, args []interface{}) (results []interface{}) {
+		fn := luaArg(L, args, 0, "pcall")
+		depth, line := L.depth, L.line
+		defer func() {
+			if r := recover(); r != nil {
+				e, ok := r.(*luaError)
+				if !ok || e.limit {
+					panic(r)
+				}
+				L.depth, L.line = depth, line
+				results = []interface{}{false, e.value}
+			}
+		}()
+		return append([]interface{}{true}, L.call(fn, args[1:])...)
+	})
+	unpack := func(L *luaState, args []interface{}) []interface{} {
+		t := luaCheckTable(L, args, 0, "unpack")
+		i, j := luaOptNumber(L, args, 1, "unpack", 1), luaOptNumber(L, args, 2, "unpack", float64(t.length()))
+		if j-i >= maxScriptDepth*100 {
+			L.errorf("too many results to unpack")
+		}
+		var results []interface{}
+		for k := i; k <= j; k++ {
+			results = append(results, t.get(k))
+		}
+		return results
+	}
+	register(g, "unpack", unpack)
+
+	str := newLuaTable()
+	g.set("string", str)
+	L.strings = str
+	register(str, "len", func(L *luaState, args []interface{}) []interface{} {
+		return []interface{}{float64(len(luaCheckString(L, args, 0, "len")))}
+	})
+	register(str, "sub", func(L *luaState, args []interface{}) []interface{} {
+		s := luaCheckString(L, args, 0, "sub")
+		i, j := luaStringRange(len(s), luaOptNumber(L, args, 1, "sub", 1), luaOptNumber(L, args, 2, "sub", -1))
+		if i > j {
+			return []interface{}{""}
+		}
+		return []interface{}{s[i-1 : j]}
+	})
+	register(str, "upper", func(L *luaState, args []interface{}) []interface{} {
+		return []interface{}{strings.ToUpper(luaCheckString(L, args, 0, "upper"))}
+	})
+	register(str, "lower", func(L *luaState, args []interface{}) []interface{} {
+		return []interface{}{strings.ToLower(luaCheckString(L, args, 0, "lower"))}
+	})
+	register(str, "rep", func(L *luaState, args []interface{}) []interface{} {
+		s := luaCheckString(L, args, 0, "rep")
+		n := int(luaCheckNumber(L, args, 1, "rep"))
+		sep := ""
+		if len(args) > 2 {
+			sep = luaCheckString(L, args, 2, "rep")
+		}
+		if n <= 0 {
+			return []interface{}{""}
+		}
+		if (len(s)+len(sep))*n > maxScriptString {
+			L.errorf("resulting string too large")
+		}
+		return []interface{}{strings.Repeat(s+sep, n-1) + s}
+	})
+	register(str, "reverse", func(L *luaState, args []interface{}) []interface{} {
+		s := []byte(luaCheckString(L, args, 0, "reverse"))
+		for i, j := 0, len(s)-1; i < j; i, j = i+1, j-1 {
+			s[i], s[j] = s[j], s[i]
+		}
+		return []interface{}{string(s)}
+	})
+	register(str, "byte", func(L *luaState, args []interface{}) []interface{} {
+		s := luaCheckString(L, args, 0, "byte")
+		first := luaOptNumber(L, args, 1, "byte", 1)
+		i, j := luaStringRange(len(s), first, luaOptNumber(L, args, 2, "byte", first))
+		var results []interface{}
+		for k := i; k <= j; k++ {
+			results = append(results, float64(s[k-1]))
+		}
+		return results
+	})
+	register(str, "char", func(L *luaState, args []interface{}) []interface{} {
+		b := make([]byte, len(args))
+		for i := range args {
+			c := luaCheckNumber(L, args, i, "char")
+			if c < 0 || c > 255 {
+				L.errorf("bad argument #%d to 'char' (value out of range)", i+1)
+			}
+			b[i] = byte(c)
+		}
+		return []interface{}{string(b)}
+	})
+	register(str, "format", luaFormat)
+	register(str, "find", func(L *luaState, args []interface{}) []interface{} {
+		return luaFind(L, args, true)
+	})
+	register(str, "match", func(L *luaState, args []interface{}) []interface{} {
+		return luaFind(L, args, false)
+	})
+	register(str, "gmatch", luaGmatch)
+	register(str, "gsub", luaGsub)
+
+	table := newLuaTable()
+	g.set("table", table)
+	register(table, "insert", func(L *luaState, args []interface{}) []interface{} {
+		t := luaCheckTable(L, args, 0, "insert")
+		n := float64(t.length())
+		switch len(args) {
+		case 2:
+			t.set(n+1, args[1])
+		case 3:
+			pos := luaCheckNumber(L, args, 1, "insert")
+			if pos < 1 || pos > n+1 {
+				L.errorf("bad argument #2 to 'insert' (position out of bounds)")
+			}
+			for i := n; i >= pos; i-- {
+				t.set(i+1, t.get(i))
+			}
+			t.set(pos, args[2])
+		default:
+			L.errorf("wrong number of arguments to 'insert'")
+		}
+		return nil
+	})
+	register(table, "remove", func(L *luaState, args []interface{}) []interface{} {
+		t := luaCheckTable(L, args, 0, "remove")
+		n := float64(t.length())
+		pos := luaOptNumber(L, args, 1, "remove", n)
+		if len(args) < 2 && n == 0 {
+			return []interface{}{nil}
+		}
+		if n+1 == pos {
+			v := t.get(pos)
+			t.set(pos, nil)
+			return []interface{}{v}
+		}
+		if pos < 1 || pos > n+1 {
+			L.errorf("bad argument #2 to 'remove' (position out of bounds)")
+		}
+		v := t.get(pos)
+		for i := pos; i < n; i++ {
+			t.set(i, t.get(i+1))
+		}
+		t.set(n, nil)
+		return []interface{}{v}
+	})
+	register(table, "concat", func(L *luaState, args []interface{}) []interface{} {
+		t := luaCheckTable(L, args, 0, "concat")
+		sep := ""
+		if len(args) > 1 && args[1] != nil {
+			sep = luaCheckString(L, args, 1, "concat")
+		}
+		i, j := luaOptNumber(L, args, 2, "concat", 1), luaOptNumber(L, args, 3, "concat", float64(t.length()))
+		var b strings.Builder
+		for k := i; k <= j; k++ {
+			s, ok := luaToStringCoerce(t.get(k))
+			if !ok {
+				L.errorf("invalid value (at index %s) in table for 'concat'", luaNumberString(k))
+			}
+			b.WriteString(s)
+			if k < j {
+				b.WriteString(sep)
+			}
+			if b.Len() > maxScriptString {
+				L.errorf("resulting string too large")
+			}
+		}
+		return []interface{}{b.String()}
+	})
+	register(table, "sort", func(L *luaState, args []interface{}) []interface{} {
+		t := luaCheckTable(L, args, 0, "sort")
+		var comp interface{}
+		if len(args) > 1 {
+			comp = args[1]
+		}
+		values := append([]interface{}{}, t.array...)
+		sort.Slice(values, func(i, j int) bool {
+			L.step()
+			if comp != nil {
+				results := L.call(comp, []interface{}{values[i], values[j]})
+				return len(results) > 0 && luaTruthy(results[0])
+			}
+			return L.less(values[i], values[j], false)
+		})
+		for i, v := range values {
+			t.set(float64(i+1), v)
+		}
+		return nil
+	})
+	register(table, "unpack", unpack)
+
+	m := newLuaTable()
+	g.set("math", m)
+	m.set("huge", math.Inf(1))
+	m.set("pi", math.Pi)
+	for name, fn := range map[string]func(float64) float64{"floor": math.Floor, "ceil": math.Ceil, "abs": math.Abs, "sqrt": math.Sqrt,
+		"exp": math.Exp, "log10": math.Log10} {
+		name, fn := name, fn
+		register(m, name, func(L *luaState, args []interface{}) []interface{} {
+			return []interface{}{fn(luaCheckNumber(L, args, 0, name))}
+		})
+	}
+	register(m, "log", func(L *luaState, args []interface{}) []interface{} {
+		x := luaCheckNumber(L, args, 0, "log")
+		if len(args) > 1 {
+			return []interface{}{math.Log(x) / math.Log(luaCheckNumber(L, args, 1, "log"))}
+		}
+		return []interface{}{math.Log(x)}
+	})
+	register(m, "fmod", func(L *luaState, args []interface{}) []interface{} {
+		return []interface{}{math.Mod(luaCheckNumber(L, args, 0, "fmod"), luaCheckNumber(L, args, 1, "fmod"))}
+	})
+	register(m, "max", func(L *luaState, args []interface{}) []interface{} {
+		n := luaCheckNumber(L, args, 0, "max")
+		for i := 1; i < len(args); i++ {
+			n = math.Max(n, luaCheckNumber(L, args, i, "max"))
+		}
+		return []interface{}{n}
+	})
+	register(m, "min", func(L *luaState, args []interface{}) []interface{} {
+		n := luaCheckNumber(L, args, 0, "min")
+		for i := 1; i < len(args); i++ {
+			n = math.Min(n, luaCheckNumber(L, args, i, "min"))
+		}
+		return []interface{}{n}
+	})
+
+	os := newLuaTable()
+	g.set("os", os)
+	register(os, "time", func(L *luaState, args []interface{}) []interface{} {
+		return []interface{}{float64(time.Now().Unix())}
+	})
+	return L
+}
+
+// argument checks
+
+func luaArg(L *luaState, args []interface{}, i int, fn string) interface{} {
+	if i >= len(args) {
+		L.errorf("bad argument #%d to '%s' (value expected)", i+1, fn)
+	}
+	return args[i]
+}
+
+func luaCheckTable(L *luaState, args []interface{}, i int, fn string) *luaTable {
+	var v interface{}
+	if i < len(args) {
+		v = args[i]
+	}
+	t, ok := v.(*luaTable)
+	if !ok {
+		L.errorf("bad argument #%d to '%s' (table expected, got %s)", i+1, fn, luaType(v))
+	}
+	return t
+}
+
+func luaCheckString(L *luaState, args []interface{}, i int, fn string) string {
+	var v interface{}
+	if i < len(args) {
+		v = args[i]
+	}
+	s, ok := luaToStringCoerce(v)
+	if !ok {
+		L.errorf("bad argument #%d to '%s' (string expected, got %s)", i+1, fn, luaType(v))
+	}
+	return s
+}
+
+func luaCheckNumber(L *luaState, args []interface{}, i int, fn string) float64 {
+	var v interface{}
+	if i < len(args) {
+		v = args[i]
+	}
+	n, ok := luaToNumber(v)
+	if !ok {
+		L.errorf("bad argument #%d to '%s' (number expected, got %s)", i+1, fn, luaType(v))
+	}
+	return n
+}
+
+func luaOptNumber(L *luaState, args []interface{}, i int, fn string, def float64) float64 {
+	if i >= len(args) || args[i] == nil {
+		return def
+	}
+	return math.Trunc(luaCheckNumber(L, args, i, fn))
+}
+
+// string positions from i to j counting from 1, negative ones from the end, clamped to the string
+func luaStringRange(length int, i, j float64) (int, int) {
+	if i < 0 {
+		i = math.Max(float64(length)+i+1, 1)
+	} else if i == 0 {
+		i = 1
+	}
+	if j < 0 {
+		j = float64(length) + j + 1
+	} else if j > float64(length) {
+		j = float64(length)
+	}
+	return int(i), int(j)
+}
+
+// string.format, with the %d %i %u %c %x %X %o %e %E %f %g %G %q %s and %% directives
+func luaFormat(L *luaState, args []interface{}) []interface{} {
+	format := luaCheckString(L, args, 0, "format")
+	var b strings.Builder
+	n := 1
+	for i := 0; i < len(format); i++ {
+		c := format[i]
+		if c != '%' {
+			b.WriteByte(c)
+			continue
+		}
+		i++
+		if i < len(format) && format[i] == '%' {
+			b.WriteByte('%')
+			continue
+		}
+		start := i
+		for i < len(format) && strings.IndexByte("-+ #0123456789.", format[i]) >= 0 {
+			i++
+		}
+		if i >= len(format) || i-start > 6 {
+			L.errorf("invalid format string to 'format'")
+		}
+		spec := "%" + format[start:i]
+		switch verb := format[i]; verb {
+		case 'd', 'i':
+			b.WriteString(fmt.Sprintf(spec+"d", int64(luaCheckNumber(L, args, n, "format"))))
+		case 'u':
+			b.WriteString(fmt.Sprintf(spec+"d", uint64(luaCheckNumber(L, args, n, "format"))))
+		case 'c':
+			b.WriteByte(byte(luaCheckNumber(L, args, n, "format")))
+		case 'x', 'X', 'o':
+			b.WriteString(fmt.Sprintf(spec+string(verb), int64(luaCheckNumber(L, args, n, "format"))))
+		case 'e', 'E', 'f', 'g', 'G':
+			b.WriteString(fmt.Sprintf(spec+string(verb), luaCheckNumber(L, args, n, "format")))
+		case 'q':
+			b.WriteString(strconv.Quote(luaCheckString(L, args, n, "format")))
+		case 's':
+			b.WriteString(fmt.Sprintf(spec+"s", luaToString(luaArg(L, args, n, "format"))))
+		default:
+			L.errorf("invalid option '%%%c' to 'format'", verb)
+		}
+		n++
+		if b.Len() > maxScriptString {
+			L.errorf("resulting string too large")
+		}
+	}
+	return []interface{}{b.String()}
+}
+
+// patterns, the way Lua matches them
+
+const (
+	luaCapUnfinished = -1
+	luaCapPosition   = -2
+)
+
+type luaMatcher struct {
+	L        *luaState
+	src, pat string
+	level    int
+	capture  [32]struct{ start, len int }
+	depth    int
+}
+
+func (m *luaMatcher) classEnd(p int) int {
+	c := m.pat[p]
+	p++
+	if c == '%' {
+		if p >= len(m.pat) {
+			m.L.errorf("malformed pattern (ends with '%%')")
+		}
+		return p + 1
+	}
+	if c == '[' {
+		if p < len(m.pat) && m.pat[p] == '^' {
+			p++
+		}
+		// the first character can be a ]
+		for {
+			if p >= len(m.pat) {
+				m.L.errorf("malformed pattern (missing ']')")
+			}
+			c := m.pat[p]
+			p++
+			if c == '%' && p < len(m.pat) {
+				p++
+			}
+			if p >= len(m.pat) {
+				m.L.errorf("malformed pattern (missing ']')")
+			}
+			if m.pat[p] == ']' {
+				return p + 1
+			}
+		}
+	}
+	return p
+}
+
+func luaMatchClass(c, class byte) bool {
+	var res bool
+	lower := class | 0x20
+	switch lower {
+	case 'a':
+		res = c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+	case 'c':
+		res = c < 32 || c == 127
+	case 'd':
+		res = c >= '0' && c <= '9'
+	case 'l':
+		res = c >= 'a' && c <= 'z'
+	case 'p':
+		res = c > 32 && c < 127 && !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9')
+	case 's':
+		res = c == ' ' || c >= '\t' && c <= '\r'
+	case 'u':
+		res = c >= 'A' && c <= 'Z'
+	case 'w':
+		res = c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+	case 'x':
+		res = isHexDigit(c)
+	case 'z':
+		res = c == 0
+	default:
+		return class == c
+	}
+	if class >= 'A' && class <= 'Z' {
+		return !res
+	}
+	return res
+}
+
+// whether c is in the set from the [ at p to the ] at end
+func (m *luaMatcher) matchBracketClass(c byte, p, end int) bool {
+	sig := true
+	if m.pat[p+1] == '^' {
+		sig = false
+		p++
+	}
+	for p++; p < end; p++ {
+		if m.pat[p] == '%' {
+			p++
+			if luaMatchClass(c, m.pat[p]) {
+				return sig
+			}
+		} else if m.pat[p+1] == '-' && p+2 < end {
+			if m.pat[p] <= c && c <= m.pat[p+2] {
+				return sig
+			}
+			p += 2
+		} else if m.pat[p] == c {
+			return sig
+		}
+	}
+	return !sig
+}
+
+func (m *luaMatcher) singleMatch(s, p, ep int) bool {
+	if s >= len(m.src) {
+		return false
+	}
+	c := m.src[s]
+	switch m.pat[p] {
+	case '.':
+		return true
+	case '%':
+		return luaMatchClass(c, m.pat[p+1])
+	case '[':
+		return m.matchBracketClass(c, p, ep-1)
+	}
+	return m.pat[p] == c
+}
+
+// where a match of the pattern from p at s ends, -1 if there is none
+func (m *luaMatcher) match(s, p int) int {
+	m.L.step()
+	m.depth++
+	defer func() { m.depth-- }()
+	if m.depth > maxScriptDepth {
+		m.L.errorf("pattern too complex")
+	}
+	for p < len(m.pat) {
+		switch m.pat[p] {
+		case '(':
+			if p+1 < len(m.pat) && m.pat[p+1] == ')' {
+				return m.startCapture(s, p+2, luaCapPosition)
+			}
+			return m.startCapture(s, p+1, luaCapUnfinished)
+		case ')':
+			return m.endCapture(s, p+1)
+		case '$':
+			if p+1 == len(m.pat) {
+				if s == len(m.src) {
+					return s
+				}
+				return -1
+			}
+		case '%':
+			if p+1 < len(m.pat) {
+				switch c := m.pat[p+1]; {
+				case c == 'b':
+					if s = m.matchBalance(s, p+2); s == -1 {
+						return -1
+					}
+					p += 4
+					continue
+				case c == 'f':
+					p += 2
+					if p >= len(m.pat) || m.pat[p] != '[' {
+						m.L.errorf("missing '[' after '%%f' in pattern")
+					}
+					ep := m.classEnd(p)
+					var prev, cur byte
+					if s > 0 {
+						prev = m.src[s-1]
+					}
+					if s < len(m.src) {
+						cur = m.src[s]
+					}
+					if m.matchBracketClass(prev, p, ep-1) || !m.matchBracketClass(cur, p, ep-1) {
+						return -1
+					}
+					p = ep
+					continue
+				case c >= '0' && c <= '9':
+					if s = m.matchCapture(s, c); s == -1 {
+						return -1
+					}
+					p += 2
+					continue
+				}
+			}
+		}
+		ep := m.classEnd(p)
+		matched := m.singleMatch(s, p, ep)
+		if ep < len(m.pat) {
+			switch m.pat[ep] {
+			case '?':
+				if matched {
+					if r := m.match(s+1, ep+1); r != -1 {
+						return r
+					}
+				}
+				p = ep + 1
+				continue
+			case '*':
+				return m.maxExpand(s, p, ep)
+			case '+':
+				if !matched {
+					return -1
+				}
+				return m.maxExpand(s+1, p, ep)
+			case '-':
+				return m.minExpand(s, p, ep)
+			}
+		}
+		if !matched {
+			return -1
+		}
+		s++
+		p = ep
+	}
+	return s
+}
+
+func (m *luaMatcher) maxExpand(s, p, ep int) int {
+	i := 0
+	for m.singleMatch(s+i, p, ep) {
+		i++
+	}
+	for ; i >= 0; i-- {
+		if r := m.match(s+i, ep+1); r != -1 {
+			return r
+		}
+	}
+	return -1
+}
+
+func (m *luaMatcher) minExpand(s, p, ep int) int {
+	for {
+		if r := m.match(s, ep+1); r != -1 {
+			return r
+		}
+		if !m.singleMatch(s, p, ep) {
+			return -1
+		}
+		s++
+	}
+}
+
+func (m *luaMatcher) startCapture(s, p, what int) int {
+	if m.level >= len(m.capture) {
+		m.L.errorf("too many captures")
+	}
+	m.capture[m.level].start, m.capture[m.level].len = s, what
+	m.level++
+	r := m.match(s, p)
+	if r == -1 {
+		m.level--
+	}
+	return r
+}
+
+func (m *luaMatcher) endCapture(s, p int) int {
+	l := -1
+	for i := m.level - 1; i >= 0; i-- {
+		if m.capture[i].len == luaCapUnfinished {
+			l = i
+			break
+		}
+	}
+	if l < 0 {
+		m.L.errorf("invalid pattern capture")
+	}
+	m.capture[l].len = s - m.capture[l].start
+	r := m.match(s, p)
+	if r == -1 {
+		m.capture[l].len = luaCapUnfinished
+	}
+	return r
+}
+
+func (m *luaMatcher) matchBalance(s, p int) int {
+	if p+1 >= len(m.pat) {
+		m.L.errorf("missing arguments to '%%b'")
+	}
+	if s >= len(m.src) || m.src[s] != m.pat[p] {
+		return -1
+	}
+	open, close := m.pat[p], m.pat[p+1]
+	count := 1
+	for i := s + 1; i < len(m.src); i++ {
+		if m.src[i] == close {
+			count--
+			if count == 0 {
+				return i + 1
+			}
+		} else if m.src[i] == open {
+			count++
+		}
+	}
+	return -1
+}
+
+func (m *luaMatcher) matchCapture(s int, c byte) int {
+	l := int(c - '1')
+	if l < 0 || l >= m.level || m.capture[l].len == luaCapUnfinished {
+		m.L.errorf("invalid capture index %%%c", c)
+	}
+	start, length := m.capture[l].start, m.capture[l].len
+	if len(m.src)-s >= length && m.src[start:start+length] == m.src[s:s+length] {
+		return s + length
+	}
+	return -1
+}
+
+func (m *luaMatcher) getCapture(i, s, e int) interface{} {
+	if i >= m.level {
+		if i != 0 {
+			m.L.errorf("invalid capture index %%%d", i+1)
+		}
+		return m.src[s:e]
+	}
+	switch l := m.capture[i].len; l {
+	case luaCapUnfinished:
+		m.L.errorf("unfinished capture")
+	case luaCapPosition:
+		return float64(m.capture[i].start + 1)
+	}
+	return m.src[m.capture[i].start : m.capture[i].start+m.capture[i].len]
+}
+
+// the captures of a match, the whole match if there are none and whole is set
+func (m *luaMatcher) captures(s, e int, whole bool) []interface{} {
+	n := m.level
+	if n == 0 && whole {
+		n = 1
+	}
+	results := make([]interface{}, n)
+	for i := range results {
+		results[i] = m.getCapture(i, s, e)
+	}
+	return results
+}
+
+// string.find and string.match
+func luaFind(L *luaState, args []interface{}, find bool) []interface{} {
+	name := "match"
+	if find {
+		name = "find"
+	}
+	s := luaCheckString(L, args, 0, name)
+	pat := luaCheckString(L, args, 1, name)
+	init := luaOptNumber(L, args, 2, name, 1)
+	if init < 0 {
+		init = math.Max(float64(len(s))+init+1, 1)
+	} else if init == 0 {
+		init = 1
+	}
+	if init > float64(len(s))+1 {
+		return []interface{}{nil}
+	}
+	start := int(init) - 1
+	plain := len(args) > 3 && luaTruthy(args[3])
+	if find && (plain || !strings.ContainsAny(pat, "^$*+?.([%-")) {
+		if i := strings.Index(s[start:], pat); i >= 0 {
+			return []interface{}{float64(start + i + 1), float64(start + i + len(pat))}
+		}
+		return []interface{}{nil}
+	}
+	m := &luaMatcher{L: L, src: s, pat: pat}
+	p, anchor := 0, strings.HasPrefix(pat, "^")
+	if anchor {
+		p = 1
+	}
+	for s1 := start; s1 <= len(s); s1++ {
+		m.level = 0
+		if e := m.match(s1, p); e != -1 {
+			if find {
+				return append([]interface{}{float64(s1 + 1), float64(e)}, m.captures(s1, e, false)...)
+			}
+			return m.captures(s1, e, true)
+		}
+		if anchor {
+			break
+		}
+	}
+	return []interface{}{nil}
+}
+
+func luaGmatch(L *luaState, args []interface{}) []interface{} {
+	s := luaCheckString(L, args, 0, "gmatch")
+	pat := luaCheckString(L, args, 1, "gmatch")
+	pos := 0
+	return []interface{}{&luaGoFunc{"gmatch", func(L *luaState, _ []interface{}) []interface{} {
+		m := &luaMatcher{L: L, src: s, pat: pat}
+		for start := pos; start <= len(s); start++ {
+			m.level = 0
+			if e := m.match(start, 0); e != -1 {
+				pos = e
+				if e == start {
+					pos++
+				}
+				return m.captures(start, e, true)
+			}
+		}
+		pos = len(s) + 1
+		return []interface{}{nil}
+	}}}
+}
+
+func luaGsub(L *luaState, args []interface{}) []interface{} {
+	s := luaCheckString(L, args, 0, "gsub")
+	pat := luaCheckString(L, args, 1, "gsub")
+	repl := luaArg(L, args, 2, "gsub")
+	switch repl.(type) {
+	case string, float64, *luaTable, *luaGoFunc, *luaClosure:
+	default:
+		L.errorf("bad argument #3 to 'gsub' (string/function/table expected)")
+	}
+	max := luaOptNumber(L, args, 3, "gsub", float64(len(s)+1))
+	m := &luaMatcher{L: L, src: s, pat: pat}
+	p, anchor := 0, strings.HasPrefix(pat, "^")
+	if anchor {
+		p = 1
+	}
+	var b strings.Builder
+	src, n := 0, 0.0
+	for n < max {
+		m.level = 0
+		e := m.match(src, p)
+		if e != -1 {
+			n++
+			m.addValue(&b, src, e, repl)
+		}
+		if e != -1 && e > src {
+			src = e
+		} else if src < len(s) {
+			b.WriteByte(s[src])
+			src++
+		} else {
+			break
+		}
+		if b.Len() > maxScriptString {
+			L.errorf("resulting string too large")
+		}
+		if anchor {
+			break
+		}
+	}
+	b.WriteString(s[src:])
+	return []interface{}{b.String(), n}
+}
+
+// write what replaces a match in gsub
+func (m *luaMatcher) addValue(b *strings.Builder, s, e int, repl interface{}) {
+	var v interface{}
+	switch r := repl.(type) {
+	case *luaTable:
+		v = r.get(m.getCapture(0, s, e))
+	case *luaGoFunc, *luaClosure:
+		if results := m.L.call(r, m.captures(s, e, true)); len(results) > 0 {
+			v = results[0]
+		}
+	default:
+		text, _ := luaToStringCoerce(r)
+		for i := 0; i < len(text); i++ {
+			c := text[i]
+			if c != '%' || i+1 >= len(text) {
+				b.WriteByte(c)
+				continue
+			}
+			i++
+			c = text[i]
+			switch {
+			case c == '0':
+				b.WriteString(m.src[s:e])
+			case c >= '1' && c <= '9':
+				capture, _ := luaToStringCoerce(m.getCapture(int(c-'1'), s, e))
+				b.WriteString(capture)
+			default:
+				b.WriteByte(c)
+			}
+		}
+		return
+	}
+	if !luaTruthy(v) {
+		b.WriteString(m.src[s:e])
+		return
+	}
+	text, ok := luaToStringCoerce(v)
+	if !ok {
+		m.L.errorf("invalid replacement value (a %s)", luaType(v))
+	}
+	b.WriteString(text)
+}
+
+// values to and from JSON, for the devices scripts get and give back
+
+// a value decoded from JSON as a Lua value, the keys of objects in order so pairs goes through them the same way
+func luaFromJSON(v interface{}) interface{} {
+	switch x := v.(type) {
+	case []interface{}:
+		t := newLuaTable()
+		for i, e := range x {
+			t.set(float64(i+1), luaFromJSON(e))
+		}
+		return t
+	case map[string]interface{}:
+		keys := make([]string, 0, len(x))
+		for k := range x {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		t := newLuaTable()
+		for _, k := range keys {
+			t.set(k, luaFromJSON(x[k]))
+		}
+		return t
+	}
+	return v
+}
+
+// a Lua value to encode as JSON, a table with only the keys 1 to n is an array and an empty one is null
+func luaToJSON(v interface{}, depth int) (interface{}, error) {
+	switch x := v.(type) {
+	case float64:
+		if math.IsNaN(x) || math.IsInf(x, 0) {
+			return nil, nil
+		}
+		return x, nil
+	case *luaTable:
+		if depth > maxScriptDepth {
+			return nil, fmt.Errorf("table is nested too deep or refers to itself")
+		}
+		if len(x.index) == 0 {
+			if len(x.array) == 0 {
+				return nil, nil
+			}
+			list := make([]interface{}, len(x.array))
+			for i, e := range x.array {
+				var err error
+				if list[i], err = luaToJSON(e, depth+1); err != nil {
+					return nil, err
+				}
+			}
+			return list, nil
+		}
+		object := make(map[string]interface{})
+		var key interface{}
+		for {
+			k, e, _ := x.next(key)
+			if k == nil {
+				return object, nil
+			}
+			key = k
+			name, ok := luaToStringCoerce(k)
+			if !ok {
+				continue
+			}
+			value, err := luaToJSON(e, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			object[name] = value
+		}
+	case *luaGoFunc, *luaClosure:
+		return nil, nil
+	}
+	return v, nil
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// parse a MAC address written with colons, dashes, dots or nothing between the digits
+// into the format netnet keeps it in, ie 00-11-22-AA-BB-CC
+func parseMAC(s string) (string, bool) {
+	// done by hand as it is called for every record of every parse
+	s = strings.TrimSpace(s)
+	var mac [17]byte
+	n := 0
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == ':' || c == '-' || c == '.':
+			continue
+		case c >= 'a' && c <= 'f':
+			c -= 'a' - 'A'
+		case c >= 'A' && c <= 'F', c >= '0' && c <= '9':
+		default:
+			return "", false
+		}
+		if n == 12 {
+			return "", false
+		}
+		pos := n + n/2
+		if n > 0 && n%2 == 0 {
+			mac[pos-1] = '-'
+		}
+		mac[pos] = c
+		n++
+	}
+	if n != 12 {
+		return "", false
+	}
+	return string(mac[:]), true
+}
+
+// make the MAC address the same format as the parsed data, anything that isn't a MAC address is only trimmed
+func normalizeMAC(mac string) string {
+	if m, ok := parseMAC(mac); ok {
+		return m
+	}
+	return strings.TrimSpace(mac)
+}
+
+// split a -mac-format like colon-lower into the separator and the case
+func parseMACFormat(format string) (separator string, lower bool, ok bool) {
+	parts := strings.SplitN(format, "-", 2)
+	switch parts[0] {
+	case "colon":
+		separator = ":"
+	case "dash":
+		separator = "-"
+	case "bare":
+		separator = ""
+	default:
+		return "", false, false
+	}
+	if len(parts) == 2 {
+		switch parts[1] {
+		case "lower":
+			lower = true
+		case "upper":
+		default:
+			return "", false, false
+		}
+	}
+	return separator, lower, true
+}
+
+// write out a MAC address kept by netnet in the -mac-format, anything that isn't a MAC address is left alone
+func formatMAC(mac string) string {
+	separator, lower, ok := parseMACFormat(*macFormat)
+	if !ok || len(mac) != 17 || mac[2] != '-' {
+		return mac
+	}
+	mac = strings.ReplaceAll(mac, "-", separator)
+	if lower {
+		mac = strings.ToLower(mac)
+	}
+	return mac
+}
+
+// set the BSSID of a client, anything that isn't a MAC address like "(not associated)" means
+// it isn't connected to an access point
+func (c *Client) setBSSID(bssid string) {
+	c.BSSID, c.Associated = parseMAC(bssid)
+}
+
+// access points are written out with their MAC addresses in the -mac-format
+func (ap AccessPoint) MarshalJSON() ([]byte, error) {
+	type accessPoint AccessPoint
+	a := accessPoint(ap)
+	a.MAC = formatMAC(a.MAC)
+	return json.Marshal(a)
+}
+
+// and read back in whatever format, ie from plugins
+func (ap *AccessPoint) UnmarshalJSON(data []byte) error {
+	type accessPoint AccessPoint
+	err := json.Unmarshal(data, (*accessPoint)(ap))
+	ap.MAC = normalizeMAC(ap.MAC)
+	return err
+}
+
+func (c Client) MarshalJSON() ([]byte, error) {
+	type client Client
+	a := client(c)
+	a.MAC = formatMAC(a.MAC)
+	a.BSSID = formatMAC(a.BSSID)
+	return json.Marshal(a)
+}
+
+func (c *Client) UnmarshalJSON(data []byte) error {
+	type client Client
+	err := json.Unmarshal(data, (*client)(c))
+	c.MAC = normalizeMAC(c.MAC)
+	c.setBSSID(c.BSSID)
+	return err
+}
+
+func (e Event) MarshalJSON() ([]byte, error) {
+	type event Event
+	a := event(e)
+	a.MAC = formatMAC(a.MAC)
+	return json.Marshal(a)
+}
+
+func (e *Event) UnmarshalJSON(data []byte) error {
+	type event Event
+	err := json.Unmarshal(data, (*event)(e))
+	e.MAC = normalizeMAC(e.MAC)
+	return err
+}
+
+func (a Alert) MarshalJSON() ([]byte, error) {
+	type alert Alert
+	b := alert(a)
+	b.MAC = formatMAC(b.MAC)
+	return json.Marshal(b)
+}
+
+func (a *Alert) UnmarshalJSON(data []byte) error {
+	type alert Alert
+	err := json.Unmarshal(data, (*alert)(a))
+	a.MAC = normalizeMAC(a.MAC)
+	return err
+}
+
+func (s Sighting) MarshalJSON() ([]byte, error) {
+	type sighting Sighting
+	a := sighting(s)
+	a.MAC = formatMAC(a.MAC)
+	return json.Marshal(a)
+}
+
+func (s *Sighting) UnmarshalJSON(data []byte) error {
+	type sighting Sighting
+	err := json.Unmarshal(data, (*sighting)(s))
+	s.MAC = normalizeMAC(s.MAC)
+	return err
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// an online lookup that found nothing is tried again after this, new OUIs take a while to show up
+const vendorRetry = 30 * 24 * time.Hour
+
+// OnlineVendor is the vendor of an OUI looked up online, empty if the service didn't know it
+type OnlineVendor struct {
+	Vendor string    `json:"vendor"`
+	Time   time.Time `json:"time"`
+}
+
+var vendorLookups = newLookupPool("vendor", 1, 30*time.Second, 1, vendorRetry, fetchVendor)
+
+func init() {
+	vendorLookups.saved = saveVendorCache
+}
+
+func loadVendorCache() {
+	vendors := make(map[string]OnlineVendor)
+	check(loadJSON("vendors.json", &vendors), "Cannot load vendor cache:")
+	cache := make(map[string]LookupResult, len(vendors))
+	for prefix, v := range vendors {
+		cache[prefix] = LookupResult{Value: v.Vendor, Time: v.Time}
+	}
+	vendorLookups.load(cache)
+}
+
+func saveVendorCache(cache map[string]LookupResult) {
+	vendors := make(map[string]OnlineVendor, len(cache))
+	for prefix, r := range cache {
+		vendors[prefix] = OnlineVendor{Vendor: r.Value, Time: r.Time}
+	}
+	check(saveJSON("vendors.json", vendors), "Cannot save vendor cache:")
+}
+
+// the vendor of a MAC address the databases don't know, from the online lookups so far,
+// a prefix that wasn't looked up yet is queued and shows up in a later parse
+func lookupOnlineVendor(mac string) string {
+	if config.Vendors.LookupURL == "" || *offline || len(mac) < 8 || isLocalMAC(mac) {
+		return ""
+	}
+	return vendorLookups.lookup(mac[:8])
+}
+
+// start looking up the queued prefixes, no faster than the lookup rate in the configuration
+func lookupVendors() {
+	settings := config.Vendors
+	if settings.LookupRate > 0 {
+		vendorLookups.rate = settings.LookupRate
+	}
+	if settings.LookupWorkers > 0 {
+		vendorLookups.workers = settings.LookupWorkers
+	}
+	if settings.LookupTimeout > 0 {
+		vendorLookups.timeout = time.Duration(settings.LookupTimeout) * time.Second
+	}
+	vendorLookups.start()
+}
+
+// ask the online service for the vendor of a prefix, macvendors style: the vendor as plain text, or 404 if it isn't known
+func fetchVendor(ctx context.Context, prefix string) (string, error) {
+	url := strings.Replace(config.Vendors.LookupURL, "{mac}", strings.Replace(prefix, "-", ":", -1), -1)
+	client, err := downloadClient(0)
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("User-Agent", "netnet/"+version)
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		body, err := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		if err != nil {
+			return "", err
+		}
+		return sanitizeName(strings.TrimSpace(string(body))), nil
+	case http.StatusNotFound:
+		return "", nil
+	}
+	return "", fmt.Errorf("lookup answered %s", resp.Status)
+}
@@ -2,11 +2,11 @@ package main
 
 import (
 	"bufio"
+	"bytes"
 	"encoding/csv"
 	"encoding/json"
 	"flag"
 	"fmt"
-	"html/template"
 	"io"
 	"io/ioutil"
 	"log"
@@ -15,17 +15,48 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 )
 
 var dir *string // directory where the public directory is in
 var port *int
 var csvFile *string
-var clientsFound []Client
-var apsFound []AccessPoint
+var capFile *string
+var csvProfile *string
+var inputFormat *string
+var pipeFile *string
+var liveInterface *string
+var collector *string
+var dataDir *string // directory where netnet keeps its own data
+var configFile *string
+var scriptsDir *string
+var tlsCert, tlsKey *string
+var acmeHost, acmeEmail, acmeDirectory, acmeHTTP *string
+var rateLimit *float64
+var rateBurst *int
+var authEnabled *bool
+var allowDeauth *bool
+var showVersion *bool
+var updateRepo, updateKey *string
+var updateUnsigned *bool
+var macFormat *string
+var gpsdAddr *string
+var offline *bool
+var strict *bool
+var maxDevices *int
+var listenAddr *string
+var sensorCA *string
+var centralURL, centralCA, sensorCert, sensorKey *string
+var forwardQueue *int
+var recordFile *string
+var dbFile *string
+var dbDays *int
 
 var ouidb map[string]string
 var ciddb map[string]string
+var mamdb map[string]string
+var nmapdb map[string]string
 
 func init() {
 	d, err := filepath.Abs(filepath.Dir(os.Args[0]))
@@ -34,14 +65,154 @@ func init() {
 	}
 	dir = flag.String("dir", d, "directory where the public directory is in")
 	port = flag.Int("p", 12121, "the port where the server starts")
+	listenAddr = flag.String("listen", "", "where the server listens instead of the -p port: host:port, unix:/path/to/socket, or systemd for the socket of a systemd socket unit")
 	csvFile = flag.String("f", "dump-01.csv", "airodump-ng csv file to parse")
-	ouidb = parseOui()
-	ciddb = parseCid()
-	flag.Parse()
+	csvProfile = flag.String("profile", "", "CSV profile in the configuration for the columns of the -f file, airodump-ng's if empty")
+	inputFormat = flag.String("format", "", "format of the -f file: csv, netxml for Kismet netxml or kismetdb for a Kismet .kismet log, by its extension if empty")
+	capFile = flag.String("cap", "", "airodump-ng pcap file to read beacons, probes and handshakes from, defaults to the -f file ending in .cap")
+	pipeFile = flag.String("pipe", "-", "file or named pipe the pipe collector reads pcap, pcapng or tshark -T ek JSON from, - for stdin")
+	collector = flag.String("collector", "airodump", "where the data comes from: airodump, hcxdumptool, pipe, live (Linux), netsh (Windows) or airport (macOS)")
+	liveInterface = flag.String("iface", "", "monitor mode interface the live collector captures 802.11 frames on, ie wlan0mon, the collector is live if it would be airodump")
+	dataDir = flag.String("data", filepath.Join(d, "data"), "directory where netnet keeps its own data")
+	configFile = flag.String("config", "", "JSON configuration file")
+	rateLimit = flag.Float64("rate", 0, "requests per second allowed for each client IP, 0 for no limit")
+	rateBurst = flag.Int("burst", 20, "number of requests a client IP can make in a burst above the rate")
+	authEnabled = flag.Bool("auth", false, "require an API key for every request")
+	tlsCert = flag.String("tls-cert", "", "TLS certificate file, serves HTTPS together with -tls-key")
+	tlsKey = flag.String("tls-key", "", "TLS private key file")
+	sensorCA = flag.String("sensor-ca", "", "CA certificate that sensors' certificates must be signed by to send data to /ingest, make one with netnet ca init")
+	centralURL = flag.String("central", "", "URL of the central server this sensor sends what it finds to, ie https://netnet.example.com:12121")
+	centralCA = flag.String("central-ca", "", "CA certificate to check the central server's certificate with, instead of the system CAs")
+	sensorCert = flag.String("sensor-cert", "", "certificate this sensor sends data to the central server with, from netnet ca sensor")
+	sensorKey = flag.String("sensor-key", "", "private key of the sensor certificate")
+	forwardQueue = flag.Int("forward-queue", 100, "most MB of data kept on disk while the central server can't be reached, the oldest is dropped first")
+	acmeHost = flag.String("acme-host", "", "hostname to get a Let's Encrypt certificate for, serves HTTPS")
+	acmeEmail = flag.String("acme-email", "", "contact email for the Let's Encrypt account")
+	acmeDirectory = flag.String("acme-directory", "https://acme-v02.api.letsencrypt.org/directory", "ACME directory URL")
+	acmeHTTP = flag.String("acme-http", ":80", "address for answering ACME http-01 challenges")
+	allowDeauth = flag.Bool("allow-deauth", false, "allow sending deauthentication frames from /admin/deauth, only for authorized testing")
+	showVersion = flag.Bool("version", false, "show the version and exit")
+	updateRepo = flag.String("update-repo", "sausheong/netnet", "GitHub repository to update netnet from")
+	updateKey = flag.String("update-key", "", "base64 Ed25519 public key that releases must be signed with")
+	updateUnsigned = flag.Bool("update-unsigned", false, "update to releases without checking their signature, without -update-key")
+	scriptsDir = flag.String("scripts", filepath.Join(d, "scripts"), "directory of Lua scripts (*.lua) that change the devices and raise alerts on every parse, loaded again when they change")
+	strict = flag.Bool("strict", false, "stop ingesting at the first malformed record instead of skipping it, POST /admin/resume after fixing the data")
+	offline = flag.Bool("offline", false, "never go on the internet, the map and vendor databases only use what netnet bundle downloaded")
+	gpsdAddr = flag.String("gpsd", "", "address of gpsd to record the sensor's track from, ie localhost:2947")
+	maxDevices = flag.Int("max-devices", 0, "most access points and clients kept in memory, the ones seen least recently are moved to disk, 0 for no limit")
+	recordFile = flag.String("record", "", "append every API request to this JSON lines file, to replay with netnet replay")
+	dbFile = flag.String("db", "", "keep every observation of the access points and clients in this SQLite database in the data directory, to query them across restarts, ie scans.db, with the sqlite3 command")
+	dbDays = flag.Int("db-days", 30, "days observations are kept in the -db database, 0 keeps them all")
+	macFormat = flag.String("mac-format", "dash", "how MAC addresses are written in the API: colon, dash or bare, with -lower for lowercase ie colon-lower")
+}
+
+// every flag can also be set with an environment variable, ie -acme-host with NETNET_ACME_HOST,
+// the command line takes precedence
+func setFlagsFromEnv() {
+	flag.VisitAll(func(f *flag.Flag) {
+		name := "NETNET_" + strings.ToUpper(strings.ReplaceAll(f.Name, "-", "_"))
+		if value, ok := os.LookupEnv(name); ok {
+			check(f.Value.Set(value), "Cannot set "+name+":")
+		}
+	})
+}
+
+// how often the airodump-ng CSV file is parsed, unless the central server says otherwise
+const defaultRefreshInterval = 10 * time.Second
+
+var refreshSetting atomic.Int64
+
+func refreshInterval() time.Duration {
+	if d := time.Duration(refreshSetting.Load()); d > 0 {
+		return d
+	}
+	return defaultRefreshInterval
 }
 
 func main() {
+	// parsed here rather than in init so go test can pass its own flags
+	setFlagsFromEnv()
+	flag.Parse()
+	if *showVersion {
+		fmt.Println(buildInfo())
+		return
+	}
+	switch flag.Arg(0) {
+	case "install-service":
+		installService(flag.Args()[1:])
+		return
+	case "update":
+		selfUpdate()
+		return
+	case "diag":
+		diag(flag.Args()[1:])
+		return
+	case "bundle":
+		bundle(flag.Args()[1:])
+		return
+	case "bench":
+		bench(flag.Args()[1:])
+		return
+	case "ca":
+		certificateAuthority(flag.Args()[1:])
+		return
+	case "fake":
+		fake(flag.Args()[1:])
+		return
+	case "parse":
+		parseFiles(flag.Args()[1:])
+		return
+	case "replay":
+		replay(flag.Args()[1:])
+		return
+	}
+	captureOutput()
+	if _, _, ok := parseMACFormat(*macFormat); !ok {
+		log.Fatal("Unknown MAC format: ", *macFormat)
+	}
+	loadConfig(*configFile)
+	ouidb = parseOui()
+	ciddb = parseCid()
+	mamdb = parseMam()
+	nmapdb = parseNmapPrefixes()
+	registerPlugins()
+	registerSources()
+	checkPipeline()
+	checkProfiles()
+	setupForwarding()
+	if err := openObservations(); err != nil {
+		log.Fatal("Cannot open the -db database: ", err)
+	}
+	loadState()
+	if *collector == "hcxdumptool" && config.Hcxdumptool.Interface != "" {
+		go runHcxdumptool()
+	}
+	if *collector == "pipe" {
+		go readPipe()
+	}
+	if *liveInterface != "" && *collector == "airodump" {
+		*collector = "live"
+	}
+	if *collector == "live" {
+		startLive()
+	}
+	if *collector == "airodump" {
+		startCaptures()
+	}
+	if *gpsdAddr != "" {
+		go runGpsd()
+	}
+	if config.Vendors.LookupURL != "" && !*offline {
+		go lookupVendors()
+	}
 	go getData()
+	go sdWatchdog()
+	go watchSensor()
+	go watchDatabases()
+	if config.NetBox.URL != "" {
+		go runNetBoxSync()
+	}
+	startRADIUS()
 	serve()
 }
 
@@ -55,19 +226,43 @@ type AccessPoint struct {
 	Privacy        string    `json:"privacy"`
 	Authentication string    `json:"authentication"`
 	Power          int       `json:"power"`
+	Beacons        int       `json:"beacons"`
+	DataPackets    int       `json:"data_packets"` // airodump-ng's # IV column, data frames seen
 	Name           string    `json:"name"`
+	NameHex        string    `json:"name_hex,omitempty"` // raw bytes of the name, if it had to be sanitized
+	WPS            bool      `json:"wps,omitempty"`      // airodump-ng doesn't write it to the CSV file, enrichers can fill it in
+	Source         string    `json:"source"`             // sensor the access point was observed by
+
+	DataRate float64   `json:"data_per_minute"` // data packets a minute over the last parse
+	LastData time.Time `json:"last_data"`       // when it was last seen sending data
+	Active   bool      `json:"active"`          // sent data in the last 5 minutes
+
+	SecurityScore int    `json:"security_score"` // 0 to 100, how hard it is to break into
+	SecurityGrade string `json:"security_grade"` // A to F
+
+	DeviceMeta
 }
 
 // Client represents the clients found
 type Client struct {
-	MAC          string    `json:"mac"`
-	FirstSeen    time.Time `json:"first_seen"`
-	LastSeen     time.Time `json:"last_seen"`
-	Power        int       `json:"power"`
-	Packets      int       `json:"packets"`
-	BSSID        string    `json:"bssid"`
-	Probes       string    `json:"probes"`
-	Organization string    `json:"organization"`
+	MAC          string             `json:"mac"`
+	FirstSeen    time.Time          `json:"first_seen"`
+	LastSeen     time.Time          `json:"last_seen"`
+	Power        int                `json:"power"`
+	Packets      int                `json:"packets"`
+	BSSID        string             `json:"bssid"` // empty if not associated
+	Associated   bool               `json:"associated"`
+	Probes       string             `json:"probes"`
+	Organization string             `json:"organization"`
+	User         string             `json:"user,omitempty"`    // who uses it, shown as the people configuration allows
+	Session      *AccountingSession `json:"session,omitempty"` // its RADIUS accounting session on an enterprise network
+	Source       string             `json:"source"`            // sensor the client was observed by
+
+	PacketDelta       int     `json:"packet_delta"`                // packets since the last parse
+	PacketRate        float64 `json:"packets_per_minute"`          // over the last parse
+	PacketRateAverage float64 `json:"packets_per_minute_smoothed"` // exponentially smoothed
+
+	DeviceMeta
 }
 
 func filterByLastSeen(clients []Client, mins int) (results []Client) {
@@ -80,32 +275,247 @@ func filterByLastSeen(clients []Client, mins int) (results []Client) {
 	return
 }
 
+func filterByOrganization(clients []Client, org string) (results []Client) {
+	for _, client := range clients {
+		if client.Organization == org || (org == "UNKNOWN" && client.Organization == "") {
+			results = append(results, client)
+		}
+	}
+	return
+}
+
+func filterClientsBySource(clients []Client, source string) (results []Client) {
+	for _, client := range clients {
+		if client.Source == source {
+			results = append(results, client)
+		}
+	}
+	return
+}
+
+func filterAPsBySource(aps []AccessPoint, source string) (results []AccessPoint) {
+	for _, ap := range aps {
+		if ap.Source == source {
+			results = append(results, ap)
+		}
+	}
+	return
+}
+
+// filter access points by ownership, unknown matches the ones not marked
+func filterAPsByOwnership(aps []AccessPoint, ownerships []string) (results []AccessPoint) {
+	for _, ap := range aps {
+		ownership := ap.Ownership
+		if ownership == "" {
+			ownership = OwnershipUnknown
+		}
+		if containsString(ownerships, ownership) {
+			results = append(results, ap)
+		}
+	}
+	return
+}
+
+func filterAPsByActivity(aps []AccessPoint, active bool) (results []AccessPoint) {
+	for _, ap := range aps {
+		if ap.Active == active {
+			results = append(results, ap)
+		}
+	}
+	return
+}
+
+// read what is kept in the data directory, at start and after a restore
+func loadState() {
+	loadCredentials()
+	loadZones()
+	loadDeviceMeta()
+	loadEventLog()
+	loadAlerts()
+	loadCoverage()
+	loadSSIDHistory()
+	loadMyClients()
+	loadInventory()
+	loadPeople()
+	loadProbeHistory()
+	loadPresenceHours()
+	loadBeacons()
+	loadFingerprints()
+	loadHandshakes()
+	loadGPS()
+	loadVendorCache()
+	loadSpilled()
+	loadObservations()
+	loadAPIKeys()
+	loadUsers()
+}
+
 func getData() {
+	first, forced := true, false
 	for {
-		apsFound, clientsFound = parseAirodumpCsv(*csvFile)
-		time.Sleep(10 * time.Second)
+		if (!paused.Load() || forced) && halted() == "" {
+			ingest(first)
+			first = false
+			markParsed()
+		}
+		if halted() != "" {
+			recordCoverage("halted")
+		} else if paused.Load() {
+			recordCoverage("paused")
+		} else {
+			recordCoverage(sensorProblem())
+		}
+		forced = waitForRefresh()
 	}
 }
 
 func serve() {
 	mux := http.NewServeMux()
-	mux.Handle("/public/", http.StripPrefix("/public/", http.FileServer(http.Dir(*dir+"/public"))))
+	mux.Handle("/public/", http.StripPrefix("/public/", http.FileServer(publicFS())))
 	mux.HandleFunc("/", index)
 	mux.HandleFunc("/clients", clients)
+	mux.HandleFunc("/clients/", clientRoutes)
 	mux.HandleFunc("/aps", accessPoints)
-	server := &http.Server{
-		Addr:    "0.0.0.0:" + strconv.Itoa(*port),
-		Handler: mux,
+	mux.HandleFunc("/observations", observationsHandler)
+	mux.HandleFunc("/unifi", unifiHandler)
+	mux.HandleFunc("/aps/", apRoutes)
+	mux.HandleFunc("/device/", device)
+	mux.HandleFunc("/occupancy", occupancy)
+	mux.HandleFunc("/heatmap", heatmap)
+	mux.HandleFunc("/stats/vendors", statsVendors)
+	mux.HandleFunc("/stats/security", statsSecurity)
+	mux.HandleFunc("/stats/power", statsPower)
+	mux.HandleFunc("/stats/countries", statsCountries)
+	mux.HandleFunc("/stats/ap-vendors", statsAPVendors)
+	mux.HandleFunc("/stats/activity", statsActivity)
+	mux.HandleFunc("/registry/", registryHandler)
+	mux.HandleFunc("/floorplan", floorplan)
+	mux.HandleFunc("/zones", zoneRoutes)
+	mux.HandleFunc("/zones/", zoneRoutes)
+	mux.HandleFunc("/metrics", metricsHandler)
+	mux.HandleFunc("/events", eventsHandler)
+	mux.HandleFunc("/events/ws", eventsWebSocket)
+	mux.HandleFunc("/mynetwork", myNetworkHandler)
+	mux.HandleFunc("/guests", guestsHandler)
+	mux.HandleFunc("/reconciliation", reconciliationHandler)
+	mux.HandleFunc("/people", peopleHandler)
+	mux.HandleFunc("/mynetwork/ws", myNetworkWebSocket)
+	mux.HandleFunc("/ws", devicesWebSocket)
+	mux.HandleFunc("/rogues", roguesHandler)
+	mux.HandleFunc("/risk", riskHandler)
+	mux.HandleFunc("/fingerprints", fingerprintsHandler)
+	mux.HandleFunc("/fingerprints/", fingerprintsHandler)
+	mux.HandleFunc("/capture/interfaces", captureInterfaces)
+	mux.HandleFunc("/gps", gpsRoutes)
+	mux.HandleFunc("/gps/", gpsRoutes)
+	mux.HandleFunc("/map", mapPage)
+	mux.HandleFunc("/map/data", mapData)
+	mux.HandleFunc("/map/tiles/", mapTiles)
+	mux.HandleFunc("/map/leaflet/", mapLeaflet)
+	mux.HandleFunc("/survey", surveyHandler)
+	mux.HandleFunc("/ssids", ssidsHandler)
+	mux.HandleFunc("/ssids/", ssidRoutes)
+	mux.HandleFunc("/alerts", alertRoutes)
+	mux.HandleFunc("/alerts/", alertRoutes)
+	mux.HandleFunc("/admin/keys", adminKeys)
+	mux.HandleFunc("/admin/keys/", adminKeys)
+	mux.HandleFunc("/admin/users", adminUsers)
+	mux.HandleFunc("/admin/users/", adminUsers)
+	mux.HandleFunc("/status", status)
+	mux.HandleFunc("/sensors", sensorsHandler)
+	mux.HandleFunc("/pipeline", pipelineHandler)
+	mux.HandleFunc("/ingest", ingestHandler)
+	mux.HandleFunc("/fleet", fleetHandler)
+	mux.HandleFunc("/coverage", coverageHandler)
+	mux.HandleFunc("/admin/refresh", adminRefresh)
+	mux.HandleFunc("/admin/pause", adminPause)
+	mux.HandleFunc("/admin/resume", adminResume)
+	mux.HandleFunc("/admin/backup", adminBackup)
+	mux.HandleFunc("/admin/restore", adminRestore)
+	mux.HandleFunc("/admin/dead-letters", adminDeadLetters)
+	mux.HandleFunc("/admin/diag", adminDiag)
+	mux.HandleFunc("/admin/handshakes", handshakesHandler)
+	mux.HandleFunc("/admin/handshakes/", handshakesHandler)
+	mux.HandleFunc("/admin/deauth", adminDeauth)
+	mux.HandleFunc("/admin/audit", adminAudit)
+	mux.HandleFunc("/admin/inventory", adminInventory)
+	mux.HandleFunc("/admin/netbox", adminNetBox)
+	mux.HandleFunc("/admin/people", adminPeople)
+	mux.HandleFunc("/admin/people/", adminPeople)
+	mux.HandleFunc("/admin/radius", adminRADIUS)
+	mux.HandleFunc("/login", login)
+	mux.HandleFunc("/healthz", healthz)
+	mux.HandleFunc("/version", versionInfo)
+	mux.HandleFunc("/logout", logout)
+	var handler http.Handler = mux
+	if *authEnabled {
+		handler = authenticate(handler)
+	}
+	if *rateLimit > 0 {
+		handler = newRateLimiter(*rateLimit, *rateBurst).handler(handler)
+	}
+	if *recordFile != "" {
+		recorder, err := newRequestRecorder(*recordFile)
+		check(err, "Cannot open file to record requests in:")
+		if err == nil {
+			handler = recorder.handler(handler)
+		}
+	}
+	server := newServer("0.0.0.0:"+strconv.Itoa(*port), handler)
+	listener, err := listen(server)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println("Started", buildInfo(), "at", listener.Addr())
+	server.TLSConfig = serverTLSConfig()
+	if *sensorCA != "" && *acmeHost == "" && *tlsCert == "" {
+		fmt.Println("Sensor certificates need HTTPS, -sensor-ca is ignored without -tls-cert or -acme-host")
+	}
+	switch {
+	case *acmeHost != "":
+		manager := newACMEManager(*acmeHost, *acmeEmail, *acmeDirectory)
+		go func() {
+			log.Fatal(http.ListenAndServe(*acmeHTTP, manager.HTTPHandler()))
+		}()
+		err := manager.start()
+		if err != nil {
+			log.Fatal("Cannot get certificate: ", err)
+		}
+		server.TLSConfig.GetCertificate = manager.GetCertificate
+		sdNotify("READY=1")
+		log.Fatal(server.ServeTLS(listener, "", ""))
+	case *tlsCert != "":
+		sdNotify("READY=1")
+		log.Fatal(server.ServeTLS(listener, *tlsCert, *tlsKey))
+	default:
+		sdNotify("READY=1")
+		log.Fatal(server.Serve(listener))
 	}
-	fmt.Println("Started netnet server at", server.Addr)
-	go getData()
-	server.ListenAndServe()
+}
+
+// health check for container orchestration, unhealthy when the data stops being parsed, a halt by -strict is
+// only reported as restarting netnet doesn't fix the data
+func healthz(w http.ResponseWriter, r *http.Request) {
+	if !parsingHealthy() {
+		http.Error(w, "not parsing", http.StatusServiceUnavailable)
+		return
+	}
+	if reason := halted(); reason != "" {
+		w.Write([]byte("halted: " + reason))
+		return
+	}
+	w.Write([]byte("ok"))
+}
+
+// path of a file in the public directory
+func publicFile(name string) string {
+	return filepath.Join(*dir, "public", name)
 }
 
 // index for web server
 func index(w http.ResponseWriter, r *http.Request) {
-	t, _ := template.ParseFiles(*dir + "/public/index.html")
-	t.Execute(w, nil)
+	t, _ := parseTemplate("index.html")
+	t.Execute(w, requestSession(r))
 }
 
 // index for web server
@@ -116,13 +526,30 @@ func clients(w http.ResponseWriter, r *http.Request) {
 	}
 	last, err := strconv.Atoi(lastParam)
 	if err != nil {
-		t, _ := template.ParseFiles(*dir + "/public/error.html")
+		t, _ := parseTemplate("error.html")
 		t.Execute(w, err)
 	}
-	filteredClients := filterByLastSeen(clientsFound, last)
+	at, ok := parseAt(w, r)
+	if !ok {
+		return
+	}
+	filteredClients := filterByLastSeen(store.Clients(), last)
+	if !at.IsZero() {
+		_, filteredClients, err = observedAt(at, time.Duration(last)*time.Minute)
+		if err != nil {
+			http.Error(w, "Cannot read observations: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	if org, ok := r.URL.Query()["organization"]; ok {
+		filteredClients = filterByOrganization(filteredClients, org[0])
+	}
+	if source := r.URL.Query().Get("source"); source != "" {
+		filteredClients = filterClientsBySource(filteredClients, source)
+	}
 	str, err := json.MarshalIndent(filteredClients, "", "  ")
 	if err != nil {
-		t, _ := template.ParseFiles(*dir + "/public/error.html")
+		t, _ := parseTemplate("error.html")
 		t.Execute(w, err)
 	}
 	w.Header().Set("Content-Type", "application/json")
@@ -130,7 +557,29 @@ func clients(w http.ResponseWriter, r *http.Request) {
 }
 
 func accessPoints(w http.ResponseWriter, r *http.Request) {
-	str, err := json.MarshalIndent(apsFound, "", "  ")
+	at, ok := parseAt(w, r)
+	if !ok {
+		return
+	}
+	aps := store.APs()
+	if !at.IsZero() {
+		var err error
+		aps, _, err = observedAt(at, time.Hour)
+		if err != nil {
+			http.Error(w, "Cannot read observations: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	if source := r.URL.Query().Get("source"); source != "" {
+		aps = filterAPsBySource(aps, source)
+	}
+	if ownership := r.URL.Query().Get("ownership"); ownership != "" {
+		aps = filterAPsByOwnership(aps, strings.Split(ownership, ","))
+	}
+	if activity := r.URL.Query().Get("activity"); activity != "" {
+		aps = filterAPsByActivity(aps, activity == "active")
+	}
+	str, err := json.MarshalIndent(aps, "", "  ")
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -138,6 +587,22 @@ func accessPoints(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte(str))
 }
 
+// write out data as indented JSON like the rest of the API
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	writeJSONStatus(w, http.StatusOK, v)
+}
+
+func writeJSONStatus(w http.ResponseWriter, status int, v interface{}) {
+	str, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(str)
+}
+
 // https://en.wikipedia.org/wiki/MAC_address
 // Addresses can either be universally administered addresses (UAA) or locally administered addresses (LAA).
 // A universally administered address is uniquely assigned to a device by its manufacturer.
@@ -160,74 +625,165 @@ func isLocalMAC(MAC string) bool {
 	return false
 }
 
-// parsing the csv dump from airodump-ng
-func parseAirodumpCsv(file string) (accessPoints []AccessPoint, clients []Client) {
+// the headers of the two sections of an airodump-ng CSV file
+const (
+	apHeader     = "BSSID, First time seen, Last time seen"
+	clientHeader = "Station MAC, First time seen, Last time seen, Power, # packets, BSSID, Probed ESSIDs"
+)
+
+// parsing the csv dump from airodump-ng, problem says what is wrong with the file if it isn't one
+func parseAirodumpCsv(file string, layout csvLayout) (accessPoints []AccessPoint, clients []Client, problem string) {
 	content, err := ioutil.ReadFile(file)
 	if err != nil {
 		fmt.Println("File not found:", err)
+		return nil, nil, "file missing"
+	}
+	if problem = csvFormatProblem(content, layout); problem != "" {
+		fmt.Println("Cannot parse", file+":", problem)
 		return
 	}
-	s := string(content)
-	csvdata := strings.Split(s, "Station MAC, First time seen, Last time seen, Power, # packets, BSSID, Probed ESSIDs")
-	accessPoints = getAPData(csvdata[0])
-	clients = getClientsData(csvdata[1])
+	// a capture that just started may not have written the clients yet
+	apData, clientData := content, []byte(nil)
+	if i := bytes.Index(content, []byte(layout.clientHeader)); i >= 0 {
+		apData, clientData = skipLine(content[:i], layout.apHeader), skipLine(content[i:], layout.clientHeader)
+	} else {
+		apData = skipLine(apData, layout.apHeader)
+	}
+	aps, badAPs := getAPData(apData, layout)
+	clientList, badClients := getClientsData(clientData, layout)
+	if bad := append(badAPs, badClients...); *strict && len(bad) > 0 {
+		problem = bad.problem()
+		haltIngestion(file + ": " + problem)
+		return nil, nil, problem
+	}
+	// airodump-ng sometimes writes the same MAC twice
+	accessPoints = mergeAPs(aps)
+	clients = mergeClients(clientList)
 	return
 }
 
-func getAPData(data string) (aps []AccessPoint) {
-	timeParseLayout := "2006-01-02 15:04:05"
-	local := time.Now().Local().Location()
-	r := csv.NewReader(strings.NewReader(data))
+// the data after the line starting with the header, all of it if there is no header
+func skipLine(data []byte, header string) []byte {
+	i := bytes.Index(data, []byte(header))
+	if i < 0 {
+		return data
+	}
+	if n := bytes.IndexByte(data[i:], '\n'); n >= 0 {
+		return data[i+n+1:]
+	}
+	return nil
+}
+
+// check that the CSV file is one airodump-ng writes, Kismet CSV files are separated by semicolons
+// and start with a Network column
+func csvFormatProblem(content []byte, layout csvLayout) string {
+	s := bytes.TrimSpace(content)
+	switch {
+	case len(s) == 0:
+		return "file empty"
+	case bytes.HasPrefix(s, []byte(layout.apHeader)) || bytes.HasPrefix(s, []byte(layout.clientHeader)):
+		return ""
+	case bytes.HasPrefix(s, []byte("Network;")):
+		return "Kismet CSV file, not airodump-ng"
+	case bytes.HasPrefix(s, []byte("<?xml")):
+		return "XML file, use -format netxml for a Kismet netxml file"
+	case bytes.HasPrefix(s, []byte("SQLite format 3")):
+		return "SQLite file, use -format kismetdb for a Kismet log"
+	}
+	return "not an airodump-ng CSV file"
+}
+
+// the time format of airodump-ng CSV files, in local time
+const airodumpTimeLayout = "2006-01-02 15:04:05"
+
+// parse a time in an airodump-ng CSV file, done by hand as there are two in every record
+func parseAirodumpTime(s string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	if len(s) != len(airodumpTimeLayout) || s[4] != '-' || s[7] != '-' || s[10] != ' ' || s[13] != ':' || s[16] != ':' {
+		return time.ParseInLocation(airodumpTimeLayout, s, time.Local)
+	}
+	var n [6]int
+	for i, pos := range [6][2]int{{0, 4}, {5, 7}, {8, 10}, {11, 13}, {14, 16}, {17, 19}} {
+		for _, c := range s[pos[0]:pos[1]] {
+			if c < '0' || c > '9' {
+				return time.ParseInLocation(airodumpTimeLayout, s, time.Local)
+			}
+			n[i] = n[i]*10 + int(c-'0')
+		}
+	}
+	t := time.Date(n[0], time.Month(n[1]), n[2], n[3], n[4], n[5], 0, time.Local)
+	// out of range values like February 30 roll over, leave the error to the standard parser
+	if int(t.Month()) != n[1] || t.Day() != n[2] || n[3] > 23 || n[4] > 59 || n[5] > 59 {
+		return time.ParseInLocation(airodumpTimeLayout, s, time.Local)
+	}
+	return t, nil
+}
+
+func getAPData(data []byte, layout csvLayout) (aps []AccessPoint, bad malformed) {
+	col := layout.aps
+	mac, first, last, channelCol, speed, privacy, auth, powerCol, beaconsCol, dataCol, essid := col["bssid"], col["first_seen"],
+		col["last_seen"], col["channel"], col["speed"], col["privacy"], col["authentication"], col["power"], col["beacons"],
+		col["data"], col["essid"]
+	r := csv.NewReader(bytes.NewReader(data))
 	// set to dynamic number of columns
 	r.FieldsPerRecord = -1
 	r.TrimLeadingSpace = true
-	i := 0
+	r.ReuseRecord = true
 	for {
 		// Read each record from csv
 		record, err := r.Read()
 		if err == io.EOF {
 			break
 		}
-		check(err, "Cannot parse airodump-ng CSV file (access points):")
-		if i != 0 {
-			if len(record) < 14 {
-				fmt.Println("Not enough columns for access points:", record)
+		bad.check(err, "Cannot parse airodump-ng CSV file (access points):")
+		if len(record) > 0 && record[0] != "BSSID" {
+			if len(record) < layout.apColumns {
+				bad.add("Not enough columns for access points:", record)
 				continue
 			}
-			firstSeen, err := time.ParseInLocation(timeParseLayout, strings.TrimSpace(record[1]), local)
-			check(err, "Cannot parse first seen date:")
-			lastSeen, err := time.ParseInLocation(timeParseLayout, strings.TrimSpace(record[2]), local)
-			check(err, "Cannot parse last seen date:")
-			channel, err := strconv.Atoi(strings.TrimSpace(record[3]))
-			check(err, "Cannot parse channel value:")
-			power, err := strconv.Atoi(strings.TrimSpace(record[8]))
-			check(err, "Cannot parse power value:")
+			firstSeen, err := parseAirodumpTime(record[first])
+			bad.check(err, "Cannot parse first seen date:")
+			lastSeen, err := parseAirodumpTime(record[last])
+			bad.check(err, "Cannot parse last seen date:")
+			channel, err := strconv.Atoi(strings.TrimSpace(record[channelCol]))
+			bad.check(err, "Cannot parse channel value:")
+			power, err := strconv.Atoi(strings.TrimSpace(record[powerCol]))
+			bad.check(err, "Cannot parse power value:")
+			beacons, err := strconv.Atoi(strings.TrimSpace(record[beaconsCol]))
+			bad.check(err, "Cannot parse beacons value:")
+			dataPackets, err := strconv.Atoi(strings.TrimSpace(record[dataCol]))
+			bad.check(err, "Cannot parse data packets value:")
 
 			ap := AccessPoint{
-				MAC:            strings.ReplaceAll(record[0], ":", "-"),
+				MAC:            normalizeMAC(record[mac]),
 				FirstSeen:      firstSeen,
 				LastSeen:       lastSeen,
 				Channel:        channel,
-				Speed:          record[4],
-				Privacy:        record[5],
-				Authentication: record[7],
+				Speed:          record[speed],
+				Privacy:        record[privacy],
+				Authentication: record[auth],
 				Power:          power,
-				Name:           record[13],
+				Beacons:        beacons,
+				DataPackets:    dataPackets,
+				Name:           record[essid],
 			}
 			aps = append(aps, ap)
 		}
-		i++
 	}
 	return
 }
 
 // create clients out of the CSV data
-func getClientsData(data string) (clients []Client) {
-	timeParseLayout := "2006-01-02 15:04:05"
-	local := time.Now().Local().Location()
-	r := csv.NewReader(strings.NewReader(data))
+func getClientsData(data []byte, layout csvLayout) (clients []Client, bad malformed) {
+	col := layout.clients
+	mac, first, last, powerCol, packetsCol, bssid, probes := col["station"], col["first_seen"], col["last_seen"],
+		col["power"], col["packets"], col["bssid"], col["probes"]
+	r := csv.NewReader(bytes.NewReader(data))
 	r.FieldsPerRecord = -1
 	r.TrimLeadingSpace = true
+	r.ReuseRecord = true
+	// about 100 bytes a client
+	clients = make([]Client, 0, len(data)/100)
 
 	// Iterate through the client records
 	for {
@@ -236,41 +792,29 @@ func getClientsData(data string) (clients []Client) {
 		if err == io.EOF {
 			break
 		}
-		check(err, "Cannot parse airodump-ng CSV file (clients):")
-		if len(record) < 7 {
-			fmt.Println("Not enough columns for clients:", record)
+		bad.check(err, "Cannot parse airodump-ng CSV file (clients):")
+		if len(record) < layout.clientColumns {
+			bad.add("Not enough columns for clients:", record)
 			continue
 		}
-		firstSeen, err := time.ParseInLocation(timeParseLayout, strings.TrimSpace(record[1]), local)
-		check(err, "Cannot parse first seen date:")
-		lastSeen, err := time.ParseInLocation(timeParseLayout, strings.TrimSpace(record[2]), local)
-		check(err, "Cannot parse last seen date:")
-		power, err := strconv.Atoi(strings.TrimSpace(record[3]))
-		check(err, "Cannot parse power value:")
-		packets, err := strconv.Atoi(strings.TrimSpace(record[4]))
-		check(err, "Cannot parse packets value:")
+		firstSeen, err := parseAirodumpTime(record[first])
+		bad.check(err, "Cannot parse first seen date:")
+		lastSeen, err := parseAirodumpTime(record[last])
+		bad.check(err, "Cannot parse last seen date:")
+		power, err := strconv.Atoi(strings.TrimSpace(record[powerCol]))
+		bad.check(err, "Cannot parse power value:")
+		packets, err := strconv.Atoi(strings.TrimSpace(record[packetsCol]))
+		bad.check(err, "Cannot parse packets value:")
 
 		c := Client{
-			MAC:       strings.ReplaceAll(record[0], ":", "-"),
+			MAC:       normalizeMAC(record[mac]),
 			FirstSeen: firstSeen,
 			LastSeen:  lastSeen,
 			Power:     power,
 			Packets:   packets,
-			BSSID:     record[5],
-			Probes:    record[6],
-		}
-		if isLocalMAC(c.MAC) {
-			cid := strings.TrimSpace(ciddb[c.MAC[:8]])
-			if cid != "" {
-				c.Organization = cid
-			} else {
-				c.Organization = "LOCAL"
-			}
-
-		} else {
-			oui := strings.TrimSpace(ouidb[c.MAC[:8]])
-			c.Organization = oui
+			Probes:    strings.Join(record[probes:], ","), // the probed ESSIDs are separated by commas too
 		}
+		c.setBSSID(record[bssid])
 
 		clients = append(clients, c)
 	}
@@ -280,7 +824,7 @@ func getClientsData(data string) (clients []Client) {
 // Parsing the OUI from http://standards-oui.ieee.org/oui.txt
 // OUI is organizational unique identifier https://en.wikipedia.org/wiki/Organizationally_unique_identifier
 func parseOui() (oui map[string]string) {
-	file, err := os.Open(*dir + "/public/oui.txt")
+	file, err := openDatabase("oui.txt", vendorURL("oui.txt"))
 	if err != nil {
 		fmt.Println("Oui.txt file not found:", err)
 		return
@@ -302,7 +846,7 @@ func parseOui() (oui map[string]string) {
 // Parsing the CID from http://standards-oui.ieee.org/cid/cid.txt
 // CID is company ID https://standards.ieee.org/products-services/regauth/cid/index.html
 func parseCid() (cid map[string]string) {
-	file, err := os.Open(*dir + "/public/cid.txt")
+	file, err := openDatabase("cid.txt", vendorURL("cid.txt"))
 	if err != nil {
 		fmt.Println("Cid.txt file not found:", err)
 		return
@@ -321,8 +865,92 @@ func parseCid() (cid map[string]string) {
 	return
 }
 
+// Parsing the MA-M from http://standards-oui.ieee.org/oui28/mam.txt
+// MA-M is a block of 2^20 addresses of an OUI the IEEE shares between organizations, keyed by the OUI and the next digit
+func parseMam() (mam map[string]string) {
+	file, err := openDatabase("mam.txt", vendorURL("mam.txt"))
+	if err != nil {
+		fmt.Println("Mam.txt file not found:", err)
+		return
+	}
+	defer file.Close()
+	mam = make(map[string]string)
+	for prefix, record := range parseRegistry(file, "MA-M") {
+		mam[prefix] = record.Organization
+	}
+	return
+}
+
+// Parsing nmap's nmap-mac-prefixes, lines of a prefix in hex and the vendor, ie "0050F2 Microsoft",
+// the prefixes are 6 digits for an OUI and longer for the MA-M and MA-S blocks
+func parseNmapPrefixes() (nmap map[string]string) {
+	files := nmapPrefixesFiles
+	if config.Vendors.Nmap != "" {
+		files = []string{config.Vendors.Nmap}
+	}
+	for _, name := range files {
+		file, err := os.Open(name)
+		if err != nil {
+			continue
+		}
+		defer file.Close()
+		recordDatabase("nmap-mac-prefixes", file)
+		nmap = make(map[string]string)
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			fields := strings.SplitN(line, " ", 2)
+			if len(fields) == 2 && len(fields[0]) >= 6 {
+				nmap[strings.ToUpper(fields[0])] = strings.TrimSpace(fields[1])
+			}
+		}
+		return
+	}
+	if config.Vendors.Nmap != "" {
+		fmt.Println("Nmap-mac-prefixes file not found:", config.Vendors.Nmap)
+	}
+	return
+}
+
+// look up a MAC address in nmap-mac-prefixes, the longest prefix first
+func lookupNmap(mac string) string {
+	digits := strings.Replace(mac, "-", "", -1)
+	for n := len(digits); n >= 6; n-- {
+		if vendor, ok := nmapdb[digits[:n]]; ok {
+			return vendor
+		}
+	}
+	return ""
+}
+
 func check(err error, msg string) {
 	if err != nil {
 		fmt.Println(msg, err)
 	}
 }
+
+// malformed records found in a parse, they are skipped or kept as far as they could be parsed unless -strict
+// stops ingestion because of them
+type malformed []string
+
+func (m *malformed) check(err error, msg string) {
+	if err != nil {
+		fmt.Println(msg, err)
+		*m = append(*m, msg+" "+err.Error())
+	}
+}
+
+func (m *malformed) add(msg string, record []string) {
+	fmt.Println(msg, record)
+	*m = append(*m, msg+" "+strings.Join(record, ","))
+}
+
+func (m malformed) problem() string {
+	if len(m) == 1 {
+		return "malformed record, " + m[0]
+	}
+	return fmt.Sprintf("%d malformed records, first %s", len(m), m[0])
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// default tile server, see https://operations.osmfoundation.org/policies/tiles/ before proxying many tiles from it
+const defaultTileURL = "https://tile.openstreetmap.org/{z}/{x}/{y}.png"
+const defaultTileAttribution = `&copy; <a href="https://www.openstreetmap.org/copyright">OpenStreetMap</a> contributors`
+
+// cached tiles are fetched again after this, but still served if the tile server can't be reached
+const tileMaxAge = 7 * 24 * time.Hour
+
+// deepest zoom level tiles are proxied for
+const maxTileZoom = 19
+
+// markers closer than this many pixels at the zoom level of the map are put into one cluster
+const clusterPixels = 60
+
+// MapConfig is how the map in the UI gets its tiles
+type MapConfig struct {
+	TileURL     string `json:"tile_url"`    // with {z}, {x} and {y}, defaults to OpenStreetMap
+	Attribution string `json:"attribution"` // shown on the map, defaults to the OpenStreetMap one
+	ProxyTiles  bool   `json:"proxy_tiles"` // fetch the tiles through netnet and keep them in the data directory for the field
+}
+
+// MapMarker is an access point on the map, where it was seen with the strongest signal
+type MapMarker struct {
+	MAC       string    `json:"mac"`
+	Name      string    `json:"name"`
+	Privacy   string    `json:"privacy"`
+	Power     int       `json:"power"` // strongest signal it was seen with
+	Lat       float64   `json:"lat"`
+	Lon       float64   `json:"lon"`
+	LastSeen  time.Time `json:"last_seen"`
+	Sightings int       `json:"sightings"`
+}
+
+// MapCluster is the markers close to each other at the zoom level, a single marker is a cluster of one
+type MapCluster struct {
+	Lat     float64     `json:"lat"`
+	Lon     float64     `json:"lon"`
+	Count   int         `json:"count"`
+	Power   int         `json:"power"` // strongest signal of the markers
+	Markers []MapMarker `json:"markers"`
+}
+
+// tiles fetched at the same time, the OpenStreetMap tile policy allows 2
+var tileFetches = make(chan struct{}, 2)
+
+// the markers of the access points sighted, at the position of their strongest sighting
+func mapMarkers() (markers []MapMarker) {
+	gpsMutex.RLock()
+	defer gpsMutex.RUnlock()
+	byMAC := make(map[string]*MapMarker)
+	for _, s := range gpsSightings {
+		m, ok := byMAC[s.MAC]
+		if !ok {
+			m = &MapMarker{MAC: s.MAC, Power: s.Power, Lat: s.Lat, Lon: s.Lon}
+			byMAC[s.MAC] = m
+		}
+		m.Sightings++
+		if bestPower(m.Power, s.Power) != m.Power {
+			m.Power, m.Lat, m.Lon = s.Power, s.Lat, s.Lon
+		}
+		// the name and security of the latest sighting
+		if !s.Time.Before(m.LastSeen) {
+			m.Name, m.Privacy, m.LastSeen = s.Name, s.Privacy, s.Time
+		}
+	}
+	for _, m := range byMAC {
+		markers = append(markers, *m)
+	}
+	sort.Slice(markers, func(i, j int) bool { return markers[i].MAC < markers[j].MAC })
+	return
+}
+
+// position in pixels of the whole world at a zoom level, in web mercator like the tiles
+func mapPixel(lat, lon float64, zoom int) (x, y float64) {
+	size := 256 * math.Exp2(float64(zoom))
+	sin := math.Sin(lat * math.Pi / 180)
+	x = (lon + 180) / 360 * size
+	y = (0.5 - math.Log((1+sin)/(1-sin))/(4*math.Pi)) * size
+	return
+}
+
+// put the markers into clusters on a grid of clusterPixels at the zoom level, every marker is a cluster of its own if zoom is negative
+func clusterMarkers(markers []MapMarker, zoom int) []MapCluster {
+	clusters := []MapCluster{}
+	index := make(map[[2]int]int)
+	for _, m := range markers {
+		i := len(clusters)
+		if zoom >= 0 {
+			x, y := mapPixel(m.Lat, m.Lon, zoom)
+			cell := [2]int{int(x / clusterPixels), int(y / clusterPixels)}
+			if j, ok := index[cell]; ok {
+				i = j
+			} else {
+				index[cell] = i
+			}
+		}
+		if i == len(clusters) {
+			clusters = append(clusters, MapCluster{Power: m.Power})
+		}
+		c := &clusters[i]
+		c.Lat, c.Lon = (c.Lat*float64(c.Count)+m.Lat)/float64(c.Count+1), (c.Lon*float64(c.Count)+m.Lon)/float64(c.Count+1)
+		c.Count++
+		c.Power = bestPower(c.Power, m.Power)
+		c.Markers = append(c.Markers, m)
+	}
+	return clusters
+}
+
+// the access points for the map at /map/data, clustered with ?zoom= and only those in ?bbox=west,south,east,north
+func mapData(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	zoom := -1
+	if s := q.Get("zoom"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 || n > 30 {
+			http.Error(w, "Invalid zoom", http.StatusBadRequest)
+			return
+		}
+		zoom = n
+	}
+	markers := mapMarkers()
+	if s := q.Get("bbox"); s != "" {
+		var box [4]float64
+		parts := strings.Split(s, ",")
+		if len(parts) != 4 {
+			http.Error(w, "Invalid bbox, use west,south,east,north", http.StatusBadRequest)
+			return
+		}
+		for i, part := range parts {
+			n, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+			if err != nil {
+				http.Error(w, "Invalid bbox, use west,south,east,north", http.StatusBadRequest)
+				return
+			}
+			box[i] = n
+		}
+		var inside []MapMarker
+		for _, m := range markers {
+			// the box can cross the antimeridian
+			inLon := m.Lon >= box[0] && m.Lon <= box[2]
+			if box[0] > box[2] {
+				inLon = m.Lon >= box[0] || m.Lon <= box[2]
+			}
+			if inLon && m.Lat >= box[1] && m.Lat <= box[3] {
+				inside = append(inside, m)
+			}
+		}
+		markers = inside
+	}
+	writeJSON(w, clusterMarkers(markers, zoom))
+}
+
+// where the map in the UI gets its tiles from
+func mapTileURL() string {
+	if config.Map.ProxyTiles || *offline {
+		return "/map/tiles/{z}/{x}/{y}.png"
+	}
+	if config.Map.TileURL != "" {
+		return config.Map.TileURL
+	}
+	return defaultTileURL
+}
+
+// the map of the access points sighted, with the sensor's track
+func mapPage(w http.ResponseWriter, r *http.Request) {
+	attribution := config.Map.Attribution
+	if attribution == "" {
+		attribution = defaultTileAttribution
+	}
+	t, err := parseTemplate("map.html")
+	if err != nil {
+		http.Error(w, "Cannot show map: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	t.Execute(w, struct {
+		TileURL     string
+		Attribution string
+		Leaflet     string
+	}{mapTileURL(), attribution, leafletBase()})
+}
+
+// map tiles at /map/tiles/{z}/{x}/{y}.png, fetched from the tile server and kept in the data directory,
+// only those already kept when offline
+func mapTiles(w http.ResponseWriter, r *http.Request) {
+	if !config.Map.ProxyTiles && !*offline {
+		http.Error(w, "Tile proxy is off", http.StatusNotFound)
+		return
+	}
+	parts := strings.Split(strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/map/tiles/"), ".png"), "/")
+	if len(parts) != 3 {
+		http.NotFound(w, r)
+		return
+	}
+	var zxy [3]int
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			http.NotFound(w, r)
+			return
+		}
+		zxy[i] = n
+	}
+	z, x, y := zxy[0], zxy[1], zxy[2]
+	if z > maxTileZoom || x >= 1<<uint(z) || y >= 1<<uint(z) {
+		http.NotFound(w, r)
+		return
+	}
+	path := tilePath(z, x, y)
+	info, err := os.Stat(path)
+	if err != nil && *offline {
+		http.Error(w, "Tile not bundled", http.StatusNotFound)
+		return
+	}
+	if err != nil || (time.Since(info.ModTime()) > tileMaxAge && !*offline) {
+		fetchErr := fetchTile(z, x, y, path)
+		if fetchErr != nil && err != nil {
+			http.Error(w, "Cannot get tile: "+fetchErr.Error(), http.StatusBadGateway)
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", "max-age=86400")
+	http.ServeFile(w, r, path)
+}
+
+// where a tile is kept in the data directory
+func tilePath(z, x, y int) string {
+	return filepath.Join(*dataDir, "tiles", strconv.Itoa(z), strconv.Itoa(x), strconv.Itoa(y)+".png")
+}
+
+// fetch a tile from the tile server into the data directory
+func fetchTile(z, x, y int, path string) error {
+	tileFetches <- struct{}{}
+	defer func() { <-tileFetches }()
+	url := config.Map.TileURL
+	if url == "" {
+		url = defaultTileURL
+	}
+	url = strings.NewReplacer("{z}", strconv.Itoa(z), "{x}", strconv.Itoa(x), "{y}", strconv.Itoa(y)).Replace(url)
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	// tile servers want to know who is asking
+	req.Header.Set("User-Agent", "netnet/"+version+" (+https://github.com/"+*updateRepo+")")
+	client, err := downloadClient(30 * time.Second)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("tile server answered %s", resp.Status)
+	}
+	err = os.MkdirAll(filepath.Dir(path), 0700)
+	if err != nil {
+		return err
+	}
+	// a tile being fetched by another request at the same time gets a file of its own
+	file, err := os.CreateTemp(filepath.Dir(path), ".tile-*")
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(file, resp.Body)
+	file.Close()
+	if err != nil {
+		os.Remove(file.Name())
+		return err
+	}
+	return os.Rename(file.Name(), path)
+}
@@ -0,0 +1,84 @@
+package main
+
+// the stronger of two power readings, airodump-ng reports -1 when it has no reading
+func bestPower(a, b int) int {
+	if a == -1 || (b != -1 && b > a) {
+		return b
+	}
+	return a
+}
+
+// merge access points listed more than once, keeping the latest record with the widest time span, most beacons
+// and data packets, and best power
+func mergeAPs(aps []AccessPoint) []AccessPoint {
+	index := make(map[string]int, len(aps))
+	merged := make([]AccessPoint, 0, len(aps))
+	for _, ap := range aps {
+		i, ok := index[ap.MAC]
+		if !ok {
+			index[ap.MAC] = len(merged)
+			merged = append(merged, ap)
+			continue
+		}
+		m := merged[i]
+		if ap.LastSeen.Before(m.LastSeen) {
+			ap, m = m, ap
+		}
+		if m.FirstSeen.Before(ap.FirstSeen) {
+			ap.FirstSeen = m.FirstSeen
+		}
+		if m.Beacons > ap.Beacons {
+			ap.Beacons = m.Beacons
+		}
+		if m.DataPackets > ap.DataPackets {
+			ap.DataPackets = m.DataPackets
+		}
+		ap.Power = bestPower(ap.Power, m.Power)
+		// sources like controllers don't know everything that is on the air
+		if ap.Name == "" {
+			ap.Name, ap.NameHex = m.Name, m.NameHex
+		}
+		if ap.Channel == 0 {
+			ap.Channel = m.Channel
+		}
+		if ap.Privacy == "" {
+			ap.Privacy, ap.Authentication, ap.Speed = m.Privacy, m.Authentication, m.Speed
+		}
+		merged[i] = ap
+	}
+	return merged
+}
+
+// merge clients listed more than once, keeping the latest record with the widest time span, most packets
+// and best power
+func mergeClients(clients []Client) []Client {
+	index := make(map[string]int, len(clients))
+	merged := make([]Client, 0, len(clients))
+	for _, c := range clients {
+		i, ok := index[c.MAC]
+		if !ok {
+			index[c.MAC] = len(merged)
+			merged = append(merged, c)
+			continue
+		}
+		m := merged[i]
+		if c.LastSeen.Before(m.LastSeen) {
+			c, m = m, c
+		}
+		if m.FirstSeen.Before(c.FirstSeen) {
+			c.FirstSeen = m.FirstSeen
+		}
+		if m.Packets > c.Packets {
+			c.Packets = m.Packets
+		}
+		c.Power = bestPower(c.Power, m.Power)
+		if !c.Associated && m.Associated {
+			c.BSSID, c.Associated = m.BSSID, m.Associated
+		}
+		if c.Probes == "" {
+			c.Probes = m.Probes
+		}
+		merged[i] = c
+	}
+	return merged
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// metrics in the Prometheus text exposition format
+type metrics struct {
+	buf  bytes.Buffer
+	seen map[string]bool
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// add a sample, labels are name and value pairs
+func (m *metrics) add(name, kind, help string, value float64, labels ...string) {
+	m.header(name, kind, help)
+	m.sample(name, value, labels...)
+}
+
+// add a histogram of values, buckets are the upper bounds in increasing order
+func (m *metrics) histogram(name, help string, values, buckets []float64) {
+	m.header(name, "histogram", help)
+	sum := 0.0
+	for _, v := range values {
+		sum += v
+	}
+	for _, le := range buckets {
+		n := 0
+		for _, v := range values {
+			if v <= le {
+				n++
+			}
+		}
+		m.sample(name+"_bucket", float64(n), "le", strconv.FormatFloat(le, 'g', -1, 64))
+	}
+	m.sample(name+"_bucket", float64(len(values)), "le", "+Inf")
+	m.sample(name+"_sum", sum)
+	m.sample(name+"_count", float64(len(values)))
+}
+
+// the help and type of a metric, once before its first sample
+func (m *metrics) header(name, kind, help string) {
+	if m.seen == nil {
+		m.seen = make(map[string]bool)
+	}
+	if !m.seen[name] {
+		m.seen[name] = true
+		m.buf.WriteString("# HELP " + name + " " + help + "\n")
+		m.buf.WriteString("# TYPE " + name + " " + kind + "\n")
+	}
+}
+
+func (m *metrics) sample(name string, value float64, labels ...string) {
+	m.buf.WriteString(name)
+	if len(labels) > 0 {
+		m.buf.WriteString("{")
+		for i := 0; i+1 < len(labels); i += 2 {
+			if i > 0 {
+				m.buf.WriteString(",")
+			}
+			m.buf.WriteString(labels[i] + `="` + labelEscaper.Replace(labels[i+1]) + `"`)
+		}
+		m.buf.WriteString("}")
+	}
+	m.buf.WriteString(" " + strconv.FormatFloat(value, 'g', -1, 64) + "\n")
+}
+
+// upper bounds of the client signal strength buckets in dBm
+var powerBuckets = []float64{-90, -80, -70, -60, -50, -40, -30}
+
+// the access points and clients seen in the last 5 minutes, by encryption and organization, and the signal strength
+// of the clients as a histogram rather than per client, as randomized MACs would make a series each
+func deviceMetrics(m *metrics) {
+	aps, clients := store.Snapshot()
+	privacy := make(map[string]int)
+	for _, ap := range aps {
+		if isActive(ap.LastSeen) {
+			privacy[encryption(ap)]++
+		}
+	}
+	organizations := make(map[string]int)
+	var power []float64
+	for _, c := range clients {
+		if !isActive(c.LastSeen) {
+			continue
+		}
+		organizations[orDefault(c.Organization, "UNKNOWN")]++
+		// airodump-ng reports -1 when it has no power reading
+		if c.Power != -1 && c.Power != 0 {
+			power = append(power, float64(c.Power))
+		}
+	}
+	for _, name := range sortedKeys(privacy) {
+		m.add("netnet_access_points", "gauge", "Access points seen in the last 5 minutes by encryption.", float64(privacy[name]), "privacy", name)
+	}
+	for _, name := range sortedKeys(organizations) {
+		m.add("netnet_clients_seen", "gauge", "Clients seen in the last 5 minutes by organization.", float64(organizations[name]), "organization", name)
+	}
+	m.histogram("netnet_client_power", "Signal strength of the clients seen in the last 5 minutes in dBm.", power, powerBuckets)
+}
+
+func sortedKeys(counts map[string]int) []string {
+	keys := make([]string, 0, len(counts))
+	for k := range counts {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Prometheus metrics at /metrics
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	var m metrics
+	f := getFlux()
+	m.add("netnet_new_macs_per_minute", "gauge", "Never-before-seen client MACs per minute over the last parse.", f.PerMinute)
+	m.add("netnet_new_macs_per_minute_smoothed", "gauge", "Exponentially smoothed never-before-seen client MACs per minute.", f.PerMinuteSmooth)
+	m.add("netnet_distinct_macs_total", "counter", "Distinct client MACs seen since netnet started.", float64(f.TotalMACs))
+	m.add("netnet_ghost_clients", "gauge", "Ghost clients pruned from the last parse.", float64(ghostClients.Load()))
+	deviceMetrics(&m)
+	m.add("netnet_parses_total", "counter", "Parses run through the ingestion pipeline.", float64(parseRuns.Load()))
+	m.add("netnet_parses_halted_total", "counter", "Parses stopped in strict mode by malformed records.", float64(parseHalts.Load()))
+	m.add("netnet_parse_seconds_total", "counter", "Time spent parsing.", time.Duration(parseNanos.Load()).Seconds())
+	if last := lastParsedTime(); !last.IsZero() {
+		m.add("netnet_last_parse_timestamp_seconds", "gauge", "When the last parse finished.", float64(last.UnixNano())/1e9)
+	}
+	stages := getStageMetrics()
+	for _, s := range stages {
+		m.add("netnet_pipeline_stage_runs_total", "counter", "Times a stage of the ingestion pipeline ran.", float64(s.Runs), "stage", s.Name)
+	}
+	for _, s := range stages {
+		m.add("netnet_pipeline_stage_seconds_total", "counter", "Time spent in a stage of the ingestion pipeline.", s.Total.Seconds(), "stage", s.Name)
+	}
+	lookups := getLookupStats()
+	for name, s := range lookups {
+		m.add("netnet_lookups_total", "counter", "Lookups with external services by result.", float64(s.Lookups), "pool", name, "result", "answered")
+		m.add("netnet_lookups_total", "counter", "Lookups with external services by result.", float64(s.Failures), "pool", name, "result", "failed")
+		m.add("netnet_lookups_total", "counter", "Lookups with external services by result.", float64(s.Dropped), "pool", name, "result", "dropped")
+	}
+	for name, s := range lookups {
+		m.add("netnet_lookup_cache_hits_total", "counter", "Lookups answered from the cache.", float64(s.Hits), "pool", name)
+	}
+	for _, h := range hubs {
+		m.add("netnet_websocket_connections", "gauge", "Open WebSocket connections.", float64(h.count()), "hub", h.name)
+	}
+	for _, h := range hubs {
+		m.add("netnet_websocket_dropped_total", "counter", "WebSocket connections dropped for falling behind.", float64(h.dropped.Load()), "hub", h.name)
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.Write(m.buf.Bytes())
+}
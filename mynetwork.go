@@ -0,0 +1,173 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// EventUnknownClient is raised when a client never seen on our access points before associates with one of them
+const EventUnknownClient = "unknown_client"
+
+// the file the clients that have been on our access points are kept in
+const myClientsFile = "my_clients.json"
+
+// MyClient is a client that has been associated with one of our access points
+type MyClient struct {
+	MAC         string    `json:"mac"`
+	FirstJoined time.Time `json:"first_joined"`
+	LastJoined  time.Time `json:"last_joined"`
+	BSSID       string    `json:"bssid"` // access point it joined last
+}
+
+// MyNetworkChange is a client joining or leaving one of our access points, sent to /mynetwork/ws
+type MyNetworkChange struct {
+	Change string    `json:"change"` // joined or left
+	Time   time.Time `json:"time"`
+	MAC    string    `json:"mac"`
+	BSSID  string    `json:"bssid"`
+	Name   string    `json:"name"` // SSID of the access point
+	Known  bool      `json:"known"`
+}
+
+var myClients = make(map[string]MyClient)
+var myClientsMutex sync.RWMutex
+
+// without a file of clients yet, the clients on our access points in the first parse are taken as known
+var myClientsBaseline bool
+
+var myNetworkHub = newHub("mynetwork")
+
+func loadMyClients() {
+	myClientsMutex.Lock()
+	defer myClientsMutex.Unlock()
+	myClients = make(map[string]MyClient)
+	_, err := os.Stat(filepath.Join(*dataDir, myClientsFile))
+	myClientsBaseline = os.IsNotExist(err)
+	check(loadJSON(myClientsFile, &myClients), "Cannot load clients of my access points:")
+}
+
+// an access point is ours if it is marked as mine or its BSSID is in the configuration
+func isMine(ap AccessPoint) bool {
+	return ap.Ownership == OwnershipMine || containsMAC(config.MyBSSIDs, ap.MAC)
+}
+
+// the active clients associated with one of our access points, by MAC address
+func myAssociations(clients []Client, mine map[string]AccessPoint) map[string]Client {
+	associated := make(map[string]Client)
+	for _, c := range clients {
+		if _, ok := mine[c.BSSID]; ok && c.Associated && isActive(c.LastSeen) {
+			associated[c.MAC] = c
+		}
+	}
+	return associated
+}
+
+// find the clients that joined or left our access points since the last parse, with an event for every client
+// that was never on them before
+func recordMyClients(oldClients, clients []Client, aps []AccessPoint, first bool) (events []Event) {
+	mine := make(map[string]AccessPoint)
+	for _, ap := range aps {
+		if isMine(ap) {
+			mine[ap.MAC] = ap
+		}
+	}
+	if len(mine) == 0 {
+		return
+	}
+	before := myAssociations(oldClients, mine)
+	now := myAssociations(clients, mine)
+	myClientsMutex.Lock()
+	defer myClientsMutex.Unlock()
+	baseline := myClientsBaseline && first
+	changed := false
+	for mac, c := range now {
+		if old, ok := before[mac]; ok && old.BSSID == c.BSSID {
+			continue
+		}
+		ap := mine[c.BSSID]
+		record, known := myClients[mac]
+		known = known || containsMAC(config.MyClients, mac)
+		if !known && !baseline {
+			events = append(events, Event{Type: EventUnknownClient, Time: time.Now(), MAC: mac,
+				Message: "Unknown client " + formatMAC(mac) + " joined my access point " + ap.Name, Data: c})
+		}
+		if record.FirstJoined.IsZero() {
+			record = MyClient{MAC: mac, FirstJoined: time.Now()}
+		}
+		record.LastJoined, record.BSSID = time.Now(), c.BSSID
+		myClients[mac] = record
+		changed = true
+		myNetworkHub.broadcastJSON(MyNetworkChange{Change: "joined", Time: time.Now(), MAC: formatMAC(mac),
+			BSSID: formatMAC(c.BSSID), Name: ap.Name, Known: known || baseline})
+	}
+	for mac, c := range before {
+		if n, ok := now[mac]; ok && n.BSSID == c.BSSID {
+			continue
+		}
+		myNetworkHub.broadcastJSON(MyNetworkChange{Change: "left", Time: time.Now(), MAC: formatMAC(mac),
+			BSSID: formatMAC(c.BSSID), Name: mine[c.BSSID].Name, Known: true})
+	}
+	if changed || baseline {
+		myClientsBaseline = false
+		check(saveJSON(myClientsFile, myClients), "Cannot save clients of my access points:")
+	}
+	return
+}
+
+// MyAP is one of our access points with the clients on it now
+type MyAP struct {
+	AccessPoint AccessPoint `json:"access_point"`
+	Clients     []Client    `json:"clients"`
+}
+
+// MyNetwork is our access points and every client that has been on them
+type MyNetwork struct {
+	APs        []MyAP     `json:"aps"`
+	KnownCount int        `json:"known_count"`
+	Known      []MyClient `json:"known"` // joined last first
+}
+
+func getMyNetwork() MyNetwork {
+	n := MyNetwork{APs: []MyAP{}, Known: []MyClient{}}
+	mine := make(map[string]AccessPoint)
+	aps, clients := store.Snapshot()
+	for _, ap := range aps {
+		if isMine(ap) {
+			mine[ap.MAC] = ap
+		}
+	}
+	associated := myAssociations(clients, mine)
+	for _, ap := range mine {
+		a := MyAP{AccessPoint: ap, Clients: []Client{}}
+		for _, c := range associated {
+			if c.BSSID == ap.MAC {
+				a.Clients = append(a.Clients, c)
+			}
+		}
+		n.APs = append(n.APs, a)
+	}
+	sort.Slice(n.APs, func(i, j int) bool { return n.APs[i].AccessPoint.MAC < n.APs[j].AccessPoint.MAC })
+	myClientsMutex.RLock()
+	for _, c := range myClients {
+		c.MAC, c.BSSID = formatMAC(c.MAC), formatMAC(c.BSSID)
+		n.Known = append(n.Known, c)
+	}
+	myClientsMutex.RUnlock()
+	sort.Slice(n.Known, func(i, j int) bool { return n.Known[i].LastJoined.After(n.Known[j].LastJoined) })
+	n.KnownCount = len(n.Known)
+	return n
+}
+
+// our access points with their clients and the clients that have been on them at /mynetwork
+func myNetworkHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, getMyNetwork())
+}
+
+// clients joining and leaving our access points as they happen at /mynetwork/ws
+func myNetworkWebSocket(w http.ResponseWriter, r *http.Request) {
+	myNetworkHub.serve(w, r)
+}
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// inventory items pulled from NetBox, the ones from a CSV file have no source
+const sourceNetBox = "netbox"
+
+// NetBoxConfig is the NetBox server netnet keeps in line with what is on the air
+type NetBoxConfig struct {
+	URL      string `json:"url"` // ie https://netbox.example.com, no sync if empty
+	Token    string `json:"token"`
+	Pull     bool   `json:"pull"`     // take the interfaces with MAC addresses in NetBox as the inventory of expected devices
+	Push     bool   `json:"push"`     // add the devices present that NetBox doesn't know as MAC addresses, NetBox 4.2 or later
+	PushAll  bool   `json:"push_all"` // push every client present, not only our access points and the clients on them
+	Tag      string `json:"tag"`      // slug of a tag in NetBox put on the MAC addresses pushed
+	Interval int    `json:"interval"` // minutes between syncs, defaults to 15
+	Timeout  int    `json:"timeout"`  // seconds a request to NetBox can take, defaults to 30
+}
+
+// NetBoxStatus is how syncing with NetBox is going, in /status
+type NetBoxStatus struct {
+	URL       string    `json:"url"`
+	LastSync  time.Time `json:"last_sync"`
+	LastError string    `json:"last_error,omitempty"`
+	Pulled    int       `json:"pulled"` // expected devices in NetBox at the last sync
+	Pushed    int       `json:"pushed"` // devices added to NetBox at the last sync
+}
+
+var netboxStatus NetBoxStatus
+var netboxStatusMutex sync.Mutex
+var netboxMutex sync.Mutex // held during a sync
+
+func netboxClient() *http.Client {
+	timeout := config.NetBox.Timeout
+	if timeout <= 0 {
+		timeout = 30
+	}
+	return &http.Client{Timeout: time.Duration(timeout) * time.Second}
+}
+
+// send a request to the NetBox API and decode the answer into v
+func netboxRequest(client *http.Client, method, url string, body, v interface{}) error {
+	if !strings.HasPrefix(url, "http") {
+		url = strings.TrimSuffix(config.NetBox.URL, "/") + url
+	}
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, url, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Token "+config.NetBox.Token)
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, 64<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("%s %s: %s: %s", method, req.URL.Path, resp.Status, strings.TrimSpace(string(data)))
+	}
+	if v == nil {
+		return nil
+	}
+	return json.Unmarshal(data, v)
+}
+
+// every object of a NetBox list, following the pages
+func netboxList(client *http.Client, path string, each func(json.RawMessage) error) error {
+	url := path + "?limit=1000"
+	for url != "" {
+		var page struct {
+			Next    string            `json:"next"`
+			Results []json.RawMessage `json:"results"`
+		}
+		if err := netboxRequest(client, http.MethodGet, url, nil, &page); err != nil {
+			return err
+		}
+		for _, r := range page.Results {
+			if err := each(r); err != nil {
+				return err
+			}
+		}
+		url = page.Next
+	}
+	return nil
+}
+
+// the MAC addresses of the interfaces in NetBox with the devices they are on, as inventory items
+func pullNetBox(client *http.Client) (map[string]InventoryItem, error) {
+	type nested struct {
+		ID   int    `json:"id"`
+		Name string `json:"name"`
+	}
+	devices := make(map[int]InventoryItem)
+	err := netboxList(client, "/api/dcim/devices/", func(data json.RawMessage) error {
+		var d struct {
+			ID       int     `json:"id"`
+			Name     string  `json:"name"`
+			AssetTag string  `json:"asset_tag"`
+			Tenant   *nested `json:"tenant"`
+		}
+		if err := json.Unmarshal(data, &d); err != nil {
+			return err
+		}
+		item := InventoryItem{Owner: d.Name, AssetTag: d.AssetTag, Source: sourceNetBox}
+		if d.Tenant != nil {
+			item.Owner = d.Tenant.Name
+		}
+		devices[d.ID] = item
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	items := make(map[string]InventoryItem)
+	err = netboxList(client, "/api/dcim/interfaces/", func(data json.RawMessage) error {
+		var i struct {
+			Device     nested  `json:"device"`
+			MACAddress *string `json:"mac_address"` // before NetBox 4.2
+			PrimaryMAC *struct {
+				MACAddress string `json:"mac_address"`
+			} `json:"primary_mac_address"`
+		}
+		if err := json.Unmarshal(data, &i); err != nil {
+			return err
+		}
+		mac := ""
+		if i.PrimaryMAC != nil {
+			mac = i.PrimaryMAC.MACAddress
+		} else if i.MACAddress != nil {
+			mac = *i.MACAddress
+		}
+		if m, ok := parseMAC(mac); ok {
+			item := devices[i.Device.ID]
+			item.MAC, item.Source = m, sourceNetBox
+			if item.Owner == "" {
+				item.Owner = i.Device.Name
+			}
+			items[m] = item
+		}
+		return nil
+	})
+	return items, err
+}
+
+// add the devices present that NetBox doesn't know as MAC addresses, known has every MAC address in NetBox
+func pushNetBox(client *http.Client, known map[string]bool) (int, error) {
+	err := netboxList(client, "/api/dcim/mac-addresses/", func(data json.RawMessage) error {
+		var m struct {
+			MACAddress string `json:"mac_address"`
+		}
+		if err := json.Unmarshal(data, &m); err != nil {
+			return err
+		}
+		if mac, ok := parseMAC(m.MACAddress); ok {
+			known[mac] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	myAPs := make(map[string]AccessPoint)
+	aps, clients := store.Snapshot()
+	for _, ap := range aps {
+		if isMine(ap) {
+			myAPs[ap.MAC] = ap
+		}
+	}
+	type device struct {
+		mac         string
+		description string
+	}
+	var devices []device
+	for _, ap := range myAPs {
+		if isActive(ap.LastSeen) {
+			devices = append(devices, device{ap.MAC, "Access point " + ap.Name})
+		}
+	}
+	for _, c := range clients {
+		ap, onMine := myAPs[c.BSSID]
+		onMine = onMine && c.Associated
+		if !isActive(c.LastSeen) || !(onMine || config.NetBox.PushAll) {
+			continue
+		}
+		description := strings.TrimSpace(c.Organization + " client")
+		if onMine {
+			description += " on " + ap.Name
+		}
+		devices = append(devices, device{c.MAC, description})
+	}
+	pushed := 0
+	for _, d := range devices {
+		if known[d.mac] {
+			continue
+		}
+		m := map[string]interface{}{
+			"mac_address": strings.ReplaceAll(d.mac, "-", ":"),
+			"description": "Seen by netnet: " + d.description,
+		}
+		if config.NetBox.Tag != "" {
+			m["tags"] = []map[string]string{{"slug": config.NetBox.Tag}}
+		}
+		if err := netboxRequest(client, http.MethodPost, "/api/dcim/mac-addresses/", m, nil); err != nil {
+			return pushed, err
+		}
+		known[d.mac] = true
+		pushed++
+	}
+	return pushed, nil
+}
+
+// pull the expected devices from NetBox into the inventory and push the devices present to it
+func syncNetBox() error {
+	netboxMutex.Lock()
+	defer netboxMutex.Unlock()
+	client := netboxClient()
+	items, err := pullNetBox(client)
+	if err == nil && config.NetBox.Pull {
+		inventoryMutex.Lock()
+		for mac, item := range inventory {
+			if item.Source == sourceNetBox {
+				delete(inventory, mac)
+			}
+		}
+		for mac, item := range items {
+			if _, ok := inventory[mac]; !ok {
+				inventory[mac] = item
+			}
+		}
+		err = saveJSON(inventoryFile, inventory)
+		inventoryMutex.Unlock()
+	}
+	pushed := 0
+	if err == nil && config.NetBox.Push {
+		known := make(map[string]bool)
+		for mac := range items {
+			known[mac] = true
+		}
+		pushed, err = pushNetBox(client, known)
+	}
+	netboxStatusMutex.Lock()
+	defer netboxStatusMutex.Unlock()
+	netboxStatus.LastSync, netboxStatus.Pulled, netboxStatus.Pushed = time.Now(), len(items), pushed
+	netboxStatus.LastError = ""
+	if err != nil {
+		netboxStatus.LastError = err.Error()
+	}
+	return err
+}
+
+// sync with NetBox every interval
+func runNetBoxSync() {
+	interval := time.Duration(config.NetBox.Interval) * time.Minute
+	if interval <= 0 {
+		interval = 15 * time.Minute
+	}
+	for {
+		if err := syncNetBox(); err != nil {
+			fmt.Println("Cannot sync with NetBox:", err)
+		}
+		time.Sleep(interval)
+	}
+}
+
+// how syncing with NetBox is going, nil if there is no NetBox
+func getNetBoxStatus() *NetBoxStatus {
+	if config.NetBox.URL == "" {
+		return nil
+	}
+	netboxStatusMutex.Lock()
+	defer netboxStatusMutex.Unlock()
+	s := netboxStatus
+	s.URL = config.NetBox.URL
+	return &s
+}
+
+// POST /admin/netbox syncs with NetBox right away
+func adminNetBox(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if config.NetBox.URL == "" {
+		http.Error(w, "NetBox is not configured", http.StatusNotFound)
+		return
+	}
+	if err := syncNetBox(); err != nil {
+		http.Error(w, "Cannot sync with NetBox: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+	writeJSON(w, getNetBoxStatus())
+}
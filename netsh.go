@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// collect the access points visible to a Windows laptop with netsh, for when airodump-ng isn't available.
+// The laptop itself is reported as a client of the access point it is connected to.
+func collectNetsh() (aps []AccessPoint, clients []Client) {
+	output, err := exec.Command("netsh", "wlan", "show", "networks", "mode=bssid").Output()
+	if err != nil {
+		fmt.Println("Cannot run netsh:", err)
+		return
+	}
+	aps = parseNetshNetworks(string(output), time.Now())
+	output, err = exec.Command("netsh", "wlan", "show", "interfaces").Output()
+	if err != nil {
+		fmt.Println("Cannot run netsh:", err)
+		return
+	}
+	clients = parseNetshInterfaces(string(output), time.Now())
+	return
+}
+
+// split a "Name : value" line from netsh
+func netshField(line string) (string, string) {
+	parts := strings.SplitN(line, ":", 2)
+	if len(parts) != 2 {
+		return "", ""
+	}
+	return strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+}
+
+// netsh shows signal quality as a percentage, which Windows maps linearly from -100 dBm to -50 dBm
+func signalToPower(signal string) int {
+	percent, err := strconv.Atoi(strings.TrimSuffix(signal, "%"))
+	if err != nil {
+		return -100
+	}
+	return percent/2 - 100
+}
+
+// map netsh authentication like WPA2-Personal to airodump-ng privacy (WPA2) and authentication (PSK)
+func netshPrivacy(auth string) (string, string) {
+	switch {
+	case auth == "Open":
+		return "OPN", ""
+	case strings.HasPrefix(auth, "WPA"):
+		parts := strings.SplitN(auth, "-", 2)
+		if len(parts) == 2 && parts[1] == "Enterprise" {
+			return parts[0], "MGT"
+		}
+		if parts[0] == "WPA3" {
+			return "WPA3", "SAE"
+		}
+		return parts[0], "PSK"
+	}
+	return auth, ""
+}
+
+// parse the output of netsh wlan show networks mode=bssid
+func parseNetshNetworks(output string, now time.Time) (aps []AccessPoint) {
+	var ssid, privacy, auth string
+	var ap *AccessPoint
+	flush := func() {
+		if ap != nil {
+			aps = append(aps, *ap)
+			ap = nil
+		}
+	}
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		name, value := netshField(scanner.Text())
+		switch {
+		case strings.HasPrefix(name, "SSID"):
+			flush()
+			ssid = value
+		case name == "Authentication":
+			privacy, auth = netshPrivacy(value)
+		case strings.HasPrefix(name, "BSSID"):
+			flush()
+			mac := normalizeMAC(value)
+			ap = &AccessPoint{MAC: mac, FirstSeen: scanFirstSeen(mac, now), LastSeen: now, Privacy: privacy, Authentication: auth, Name: ssid}
+		case ap != nil && name == "Signal":
+			ap.Power = signalToPower(value)
+		case ap != nil && name == "Channel":
+			ap.Channel, _ = strconv.Atoi(value)
+		case ap != nil && name == "Radio type":
+			ap.Speed = value
+		}
+	}
+	flush()
+	return
+}
+
+// parse the output of netsh wlan show interfaces into the interfaces connected to an access point
+func parseNetshInterfaces(output string, now time.Time) (clients []Client) {
+	var client *Client
+	flush := func() {
+		if client != nil && client.BSSID != "" {
+			clients = append(clients, *client)
+		}
+		client = nil
+	}
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		name, value := netshField(scanner.Text())
+		switch {
+		case name == "Name":
+			flush()
+			client = &Client{FirstSeen: now, LastSeen: now}
+		case client == nil:
+		case name == "Physical address":
+			client.MAC = normalizeMAC(value)
+		case name == "BSSID":
+			client.setBSSID(value)
+		case name == "SSID":
+			client.Probes = value
+		case name == "Signal":
+			client.Power = signalToPower(value)
+		}
+	}
+	flush()
+	return
+}
@@ -0,0 +1,400 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// how far back the history of the devices is filled in from the observations when netnet starts, about what it
+// keeps in memory
+const observationsRestore = 4 * time.Hour
+
+// most observations /observations gives at once
+const maxObservations = 10000
+
+// how often observations older than -db-days are deleted, and how long the last seen time of a device not seen
+// since is remembered
+const observationsPruneInterval = 24 * time.Hour
+
+// how long the sqlite3 command waits for the database to be unlocked, by the writes of a parse or a backup
+const sqliteBusyTimeout = 10 * time.Second
+
+// the table of the -db database, the times are in Unix nanoseconds with 0 for none
+const observationsSchema = `PRAGMA journal_mode=WAL;
+CREATE TABLE IF NOT EXISTS observations (
+	time INTEGER NOT NULL,
+	kind TEXT NOT NULL,
+	mac TEXT NOT NULL,
+	first_seen INTEGER NOT NULL,
+	last_seen INTEGER NOT NULL,
+	power INTEGER NOT NULL,
+	packets INTEGER NOT NULL,
+	bssid TEXT NOT NULL,
+	name TEXT NOT NULL,
+	channel INTEGER NOT NULL,
+	privacy TEXT NOT NULL,
+	probes TEXT NOT NULL,
+	source TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS observations_time ON observations (time);
+CREATE INDEX IF NOT EXISTS observations_mac ON observations (mac, time);
+`
+
+// the columns of the observations table in the order they are inserted
+const observationColumns = "time, kind, mac, first_seen, last_seen, power, packets, bssid, name, channel, privacy, probes, source"
+
+// Observation is an access point or client as it was seen in a parse, kept in the -db database
+type Observation struct {
+	Time      time.Time `json:"time"` // of the parse
+	Kind      string    `json:"kind"` // ap or client
+	MAC       string    `json:"mac"`
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+	Power     int       `json:"power"`
+	Packets   int       `json:"packets,omitempty"` // clients only
+	BSSID     string    `json:"bssid,omitempty"`   // access point a client is associated with
+	Name      string    `json:"name,omitempty"`    // access points only
+	Channel   int       `json:"channel,omitempty"`
+	Privacy   string    `json:"privacy,omitempty"`
+	Probes    string    `json:"probes,omitempty"` // clients only
+	Source    string    `json:"source,omitempty"`
+}
+
+// a row of the observations table as sqlite3 -json writes it
+type observationRow struct {
+	Time      int64  `json:"time"`
+	Kind      string `json:"kind"`
+	MAC       string `json:"mac"`
+	FirstSeen int64  `json:"first_seen"`
+	LastSeen  int64  `json:"last_seen"`
+	Power     int    `json:"power"`
+	Packets   int    `json:"packets"`
+	BSSID     string `json:"bssid"`
+	Name      string `json:"name"`
+	Channel   int    `json:"channel"`
+	Privacy   string `json:"privacy"`
+	Probes    string `json:"probes"`
+	Source    string `json:"source"`
+}
+
+func (r observationRow) observation() Observation {
+	return Observation{Time: fromNanos(r.Time), Kind: r.Kind, MAC: r.MAC, FirstSeen: fromNanos(r.FirstSeen),
+		LastSeen: fromNanos(r.LastSeen), Power: r.Power, Packets: r.Packets, BSSID: r.BSSID, Name: r.Name,
+		Channel: r.Channel, Privacy: r.Privacy, Probes: r.Probes, Source: r.Source}
+}
+
+// last seen time of every device in its latest observation, a device is only written again when it was seen again
+var lastObserved = make(map[string]time.Time)
+var observationsPruned time.Time
+var observationsMutex sync.Mutex
+
+// held for reading by every sqlite3 command and for writing while a restore replaces the database
+var dbMutex sync.RWMutex
+
+func observationsEnabled() bool {
+	return *dbFile != ""
+}
+
+func observationsPath() string {
+	return filepath.Join(*dataDir, *dbFile)
+}
+
+// a time as Unix nanoseconds, 0 for none
+func nanos(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
+
+func fromNanos(n int64) time.Time {
+	if n == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, n).UTC()
+}
+
+// a string as an SQL literal
+func sqlQuote(s string) string {
+	return "'" + strings.ReplaceAll(strings.ReplaceAll(s, "\x00", ""), "'", "''") + "'"
+}
+
+// run SQL statements on a database with the sqlite3 command, if rows isn't nil it is called with a decoder of
+// the rows the last statement returns as JSON objects, one at a time
+func sqlite(path, sql string, rows func(*json.Decoder) error) error {
+	dbMutex.RLock()
+	defer dbMutex.RUnlock()
+	args := []string{"-batch", "-bail"}
+	if rows != nil {
+		args = append(args, "-json")
+	}
+	cmd := exec.Command("sqlite3", append(args, path)...)
+	cmd.Stdin = strings.NewReader(fmt.Sprintf(".timeout %d\n%s", sqliteBusyTimeout.Milliseconds(), sql))
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err = cmd.Start(); err != nil {
+		return fmt.Errorf("cannot run sqlite3: %v", err)
+	}
+	if rows != nil {
+		// no rows is no output at all rather than []
+		dec := json.NewDecoder(stdout)
+		if _, err = dec.Token(); err == nil {
+			for dec.More() && err == nil {
+				err = rows(dec)
+			}
+		} else if err == io.EOF {
+			err = nil
+		}
+	}
+	io.Copy(ioutil.Discard, stdout)
+	if waitErr := cmd.Wait(); waitErr != nil {
+		return fmt.Errorf("sqlite3: %v %s", waitErr, strings.TrimSpace(stderr.String()))
+	}
+	return err
+}
+
+// call fn with the observations an SQL query returns
+func queryObservationRows(sql string, fn func(o Observation)) error {
+	return sqlite(observationsPath(), sql, func(dec *json.Decoder) error {
+		var row observationRow
+		if err := dec.Decode(&row); err != nil {
+			return err
+		}
+		fn(row.observation())
+		return nil
+	})
+}
+
+// create the -db database if it isn't there yet, it needs the sqlite3 command
+func openObservations() error {
+	if !observationsEnabled() {
+		return nil
+	}
+	if err := os.MkdirAll(*dataDir, 0700); err != nil {
+		return err
+	}
+	return sqlite(observationsPath(), observationsSchema, nil)
+}
+
+// read the observations kept before, to know what was written and fill in the recent history
+func loadObservations() {
+	if !observationsEnabled() {
+		return
+	}
+	observationsMutex.Lock()
+	defer observationsMutex.Unlock()
+	now := time.Now()
+	lastObserved = make(map[string]time.Time)
+	err := sqlite(observationsPath(), fmt.Sprintf("SELECT mac, MAX(last_seen) AS last_seen FROM observations "+
+		"WHERE time >= %d GROUP BY mac;", nanos(now.Add(-observationsPruneInterval))), func(dec *json.Decoder) error {
+		var row observationRow
+		if err := dec.Decode(&row); err != nil {
+			return err
+		}
+		lastObserved[row.MAC] = fromNanos(row.LastSeen)
+		return nil
+	})
+	check(err, "Cannot load observations:")
+	restored := make(map[string][]Sample)
+	err = queryObservationRows(fmt.Sprintf("SELECT "+observationColumns+" FROM observations WHERE time >= %d ORDER BY time;",
+		nanos(now.Add(-observationsRestore))), func(o Observation) {
+		restored[o.MAC] = append(restored[o.MAC], Sample{Time: o.Time, LastSeen: o.LastSeen, Power: o.Power, Packets: o.Packets})
+	})
+	check(err, "Cannot load observations:")
+	historyMutex.Lock()
+	defer historyMutex.Unlock()
+	for mac, samples := range restored {
+		if len(history[mac]) == 0 {
+			for _, s := range samples {
+				addSample(mac, s)
+			}
+		}
+	}
+}
+
+// insert the devices seen anew in this parse into the -db database
+func recordObservations(aps []AccessPoint, clients []Client) {
+	if !observationsEnabled() {
+		return
+	}
+	observationsMutex.Lock()
+	defer observationsMutex.Unlock()
+	now := time.Now()
+	var out []Observation
+	for _, ap := range aps {
+		if ap.LastSeen.After(lastObserved[ap.MAC]) {
+			out = append(out, Observation{Time: now, Kind: "ap", MAC: ap.MAC, FirstSeen: ap.FirstSeen, LastSeen: ap.LastSeen,
+				Power: ap.Power, Name: ap.Name, Channel: ap.Channel, Privacy: ap.Privacy, Source: ap.Source})
+		}
+	}
+	for _, c := range clients {
+		if c.LastSeen.After(lastObserved[c.MAC]) {
+			out = append(out, Observation{Time: now, Kind: "client", MAC: c.MAC, FirstSeen: c.FirstSeen, LastSeen: c.LastSeen,
+				Power: c.Power, Packets: c.Packets, BSSID: c.BSSID, Probes: c.Probes, Source: c.Source})
+		}
+	}
+	var sql strings.Builder
+	if len(out) > 0 {
+		sql.WriteString("BEGIN;\n")
+		for _, o := range out {
+			fmt.Fprintf(&sql, "INSERT INTO observations ("+observationColumns+") VALUES (%d, %s, %s, %d, %d, %d, %d, %s, %s, %d, %s, %s, %s);\n",
+				nanos(o.Time), sqlQuote(o.Kind), sqlQuote(o.MAC), nanos(o.FirstSeen), nanos(o.LastSeen), o.Power, o.Packets,
+				sqlQuote(o.BSSID), sqlQuote(o.Name), o.Channel, sqlQuote(o.Privacy), sqlQuote(o.Probes), sqlQuote(o.Source))
+		}
+		sql.WriteString("COMMIT;\n")
+	}
+	prune := now.Sub(observationsPruned) >= observationsPruneInterval
+	if prune {
+		observationsPruned = now
+		for mac, lastSeen := range lastObserved {
+			if now.Sub(lastSeen) > observationsPruneInterval {
+				delete(lastObserved, mac)
+			}
+		}
+		if *dbDays > 0 {
+			fmt.Fprintf(&sql, "DELETE FROM observations WHERE time < %d;\n", nanos(now.Add(-time.Duration(*dbDays)*24*time.Hour)))
+		}
+	}
+	if sql.Len() == 0 {
+		return
+	}
+	if err := sqlite(observationsPath(), sql.String(), nil); err != nil {
+		check(err, "Cannot save observations:")
+		return
+	}
+	for _, o := range out {
+		lastObserved[o.MAC] = o.LastSeen
+	}
+}
+
+// the observations between two times, of one device if mac isn't empty and of one kind if kind isn't, the
+// latest limit of them oldest first
+func queryObservations(mac, kind string, from, to time.Time, limit int) ([]Observation, error) {
+	where := fmt.Sprintf("time >= %d AND time <= %d", nanos(from), nanos(to))
+	if mac != "" {
+		where += " AND mac = " + sqlQuote(mac)
+	}
+	if kind != "" {
+		where += " AND kind = " + sqlQuote(kind)
+	}
+	found := []Observation{}
+	err := queryObservationRows("SELECT * FROM (SELECT "+observationColumns+" FROM observations WHERE "+where+
+		fmt.Sprintf(" ORDER BY time DESC LIMIT %d) ORDER BY time;", limit), func(o Observation) {
+		found = append(found, o)
+	})
+	return found, err
+}
+
+// the access points and clients as they were at a time, the ones last seen within the window before it
+func observedAt(at time.Time, window time.Duration) ([]AccessPoint, []Client, error) {
+	// a device is observed when it is seen anew, so its latest observation is in the window too
+	latest := make(map[string]Observation)
+	err := queryObservationRows(fmt.Sprintf("SELECT "+observationColumns+" FROM observations WHERE time >= %d AND time <= %d "+
+		"AND last_seen >= %d ORDER BY time;", nanos(at.Add(-window)), nanos(at), nanos(at.Add(-window))), func(o Observation) {
+		latest[o.MAC] = o
+	})
+	aps, clients := []AccessPoint{}, []Client{}
+	deviceMetaMutex.Lock()
+	defer deviceMetaMutex.Unlock()
+	for _, o := range latest {
+		if o.Kind == "ap" {
+			aps = append(aps, AccessPoint{MAC: o.MAC, FirstSeen: o.FirstSeen, LastSeen: o.LastSeen, Channel: o.Channel,
+				Privacy: o.Privacy, Power: o.Power, Name: o.Name, Source: o.Source, DeviceMeta: deviceMeta[o.MAC]})
+		} else {
+			clients = append(clients, Client{MAC: o.MAC, FirstSeen: o.FirstSeen, LastSeen: o.LastSeen, Power: o.Power,
+				Packets: o.Packets, BSSID: o.BSSID, Associated: o.BSSID != "", Probes: o.Probes,
+				Source: o.Source, DeviceMeta: deviceMeta[o.MAC]})
+		}
+	}
+	for i := range clients {
+		clients[i].Organization = lookupOrganization(clients[i].MAC)
+	}
+	sort.Slice(aps, func(i, j int) bool { return aps[i].MAC < aps[j].MAC })
+	sort.Slice(clients, func(i, j int) bool { return clients[i].MAC < clients[j].MAC })
+	return aps, clients, err
+}
+
+// parse the ?at= time of a request for the devices as they were, zero if there is none
+func parseAt(w http.ResponseWriter, r *http.Request) (time.Time, bool) {
+	param := r.URL.Query().Get("at")
+	if param == "" {
+		return time.Time{}, true
+	}
+	if !observationsEnabled() {
+		http.Error(w, "No observations are kept, start netnet with -db", http.StatusNotFound)
+		return time.Time{}, false
+	}
+	at, err := time.Parse(time.RFC3339, param)
+	if err != nil {
+		http.Error(w, "Invalid at parameter, use RFC 3339 ie 2024-05-01T18:00:00Z", http.StatusBadRequest)
+		return time.Time{}, false
+	}
+	return at, true
+}
+
+// the observations kept in the -db database at /observations, ?mac= of one device, ?kind=ap or client, ?from= and
+// ?to= in RFC 3339, the last 24 hours by default, and ?limit=
+func observationsHandler(w http.ResponseWriter, r *http.Request) {
+	if !observationsEnabled() {
+		http.Error(w, "No observations are kept, start netnet with -db", http.StatusNotFound)
+		return
+	}
+	q := r.URL.Query()
+	to, from := time.Now(), time.Now().Add(-24*time.Hour)
+	for name, t := range map[string]*time.Time{"from": &from, "to": &to} {
+		if q.Get(name) == "" {
+			continue
+		}
+		parsed, err := time.Parse(time.RFC3339, q.Get(name))
+		if err != nil {
+			http.Error(w, "Invalid "+name+" parameter, use RFC 3339 ie 2024-05-01T18:00:00Z", http.StatusBadRequest)
+			return
+		}
+		*t = parsed
+	}
+	mac := ""
+	if q.Get("mac") != "" {
+		m, ok := parseMAC(q.Get("mac"))
+		if !ok {
+			http.Error(w, "Invalid MAC address", http.StatusBadRequest)
+			return
+		}
+		mac = m
+	}
+	limit := maxObservations
+	if q.Get("limit") != "" {
+		n, err := strconv.Atoi(q.Get("limit"))
+		if err != nil || n <= 0 {
+			http.Error(w, "Invalid limit parameter", http.StatusBadRequest)
+			return
+		}
+		if n < limit {
+			limit = n
+		}
+	}
+	found, err := queryObservations(mac, q.Get("kind"), from, to, limit)
+	if err != nil {
+		http.Error(w, "Cannot read observations: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	for i := range found {
+		found[i].MAC, found[i].BSSID = formatMAC(found[i].MAC), formatMAC(found[i].BSSID)
+	}
+	writeJSON(w, found)
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// OccupancyConfig tunes the occupancy estimate
+type OccupancyConfig struct {
+	Window           int     `json:"window"`             // minutes a client counts as present after it was last seen, defaults to 5
+	MinPower         int     `json:"min_power"`          // ignore clients weaker than this (ie outside), defaults to -85 dBm
+	RandomizedWeight float64 `json:"randomized_weight"`  // how much a randomized probe-only MAC counts for, defaults to 0.3
+	DevicesPerPerson float64 `json:"devices_per_person"` // average number of devices a person carries, defaults to 1.3
+}
+
+// Occupancy is the estimated number of people present
+type Occupancy struct {
+	Time                 time.Time `json:"time"`
+	Window               int       `json:"window"`
+	Devices              int       `json:"devices"`
+	Universal            int       `json:"universal"`             // devices with a manufacturer assigned MAC
+	RandomizedAssociated int       `json:"randomized_associated"` // randomized MACs connected to an AP
+	RandomizedProbing    int       `json:"randomized_probing"`    // randomized MACs only probing, likely to be the same phones over and over
+	Estimate             int       `json:"estimate"`
+}
+
+func occupancySettings() OccupancyConfig {
+	c := config.Occupancy
+	if c.Window <= 0 {
+		c.Window = 5
+	}
+	if c.MinPower == 0 {
+		c.MinPower = -85
+	}
+	if c.RandomizedWeight <= 0 {
+		c.RandomizedWeight = 0.3
+	}
+	if c.DevicesPerPerson <= 0 {
+		c.DevicesPerPerson = 1.3
+	}
+	return c
+}
+
+// estimate the number of people from the clients seen within the window
+func estimateOccupancy(clients []Client, settings OccupancyConfig) (o Occupancy) {
+	o.Time = time.Now()
+	o.Window = settings.Window
+	for _, client := range filterByLastSeen(clients, settings.Window) {
+		// airodump-ng reports -1 when it has no power reading
+		if client.Power < settings.MinPower || client.Power == -1 {
+			continue
+		}
+		o.Devices++
+		switch {
+		case !isLocalMAC(client.MAC):
+			o.Universal++
+		case client.Associated:
+			o.RandomizedAssociated++
+		default:
+			o.RandomizedProbing++
+		}
+	}
+	devices := float64(o.Universal+o.RandomizedAssociated) + float64(o.RandomizedProbing)*settings.RandomizedWeight
+	o.Estimate = int(math.Round(devices / settings.DevicesPerPerson))
+	return
+}
+
+// estimated number of people present at /occupancy, the window can be changed with ?window=minutes
+func occupancy(w http.ResponseWriter, r *http.Request) {
+	settings := occupancySettings()
+	if window, err := strconv.Atoi(r.URL.Query().Get("window")); err == nil && window > 0 {
+		settings.Window = window
+	}
+	writeJSON(w, estimateOccupancy(store.Clients(), settings))
+}
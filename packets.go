@@ -0,0 +1,154 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// time of the parse the packet rates were last worked out at
+var lastPacketUpdate time.Time
+
+// an access point that hasn't sent data for this long is idle
+const apIdleAfter = 5 * time.Minute
+
+// work out the packets sent by each access point and client since the last parse from the cumulative packet counts
+func updatePacketRates(oldAPs, aps []AccessPoint, oldClients, clients []Client) {
+	now := time.Now()
+	elapsed := now.Sub(lastPacketUpdate)
+	first := lastPacketUpdate.IsZero()
+	lastPacketUpdate = now
+	if first || elapsed <= 0 {
+		return
+	}
+	updateDataRates(oldAPs, aps, now, elapsed)
+	old := make(map[string]Client, len(oldClients))
+	for _, client := range oldClients {
+		old[client.MAC] = client
+	}
+	alpha := 1 - math.Exp(-float64(elapsed)/float64(fluxSmoothing))
+	for i := range clients {
+		c := &clients[i]
+		prev, ok := old[c.MAC]
+		if !ok {
+			continue
+		}
+		c.PacketDelta = c.Packets - prev.Packets
+		// the count starts over when airodump-ng is restarted
+		if c.PacketDelta < 0 {
+			c.PacketDelta = c.Packets
+		}
+		addPackets(c, c.PacketDelta)
+		c.PacketRate = float64(c.PacketDelta) / elapsed.Minutes()
+		c.PacketRateAverage = prev.PacketRateAverage + alpha*(c.PacketRate-prev.PacketRateAverage)
+	}
+}
+
+// work out the data packets sent by each access point since the last parse and whether it is still active
+func updateDataRates(oldAPs, aps []AccessPoint, now time.Time, elapsed time.Duration) {
+	old := make(map[string]AccessPoint, len(oldAPs))
+	for _, ap := range oldAPs {
+		old[ap.MAC] = ap
+	}
+	for i := range aps {
+		ap := &aps[i]
+		prev, ok := old[ap.MAC]
+		if !ok {
+			continue
+		}
+		delta := ap.DataPackets - prev.DataPackets
+		// the count starts over when airodump-ng is restarted
+		if delta < 0 {
+			delta = ap.DataPackets
+		}
+		ap.DataRate = float64(delta) / elapsed.Minutes()
+		ap.LastData = prev.LastData
+		if delta > 0 {
+			ap.LastData = now
+		}
+		ap.Active = now.Sub(ap.LastData) < apIdleAfter
+	}
+}
+
+// PacketPoint is the packets a client sent in one interval of /clients/{mac}/packets-series
+type PacketPoint struct {
+	Time    time.Time `json:"time"` // start of the interval
+	Packets int       `json:"packets"`
+	Rate    float64   `json:"rate"` // packets a minute
+}
+
+// PacketSeries is the packets a client sent interval by interval, to chart its traffic
+type PacketSeries struct {
+	MAC      string        `json:"mac"`
+	Interval int           `json:"interval"` // seconds
+	Window   int           `json:"window"`   // minutes
+	Total    int           `json:"total"`
+	Points   []PacketPoint `json:"points"`
+}
+
+// the packets sent between the samples added up in intervals starting with the one of the first sample, intervals
+// without packets are there with 0 so the chart has no gaps
+func packetSeries(samples []Sample, interval time.Duration) []PacketPoint {
+	points := []PacketPoint{}
+	if len(samples) < 2 {
+		return points
+	}
+	start := samples[0].Time.Truncate(interval)
+	for i := 1; i < len(samples); i++ {
+		delta := samples[i].Packets - samples[i-1].Packets
+		// the count starts over when airodump-ng is restarted
+		if delta < 0 {
+			delta = samples[i].Packets
+		}
+		n := int(samples[i].Time.Sub(start) / interval)
+		for len(points) <= n {
+			points = append(points, PacketPoint{Time: start.Add(time.Duration(len(points)) * interval)})
+		}
+		points[n].Packets += delta
+	}
+	for i := range points {
+		points[i].Rate = float64(points[i].Packets) / interval.Minutes()
+	}
+	return points
+}
+
+// packets a client sent in every minute or ?interval=seconds over the last 60 minutes or ?window=minutes at
+// /clients/{mac}/packets-series, neither longer than the history kept
+func clientPacketSeries(w http.ResponseWriter, r *http.Request, mac string) {
+	s := PacketSeries{MAC: formatMAC(mac), Interval: 60, Window: 60}
+	// neither can be longer than the history kept, which also keeps the durations from overflowing
+	retention := historyRetention()
+	for name, v := range map[string]*int{"interval": &s.Interval, "window": &s.Window} {
+		param := r.URL.Query().Get(name)
+		if param == "" {
+			continue
+		}
+		n, err := strconv.Atoi(param)
+		if err != nil || n <= 0 {
+			http.Error(w, "Invalid "+name+" parameter", http.StatusBadRequest)
+			return
+		}
+		*v = n
+	}
+	if max := int(retention / time.Second); s.Interval > max {
+		s.Interval = max
+	}
+	if max := int(retention / time.Minute); s.Window > max {
+		s.Window = max
+	}
+	samples := getHistory(mac)
+	if len(samples) == 0 {
+		http.Error(w, "Client "+formatMAC(mac)+" not found", http.StatusNotFound)
+		return
+	}
+	since := time.Now().Add(-time.Duration(s.Window) * time.Minute)
+	for len(samples) > 0 && samples[0].Time.Before(since) {
+		samples = samples[1:]
+	}
+	s.Points = packetSeries(samples, time.Duration(s.Interval)*time.Second)
+	for _, p := range s.Points {
+		s.Total += p.Packets
+	}
+	writeJSON(w, s)
+}
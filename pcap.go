@@ -0,0 +1,478 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/bits"
+	"os"
+	"strings"
+	"time"
+)
+
+// link types of the pcap files airodump-ng writes
+const (
+	linkTypeIEEE80211 = 105
+	linkTypeRadiotap  = 127
+)
+
+// the largest record read from a capture file, anything bigger means the file is corrupt
+const maxCaptureRecord = 262144
+
+// the radiotap flag set when the frame ends with its FCS
+const radiotapFlagFCS = 0x10
+
+// 802.11 frame types and subtypes
+const (
+	frameManagement = 0
+	frameData       = 2
+	subtypeProbeReq = 4
+	subtypeBeacon   = 8
+)
+
+// information elements
+const (
+	elementRates    = 1
+	elementExtRates = 50
+	elementVendor   = 221
+)
+
+// Frame is an 802.11 frame read from the capture file
+type Frame struct {
+	Time    time.Time
+	Type    int
+	Subtype int
+	Addr1   string // receiver
+	Addr2   string // transmitter
+	Addr3   string // BSSID for management frames
+	ToDS    bool
+	FromDS  bool
+	Power   int    // signal in dBm from the radiotap header, 0 if unknown
+	Channel int    // from the radiotap header, 0 if unknown
+	Body    []byte // frame body after the MAC header, without the FCS
+	Partial bool   // made up from decoded fields, the body only has some of the elements
+}
+
+// Element is an information element in a management frame body
+type Element struct {
+	ID   int
+	Data []byte
+}
+
+// pcapng block types
+const (
+	blockSectionHeader   = 0x0a0d0d0a
+	blockInterface       = 1
+	blockSimplePacket    = 3
+	blockEnhancedPacket  = 6
+	optionTimeResolution = 9
+)
+
+// where the capture file was read up to, so every parse only reads the frames written since
+type captureState struct {
+	path       string
+	offset     int64
+	order      binary.ByteOrder
+	ng         bool               // pcapng as written by hcxdumptool, otherwise pcap
+	nano       bool               // pcap with nanosecond timestamps
+	linkType   uint32             // of a pcap file
+	interfaces []captureInterface // of the current section of a pcapng file
+}
+
+// captureInterface is an interface described in a pcapng file
+type captureInterface struct {
+	linkType uint32
+	units    uint64 // timestamp units per second
+}
+
+// where each capture file was read up to, by path
+var captures = make(map[string]*captureState)
+
+// the capture files, by default the pcap files airodump-ng writes next to its CSV files, dump-01.csv goes with dump-01.cap
+func captureFiles() []string {
+	if *capFile != "" {
+		return []string{*capFile}
+	}
+	switch {
+	case *collector == "airodump" && len(config.Capture) > 0:
+		return managedCaptureFiles()
+	case *collector == "airodump":
+		return []string{strings.TrimSuffix(*csvFile, ".csv") + ".cap"}
+	case *collector == "hcxdumptool":
+		return []string{hcxdumptoolFile()}
+	}
+	return nil
+}
+
+// read the frames written to the capture files since the last parse, nothing if there are no capture files,
+// or the frames that came down the pipe or were captured live for the pipe and live collectors
+func readCapture() (frames []Frame) {
+	if *collector == "pipe" || *collector == "live" {
+		return drainPipe()
+	}
+	files := captureFiles()
+	// forget the files that were replaced by newer ones
+	for path := range captures {
+		if !containsString(files, path) {
+			delete(captures, path)
+		}
+	}
+	for _, path := range files {
+		if captures[path] == nil {
+			captures[path] = &captureState{path: path}
+		}
+		frames = append(frames, captures[path].read()...)
+	}
+	return
+}
+
+// read the frames written to the capture file since it was last read
+func (c *captureState) read() (frames []Frame) {
+	file, err := os.Open(c.path)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return
+	}
+	// the capture was started over in the same file
+	if info.Size() < c.offset {
+		*c = captureState{path: c.path}
+	}
+	_, err = file.Seek(c.offset, io.SeekStart)
+	if err != nil {
+		return
+	}
+	reader := bufio.NewReader(file)
+	if c.offset == 0 {
+		magic, err := reader.Peek(4)
+		if err != nil {
+			return
+		}
+		if binary.LittleEndian.Uint32(magic) == blockSectionHeader {
+			c.ng = true
+		} else {
+			header := make([]byte, 24)
+			if _, err = io.ReadFull(reader, header); err != nil {
+				return
+			}
+			if !c.readHeader(header) {
+				fmt.Println("Cannot read capture file:", c.path, "is not a pcap file")
+				return
+			}
+			c.offset = 24
+		}
+	}
+	// a big file is read a part at a time, the next parse carries on from the offset
+	for len(frames) < maxPipeFrames {
+		// the last record can be half written, it is read again on the next parse
+		t, data, linkType, length, err := c.readRecord(reader)
+		if err != nil {
+			if err != io.EOF && err != io.ErrUnexpectedEOF {
+				fmt.Println("Cannot read capture file:", c.path, err)
+			}
+			return
+		}
+		c.offset += length
+		if linkType != linkTypeIEEE80211 && linkType != linkTypeRadiotap {
+			continue
+		}
+		if frame, ok := parseFrame(t, data, linkType); ok {
+			frames = append(frames, frame)
+		}
+	}
+	return
+}
+
+// read the byte order, timestamp precision and link type from the pcap global header
+func (c *captureState) readHeader(header []byte) bool {
+	switch binary.LittleEndian.Uint32(header[0:4]) {
+	case 0xa1b2c3d4:
+		c.order = binary.LittleEndian
+	case 0xa1b23c4d:
+		c.order, c.nano = binary.LittleEndian, true
+	case 0xd4c3b2a1:
+		c.order = binary.BigEndian
+	case 0x4d3cb2a1:
+		c.order, c.nano = binary.BigEndian, true
+	default:
+		return false
+	}
+	c.linkType = c.order.Uint32(header[20:24])
+	return true
+}
+
+// read the next packet, with the number of bytes read, blocks of a pcapng file without a packet have no data
+func (c *captureState) readRecord(reader *bufio.Reader) (t time.Time, data []byte, linkType uint32, length int64, err error) {
+	if c.ng {
+		return c.readBlock(reader)
+	}
+	header := make([]byte, 16)
+	if _, err = io.ReadFull(reader, header); err != nil {
+		return
+	}
+	sec, frac := c.order.Uint32(header[0:4]), c.order.Uint32(header[4:8])
+	size := c.order.Uint32(header[8:12])
+	if size > maxCaptureRecord {
+		err = fmt.Errorf("has a record of %d bytes", size)
+		return
+	}
+	data = make([]byte, size)
+	if _, err = io.ReadFull(reader, data); err != nil {
+		return
+	}
+	t = time.Unix(int64(sec), int64(frac)*1000)
+	if c.nano {
+		t = time.Unix(int64(sec), int64(frac))
+	}
+	return t, data, c.linkType, 16 + int64(size), nil
+}
+
+// read the next block of a pcapng file
+func (c *captureState) readBlock(reader *bufio.Reader) (t time.Time, data []byte, linkType uint32, length int64, err error) {
+	head, err := reader.Peek(12)
+	if err != nil {
+		return
+	}
+	// a section header sets the byte order of the blocks after it
+	if binary.LittleEndian.Uint32(head[0:4]) == blockSectionHeader {
+		switch binary.LittleEndian.Uint32(head[8:12]) {
+		case 0x1a2b3c4d:
+			c.order = binary.LittleEndian
+		case 0x4d3c2b1a:
+			c.order = binary.BigEndian
+		default:
+			err = fmt.Errorf("has an unknown byte order")
+			return
+		}
+		c.interfaces = nil
+	}
+	if c.order == nil {
+		err = fmt.Errorf("is not a pcapng file")
+		return
+	}
+	size := c.order.Uint32(head[4:8])
+	if size < 12 || size%4 != 0 || size > maxCaptureRecord+64 {
+		err = fmt.Errorf("has a block of %d bytes", size)
+		return
+	}
+	block := make([]byte, size)
+	if _, err = io.ReadFull(reader, block); err != nil {
+		return
+	}
+	length = int64(size)
+	body := block[8 : size-4]
+	switch c.order.Uint32(block[0:4]) {
+	case blockInterface:
+		if len(body) < 8 {
+			return
+		}
+		iface := captureInterface{linkType: uint32(c.order.Uint16(body[0:2])), units: 1000000}
+		for options := body[8:]; len(options) >= 4; {
+			code, n := c.order.Uint16(options[0:2]), int(c.order.Uint16(options[2:4]))
+			if code == 0 || len(options) < 4+n {
+				break
+			}
+			// the resolution is a power of 10, or of 2 with the top bit set, microseconds if it doesn't fit in 64 bits
+			if code == optionTimeResolution && n >= 1 {
+				exponent := options[4] & 0x7f
+				binary := options[4]&0x80 != 0
+				switch {
+				case binary && exponent > 63, !binary && exponent > 19:
+				case binary:
+					iface.units = 1 << exponent
+				default:
+					iface.units = 1
+					for i := byte(0); i < exponent; i++ {
+						iface.units *= 10
+					}
+				}
+			}
+			options = options[4+(n+3)&^3:]
+		}
+		c.interfaces = append(c.interfaces, iface)
+	case blockEnhancedPacket:
+		if len(body) < 20 {
+			return
+		}
+		// the ID and length are compared unsigned, they can overflow an int in 32 bits
+		id := c.order.Uint32(body[0:4])
+		if uint64(id) >= uint64(len(c.interfaces)) {
+			return
+		}
+		iface := c.interfaces[id]
+		ts := uint64(c.order.Uint32(body[4:8]))<<32 | uint64(c.order.Uint32(body[8:12]))
+		captured := uint64(c.order.Uint32(body[12:16]))
+		if 20+captured > uint64(len(body)) {
+			return
+		}
+		// the fraction in nanoseconds, in 128 bits as it overflows 64 with finer units than about 1e10 a second
+		hi, lo := bits.Mul64(ts%iface.units, 1000000000)
+		nanos, _ := bits.Div64(hi, lo, iface.units)
+		t = time.Unix(int64(ts/iface.units), int64(nanos))
+		return t, body[20 : 20+captured], iface.linkType, length, nil
+	case blockSimplePacket:
+		// simple packets are from the first interface and have no timestamp
+		if len(body) < 4 || len(c.interfaces) == 0 {
+			return
+		}
+		captured := uint64(c.order.Uint32(body[0:4]))
+		if 4+captured > uint64(len(body)) {
+			captured = uint64(len(body) - 4)
+		}
+		return time.Now(), body[4 : 4+captured], c.interfaces[0].linkType, length, nil
+	}
+	return
+}
+
+// LLC/SNAP header of 802.1X authentication, ie EAPOL, in a data frame
+var eapolSNAP = []byte{0xaa, 0xaa, 0x03, 0x00, 0x00, 0x00, 0x88, 0x8e}
+
+// parse an 802.11 frame, management and data frames are kept
+func parseFrame(t time.Time, data []byte, linkType uint32) (frame Frame, ok bool) {
+	var rt radiotap
+	if linkType == linkTypeRadiotap {
+		rt, ok = parseRadiotap(data)
+		if !ok {
+			return
+		}
+		data = data[rt.length:]
+	}
+	if rt.hasFCS {
+		if len(data) < 4 {
+			return frame, false
+		}
+		data = data[:len(data)-4]
+	}
+	if len(data) < 24 {
+		return frame, false
+	}
+	frame = Frame{
+		Time:    t,
+		Type:    int(data[0]>>2) & 3,
+		Subtype: int(data[0] >> 4),
+		Addr1:   macString(data[4:10]),
+		Addr2:   macString(data[10:16]),
+		Addr3:   macString(data[16:22]),
+		ToDS:    data[1]&1 != 0,
+		FromDS:  data[1]&2 != 0,
+		Power:   rt.power,
+		Channel: frequencyChannel(rt.freq),
+	}
+	header := 24
+	switch frame.Type {
+	case frameManagement:
+		// the order bit on a management frame means there is an HT control field
+		if data[1]&0x80 != 0 {
+			header += 4
+		}
+	case frameData:
+		// the body of a protected frame is of no use, the addresses and signal still are
+		if data[1]&0x40 != 0 {
+			return frame, true
+		}
+		if frame.ToDS && frame.FromDS {
+			header += 6
+		}
+		// QoS data has a QoS control field, and an HT control field with the order bit
+		if frame.Subtype&8 != 0 {
+			header += 2
+			if data[1]&0x80 != 0 {
+				header += 4
+			}
+		}
+	default:
+		return frame, false
+	}
+	if len(data) < header {
+		return frame, frame.Type == frameData
+	}
+	frame.Body = data[header:]
+	return frame, true
+}
+
+// alignment and size of the radiotap fields up to the antenna signal, by present bit
+var radiotapFields = [][2]int{{8, 8}, {1, 1}, {1, 1}, {2, 4}, {1, 2}, {1, 1}}
+
+// radiotap header fields netnet uses
+type radiotap struct {
+	length int
+	hasFCS bool
+	power  int // antenna signal in dBm, 0 if unknown
+	freq   int // channel frequency in MHz, 0 if unknown
+}
+
+// parse the radiotap header in front of a frame
+func parseRadiotap(data []byte) (rt radiotap, ok bool) {
+	if len(data) < 8 {
+		return
+	}
+	rt.length = int(binary.LittleEndian.Uint16(data[2:4]))
+	if rt.length < 8 || rt.length > len(data) {
+		return
+	}
+	// skip the extended present bitmaps to find where the fields start
+	present := binary.LittleEndian.Uint32(data[4:8])
+	offset := 8
+	for p := present; p&(1<<31) != 0 && offset+4 <= rt.length; offset += 4 {
+		p = binary.LittleEndian.Uint32(data[offset : offset+4])
+	}
+	// the fields are in the order of their bits, each aligned to its natural boundary
+	for bit, field := range radiotapFields {
+		if present&(1<<uint(bit)) == 0 {
+			continue
+		}
+		offset = (offset + field[0] - 1) / field[0] * field[0]
+		if offset+field[1] > rt.length {
+			break
+		}
+		switch bit {
+		case 1:
+			rt.hasFCS = data[offset]&radiotapFlagFCS != 0
+		case 3:
+			rt.freq = int(binary.LittleEndian.Uint16(data[offset : offset+2]))
+		case 5:
+			rt.power = int(int8(data[offset]))
+		}
+		offset += field[1]
+	}
+	return rt, true
+}
+
+// work out the channel from its frequency in MHz
+func frequencyChannel(freq int) int {
+	switch {
+	case freq == 2484:
+		return 14
+	case freq >= 2412 && freq < 2484:
+		return (freq - 2407) / 5
+	case freq >= 5955 && freq <= 7115:
+		return (freq - 5950) / 5
+	case freq >= 5000 && freq < 5955:
+		return (freq - 5000) / 5
+	case freq >= 58320 && freq <= 70200:
+		return (freq - 56160) / 2160
+	}
+	return 0
+}
+
+// split a frame body into its information elements
+func parseElements(body []byte) (elements []Element) {
+	for len(body) >= 2 {
+		length := int(body[1])
+		if len(body) < 2+length {
+			break
+		}
+		elements = append(elements, Element{ID: int(body[0]), Data: body[2 : 2+length]})
+		body = body[2+length:]
+	}
+	return
+}
+
+// write a MAC address the way netnet keeps them
+func macString(b []byte) string {
+	return strings.ToUpper(fmt.Sprintf("%02x-%02x-%02x-%02x-%02x-%02x", b[0], b[1], b[2], b[3], b[4], b[5]))
+}
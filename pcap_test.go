@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// a beacon of 02:11:22:33:44:55 for the network home
+var testBeacon = append([]byte{
+	0x80, 0x00, 0x00, 0x00, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x02, 0x11, 0x22, 0x33, 0x44, 0x55,
+	0x02, 0x11, 0x22, 0x33, 0x44, 0x55, 0x00, 0x00}, testBeaconBody...)
+
+var testBeaconBody = []byte{1, 2, 3, 4, 5, 6, 7, 8, 0x64, 0x00, 0x11, 0x04, 0, 4, 'h', 'o', 'm', 'e'}
+
+// a radiotap header with the flags (FCS at the end), the channel (2437 MHz) and the antenna signal (-40 dBm)
+var testRadiotap = []byte{0x00, 0x00, 0x0f, 0x00, 0x2a, 0x00, 0x00, 0x00, 0x10, 0x00, 0x85, 0x09, 0x00, 0x00, 0xd8}
+
+// a data frame header with the frame control bytes, from 02:11:22:33:44:55 to 02:aa:bb:cc:dd:ee
+func dataHeader(fc0, fc1 byte, size int) []byte {
+	header := []byte{fc0, fc1, 0, 0, 0x02, 0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0x02, 0x11, 0x22, 0x33, 0x44, 0x55,
+		0x02, 0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0, 0}
+	return append(header, make([]byte, size-len(header))...)
+}
+
+func TestParseFrame(t *testing.T) {
+	now := time.Now()
+	frame, ok := parseFrame(now, testBeacon, linkTypeIEEE80211)
+	if !ok || frame.Type != frameManagement || frame.Subtype != subtypeBeacon || frame.Addr2 != "02-11-22-33-44-55" ||
+		frame.Addr3 != "02-11-22-33-44-55" || !bytes.Equal(frame.Body, testBeaconBody) || !frame.Time.Equal(now) {
+		t.Errorf("beacon read as %+v %v", frame, ok)
+	}
+	if elements := parseElements(frame.Body[12:]); len(elements) != 1 || string(elements[0].Data) != "home" {
+		t.Errorf("beacon elements %v", elements)
+	}
+	data := append(append(append([]byte{}, testRadiotap...), testBeacon...), 0xde, 0xad, 0xbe, 0xef)
+	frame, ok = parseFrame(now, data, linkTypeRadiotap)
+	if !ok || frame.Power != -40 || frame.Channel != 6 || !bytes.Equal(frame.Body, testBeaconBody) {
+		t.Errorf("radiotap beacon read as %+v %v", frame, ok)
+	}
+
+	eapol := append(append([]byte{}, eapolSNAP...), 1, 3, 0, 0)
+	for _, test := range []struct {
+		data []byte
+		ok   bool
+		body []byte
+	}{
+		{dataHeader(0x08, 0x41, 40), true, nil},                        // protected, to the AP
+		{append(dataHeader(0x88, 0x03, 32), eapol...), true, eapol},    // QoS data with 4 addresses
+		{append(dataHeader(0x88, 0x81, 30), eapol...), true, eapol},    // QoS data with HT control
+		{append(dataHeader(0x08, 0x02, 24), eapol...), true, eapol},    // data from the AP
+		{dataHeader(0x88, 0x01, 24), true, nil},                        // QoS data cut in its header
+		{dataHeader(0xd4, 0x00, 24), false, nil},                       // ACK, a control frame
+		{testBeacon[:23], false, nil},                                  // shorter than a header
+		{append(dataHeader(0x80, 0x80, 28), 0, 0), true, []byte{0, 0}}, // beacon with HT control
+	} {
+		frame, ok := parseFrame(now, test.data, linkTypeIEEE80211)
+		if ok != test.ok || ok && !bytes.Equal(frame.Body, test.body) {
+			t.Errorf("%x read as %v %x", test.data[:2], ok, frame.Body)
+		}
+	}
+}
+
+// a header or frame cut short or corrupted has to give no frame or a wrong one, never a panic
+func TestParseFrameMalformed(t *testing.T) {
+	for _, data := range [][]byte{
+		{0x00, 0x00, 0x08}, // cut in the radiotap header
+		{0x00, 0x00, 0x40, 0x00, 0x00, 0x00, 0x00, 0x00},       // header longer than the data
+		{0x00, 0x00, 0x04, 0x00, 0x00, 0x00, 0x00, 0x00},       // header shorter than its fixed part
+		{0x00, 0x00, 0x09, 0x00, 0x02, 0x00, 0x00, 0x00, 0x10}, // FCS and nothing else
+	} {
+		if frame, ok := parseFrame(time.Now(), data, linkTypeRadiotap); ok {
+			t.Errorf("%x read as %+v", data, frame)
+		}
+	}
+	// extended present bitmaps running past the header
+	data := append([]byte{0x00, 0x00, 0x0c, 0x00, 0x2a, 0x00, 0x00, 0x80, 0xff, 0xff, 0xff, 0xff}, testBeacon...)
+	if _, ok := parseFrame(time.Now(), data, linkTypeRadiotap); !ok {
+		t.Errorf("frame with unknown radiotap fields not read")
+	}
+	data = append(append(append([]byte{}, testRadiotap...), testBeacon...), 0xde, 0xad, 0xbe, 0xef)
+	for size := 0; size < len(data); size++ {
+		parseFrame(time.Now(), data[:size], linkTypeRadiotap)
+	}
+	for i := range data {
+		for _, b := range []byte{0x00, 0xff, data[i] ^ 0x80} {
+			corrupted := append([]byte{}, data...)
+			corrupted[i] = b
+			parseFrame(time.Now(), corrupted, linkTypeRadiotap)
+		}
+	}
+}
+
+// a pcapng block, little endian
+func pcapngBlock(blockType uint32, body []byte) []byte {
+	for len(body)%4 != 0 {
+		body = append(body, 0)
+	}
+	size := uint32(12 + len(body))
+	block := binary.LittleEndian.AppendUint32(nil, blockType)
+	block = binary.LittleEndian.AppendUint32(block, size)
+	block = append(block, body...)
+	return binary.LittleEndian.AppendUint32(block, size)
+}
+
+// an interface description block for 802.11 frames with the options
+func pcapngInterface(options ...byte) []byte {
+	return pcapngBlock(blockInterface, append([]byte{linkTypeIEEE80211, 0, 0, 0, 0, 0, 4, 0}, options...))
+}
+
+func pcapngPacket(id uint32, ts uint64, captured uint32, data []byte) []byte {
+	body := binary.LittleEndian.AppendUint32(nil, id)
+	body = binary.LittleEndian.AppendUint32(body, uint32(ts>>32))
+	body = binary.LittleEndian.AppendUint32(body, uint32(ts))
+	body = binary.LittleEndian.AppendUint32(body, captured)
+	body = binary.LittleEndian.AppendUint32(body, uint32(len(data)))
+	return pcapngBlock(blockEnhancedPacket, append(body, data...))
+}
+
+func TestPcapng(t *testing.T) {
+	beacon := uint32(len(testBeacon))
+	var file []byte
+	for _, block := range [][]byte{
+		pcapngBlock(blockSectionHeader, []byte{0x4d, 0x3c, 0x2b, 0x1a, 1, 0, 0, 0, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}),
+		pcapngInterface(),                          // microseconds
+		pcapngInterface(9, 0, 1, 0, 12, 0, 0, 0),   // picoseconds
+		pcapngInterface(9, 0, 1, 0, 0x9e, 0, 0, 0), // 2^-30 seconds
+		pcapngInterface(2, 0, 5, 0, 'w', 'l', 'a', 'n', '0', 0, 0, 0, 9, 0, 1, 0, 9, 0, 0, 0, 0, 0, 0, 0), // name, nanoseconds
+		pcapngInterface(9, 0, 1, 0, 20, 0, 0, 0),                                                          // 10^-20 seconds, left as microseconds
+		pcapngPacket(0, 1700000000123456, beacon, testBeacon),
+		pcapngPacket(1, 1000000*1000000000000+123456789012, beacon, testBeacon),
+		pcapngPacket(2, 5<<30|1<<29, beacon, testBeacon),
+		pcapngPacket(3, 1700000000123456789, beacon, testBeacon),
+		pcapngPacket(4, 1700000000123456, beacon, testBeacon),
+		pcapngPacket(5, 0, beacon, testBeacon),          // no such interface
+		pcapngPacket(0xffffffff, 0, beacon, testBeacon), // no such interface, negative in 32 bits
+		pcapngPacket(0, 0, 0xffffffff, testBeacon),      // longer than the block, negative in 32 bits
+		pcapngPacket(0, 0, beacon+3, testBeacon),        // longer than the block and its padding
+		pcapngBlock(blockSimplePacket, append([]byte{0xff, 0xff, 0xff, 0xff}, testBeacon...)),
+		pcapngBlock(blockSimplePacket, append(binary.LittleEndian.AppendUint32(nil, beacon), testBeacon...)),
+	} {
+		file = append(file, block...)
+	}
+	path := filepath.Join(t.TempDir(), "capture.pcapng")
+	if err := ioutil.WriteFile(path, file, 0600); err != nil {
+		t.Fatal(err)
+	}
+	c := &captureState{path: path}
+	frames := c.read()
+	if len(frames) != 7 || c.offset != int64(len(file)) {
+		t.Fatalf("read %d frames up to %d of %d bytes", len(frames), c.offset, len(file))
+	}
+	for i, want := range []time.Time{
+		time.Unix(1700000000, 123456000),
+		time.Unix(1000000, 123456789),
+		time.Unix(5, 500000000),
+		time.Unix(1700000000, 123456789),
+		time.Unix(1700000000, 123456000),
+	} {
+		if !frames[i].Time.Equal(want) {
+			t.Errorf("frame %d at %v, want %v", i, frames[i].Time, want)
+		}
+	}
+	// the simple packet longer than its block has the padding of the block at the end
+	for i, frame := range frames {
+		if frame.Addr2 != "02-11-22-33-44-55" || !bytes.HasPrefix(frame.Body, testBeaconBody) {
+			t.Errorf("frame %d read as %+v", i, frame)
+		}
+	}
+	// a block half written is read again on the next parse
+	c = &captureState{path: path}
+	if err := ioutil.WriteFile(path, file[:len(file)-10], 0600); err != nil {
+		t.Fatal(err)
+	}
+	if frames = c.read(); len(frames) != 6 {
+		t.Errorf("read %d frames of a file cut short", len(frames))
+	}
+	if err := ioutil.WriteFile(path, file, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if frames = c.read(); len(frames) != 1 || c.offset != int64(len(file)) {
+		t.Errorf("read %d frames after the file was written to the end", len(frames))
+	}
+}
+
+// corrupting any byte of a capture file has to give errors or wrong frames, never a panic
+func TestPcapngCorrupted(t *testing.T) {
+	file := pcapngBlock(blockSectionHeader, []byte{0x4d, 0x3c, 0x2b, 0x1a, 1, 0, 0, 0, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff})
+	file = append(file, pcapngInterface(9, 0, 1, 0, 12, 0, 0, 0)...)
+	file = append(file, pcapngPacket(0, 1700000000123456, uint32(len(testBeacon)), testBeacon)...)
+	path := filepath.Join(t.TempDir(), "corrupted.pcapng")
+	for i := range file {
+		for _, b := range []byte{0x00, 0xff, file[i] ^ 0x80} {
+			corrupted := append([]byte{}, file...)
+			corrupted[i] = b
+			if err := ioutil.WriteFile(path, corrupted, 0600); err != nil {
+				t.Fatal(err)
+			}
+			(&captureState{path: path}).read()
+		}
+	}
+}
@@ -0,0 +1,313 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// the file the users of devices are kept in
+const peopleFile = "people.json"
+
+// where a device's user came from
+const (
+	UserSourceManual = "manual"
+	UserSourceRADIUS = "radius"
+)
+
+// how the users of devices are shown outside of /admin
+const (
+	ShowName      = "name"      // display name from the directory
+	ShowUsername  = "username"  // directory username
+	ShowPseudonym = "pseudonym" // the same made-up name for the same user, so presence can be followed without knowing who
+	ShowNone      = "none"
+)
+
+// DirectoryConfig is the LDAP or Active Directory server users' details are looked up in
+type DirectoryConfig struct {
+	URL                 string `json:"url"`     // ie ldaps://dc.example.com, users are only known by their username if empty
+	BindDN              string `json:"bind_dn"` // ie CN=netnet,OU=Services,DC=example,DC=com
+	BindPassword        string `json:"bind_password"`
+	BaseDN              string `json:"base_dn"`
+	UserAttribute       string `json:"user_attribute"`       // defaults to sAMAccountName
+	NameAttribute       string `json:"name_attribute"`       // defaults to displayName
+	EmailAttribute      string `json:"email_attribute"`      // defaults to mail
+	DepartmentAttribute string `json:"department_attribute"` // defaults to department
+	CacheHours          int    `json:"cache_hours"`          // how long a user's details are kept before looking them up again, defaults to 24
+}
+
+// PeopleConfig is how the users of devices are shown
+type PeopleConfig struct {
+	Show          string `json:"show"`           // name, username, pseudonym or none, defaults to pseudonym
+	RetentionDays int    `json:"retention_days"` // users of devices not updated for this long are forgotten, except manual ones, 0 to keep them
+}
+
+// DirectoryUser is a user in the directory
+type DirectoryUser struct {
+	Username   string `json:"username"`
+	Name       string `json:"name,omitempty"`
+	Email      string `json:"email,omitempty"`
+	Department string `json:"department,omitempty"`
+}
+
+// DeviceUser is the user of a device
+type DeviceUser struct {
+	MAC string `json:"mac"`
+	DirectoryUser
+	Source  string    `json:"source"` // manual or radius
+	Updated time.Time `json:"updated"`
+	Hidden  bool      `json:"hidden,omitempty"` // never shown outside of /admin, ie the user opted out
+}
+
+type peopleData struct {
+	Salt    string                `json:"salt"` // of the pseudonyms
+	Devices map[string]DeviceUser `json:"devices"`
+}
+
+var people = peopleData{Devices: make(map[string]DeviceUser)}
+var peopleMutex sync.RWMutex
+
+// the directory's answers by username
+var directoryCache = make(map[string]directoryEntry)
+var directoryCacheMutex sync.Mutex
+
+type directoryEntry struct {
+	user    DirectoryUser
+	fetched time.Time
+}
+
+func loadPeople() {
+	peopleMutex.Lock()
+	defer peopleMutex.Unlock()
+	people = peopleData{Devices: make(map[string]DeviceUser)}
+	check(loadJSON(peopleFile, &people), "Cannot load users of devices:")
+	if people.Devices == nil {
+		people.Devices = make(map[string]DeviceUser)
+	}
+	if people.Salt == "" {
+		people.Salt = randomHex(16)
+		check(saveJSON(peopleFile, people), "Cannot save users of devices:")
+	}
+}
+
+func orDefault(s, def string) string {
+	if s == "" {
+		return def
+	}
+	return s
+}
+
+// look up a user's details in the directory, or in the cache if they were looked up recently
+func lookupDirectoryUser(username string) (DirectoryUser, error) {
+	d := config.Directory
+	user := DirectoryUser{Username: username}
+	if d.URL == "" {
+		return user, nil
+	}
+	cache := time.Duration(d.CacheHours) * time.Hour
+	if cache <= 0 {
+		cache = 24 * time.Hour
+	}
+	directoryCacheMutex.Lock()
+	entry, ok := directoryCache[strings.ToLower(username)]
+	directoryCacheMutex.Unlock()
+	if ok && time.Since(entry.fetched) < cache {
+		return entry.user, nil
+	}
+	conn, err := ldapDial(d.URL)
+	if err != nil {
+		return user, err
+	}
+	defer conn.close()
+	if d.BindDN != "" {
+		if err = conn.bind(d.BindDN, d.BindPassword); err != nil {
+			return user, err
+		}
+	}
+	name, email, department := orDefault(d.NameAttribute, "displayName"), orDefault(d.EmailAttribute, "mail"),
+		orDefault(d.DepartmentAttribute, "department")
+	found, err := conn.searchOne(d.BaseDN, orDefault(d.UserAttribute, "sAMAccountName"), username, []string{name, email, department})
+	if err != nil {
+		return user, err
+	}
+	if found == nil {
+		return user, fmt.Errorf("%s is not in the directory", username)
+	}
+	user.Name, user.Email, user.Department = found[strings.ToLower(name)], found[strings.ToLower(email)], found[strings.ToLower(department)]
+	directoryCacheMutex.Lock()
+	directoryCache[strings.ToLower(username)] = directoryEntry{user, time.Now()}
+	directoryCacheMutex.Unlock()
+	return user, nil
+}
+
+// set the user of a device, with the details from the directory if there is one, a manual user isn't replaced
+// by one from anywhere else
+func setDeviceUser(mac, username, source string) error {
+	mac = normalizeMAC(mac)
+	peopleMutex.RLock()
+	current, ok := people.Devices[mac]
+	peopleMutex.RUnlock()
+	if ok && current.Source == UserSourceManual && source != UserSourceManual {
+		return nil
+	}
+	if ok && current.Username == username && current.Source == source && time.Since(current.Updated) < time.Hour {
+		return nil
+	}
+	user, err := lookupDirectoryUser(username)
+	peopleMutex.Lock()
+	defer peopleMutex.Unlock()
+	people.Devices[mac] = DeviceUser{MAC: mac, DirectoryUser: user, Source: source, Updated: time.Now(), Hidden: current.Hidden}
+	check(saveJSON(peopleFile, people), "Cannot save users of devices:")
+	return err
+}
+
+// forget the users of devices not updated for the retention days
+func expirePeople() {
+	days := config.People.RetentionDays
+	if days <= 0 {
+		return
+	}
+	peopleMutex.Lock()
+	defer peopleMutex.Unlock()
+	changed := false
+	for mac, u := range people.Devices {
+		if u.Source != UserSourceManual && time.Since(u.Updated) > time.Duration(days)*24*time.Hour {
+			delete(people.Devices, mac)
+			changed = true
+		}
+	}
+	if changed {
+		check(saveJSON(peopleFile, people), "Cannot save users of devices:")
+	}
+}
+
+// the user of a device as the people configuration allows it to be shown, empty if it can't be or there is none
+func shownUser(mac string) string {
+	peopleMutex.RLock()
+	defer peopleMutex.RUnlock()
+	u, ok := people.Devices[mac]
+	if !ok || u.Hidden {
+		return ""
+	}
+	switch orDefault(config.People.Show, ShowPseudonym) {
+	case ShowName:
+		return orDefault(u.Name, u.Username)
+	case ShowUsername:
+		return u.Username
+	case ShowPseudonym:
+		h := hmac.New(sha256.New, []byte(people.Salt))
+		h.Write([]byte(strings.ToLower(u.Username)))
+		return "Person " + hex.EncodeToString(h.Sum(nil))[:6]
+	}
+	return ""
+}
+
+// put the users of the clients on them
+func applyPeople(clients []Client) {
+	expirePeople()
+	for i := range clients {
+		clients[i].User = shownUser(clients[i].MAC)
+	}
+}
+
+// PersonPresence is a user with the devices they are using at /people
+type PersonPresence struct {
+	User     string    `json:"user"`
+	Devices  []string  `json:"devices"`
+	Present  bool      `json:"present"` // any of the devices was seen in the last 5 minutes
+	LastSeen time.Time `json:"last_seen"`
+}
+
+func getPresence(clients []Client) []PersonPresence {
+	byUser := make(map[string]*PersonPresence)
+	for _, c := range clients {
+		if c.User == "" {
+			continue
+		}
+		p, ok := byUser[c.User]
+		if !ok {
+			p = &PersonPresence{User: c.User}
+			byUser[c.User] = p
+		}
+		p.Devices = append(p.Devices, formatMAC(c.MAC))
+		p.Present = p.Present || isActive(c.LastSeen)
+		if c.LastSeen.After(p.LastSeen) {
+			p.LastSeen = c.LastSeen
+		}
+	}
+	presence := []PersonPresence{}
+	for _, p := range byUser {
+		sort.Strings(p.Devices)
+		presence = append(presence, *p)
+	}
+	sort.Slice(presence, func(i, j int) bool { return presence[i].LastSeen.After(presence[j].LastSeen) })
+	return presence
+}
+
+// the users of devices with their devices at /people, named as the people configuration allows
+func peopleHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, getPresence(store.Clients()))
+}
+
+// the users of every device at /admin/people, PUT /admin/people/{mac} {"username": "jdoe"} sets the user of a
+// device and {"hidden": true} keeps it from being shown, DELETE forgets it
+func adminPeople(w http.ResponseWriter, r *http.Request) {
+	mac := normalizeMAC(strings.Trim(strings.TrimPrefix(r.URL.Path, "/admin/people"), "/"))
+	switch {
+	case mac == "" && r.Method == http.MethodGet:
+		peopleMutex.RLock()
+		users := []DeviceUser{}
+		for _, u := range people.Devices {
+			u.MAC = formatMAC(u.MAC)
+			users = append(users, u)
+		}
+		peopleMutex.RUnlock()
+		sort.Slice(users, func(i, j int) bool { return users[i].MAC < users[j].MAC })
+		writeJSON(w, users)
+	case mac != "" && r.Method == http.MethodPut:
+		var patch struct {
+			Username *string `json:"username"`
+			Hidden   *bool   `json:"hidden"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+			http.Error(w, "Cannot parse user: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if patch.Username != nil && strings.TrimSpace(*patch.Username) != "" {
+			if err := setDeviceUser(mac, strings.TrimSpace(*patch.Username), UserSourceManual); err != nil {
+				fmt.Println("Cannot look up user in the directory:", err)
+			}
+		}
+		peopleMutex.Lock()
+		u, ok := people.Devices[mac]
+		if ok && patch.Hidden != nil {
+			u.Hidden = *patch.Hidden
+			people.Devices[mac] = u
+			check(saveJSON(peopleFile, people), "Cannot save users of devices:")
+		}
+		peopleMutex.Unlock()
+		if !ok {
+			http.Error(w, "No user for "+formatMAC(mac)+", set a username", http.StatusBadRequest)
+			return
+		}
+		requestRefresh()
+		u.MAC = formatMAC(u.MAC)
+		writeJSON(w, u)
+	case mac != "" && r.Method == http.MethodDelete:
+		peopleMutex.Lock()
+		delete(people.Devices, mac)
+		check(saveJSON(peopleFile, people), "Cannot save users of devices:")
+		peopleMutex.Unlock()
+		requestRefresh()
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// save data as a JSON file in the data directory
+func saveJSON(name string, v interface{}) error {
+	err := os.MkdirAll(*dataDir, 0700)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	file := filepath.Join(*dataDir, name)
+	err = ioutil.WriteFile(file+".tmp", data, 0600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(file+".tmp", file)
+}
+
+// load data from a JSON file in the data directory, a missing file is not an error
+func loadJSON(name string, v interface{}) error {
+	data, err := ioutil.ReadFile(filepath.Join(*dataDir, name))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
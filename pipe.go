@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// most frames kept between parses, the oldest are dropped when netnet can't keep up
+const maxPipeFrames = 100000
+
+// how long to wait before opening the named pipe again after the writer went away
+const pipeReopen = time.Second
+
+var pipeFrames []Frame
+var pipeLastFrame time.Time
+var pipeClosed bool
+var pipeMutex sync.Mutex
+
+// read the pipe collector's stream as it comes, a named pipe is opened again whenever the writer closes it
+func readPipe() {
+	for {
+		var in io.ReadCloser = os.Stdin
+		if *pipeFile != "-" {
+			file, err := os.Open(*pipeFile)
+			if err != nil {
+				fmt.Println("Cannot open pipe:", err)
+				time.Sleep(pipeReopen)
+				continue
+			}
+			in = file
+		}
+		err := readStream(bufio.NewReader(in))
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			fmt.Println("Cannot read pipe:", err)
+		}
+		in.Close()
+		if *pipeFile == "-" {
+			pipeMutex.Lock()
+			pipeClosed = true
+			pipeMutex.Unlock()
+			return
+		}
+		time.Sleep(pipeReopen)
+	}
+}
+
+// read pcap, pcapng or tshark -T ek JSON, whichever the stream starts with
+func readStream(reader *bufio.Reader) error {
+	magic, err := reader.Peek(4)
+	if err != nil {
+		return err
+	}
+	var stream captureState
+	switch binary.LittleEndian.Uint32(magic) {
+	case blockSectionHeader:
+		stream.ng = true
+	case 0xa1b2c3d4, 0xa1b23c4d, 0xd4c3b2a1, 0x4d3cb2a1:
+		header := make([]byte, 24)
+		if _, err = io.ReadFull(reader, header); err != nil {
+			return err
+		}
+		stream.readHeader(header)
+	default:
+		return readEK(reader)
+	}
+	for {
+		t, data, linkType, _, err := stream.readRecord(reader)
+		if err != nil {
+			return err
+		}
+		if linkType != linkTypeIEEE80211 && linkType != linkTypeRadiotap {
+			continue
+		}
+		if frame, ok := parseFrame(t, data, linkType); ok {
+			addPipeFrame(frame)
+		}
+	}
+}
+
+func addPipeFrame(frame Frame) {
+	pipeMutex.Lock()
+	defer pipeMutex.Unlock()
+	if len(pipeFrames) >= maxPipeFrames {
+		pipeFrames = pipeFrames[1:]
+	}
+	pipeFrames = append(pipeFrames, frame)
+	pipeLastFrame = time.Now()
+}
+
+// take the frames read from the pipe since the last parse
+func drainPipe() []Frame {
+	pipeMutex.Lock()
+	defer pipeMutex.Unlock()
+	frames := pipeFrames
+	pipeFrames = nil
+	return frames
+}
+
+// what is wrong with the pipe, if anything
+func pipeProblem() string {
+	pipeMutex.Lock()
+	defer pipeMutex.Unlock()
+	switch {
+	case pipeClosed:
+		return "pipe closed"
+	case time.Since(pipeLastFrame) > sensorStale:
+		return "nothing received"
+	}
+	return ""
+}
+
+// read the packets tshark -T ek writes, one JSON object per line with an index line before each,
+// the frames are made up from the decoded fields so they can't have handshakes or fingerprints
+func readEK(reader *bufio.Reader) error {
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), maxCaptureRecord)
+	for scanner.Scan() {
+		var packet struct {
+			Timestamp string                 `json:"timestamp"`
+			Layers    map[string]interface{} `json:"layers"`
+		}
+		if json.Unmarshal(scanner.Bytes(), &packet) != nil || packet.Layers == nil {
+			continue
+		}
+		fields := make(map[string]string)
+		flattenEK(packet.Layers, fields)
+		if frame, ok := ekFrame(packet.Timestamp, fields); ok {
+			addPipeFrame(frame)
+		}
+	}
+	return scanner.Err()
+}
+
+// collect the fields of every layer, keeping the first value of the fields that have several
+func flattenEK(layer map[string]interface{}, fields map[string]string) {
+	for key, value := range layer {
+		switch v := value.(type) {
+		case string:
+			fields[key] = v
+		case []interface{}:
+			if len(v) > 0 {
+				if s, ok := v[0].(string); ok {
+					fields[key] = s
+				}
+			}
+		case map[string]interface{}:
+			flattenEK(v, fields)
+		}
+	}
+}
+
+// get a field by its tshark name with the dots as underscores, tshark puts the protocol in front of it in some versions
+func ekField(fields map[string]string, names ...string) string {
+	for _, name := range names {
+		if v, ok := fields[name]; ok {
+			return v
+		}
+		for key, v := range fields {
+			if strings.HasSuffix(key, "_"+name) {
+				return v
+			}
+		}
+	}
+	return ""
+}
+
+// parse a number tshark wrote in decimal or hex
+func ekNumber(s string) int {
+	n, _ := strconv.ParseInt(strings.TrimSpace(s), 0, 64)
+	return int(n)
+}
+
+// make a frame from the fields tshark decoded
+func ekFrame(timestamp string, fields map[string]string) (frame Frame, ok bool) {
+	typeSubtype := ekField(fields, "wlan_fc_type_subtype")
+	if typeSubtype == "" {
+		return frame, false
+	}
+	// the timestamp is in milliseconds
+	ms, _ := strconv.ParseInt(timestamp, 10, 64)
+	ds := ekNumber(ekField(fields, "wlan_fc_ds"))
+	frame = Frame{
+		Time:    time.Unix(0, ms*int64(time.Millisecond)),
+		Type:    ekNumber(typeSubtype) >> 4,
+		Subtype: ekNumber(typeSubtype) & 0xf,
+		Addr1:   normalizeMAC(ekField(fields, "wlan_ra", "wlan_da")),
+		Addr2:   normalizeMAC(ekField(fields, "wlan_ta", "wlan_sa")),
+		Addr3:   normalizeMAC(ekField(fields, "wlan_bssid")),
+		ToDS:    ds&1 != 0,
+		FromDS:  ds&2 != 0,
+		Power:   ekNumber(ekField(fields, "wlan_radio_signal_dbm", "radiotap_dbm_antsignal")),
+		Channel: ekNumber(ekField(fields, "wlan_radio_channel")),
+		Partial: true,
+	}
+	if ms == 0 {
+		frame.Time = time.Now()
+	}
+	if frame.Type != frameManagement {
+		return frame, frame.Type == frameData
+	}
+	var elements []byte
+	if ssid := ekSSID(ekField(fields, "wlan_ssid")); ssid != "" {
+		elements = append(elements, elementSSID, byte(len(ssid)))
+		elements = append(elements, ssid...)
+	}
+	switch frame.Subtype {
+	case subtypeBeacon, subtypeProbeResp:
+		if channel := ekNumber(ekField(fields, "wlan_ds_current_channel")); channel > 0 {
+			elements = append(elements, elementDSParams, 1, byte(channel))
+		}
+		// an RSN element with CCMP and the first AKM suite
+		if akm := ekField(fields, "wlan_rsn_akms_type"); akm != "" {
+			elements = append(elements, elementRSN, 18, 1, 0, 0x00, 0x0f, 0xac, 4, 1, 0, 0x00, 0x0f, 0xac, 4, 1, 0, 0x00, 0x0f, 0xac, byte(ekNumber(akm)))
+		}
+		fixed := make([]byte, 12)
+		binary.LittleEndian.PutUint16(fixed[8:10], uint16(ekNumber(ekField(fields, "wlan_fixed_beacon"))))
+		binary.LittleEndian.PutUint16(fixed[10:12], uint16(ekNumber(ekField(fields, "wlan_fixed_capabilities"))))
+		frame.Body = append(fixed, elements...)
+	case subtypeProbeReq:
+		frame.Body = elements
+	case subtypeAssocReq, subtypeReassocReq:
+	default:
+		return frame, false
+	}
+	return frame, true
+}
+
+// older versions of tshark write the SSID as hex bytes separated by colons
+func ekSSID(s string) string {
+	if len(s) >= 2 && len(s)%3 == 2 && strings.Count(s, ":") == len(s)/3 {
+		if b, err := hex.DecodeString(strings.Replace(s, ":", "", -1)); err == nil {
+			s = string(b)
+		}
+	}
+	if len(s) > 32 {
+		s = s[:32]
+	}
+	return s
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// PipelineConfig turns stages of the ingestion pipeline off, ie the plugins while debugging an enricher
+type PipelineConfig struct {
+	Disabled []string `json:"disabled"` // names of the stages to skip, parse and store can't be skipped
+}
+
+// Ingest is what a parse works on, handed from stage to stage
+type Ingest struct {
+	First         bool
+	Frames        []Frame       // read from the capture file since the last parse
+	APs           []AccessPoint // found in this parse
+	Clients       []Client
+	OldAPs        []AccessPoint // found before this parse
+	OldClients    []Client
+	StoredAPs     []AccessPoint // found before and in this parse, put in the store
+	StoredClients []Client
+	Events        []Event // to send out at the end
+}
+
+// a stage of the ingestion pipeline, the stages run in order: parse, normalize, enrich, store and notify
+type stage struct {
+	name     string
+	kind     string
+	required bool
+	run      func(in *Ingest)
+}
+
+var pipeline = []stage{
+	{"parse", "parse", true, func(in *Ingest) {
+		in.Frames = readCapture()
+		in.APs, in.Clients = collect(in.Frames)
+	}},
+	{"sanitize", "normalize", false, func(in *Ingest) {
+		in.APs, in.Clients = sanitize(in.APs, in.Clients)
+	}},
+	{"ghosts", "normalize", false, func(in *Ingest) {
+		in.Clients = pruneGhosts(in.Clients)
+	}},
+	{"vendors", "enrich", false, func(in *Ingest) {
+		for i := range in.Clients {
+			if in.Clients[i].Organization == "" {
+				in.Clients[i].Organization = lookupOrganization(in.Clients[i].MAC)
+			}
+		}
+	}},
+	{"people", "enrich", false, func(in *Ingest) {
+		applyPeople(in.Clients)
+	}},
+	{"radius", "enrich", false, func(in *Ingest) {
+		applySessions(in.Clients)
+	}},
+	{"plugins", "enrich", false, func(in *Ingest) {
+		in.APs, in.Clients = enrich(in.APs, in.Clients)
+	}},
+	{"security", "enrich", false, func(in *Ingest) {
+		scoreAPs(in.APs)
+	}},
+	{"gps", "enrich", false, func(in *Ingest) {
+		recordSightings(in.APs)
+	}},
+	{"restore", "store", false, restoreStage},
+	{"store", "store", true, func(in *Ingest) {
+		in.StoredAPs = upsertAPs(in.OldAPs, in.APs)
+		in.StoredClients = upsertClients(in.OldClients, in.Clients)
+		updatePacketRates(in.OldAPs, in.StoredAPs, in.OldClients, in.StoredClients)
+		store.Update(in.StoredAPs, in.StoredClients)
+		check(saveDeviceMeta(false), "Cannot save device metadata:")
+	}},
+	{"history", "store", false, func(in *Ingest) {
+		recordHistory(in.StoredAPs, in.StoredClients)
+		recordObservations(in.APs, in.Clients)
+		updateFlux(in.StoredClients, in.First)
+	}},
+	{"fingerprints", "store", false, func(in *Ingest) {
+		recordFingerprints(in.Frames)
+	}},
+	{"handshakes", "store", false, func(in *Ingest) {
+		recordHandshakes(in.Frames)
+	}},
+	{"probes", "store", false, func(in *Ingest) {
+		recordProbes(in.Clients, in.Frames)
+	}},
+	{"hours", "store", false, func(in *Ingest) {
+		recordPresenceHours(in.APs, in.Clients)
+	}},
+	{"ssids", "store", false, func(in *Ingest) {
+		in.Events = append(in.Events, recordSSIDs(in.APs)...)
+	}},
+	{"mynetwork", "store", false, func(in *Ingest) {
+		in.Events = append(in.Events, recordMyClients(in.OldClients, in.StoredClients, in.StoredAPs, in.First)...)
+	}},
+	{"beacons", "store", false, func(in *Ingest) {
+		in.Events = append(in.Events, recordBeacons(in.Frames)...)
+	}},
+	{"events", "notify", false, func(in *Ingest) {
+		in.Events = append(detectEvents(in.OldAPs, in.StoredAPs, in.OldClients, in.StoredClients, in.First), in.Events...)
+	}},
+	{"updates", "notify", false, func(in *Ingest) {
+		sendDeviceUpdates(in.OldAPs, in.StoredAPs, in.OldClients, in.StoredClients, in.First)
+	}},
+	{"notify", "notify", false, func(in *Ingest) {
+		emit(in.Events)
+	}},
+	{"forward", "notify", false, forwardStage},
+	{"spill", "store", false, func(in *Ingest) {
+		in.StoredAPs, in.StoredClients = spillDevices(in.StoredAPs, in.StoredClients, in.seen(), *maxDevices)
+		store.Update(in.StoredAPs, in.StoredClients)
+	}},
+}
+
+// StageMetrics is how a stage of the ingestion pipeline has been doing, at /pipeline
+type StageMetrics struct {
+	Name     string        `json:"name"`
+	Kind     string        `json:"kind"` // parse, normalize, enrich, store or notify
+	Enabled  bool          `json:"enabled"`
+	Runs     int64         `json:"runs"`
+	Last     time.Duration `json:"last_ns"`  // how long the last run took
+	Total    time.Duration `json:"total_ns"` // how long all the runs took
+	Slowest  time.Duration `json:"slowest_ns"`
+	APs      int           `json:"aps"` // access points and clients in the parse after the last run
+	Clients  int           `json:"clients"`
+	LastRun  time.Time     `json:"last_run"`
+	Required bool          `json:"required"`
+}
+
+var stageMetrics = make(map[string]*StageMetrics)
+var stageMetricsMutex sync.RWMutex
+
+// parses run, stopped in strict mode and the time they took, for /metrics
+var parseRuns, parseHalts, parseNanos atomic.Int64
+
+func stageEnabled(s stage) bool {
+	return s.required || !containsString(config.Pipeline.Disabled, s.name)
+}
+
+// warn about the stages in the configuration that can't be disabled or don't exist
+func checkPipeline() {
+	for _, name := range config.Pipeline.Disabled {
+		found := false
+		for _, s := range pipeline {
+			if s.name != name {
+				continue
+			}
+			found = true
+			if s.required {
+				fmt.Println("Pipeline stage", name, "can't be disabled")
+			}
+		}
+		if !found {
+			fmt.Println("Unknown pipeline stage:", name)
+		}
+	}
+}
+
+// held by a parse, and by a restore to keep parses out while the data directory is swapped
+var ingestMutex sync.Mutex
+
+// run a parse through every enabled stage of the pipeline
+func ingest(first bool) {
+	ingestMutex.Lock()
+	defer ingestMutex.Unlock()
+	parseStart := time.Now()
+	defer func() {
+		parseRuns.Add(1)
+		parseNanos.Add(int64(time.Since(parseStart)))
+	}()
+	in := &Ingest{First: first}
+	in.OldAPs, in.OldClients = store.Snapshot()
+	for _, s := range pipeline {
+		if !stageEnabled(s) {
+			continue
+		}
+		start := time.Now()
+		s.run(in)
+		took := time.Since(start)
+		stageMetricsMutex.Lock()
+		m, ok := stageMetrics[s.name]
+		if !ok {
+			m = &StageMetrics{Name: s.name}
+			stageMetrics[s.name] = m
+		}
+		m.Runs++
+		m.Last, m.Total, m.LastRun = took, m.Total+took, start
+		if took > m.Slowest {
+			m.Slowest = took
+		}
+		m.APs, m.Clients = len(in.APs), len(in.Clients)
+		stageMetricsMutex.Unlock()
+		// nothing of a parse with malformed records is kept in strict mode
+		if halted() != "" {
+			parseHalts.Add(1)
+			return
+		}
+	}
+}
+
+// the stages in order with how they have been doing
+func getStageMetrics() []StageMetrics {
+	stageMetricsMutex.RLock()
+	defer stageMetricsMutex.RUnlock()
+	list := []StageMetrics{}
+	for _, s := range pipeline {
+		m := StageMetrics{Name: s.name}
+		if sm, ok := stageMetrics[s.name]; ok {
+			m = *sm
+		}
+		m.Kind, m.Enabled, m.Required = s.kind, stageEnabled(s), s.required
+		list = append(list, m)
+	}
+	return list
+}
+
+// the stages of the ingestion pipeline at /pipeline
+func pipelineHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, getStageMetrics())
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+// Enricher adds information to the access points and clients after every parse
+type Enricher interface {
+	Name() string
+	Enrich(aps []AccessPoint, clients []Client) ([]AccessPoint, []Client, error)
+}
+
+// Notifier is told about every event
+type Notifier interface {
+	Name() string
+	Notify(e Event) error
+}
+
+var enrichers []Enricher
+var notifiers []Notifier
+var pluginsMutex sync.RWMutex
+
+// RegisterEnricher adds an enricher, built-in integrations call this from init
+func RegisterEnricher(e Enricher) {
+	pluginsMutex.Lock()
+	defer pluginsMutex.Unlock()
+	enrichers = append(enrichers, e)
+}
+
+// RegisterNotifier adds a notifier, built-in integrations call this from init
+func RegisterNotifier(n Notifier) {
+	pluginsMutex.Lock()
+	defer pluginsMutex.Unlock()
+	notifiers = append(notifiers, n)
+}
+
+// run all the enrichers in turn, an enricher that fails is skipped
+func enrich(aps []AccessPoint, clients []Client) ([]AccessPoint, []Client) {
+	pluginsMutex.RLock()
+	list := enrichers
+	pluginsMutex.RUnlock()
+	for _, e := range list {
+		a, c, err := e.Enrich(aps, clients)
+		if err != nil {
+			fmt.Println("Enricher", e.Name(), "failed:", err)
+			continue
+		}
+		aps, clients = a, c
+	}
+	return aps, clients
+}
+
+// tell all the notifiers about the event
+func notify(e Event) {
+	pluginsMutex.RLock()
+	defer pluginsMutex.RUnlock()
+	for _, n := range notifiers {
+		go func(n Notifier) {
+			err := n.Notify(e)
+			if err != nil {
+				fmt.Println("Notifier", n.Name(), "failed:", err)
+			}
+		}(n)
+	}
+}
+
+// Plugin is an external command that talks to netnet with JSON over stdin and stdout,
+// so integrations can be written in any language without changing netnet.
+//
+// Enrichers get {"aps": [...], "clients": [...]} on stdin and must write the same,
+// enriched, document to stdout. Notifiers get the event on stdin and their output is ignored.
+type Plugin struct {
+	Command string   `json:"command"`
+	Args    []string `json:"args"`
+	Timeout int      `json:"timeout"` // in seconds, defaults to 30
+}
+
+// Name of the plugin
+func (p Plugin) Name() string {
+	return p.Command
+}
+
+func (p Plugin) run(input interface{}) ([]byte, error) {
+	data, err := json.Marshal(input)
+	if err != nil {
+		return nil, err
+	}
+	timeout := time.Duration(p.Timeout) * time.Second
+	if timeout <= 0 {
+		timeout = hookTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, p.Command, p.Args...)
+	cmd.Stdin = bytes.NewReader(data)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("%v %s", err, stderr.String())
+	}
+	return output, nil
+}
+
+type pluginData struct {
+	APs     []AccessPoint `json:"aps"`
+	Clients []Client      `json:"clients"`
+}
+
+// Enrich the access points and clients with an external command
+func (p Plugin) Enrich(aps []AccessPoint, clients []Client) ([]AccessPoint, []Client, error) {
+	output, err := p.run(pluginData{APs: aps, Clients: clients})
+	if err != nil {
+		return nil, nil, err
+	}
+	// aps or clients left out of the output stay as they were
+	var result struct {
+		APs     *[]AccessPoint `json:"aps"`
+		Clients *[]Client      `json:"clients"`
+	}
+	err = json.Unmarshal(output, &result)
+	if err != nil {
+		return nil, nil, err
+	}
+	if result.APs != nil {
+		aps = *result.APs
+	}
+	if result.Clients != nil {
+		clients = *result.Clients
+	}
+	return aps, clients, nil
+}
+
+// Notify an external command of the event
+func (p Plugin) Notify(e Event) error {
+	_, err := p.run(e)
+	return err
+}
+
+// register the plugins and hooks in the configuration
+func registerPlugins() {
+	for _, p := range config.Enrichers {
+		RegisterEnricher(p)
+	}
+	for _, p := range config.Notifiers {
+		RegisterNotifier(p)
+	}
+	for i := range config.Hooks {
+		hook := &config.Hooks[i]
+		if hook.QuietHours != "" {
+			_, _, err := parseQuietHours(hook.QuietHours)
+			check(err, "Hook "+hook.Name()+" ignores its quiet hours:")
+		}
+		RegisterNotifier(hook)
+	}
+	for i := range config.Webhooks {
+		RegisterNotifier(&config.Webhooks[i])
+	}
+	RegisterEnricher(&scriptEnricher{dir: *scriptsDir})
+}
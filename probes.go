@@ -0,0 +1,110 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+// probes for the same SSID closer together than this are one record
+const probeRepeat = 5 * time.Minute
+
+// most probe records kept per client, the oldest are dropped
+const maxProbeRecords = 1000
+
+// ProbeRecord is a client probing for an SSID, once or again and again until Last
+type ProbeRecord struct {
+	Time  time.Time `json:"time"`
+	Last  time.Time `json:"last"`
+	SSID  string    `json:"ssid"`
+	Count int       `json:"count"` // probe requests seen, 0 if it only came from the CSV file
+}
+
+var probeHistory = make(map[string][]ProbeRecord)
+var probeHistoryMutex sync.RWMutex
+var probeHistorySaved time.Time
+
+func loadProbeHistory() {
+	probeHistoryMutex.Lock()
+	defer probeHistoryMutex.Unlock()
+	probeHistory = make(map[string][]ProbeRecord)
+	check(loadJSON("probes.json", &probeHistory), "Cannot load probe history:")
+}
+
+// record when the clients probed for which SSIDs, from the probe requests captured and,
+// for the SSIDs no probe request was captured for, from when they showed up in a client's probes
+func recordProbes(clients []Client, frames []Frame) {
+	probeHistoryMutex.Lock()
+	defer probeHistoryMutex.Unlock()
+	changed := false
+	for _, frame := range frames {
+		if frame.Type != frameManagement || frame.Subtype != subtypeProbeReq {
+			continue
+		}
+		for _, e := range parseElements(frame.Body) {
+			if ssid := sanitizeName(string(e.Data)); e.ID == elementSSID && ssid != "" {
+				addProbe(frame.Addr2, ssid, frame.Time, 1)
+				changed = true
+			}
+		}
+	}
+	for _, c := range clients {
+		for _, ssid := range probedSSIDs(c) {
+			if !hasProbed(c.MAC, ssid) {
+				addProbe(c.MAC, ssid, c.LastSeen, 0)
+				changed = true
+			}
+		}
+	}
+	if changed && time.Since(probeHistorySaved) >= metaSaveInterval {
+		probeHistorySaved = time.Now()
+		check(saveJSON("probes.json", probeHistory), "Cannot save probe history:")
+	}
+}
+
+func hasProbed(mac, ssid string) bool {
+	for _, record := range probeHistory[mac] {
+		if record.SSID == ssid {
+			return true
+		}
+	}
+	return false
+}
+
+// add a probe to the client's records, keeping them in order of time
+func addProbe(mac, ssid string, t time.Time, count int) {
+	records := probeHistory[mac]
+	for i := len(records) - 1; i >= 0; i-- {
+		if records[i].SSID != ssid {
+			continue
+		}
+		if !t.Before(records[i].Time) && t.Sub(records[i].Last) <= probeRepeat {
+			if t.After(records[i].Last) {
+				records[i].Last = t
+			}
+			records[i].Count += count
+			return
+		}
+		break
+	}
+	records = append(records, ProbeRecord{Time: t, Last: t, SSID: ssid, Count: count})
+	for i := len(records) - 1; i > 0 && records[i].Time.Before(records[i-1].Time); i-- {
+		records[i], records[i-1] = records[i-1], records[i]
+	}
+	if len(records) > maxProbeRecords {
+		records = records[len(records)-maxProbeRecords:]
+	}
+	probeHistory[mac] = records
+}
+
+// get a copy of the probe records of a client
+func getProbeHistory(mac string) []ProbeRecord {
+	probeHistoryMutex.RLock()
+	defer probeHistoryMutex.RUnlock()
+	return append([]ProbeRecord{}, probeHistory[mac]...)
+}
+
+// when a client probed for which SSIDs at /clients/{mac}/probes, oldest first
+func clientProbes(w http.ResponseWriter, r *http.Request, mac string) {
+	writeJSON(w, getProbeHistory(mac))
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+)
+
+// CSVProfile is how to read the CSV files of an airodump-ng version or fork that writes the columns elsewhere,
+// the columns not in the profile are where airodump-ng has them
+type CSVProfile struct {
+	Name         string         `json:"name"`
+	APHeader     string         `json:"ap_header"`     // how the line before the access points starts, defaults to airodump-ng's
+	ClientHeader string         `json:"client_header"` // the line before the clients
+	APs          map[string]int `json:"aps"`           // column of the access point fields by name, the first column is 0
+	Clients      map[string]int `json:"clients"`       // column of the client fields, probes is from its column to the end
+}
+
+// the columns of airodump-ng CSV files
+var airodumpAPColumns = map[string]int{
+	"bssid": 0, "first_seen": 1, "last_seen": 2, "channel": 3, "speed": 4, "privacy": 5, "authentication": 7,
+	"power": 8, "beacons": 9, "data": 10, "essid": 13,
+}
+
+var airodumpClientColumns = map[string]int{
+	"station": 0, "first_seen": 1, "last_seen": 2, "power": 3, "packets": 4, "bssid": 5, "probes": 6,
+}
+
+// csvLayout is where the headers and columns are in a CSV file
+type csvLayout struct {
+	apHeader      string
+	clientHeader  string
+	aps           map[string]int
+	clients       map[string]int
+	apColumns     int // columns a record needs to have every field
+	clientColumns int
+}
+
+var airodumpLayout = newCSVLayout(CSVProfile{})
+
+func newCSVLayout(p CSVProfile) csvLayout {
+	l := csvLayout{apHeader: apHeader, clientHeader: clientHeader, aps: overlay(airodumpAPColumns, p.APs),
+		clients: overlay(airodumpClientColumns, p.Clients)}
+	if p.APHeader != "" {
+		l.apHeader = p.APHeader
+	}
+	if p.ClientHeader != "" {
+		l.clientHeader = p.ClientHeader
+	}
+	for _, column := range l.aps {
+		if column+1 > l.apColumns {
+			l.apColumns = column + 1
+		}
+	}
+	for _, column := range l.clients {
+		if column+1 > l.clientColumns {
+			l.clientColumns = column + 1
+		}
+	}
+	return l
+}
+
+func overlay(columns, profile map[string]int) map[string]int {
+	m := make(map[string]int, len(columns))
+	for field, column := range columns {
+		m[field] = column
+	}
+	for field, column := range profile {
+		if column >= 0 {
+			m[field] = column
+		}
+	}
+	return m
+}
+
+// the layout of the CSV profile with the name, airodump-ng's if the name is empty
+func csvLayoutFor(name string) (csvLayout, bool) {
+	if name == "" {
+		return airodumpLayout, true
+	}
+	for _, p := range config.CSVProfiles {
+		if p.Name == name {
+			return newCSVLayout(p), true
+		}
+	}
+	return csvLayout{}, false
+}
+
+// warn about the CSV profiles with fields that don't exist or negative columns, and the profiles used that don't exist
+func checkProfiles() {
+	check := func(profile, kind string, columns, known map[string]int) {
+		var fields []string
+		for field := range columns {
+			fields = append(fields, field)
+		}
+		sort.Strings(fields)
+		for _, field := range fields {
+			if _, ok := known[field]; !ok {
+				fmt.Println("Unknown", kind, "field", field, "in CSV profile", profile)
+			} else if columns[field] < 0 {
+				fmt.Println("Negative column for", kind, "field", field, "in CSV profile", profile+", it is ignored")
+			}
+		}
+	}
+	for _, p := range config.CSVProfiles {
+		check(p.Name, "access point", p.APs, airodumpAPColumns)
+		check(p.Name, "client", p.Clients, airodumpClientColumns)
+	}
+	used := []string{*csvProfile}
+	for _, s := range config.Sources {
+		used = append(used, s.Profile)
+	}
+	for _, c := range config.Capture {
+		used = append(used, c.Profile)
+	}
+	for _, name := range used {
+		if _, ok := csvLayoutFor(name); !ok {
+			fmt.Println("Unknown CSV profile:", name)
+		}
+	}
+}
@@ -0,0 +1,429 @@
+package main
+
+import (
+	"errors"
+	"image"
+	"image/color"
+)
+
+// A small QR code encoder (byte mode, error correction level M, versions 1 to 10),
+// which is more than enough for Wi-Fi network configuration strings.
+// See ISO/IEC 18004 or https://www.thonky.com/qr-code-tutorial/ for the details.
+
+type qrVersion struct {
+	ecPerBlock int
+	groups     [][2]int // number of blocks, data codewords per block
+	alignment  []int
+}
+
+var qrVersions = []qrVersion{
+	{10, [][2]int{{1, 16}}, nil},
+	{16, [][2]int{{1, 28}}, []int{6, 18}},
+	{26, [][2]int{{1, 44}}, []int{6, 22}},
+	{18, [][2]int{{2, 32}}, []int{6, 26}},
+	{24, [][2]int{{2, 43}}, []int{6, 30}},
+	{16, [][2]int{{4, 27}}, []int{6, 34}},
+	{18, [][2]int{{4, 31}}, []int{6, 22, 38}},
+	{22, [][2]int{{2, 38}, {2, 39}}, []int{6, 24, 42}},
+	{22, [][2]int{{3, 36}, {2, 37}}, []int{6, 26, 46}},
+	{26, [][2]int{{4, 43}, {1, 44}}, []int{6, 28, 50}},
+}
+
+// version information for versions 7 and above
+var qrVersionInfo = map[int]int{7: 0x07C94, 8: 0x085BC, 9: 0x09A99, 10: 0x0A4D3}
+
+func (v qrVersion) dataCodewords() (n int) {
+	for _, g := range v.groups {
+		n += g[0] * g[1]
+	}
+	return
+}
+
+// QR code modules, true is dark
+type qrCode struct {
+	size     int
+	modules  [][]bool
+	reserved [][]bool
+}
+
+// encode the data into a QR code
+func encodeQR(data []byte) (*qrCode, error) {
+	version := 0
+	for i, v := range qrVersions {
+		countBits := 8
+		if i+1 >= 10 {
+			countBits = 16
+		}
+		if 4+countBits+8*len(data) <= 8*v.dataCodewords() {
+			version = i + 1
+			break
+		}
+	}
+	if version == 0 {
+		return nil, errors.New("data too long for QR code")
+	}
+	v := qrVersions[version-1]
+
+	// data bits
+	bits := &bitBuffer{}
+	bits.append(0x4, 4)
+	if version >= 10 {
+		bits.append(len(data), 16)
+	} else {
+		bits.append(len(data), 8)
+	}
+	for _, b := range data {
+		bits.append(int(b), 8)
+	}
+	capacity := 8 * v.dataCodewords()
+	for i := 0; i < 4 && bits.len() < capacity; i++ {
+		bits.append(0, 1)
+	}
+	for bits.len()%8 != 0 {
+		bits.append(0, 1)
+	}
+	for pad := 0; bits.len() < capacity; pad++ {
+		if pad%2 == 0 {
+			bits.append(0xEC, 8)
+		} else {
+			bits.append(0x11, 8)
+		}
+	}
+
+	// split into blocks, add error correction and interleave
+	var dataBlocks, ecBlocks [][]byte
+	codewords := bits.bytes()
+	for _, g := range v.groups {
+		for i := 0; i < g[0]; i++ {
+			block := codewords[:g[1]]
+			codewords = codewords[g[1]:]
+			dataBlocks = append(dataBlocks, block)
+			ecBlocks = append(ecBlocks, reedSolomon(block, v.ecPerBlock))
+		}
+	}
+	var final []byte
+	for i := 0; i < len(dataBlocks[len(dataBlocks)-1]); i++ {
+		for _, block := range dataBlocks {
+			if i < len(block) {
+				final = append(final, block[i])
+			}
+		}
+	}
+	for i := 0; i < v.ecPerBlock; i++ {
+		for _, block := range ecBlocks {
+			final = append(final, block[i])
+		}
+	}
+
+	// try all the masks and keep the one with the lowest penalty
+	var best *qrCode
+	bestPenalty := -1
+	for mask := 0; mask < 8; mask++ {
+		qr := newQRCode(version)
+		qr.placeData(final)
+		qr.applyMask(mask)
+		qr.placeFormat(mask)
+		if p := qr.penalty(); bestPenalty < 0 || p < bestPenalty {
+			best, bestPenalty = qr, p
+		}
+	}
+	return best, nil
+}
+
+func newQRCode(version int) *qrCode {
+	size := 17 + 4*version
+	qr := &qrCode{size: size}
+	qr.modules = make([][]bool, size)
+	qr.reserved = make([][]bool, size)
+	for i := range qr.modules {
+		qr.modules[i] = make([]bool, size)
+		qr.reserved[i] = make([]bool, size)
+	}
+	// finder patterns and separators
+	for _, p := range [][2]int{{0, 0}, {size - 7, 0}, {0, size - 7}} {
+		for dy := -1; dy <= 7; dy++ {
+			for dx := -1; dx <= 7; dx++ {
+				x, y := p[0]+dx, p[1]+dy
+				if x < 0 || y < 0 || x >= size || y >= size {
+					continue
+				}
+				dark := dx >= 0 && dx <= 6 && dy >= 0 && dy <= 6 &&
+					(dx == 0 || dx == 6 || dy == 0 || dy == 6 || (dx >= 2 && dx <= 4 && dy >= 2 && dy <= 4))
+				qr.set(x, y, dark)
+			}
+		}
+	}
+	// alignment patterns
+	positions := qrVersions[version-1].alignment
+	for _, cy := range positions {
+		for _, cx := range positions {
+			if qr.reserved[cy][cx] {
+				continue
+			}
+			for dy := -2; dy <= 2; dy++ {
+				for dx := -2; dx <= 2; dx++ {
+					dark := dx == -2 || dx == 2 || dy == -2 || dy == 2 || (dx == 0 && dy == 0)
+					qr.set(cx+dx, cy+dy, dark)
+				}
+			}
+		}
+	}
+	// timing patterns
+	for i := 8; i < size-8; i++ {
+		qr.set(i, 6, i%2 == 0)
+		qr.set(6, i, i%2 == 0)
+	}
+	// dark module and the reserved format areas
+	qr.set(8, size-8, true)
+	for i := 0; i < 9; i++ {
+		qr.reserved[8][i] = true
+		qr.reserved[i][8] = true
+	}
+	for i := 0; i < 8; i++ {
+		qr.reserved[8][size-1-i] = true
+		qr.reserved[size-1-i][8] = true
+	}
+	// version information
+	if info, ok := qrVersionInfo[version]; ok {
+		for i := 0; i < 18; i++ {
+			dark := (info>>uint(i))&1 == 1
+			a, b := size-11+i%3, i/3
+			qr.set(a, b, dark)
+			qr.set(b, a, dark)
+		}
+	}
+	return qr
+}
+
+func (qr *qrCode) set(x, y int, dark bool) {
+	qr.modules[y][x] = dark
+	qr.reserved[y][x] = true
+}
+
+// place the codewords in the zigzag pattern, from the bottom right corner
+func (qr *qrCode) placeData(data []byte) {
+	i := 0
+	upward := true
+	for right := qr.size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+		for n := 0; n < qr.size; n++ {
+			y := n
+			if upward {
+				y = qr.size - 1 - n
+			}
+			for _, x := range []int{right, right - 1} {
+				if qr.reserved[y][x] {
+					continue
+				}
+				if i < len(data)*8 {
+					qr.modules[y][x] = (data[i/8]>>uint(7-i%8))&1 == 1
+				}
+				i++
+			}
+		}
+		upward = !upward
+	}
+}
+
+func (qr *qrCode) applyMask(mask int) {
+	for y := 0; y < qr.size; y++ {
+		for x := 0; x < qr.size; x++ {
+			if qr.reserved[y][x] {
+				continue
+			}
+			var invert bool
+			switch mask {
+			case 0:
+				invert = (x+y)%2 == 0
+			case 1:
+				invert = y%2 == 0
+			case 2:
+				invert = x%3 == 0
+			case 3:
+				invert = (x+y)%3 == 0
+			case 4:
+				invert = (y/2+x/3)%2 == 0
+			case 5:
+				invert = (x*y)%2+(x*y)%3 == 0
+			case 6:
+				invert = ((x*y)%2+(x*y)%3)%2 == 0
+			case 7:
+				invert = ((x+y)%2+(x*y)%3)%2 == 0
+			}
+			if invert {
+				qr.modules[y][x] = !qr.modules[y][x]
+			}
+		}
+	}
+}
+
+// format information for error correction level M with the mask
+func (qr *qrCode) placeFormat(mask int) {
+	data := mask // level M is 00
+	rem := data
+	for i := 0; i < 10; i++ {
+		rem = (rem << 1) ^ ((rem >> 9) * 0x537)
+	}
+	bits := ((data << 10) | rem) ^ 0x5412
+	bit := func(i int) bool { return (bits>>uint(i))&1 == 1 }
+	size := qr.size
+	for i := 0; i <= 5; i++ {
+		qr.modules[i][8] = bit(i)
+	}
+	qr.modules[7][8] = bit(6)
+	qr.modules[8][8] = bit(7)
+	qr.modules[8][7] = bit(8)
+	for i := 9; i < 15; i++ {
+		qr.modules[8][14-i] = bit(i)
+	}
+	for i := 0; i < 8; i++ {
+		qr.modules[8][size-1-i] = bit(i)
+	}
+	for i := 8; i < 15; i++ {
+		qr.modules[size-15+i][8] = bit(i)
+	}
+}
+
+// penalty score of the QR code used to pick the best mask
+func (qr *qrCode) penalty() (score int) {
+	size := qr.size
+	at := func(x, y int, horizontal bool) bool {
+		if horizontal {
+			return qr.modules[y][x]
+		}
+		return qr.modules[x][y]
+	}
+	dark := 0
+	for _, horizontal := range []bool{true, false} {
+		for y := 0; y < size; y++ {
+			run := 1
+			for x := 1; x < size; x++ {
+				if at(x, y, horizontal) == at(x-1, y, horizontal) {
+					run++
+					continue
+				}
+				if run >= 5 {
+					score += run - 2
+				}
+				run = 1
+			}
+			if run >= 5 {
+				score += run - 2
+			}
+			// finder-like patterns
+			for x := 0; x+6 < size; x++ {
+				if at(x, y, horizontal) && !at(x+1, y, horizontal) && at(x+2, y, horizontal) &&
+					at(x+3, y, horizontal) && at(x+4, y, horizontal) && !at(x+5, y, horizontal) && at(x+6, y, horizontal) {
+					score += 40
+				}
+			}
+		}
+	}
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			if qr.modules[y][x] {
+				dark++
+			}
+			if x+1 < size && y+1 < size {
+				c := qr.modules[y][x]
+				if qr.modules[y][x+1] == c && qr.modules[y+1][x] == c && qr.modules[y+1][x+1] == c {
+					score += 3
+				}
+			}
+		}
+	}
+	deviation := dark*100/(size*size) - 50
+	if deviation < 0 {
+		deviation = -deviation
+	}
+	score += deviation / 5 * 10
+	return
+}
+
+// render the QR code as an image with each module scale pixels wide and a quiet zone of 4 modules
+func (qr *qrCode) image(scale int) image.Image {
+	border := 4
+	width := (qr.size + 2*border) * scale
+	img := image.NewGray(image.Rect(0, 0, width, width))
+	for y := 0; y < width; y++ {
+		for x := 0; x < width; x++ {
+			mx, my := x/scale-border, y/scale-border
+			c := color.Gray{255}
+			if mx >= 0 && my >= 0 && mx < qr.size && my < qr.size && qr.modules[my][mx] {
+				c = color.Gray{0}
+			}
+			img.SetGray(x, y, c)
+		}
+	}
+	return img
+}
+
+type bitBuffer struct {
+	bits []bool
+}
+
+func (b *bitBuffer) append(value, length int) {
+	for i := length - 1; i >= 0; i-- {
+		b.bits = append(b.bits, (value>>uint(i))&1 == 1)
+	}
+}
+
+func (b *bitBuffer) len() int {
+	return len(b.bits)
+}
+
+func (b *bitBuffer) bytes() []byte {
+	out := make([]byte, (len(b.bits)+7)/8)
+	for i, bit := range b.bits {
+		if bit {
+			out[i/8] |= 1 << uint(7-i%8)
+		}
+	}
+	return out
+}
+
+// Reed-Solomon error correction codewords over GF(256)
+func reedSolomon(data []byte, n int) []byte {
+	var exp [512]byte
+	var log [256]int
+	x := 1
+	for i := 0; i < 255; i++ {
+		exp[i] = byte(x)
+		log[x] = i
+		x <<= 1
+		if x&0x100 != 0 {
+			x ^= 0x11D
+		}
+	}
+	for i := 255; i < 512; i++ {
+		exp[i] = exp[i-255]
+	}
+	mul := func(a, b byte) byte {
+		if a == 0 || b == 0 {
+			return 0
+		}
+		return exp[log[a]+log[b]]
+	}
+	// generator polynomial
+	gen := []byte{1}
+	for i := 0; i < n; i++ {
+		next := make([]byte, len(gen)+1)
+		for j, g := range gen {
+			next[j] ^= g
+			next[j+1] ^= mul(g, exp[i])
+		}
+		gen = next
+	}
+	rem := make([]byte, n)
+	for _, d := range data {
+		factor := d ^ rem[0]
+		copy(rem, rem[1:])
+		rem[n-1] = 0
+		for j := 0; j < n; j++ {
+			rem[j] ^= mul(gen[j+1], factor)
+		}
+	}
+	return rem
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// directory in the data directory where a sensor keeps the batches the central server hasn't got yet,
+// one file a batch named by when it was made so they are sent in order
+const forwardQueueDir = "forward"
+
+// ForwardStatus is how sending to the central server is going, in /status of a sensor
+type ForwardStatus struct {
+	Central   string    `json:"central"`
+	Queued    int       `json:"queued"` // batches waiting on disk for the central server
+	Bytes     int64     `json:"bytes"`
+	Dropped   int64     `json:"dropped"` // oldest batches thrown away to stay under -forward-queue
+	LastSent  time.Time `json:"last_sent"`
+	LastError string    `json:"last_error,omitempty"`
+}
+
+var forwardStatus ForwardStatus
+var forwardMutex sync.Mutex // held while the queue is changed
+
+// only one replay of the queue at a time
+var replaying atomic.Bool
+
+func queueDir() string {
+	return filepath.Join(*dataDir, forwardQueueDir)
+}
+
+// send a batch to the central server, or queue it on disk if the central server can't be reached or older
+// batches are still waiting so they arrive in order
+func forwardOrQueue(b Batch) {
+	waiting := queuedBatches() > 0
+	if !waiting {
+		err := sendBatch(b)
+		if err == nil {
+			return
+		}
+		fmt.Println("Cannot send to the central server, queuing:", err)
+	}
+	if err := queueBatch(b); err != nil {
+		fmt.Println("Cannot queue batch for the central server:", err)
+		return
+	}
+	if waiting {
+		replayQueue()
+	}
+}
+
+// send a batch with when it was sent by the sensor clock, which tells the central server how old it is
+func sendBatch(b Batch) error {
+	b.Sent = time.Now()
+	err := forwardBatch(b)
+	forwardMutex.Lock()
+	defer forwardMutex.Unlock()
+	if err != nil {
+		forwardStatus.LastError = err.Error()
+		return err
+	}
+	forwardStatus.LastSent, forwardStatus.LastError = b.Sent, ""
+	return nil
+}
+
+func queueBatch(b Batch) error {
+	data, err := json.Marshal(b)
+	if err != nil {
+		return err
+	}
+	forwardMutex.Lock()
+	defer forwardMutex.Unlock()
+	if err = os.MkdirAll(queueDir(), 0700); err != nil {
+		return err
+	}
+	name := filepath.Join(queueDir(), fmt.Sprintf("%d.json", b.Written.UnixNano()))
+	if err = ioutil.WriteFile(name, data, 0600); err != nil {
+		return err
+	}
+	trimQueue(int64(*forwardQueue) << 20)
+	return nil
+}
+
+// the files of the queued batches, oldest first
+func queueFiles() []os.FileInfo {
+	entries, err := ioutil.ReadDir(queueDir())
+	if err != nil {
+		return nil
+	}
+	var files []os.FileInfo
+	for _, e := range entries {
+		if !e.IsDir() && strings.HasSuffix(e.Name(), ".json") {
+			files = append(files, e)
+		}
+	}
+	// the names are all the same length until 2286
+	sort.Slice(files, func(i, j int) bool { return files[i].Name() < files[j].Name() })
+	return files
+}
+
+func queuedBatches() int {
+	forwardMutex.Lock()
+	defer forwardMutex.Unlock()
+	return len(queueFiles())
+}
+
+// drop the oldest batches until the queue is at most max bytes, keeping the newest batch even if it is bigger,
+// the caller holds the lock
+func trimQueue(max int64) {
+	files := queueFiles()
+	var size int64
+	for _, f := range files {
+		size += f.Size()
+	}
+	dropped := 0
+	for len(files) > 1 && size > max {
+		if os.Remove(filepath.Join(queueDir(), files[0].Name())) == nil {
+			dropped++
+		}
+		size -= files[0].Size()
+		files = files[1:]
+	}
+	if dropped > 0 {
+		forwardStatus.Dropped += int64(dropped)
+		fmt.Println("Forward queue is full, dropped the", dropped, "oldest batches")
+	}
+}
+
+// send the queued batches oldest first in the background until the queue is empty or the central server can't
+// be reached, the next parse tries again
+func replayQueue() {
+	if !replaying.CompareAndSwap(false, true) {
+		return
+	}
+	go func() {
+		defer replaying.Store(false)
+		sent := 0
+		for {
+			forwardMutex.Lock()
+			files := queueFiles()
+			forwardMutex.Unlock()
+			if len(files) == 0 {
+				break
+			}
+			name := filepath.Join(queueDir(), files[0].Name())
+			data, err := ioutil.ReadFile(name)
+			var b Batch
+			if err == nil {
+				err = json.Unmarshal(data, &b)
+			}
+			if err != nil {
+				fmt.Println("Dropping unreadable queued batch", files[0].Name()+":", err)
+				os.Remove(name)
+				continue
+			}
+			if sendBatch(b) != nil {
+				break
+			}
+			os.Remove(name)
+			sent++
+		}
+		if sent > 0 {
+			fmt.Println("Sent", sent, "queued batches to the central server")
+		}
+	}()
+}
+
+// how sending to the central server is going, nil if netnet isn't a sensor
+func getForwardStatus() *ForwardStatus {
+	if *centralURL == "" {
+		return nil
+	}
+	forwardMutex.Lock()
+	defer forwardMutex.Unlock()
+	s := forwardStatus
+	s.Central = *centralURL
+	for _, f := range queueFiles() {
+		s.Queued++
+		s.Bytes += f.Size()
+	}
+	return &s
+}
@@ -0,0 +1,389 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/md5"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// https://datatracker.ietf.org/doc/html/rfc2866, only the accounting a NAS sends about its 802.1X sessions
+
+// RADIUS codes and attributes used
+const (
+	radiusAccountingRequest  = 4
+	radiusAccountingResponse = 5
+
+	radiusUserName           = 1
+	radiusNASIPAddress       = 4
+	radiusFramedIPAddress    = 8
+	radiusCalledStationID    = 30
+	radiusCallingStationID   = 31
+	radiusNASIdentifier      = 32
+	radiusAcctStatusType     = 40
+	radiusAcctInputOctets    = 42
+	radiusAcctOutputOctets   = 43
+	radiusAcctSessionID      = 44
+	radiusAcctSessionTime    = 46
+	radiusAcctInputGigawords = 52
+	radiusAcctOutputGiga     = 53
+)
+
+// how long a session is kept after it stopped or was last updated
+const radiusSessionTTL = 24 * time.Hour
+
+// how often a FreeRADIUS detail file is checked for new records
+const radiusDetailInterval = 5 * time.Second
+
+// RADIUSConfig is where the RADIUS accounting of an enterprise network comes from, to know who uses which client
+type RADIUSConfig struct {
+	Listen     string `json:"listen"`      // address to take Accounting-Request packets on, ie :1813, none if empty
+	Secret     string `json:"secret"`      // shared with the NAS or the RADIUS server proxying the accounting
+	DetailFile string `json:"detail_file"` // FreeRADIUS detail file to follow instead, or as well
+}
+
+// AccountingSession is a RADIUS accounting session of a client, the username is only at /admin/radius
+type AccountingSession struct {
+	MAC         string    `json:"mac"`
+	Username    string    `json:"username,omitempty"`
+	SessionID   string    `json:"session_id"`
+	NAS         string    `json:"nas,omitempty"`   // NAS-Identifier or NAS-IP-Address
+	BSSID       string    `json:"bssid,omitempty"` // from the Called-Station-Id
+	SSID        string    `json:"ssid,omitempty"`
+	IP          string    `json:"ip,omitempty"` // Framed-IP-Address
+	Start       time.Time `json:"start"`
+	Updated     time.Time `json:"updated"`
+	Stopped     bool      `json:"stopped"`
+	Duration    int       `json:"duration"` // seconds, from the NAS
+	InputBytes  uint64    `json:"input_bytes"`
+	OutputBytes uint64    `json:"output_bytes"`
+}
+
+// RADIUSStatus is how the RADIUS accounting is coming in, in /status
+type RADIUSStatus struct {
+	Listen     string    `json:"listen,omitempty"`
+	DetailFile string    `json:"detail_file,omitempty"`
+	Packets    int64     `json:"packets"`  // accounting records taken
+	Rejected   int64     `json:"rejected"` // packets with the wrong secret or malformed
+	Sessions   int       `json:"sessions"`
+	LastRecord time.Time `json:"last_record,omitempty"`
+}
+
+// the latest session of every client
+var radiusSessions = make(map[string]AccountingSession)
+var radiusStatus RADIUSStatus
+var radiusMutex sync.Mutex
+
+// clients whose user is being looked up in the directory, interim updates don't look it up again meanwhile
+var radiusLookups = make(map[string]bool)
+
+// an accounting record, the attributes by name the way FreeRADIUS writes them in a detail file
+type accountingRecord map[string]string
+
+// take an accounting record, from a packet or a detail file
+func recordAccounting(rec accountingRecord) {
+	mac, ok := parseMAC(rec["Calling-Station-Id"])
+	if !ok {
+		return
+	}
+	now := time.Now()
+	radiusMutex.Lock()
+	radiusStatus.Packets++
+	radiusStatus.LastRecord = now
+	s, known := radiusSessions[mac]
+	id := rec["Acct-Session-Id"]
+	if !known || s.SessionID != id {
+		s = AccountingSession{MAC: mac, SessionID: id, Start: now}
+	}
+	s.Updated = now
+	if user := rec["User-Name"]; user != "" {
+		s.Username = user
+	}
+	s.NAS = orDefault(rec["NAS-Identifier"], orDefault(rec["NAS-IP-Address"], s.NAS))
+	s.IP = orDefault(rec["Framed-IP-Address"], s.IP)
+	// Called-Station-Id is usually the BSSID and the SSID, ie 00-11-22-33-44-55:Corp
+	called := rec["Called-Station-Id"]
+	if bssid, ok := parseMAC(called); ok {
+		s.BSSID = bssid
+	} else if i := strings.LastIndex(called, ":"); i > 0 {
+		if bssid, ok := parseMAC(called[:i]); ok {
+			s.BSSID, s.SSID = bssid, called[i+1:]
+		}
+	}
+	if n, err := strconv.Atoi(rec["Acct-Session-Time"]); err == nil {
+		s.Duration = n
+		s.Start = now.Add(-time.Duration(n) * time.Second)
+	}
+	s.InputBytes = octets(rec["Acct-Input-Octets"], rec["Acct-Input-Gigawords"], s.InputBytes)
+	s.OutputBytes = octets(rec["Acct-Output-Octets"], rec["Acct-Output-Gigawords"], s.OutputBytes)
+	s.Stopped = rec["Acct-Status-Type"] == "Stop"
+	radiusSessions[mac] = s
+	for m, old := range radiusSessions {
+		if now.Sub(old.Updated) > radiusSessionTTL {
+			delete(radiusSessions, m)
+		}
+	}
+	lookup := s.Username != "" && !s.Stopped && !radiusLookups[mac]
+	if lookup {
+		radiusLookups[mac] = true
+	}
+	radiusMutex.Unlock()
+	if lookup {
+		// looking the user up in the directory can take a while
+		go func() {
+			if err := setDeviceUser(mac, radiusUsername(s.Username), UserSourceRADIUS); err != nil {
+				fmt.Println("Cannot look up RADIUS user in the directory:", err)
+			}
+			radiusMutex.Lock()
+			delete(radiusLookups, mac)
+			radiusMutex.Unlock()
+		}()
+	}
+}
+
+// the byte count of a session from the octets and the times they wrapped around, last if there is none
+func octets(count, gigawords string, last uint64) uint64 {
+	n, err := strconv.ParseUint(count, 10, 32)
+	if err != nil {
+		return last
+	}
+	g, _ := strconv.ParseUint(gigawords, 10, 32)
+	return g<<32 + n
+}
+
+// the username without the domain or realm, ie jdoe for EXAMPLE\jdoe or jdoe@example.com
+func radiusUsername(user string) string {
+	if i := strings.LastIndex(user, `\`); i >= 0 {
+		user = user[i+1:]
+	}
+	if i := strings.Index(user, "@"); i > 0 {
+		user = user[:i]
+	}
+	return user
+}
+
+// the accounting record in an Accounting-Request packet, checking its authenticator with the secret
+func parseAccountingRequest(packet []byte, secret string) (accountingRecord, error) {
+	if len(packet) < 20 || packet[0] != radiusAccountingRequest {
+		return nil, fmt.Errorf("not an Accounting-Request")
+	}
+	length := int(binary.BigEndian.Uint16(packet[2:4]))
+	if length < 20 || length > len(packet) {
+		return nil, fmt.Errorf("invalid length %d", length)
+	}
+	packet = packet[:length]
+	// the request authenticator is the MD5 of the packet with zeros in its place and the secret
+	h := md5.New()
+	h.Write(packet[:4])
+	h.Write(make([]byte, 16))
+	h.Write(packet[20:])
+	h.Write([]byte(secret))
+	if !bytes.Equal(h.Sum(nil), packet[4:20]) {
+		return nil, fmt.Errorf("wrong secret")
+	}
+	rec := make(accountingRecord)
+	for attrs := packet[20:]; len(attrs) > 0; {
+		if len(attrs) < 2 || attrs[1] < 2 || int(attrs[1]) > len(attrs) {
+			return nil, fmt.Errorf("malformed attribute")
+		}
+		t, v := attrs[0], attrs[2:attrs[1]]
+		attrs = attrs[attrs[1]:]
+		number := func() string {
+			if len(v) != 4 {
+				return ""
+			}
+			return strconv.FormatUint(uint64(binary.BigEndian.Uint32(v)), 10)
+		}
+		switch t {
+		case radiusUserName:
+			rec["User-Name"] = string(v)
+		case radiusNASIPAddress, radiusFramedIPAddress:
+			if len(v) == 4 {
+				name := map[byte]string{radiusNASIPAddress: "NAS-IP-Address", radiusFramedIPAddress: "Framed-IP-Address"}[t]
+				rec[name] = net.IP(v).String()
+			}
+		case radiusCalledStationID:
+			rec["Called-Station-Id"] = string(v)
+		case radiusCallingStationID:
+			rec["Calling-Station-Id"] = string(v)
+		case radiusNASIdentifier:
+			rec["NAS-Identifier"] = string(v)
+		case radiusAcctSessionID:
+			rec["Acct-Session-Id"] = string(v)
+		case radiusAcctStatusType:
+			rec["Acct-Status-Type"] = map[string]string{"1": "Start", "2": "Stop", "3": "Interim-Update"}[number()]
+		case radiusAcctInputOctets:
+			rec["Acct-Input-Octets"] = number()
+		case radiusAcctOutputOctets:
+			rec["Acct-Output-Octets"] = number()
+		case radiusAcctInputGigawords:
+			rec["Acct-Input-Gigawords"] = number()
+		case radiusAcctOutputGiga:
+			rec["Acct-Output-Gigawords"] = number()
+		case radiusAcctSessionTime:
+			rec["Acct-Session-Time"] = number()
+		}
+	}
+	return rec, nil
+}
+
+// the Accounting-Response to a request, the NAS sends the request again until it gets one
+func accountingResponse(request []byte, secret string) []byte {
+	response := []byte{radiusAccountingResponse, request[1], 0, 20}
+	h := md5.New()
+	h.Write(response)
+	h.Write(request[4:20])
+	h.Write([]byte(secret))
+	return append(response, h.Sum(nil)...)
+}
+
+// take Accounting-Request packets on the configured address
+func runRADIUS() {
+	// anyone could make up packets with an empty secret
+	if config.RADIUS.Secret == "" {
+		return
+	}
+	conn, err := net.ListenPacket("udp", config.RADIUS.Listen)
+	if err != nil {
+		fmt.Println("Cannot listen for RADIUS accounting:", err)
+		return
+	}
+	buf := make([]byte, 4096)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			fmt.Println("Cannot read RADIUS accounting:", err)
+			continue
+		}
+		rec, err := parseAccountingRequest(buf[:n], config.RADIUS.Secret)
+		if err != nil {
+			radiusMutex.Lock()
+			radiusStatus.Rejected++
+			radiusMutex.Unlock()
+			fmt.Println("Ignoring RADIUS packet from", addr, err)
+			continue
+		}
+		recordAccounting(rec)
+		conn.WriteTo(accountingResponse(buf[:n], config.RADIUS.Secret), addr)
+	}
+}
+
+// read the complete records of a FreeRADIUS detail file, a timestamp line and then the attributes, one per indented
+// line, up to a blank line
+func parseDetail(r io.Reader) []accountingRecord {
+	var records []accountingRecord
+	var rec accountingRecord
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.TrimSpace(line) == "":
+			if rec != nil {
+				records = append(records, rec)
+			}
+			rec = nil
+		case line[0] != ' ' && line[0] != '\t':
+			rec = make(accountingRecord)
+		case rec != nil:
+			if i := strings.Index(line, "="); i > 0 {
+				rec[strings.TrimSpace(line[:i])] = strings.Trim(strings.TrimSpace(line[i+1:]), `"`)
+			}
+		}
+	}
+	return records
+}
+
+// follow a FreeRADIUS detail file, reading the records written since the last check, from the start again if it
+// was rotated
+func followDetail(file string) {
+	var offset int64
+	for ; ; time.Sleep(radiusDetailInterval) {
+		f, err := os.Open(file)
+		if err != nil {
+			continue
+		}
+		info, err := f.Stat()
+		if err == nil && info.Size() < offset {
+			offset = 0
+		}
+		if err == nil && info.Size() > offset {
+			data := make([]byte, info.Size()-offset)
+			n, _ := f.ReadAt(data, offset)
+			data = data[:n]
+			// only up to the end of the last complete record, the rest is still being written
+			if end := bytes.LastIndex(data, []byte("\n\n")); end >= 0 {
+				for _, rec := range parseDetail(bytes.NewReader(data[:end+2])) {
+					recordAccounting(rec)
+				}
+				offset += int64(end + 2)
+			}
+		}
+		f.Close()
+	}
+}
+
+// start taking RADIUS accounting if it is configured, packets only with a secret to check them with
+func startRADIUS() {
+	if config.RADIUS.Listen != "" && config.RADIUS.Secret == "" {
+		fmt.Println("Not listening for RADIUS accounting on", config.RADIUS.Listen+", radius.secret is not set")
+	} else if config.RADIUS.Listen != "" {
+		go runRADIUS()
+	}
+	if config.RADIUS.DetailFile != "" {
+		go followDetail(config.RADIUS.DetailFile)
+	}
+}
+
+// put the RADIUS sessions on the clients, without the usernames which are shown as the people configuration allows
+func applySessions(clients []Client) {
+	radiusMutex.Lock()
+	defer radiusMutex.Unlock()
+	for i := range clients {
+		if s, ok := radiusSessions[clients[i].MAC]; ok {
+			s.MAC, s.Username, s.BSSID = formatMAC(s.MAC), "", formatMAC(s.BSSID)
+			clients[i].Session = &s
+		}
+	}
+}
+
+// how the RADIUS accounting is coming in, nil if there is none
+func getRADIUSStatus() *RADIUSStatus {
+	if config.RADIUS.Listen == "" && config.RADIUS.DetailFile == "" {
+		return nil
+	}
+	radiusMutex.Lock()
+	defer radiusMutex.Unlock()
+	s := radiusStatus
+	s.DetailFile, s.Sessions = config.RADIUS.DetailFile, len(radiusSessions)
+	if config.RADIUS.Secret != "" {
+		s.Listen = config.RADIUS.Listen
+	}
+	return &s
+}
+
+// the RADIUS sessions with their usernames at /admin/radius, latest first, ?active=true leaves out the stopped ones
+func adminRADIUS(w http.ResponseWriter, r *http.Request) {
+	active := r.URL.Query().Get("active") == "true"
+	radiusMutex.Lock()
+	sessions := []AccountingSession{}
+	for _, s := range radiusSessions {
+		if active && s.Stopped {
+			continue
+		}
+		s.MAC, s.BSSID = formatMAC(s.MAC), formatMAC(s.BSSID)
+		sessions = append(sessions, s)
+	}
+	radiusMutex.Unlock()
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].Updated.After(sessions[j].Updated) })
+	writeJSON(w, sessions)
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"testing"
+)
+
+// an Accounting-Request with the secret testing123, its authenticator worked out apart from netnet
+const radiusStart = "04070058a56e2ff9d3dad0515668a88635bf4e1301126a646f65406578616d706c652e636f6d1f1341412d42422d43432d4" +
+	"4442d45452d46462806000000012c0773657373312a06000003e834060000000208060a000005"
+
+func TestRADIUSAccountingRequest(t *testing.T) {
+	packet, _ := hex.DecodeString(radiusStart)
+	rec, err := parseAccountingRequest(packet, "testing123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, value := range map[string]string{
+		"User-Name":            "jdoe@example.com",
+		"Calling-Station-Id":   "AA-BB-CC-DD-EE-FF",
+		"Acct-Status-Type":     "Start",
+		"Acct-Session-Id":      "sess1",
+		"Acct-Input-Octets":    "1000",
+		"Acct-Input-Gigawords": "2",
+		"Framed-IP-Address":    "10.0.0.5",
+	} {
+		if rec[name] != value {
+			t.Errorf("%s is %q, want %q", name, rec[name], value)
+		}
+	}
+	// bytes after the length of the packet are padding, not attributes
+	if _, err = parseAccountingRequest(append(packet, 0, 0, 0), "testing123"); err != nil {
+		t.Errorf("padded packet: %v", err)
+	}
+	if _, err = parseAccountingRequest(packet, "testing124"); err == nil {
+		t.Errorf("wrong secret gave no error")
+	}
+}
+
+// cutting or corrupting the packet has to give an error, never a panic
+func TestRADIUSMalformed(t *testing.T) {
+	packet, _ := hex.DecodeString(radiusStart)
+	for size := 0; size < len(packet); size++ {
+		if _, err := parseAccountingRequest(packet[:size], "testing123"); err == nil {
+			t.Errorf("cut to %d bytes, no error", size)
+		}
+	}
+	for i := range packet {
+		for _, b := range []byte{0x00, 0x01, 0xff, packet[i] ^ 0x80} {
+			if b == packet[i] {
+				continue
+			}
+			corrupted := append([]byte{}, packet...)
+			corrupted[i] = b
+			if _, err := parseAccountingRequest(corrupted, "testing123"); err == nil {
+				t.Errorf("byte %d set to %#x, no error", i, b)
+			}
+		}
+	}
+	// well signed packets with broken attributes, with an empty secret
+	for _, attrs := range [][]byte{
+		{1},           // attribute header cut short
+		{1, 0},        // length 0
+		{1, 1},        // length 1
+		{1, 5, 'a'},   // longer than the packet
+		{31, 2, 1, 3}, // the second cut short
+	} {
+		if _, err := parseAccountingRequest(radiusPacket(attrs), ""); err == nil {
+			t.Errorf("attributes %v gave no error", attrs)
+		}
+	}
+	if rec, err := parseAccountingRequest(radiusPacket([]byte{40, 4, 0, 1, 42, 3, 1}), ""); err != nil ||
+		rec["Acct-Status-Type"] != "" || rec["Acct-Input-Octets"] != "" {
+		t.Errorf("numbers of the wrong size %v %v", rec, err)
+	}
+}
+
+// an Accounting-Request with the attributes, signed with an empty secret
+func radiusPacket(attrs []byte) []byte {
+	packet := append([]byte{radiusAccountingRequest, 1, 0, byte(20 + len(attrs))}, make([]byte, 16)...)
+	packet = append(packet, attrs...)
+	h := md5.Sum(packet)
+	copy(packet[4:20], h[:])
+	return packet
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// token bucket for a single client IP
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter limits the number of requests per second for each client IP
+type rateLimiter struct {
+	rate    float64 // tokens added per second
+	burst   float64 // maximum number of tokens
+	buckets map[string]*bucket
+	mutex   sync.Mutex
+}
+
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	limiter := &rateLimiter{
+		rate:    rate,
+		burst:   float64(burst),
+		buckets: make(map[string]*bucket),
+	}
+	go limiter.cleanup()
+	return limiter
+}
+
+// take a token for the IP, if there are none left return how long to wait for the next one
+func (l *rateLimiter) allow(ip string) (bool, time.Duration) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	now := time.Now()
+	b, ok := l.buckets[ip]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[ip] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
+
+// remove the buckets of IPs that have been quiet long enough to have a full bucket again
+func (l *rateLimiter) cleanup() {
+	for {
+		time.Sleep(time.Minute)
+		l.mutex.Lock()
+		for ip, b := range l.buckets {
+			if time.Since(b.last).Seconds()*l.rate >= l.burst {
+				delete(l.buckets, ip)
+			}
+		}
+		l.mutex.Unlock()
+	}
+}
+
+// middleware that rejects requests over the limit with 429 Too Many Requests
+func (l *rateLimiter) handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			ip = r.RemoteAddr
+		}
+		ok, wait := l.allow(ip)
+		if !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			http.Error(w, "Too many requests", http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RegistryRecord is the IEEE registry entry of an OUI or CID
+type RegistryRecord struct {
+	Prefix       string   `json:"prefix"`
+	Registry     string   `json:"registry"` // MA-L for OUIs, MA-M for the blocks of shared OUIs, CID for company IDs of local addresses
+	Organization string   `json:"organization"`
+	Address      []string `json:"address"`
+	Country      string   `json:"country"` // ISO 3166 code, empty if the registry has none
+}
+
+// the full registry records are only read when first asked for, the vendor lookups only need the names
+var registry map[string]RegistryRecord
+var registryMutex sync.Mutex
+
+// read the records of an IEEE registry file, each starts with a (hex) line, then a (base 16) line,
+// then the address with the country last, and ends with a blank line
+func parseRegistry(reader io.Reader, name string) map[string]RegistryRecord {
+	records := make(map[string]RegistryRecord)
+	var record *RegistryRecord
+	done := func() {
+		if record == nil {
+			return
+		}
+		if n := len(record.Address); n > 0 && isCountryCode(record.Address[n-1]) {
+			record.Country = record.Address[n-1]
+			record.Address = record.Address[:n-1]
+		}
+		records[record.Prefix] = *record
+		record = nil
+	}
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case strings.Contains(line, "(hex)"):
+			done()
+			fields := strings.SplitN(line, "(hex)", 2)
+			record = &RegistryRecord{
+				Prefix:       strings.TrimSpace(fields[0]),
+				Registry:     name,
+				Organization: strings.TrimSpace(fields[1]),
+				Address:      []string{},
+			}
+		case line == "":
+			done()
+		case record != nil && strings.Contains(line, "(base 16)"):
+			// an MA-M block is a range like A00000-AFFFFF of the OUI, the first digit tells the blocks apart
+			if name == "MA-M" {
+				record.Prefix += "-" + line[:1]
+			}
+		case record != nil:
+			record.Address = append(record.Address, strings.Join(strings.Fields(line), " "))
+		}
+	}
+	done()
+	return records
+}
+
+func isCountryCode(s string) bool {
+	return len(s) == 2 && strings.ToUpper(s) == s && strings.Trim(s, "ABCDEFGHIJKLMNOPQRSTUVWXYZ") == ""
+}
+
+// read the OUI and CID registries, if they haven't been read yet
+func loadRegistry() map[string]RegistryRecord {
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+	if registry != nil {
+		return registry
+	}
+	registry = make(map[string]RegistryRecord)
+	for _, db := range vendorDatabases() {
+		file, err := openDatabase(db.file, db.url)
+		if err != nil {
+			check(err, "Cannot read registry:")
+			continue
+		}
+		// CIDs have the local bit set so they never clash with OUIs, and MA-M blocks are a digit longer
+		for prefix, record := range parseRegistry(file, db.registry) {
+			registry[prefix] = record
+		}
+		file.Close()
+	}
+	return registry
+}
+
+// the registry record of the organization a MAC address belongs to
+func lookupRegistry(mac string) (RegistryRecord, bool) {
+	if len(mac) < 8 {
+		return RegistryRecord{}, false
+	}
+	registry := loadRegistry()
+	if len(mac) >= 10 {
+		if record, ok := registry[mac[:10]]; ok {
+			return record, ok
+		}
+	}
+	record, ok := registry[mac[:8]]
+	return record, ok
+}
+
+// the registry record for the vendor of a MAC address at /registry/{mac}
+func registryHandler(w http.ResponseWriter, r *http.Request) {
+	mac := normalizeMAC(strings.Trim(strings.TrimPrefix(r.URL.Path, "/registry/"), "/"))
+	record, ok := lookupRegistry(mac)
+	if !ok {
+		http.Error(w, "No registry record for "+formatMAC(mac), http.StatusNotFound)
+		return
+	}
+	writeJSON(w, record)
+}
+
+// CountryStats are the devices whose vendors are registered in one country
+type CountryStats struct {
+	Country       string   `json:"country"` // UNKNOWN if the vendor or its country isn't known
+	Clients       int      `json:"clients"`
+	APs           int      `json:"aps"`
+	Organizations []string `json:"organizations"`
+}
+
+// group the clients and access points by the country their vendor is registered in, the ones with the most devices first
+func countryStats(clients []Client, aps []AccessPoint) []CountryStats {
+	stats := make(map[string]*CountryStats)
+	add := func(mac string) *CountryStats {
+		country, org := "UNKNOWN", ""
+		if record, ok := lookupRegistry(mac); ok {
+			org = record.Organization
+			if record.Country != "" {
+				country = record.Country
+			}
+		}
+		s, ok := stats[country]
+		if !ok {
+			s = &CountryStats{Country: country, Organizations: []string{}}
+			stats[country] = s
+		}
+		if org != "" && !containsString(s.Organizations, org) {
+			s.Organizations = append(s.Organizations, org)
+		}
+		return s
+	}
+	for _, c := range clients {
+		add(c.MAC).Clients++
+	}
+	for _, ap := range aps {
+		add(ap.MAC).APs++
+	}
+	list := []CountryStats{}
+	for _, s := range stats {
+		sort.Strings(s.Organizations)
+		list = append(list, *s)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Clients+list[i].APs != list[j].Clients+list[j].APs {
+			return list[i].Clients+list[i].APs > list[j].Clients+list[j].APs
+		}
+		return list[i].Country < list[j].Country
+	})
+	return list
+}
+
+// device counts per vendor country at /stats/countries, for devices seen in the last 60 minutes or ?last=minutes
+func statsCountries(w http.ResponseWriter, r *http.Request) {
+	last := 60
+	if lastParam := r.URL.Query().Get("last"); lastParam != "" {
+		n, err := strconv.Atoi(lastParam)
+		if err != nil {
+			http.Error(w, "Invalid last parameter", http.StatusBadRequest)
+			return
+		}
+		last = n
+	}
+	var aps []AccessPoint
+	since := time.Now().Add(-time.Duration(last) * time.Minute)
+	found, clients := store.Snapshot()
+	for _, ap := range found {
+		if since.Before(ap.LastSeen) {
+			aps = append(aps, ap)
+		}
+	}
+	writeJSON(w, countryStats(filterByLastSeen(clients, last), aps))
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// biggest batch a sensor can send in, a busy place is a few MB
+const maxIngestBody = 64 << 20
+
+// how long sending a batch to the central server can take
+const forwardTimeout = 30 * time.Second
+
+// remoteSource is a sensor sending its batches to /ingest, its newest batch is merged into every parse
+type remoteSource struct {
+	name    string
+	batches chan Batch
+	mutex   sync.Mutex // held while a batch is merged with the pending one
+}
+
+func (s *remoteSource) Name() string {
+	return s.name
+}
+
+// nothing until the sensor sends its first batch
+func (s *remoteSource) Poll() (Batch, error) {
+	return Batch{Source: s.name, Problem: "no data sent yet"}, nil
+}
+
+func (s *remoteSource) Watch(stop <-chan struct{}) <-chan Batch {
+	return s.batches
+}
+
+var remoteSources = make(map[string]*remoteSource)
+var remoteSourcesMutex sync.Mutex
+
+// hand a batch from a sensor to its data source, registering the source the first time the sensor sends one
+func pushBatch(b Batch) {
+	remoteSourcesMutex.Lock()
+	s, ok := remoteSources[b.Source]
+	if !ok {
+		// buffered so a sensor sending faster than the parses doesn't wait
+		s = &remoteSource{name: b.Source, batches: make(chan Batch, 1)}
+		remoteSources[b.Source] = s
+		RegisterDataSource(s)
+	}
+	remoteSourcesMutex.Unlock()
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	select {
+	case pending := <-s.batches:
+		// merge with the batch the parse hasn't taken yet, ie when a sensor replays what it queued
+		b.APs = mergeAPs(append(pending.APs, b.APs...))
+		b.Clients = mergeClients(append(pending.Clients, b.Clients...))
+	default:
+	}
+	s.batches <- b
+}
+
+// the sensor ID of a request to /ingest, from its certificate, or else its API key or the sensor parameter
+func ingestSensorID(r *http.Request) (string, error) {
+	if id := sensorCertID(r); id != "" {
+		return id, nil
+	}
+	if *sensorCA != "" && !*authEnabled {
+		return "", errors.New("a sensor certificate is needed")
+	}
+	id := r.URL.Query().Get("sensor")
+	if key := findAPIKey(requestKey(r)); key != nil && id == "" {
+		id = key.Name
+	}
+	if id == "" {
+		return "", errors.New("missing sensor parameter")
+	}
+	return id, nil
+}
+
+// POST /ingest takes a batch from a sensor, the sensor ID is the name in its certificate so a sensor can't send
+// data as another sensor
+func ingestHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id, err := ingestSensorID(r)
+	if err != nil {
+		http.Error(w, "Unauthorized: "+err.Error(), http.StatusUnauthorized)
+		return
+	}
+	data, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxIngestBody))
+	if err != nil {
+		http.Error(w, "Cannot read batch: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	var b Batch
+	if err = json.Unmarshal(data, &b); err != nil {
+		http.Error(w, "Cannot parse batch: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	b.Source = id
+	// a batch the sensor queued while it couldn't reach the server is as old as the sensor clock says
+	now := time.Now()
+	if age := b.Sent.Sub(b.Written); !b.Sent.IsZero() && !b.Written.IsZero() && age > 0 {
+		b.Written = now.Add(-age)
+	} else {
+		b.Written = now
+	}
+	pushBatch(b)
+	recordCheckin(id, b.Version, len(data))
+	// checking in is how a sensor gets its settings
+	writeJSONStatus(w, http.StatusAccepted, settingsFor(id))
+}
+
+var forwardClient *http.Client
+
+// the client for sending batches to the central server, with the sensor certificate if there is one
+func newForwardClient() (*http.Client, error) {
+	tlsConfig := &tls.Config{}
+	if *sensorCert != "" {
+		cert, err := tls.LoadX509KeyPair(*sensorCert, *sensorKey)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	if *centralCA != "" {
+		pool, err := loadCertPool(*centralCA)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = pool
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	return &http.Client{Timeout: forwardTimeout, Transport: transport}, nil
+}
+
+// set up sending to the central server when netnet is a sensor with -central
+func setupForwarding() {
+	if *centralURL == "" {
+		return
+	}
+	client, err := newForwardClient()
+	if err != nil {
+		fmt.Println("Cannot set up sending to the central server:", err)
+		os.Exit(1)
+	}
+	forwardClient = client
+	loadSensorSettings()
+}
+
+// send what this parse found to the central server
+func forwardStage(in *Ingest) {
+	if forwardClient == nil {
+		return
+	}
+	name, _ := os.Hostname()
+	b := Batch{Source: name, Version: version, Written: time.Now(), Problem: sensorProblem(), APs: in.APs, Clients: in.Clients}
+	forwardOrQueue(b)
+	applySensorSettings()
+}
+
+func forwardBatch(b Batch) error {
+	data, err := json.Marshal(b)
+	if err != nil {
+		return err
+	}
+	ingestURL := strings.TrimSuffix(*centralURL, "/") + "/ingest?sensor=" + url.QueryEscape(b.Source)
+	resp, err := forwardClient.Post(ingestURL, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if resp.StatusCode != http.StatusAccepted {
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	if len(body) > 0 {
+		receiveSettings(body)
+	}
+	return nil
+}
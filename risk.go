@@ -0,0 +1,293 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// a device has to be seen in at least this many different hours before being seen only at odd hours counts
+const minOddHoursSightings = 3
+
+// factors that make up the risk score of a device
+const (
+	RiskUnknownVendor  = "unknown_vendor"  // a universal MAC address no vendor database knows
+	RiskCorporateProbe = "corporate_probe" // probes for one of our SSIDs without being on one of our APs
+	RiskOpenHotspot    = "open_hotspot"    // an open access point, or a client on one that isn't ours
+	RiskRogue          = "rogue"           // an access point that is probably up to no good, as at /rogues
+	RiskOddHours       = "odd_hours"       // only ever seen at odd hours
+)
+
+// points each factor adds to the risk score by default
+var riskWeights = map[string]int{
+	RiskUnknownVendor:  20,
+	RiskCorporateProbe: 30,
+	RiskOpenHotspot:    25,
+	RiskRogue:          40,
+	RiskOddHours:       25,
+}
+
+// RiskConfig tunes the risk scores of the devices
+type RiskConfig struct {
+	CorporateSSIDs []string       `json:"corporate_ssids"` // SSIDs of the organization, defaults to my_ssids and the SSIDs of our APs
+	OddHours       string         `json:"odd_hours"`       // ie 22:00-06:00, the default
+	Weights        map[string]int `json:"weights"`         // points of each factor, to change the defaults
+}
+
+// RiskFactor is one reason a device is risky
+type RiskFactor struct {
+	Factor string `json:"factor"`
+	Points int    `json:"points"`
+	Reason string `json:"reason"`
+}
+
+// RiskScore is how much attention a device deserves from 0 to 100 and why
+type RiskScore struct {
+	MAC          string       `json:"mac"`
+	Kind         string       `json:"kind"` // ap or client
+	Name         string       `json:"name,omitempty"`
+	Organization string       `json:"organization"`
+	LastSeen     time.Time    `json:"last_seen"`
+	Score        int          `json:"score"`
+	Factors      []RiskFactor `json:"factors"`
+}
+
+// PresenceHours is how many different hours a device was seen in at each hour of the day
+type PresenceHours struct {
+	Hours [24]int   `json:"hours"`
+	Last  time.Time `json:"last"` // the hour it was last counted in
+}
+
+var presenceHours = make(map[string]PresenceHours)
+var presenceHoursMutex sync.RWMutex
+var presenceHoursSaved time.Time
+
+func loadPresenceHours() {
+	presenceHoursMutex.Lock()
+	defer presenceHoursMutex.Unlock()
+	presenceHours = make(map[string]PresenceHours)
+	check(loadJSON("hours.json", &presenceHours), "Cannot load presence hours:")
+}
+
+// count the hours of the day the devices are seen in, once for every hour they are seen in
+func recordPresenceHours(aps []AccessPoint, clients []Client) {
+	presenceHoursMutex.Lock()
+	defer presenceHoursMutex.Unlock()
+	changed := false
+	count := func(mac string, lastSeen time.Time) {
+		hour := lastSeen.Truncate(time.Hour)
+		p := presenceHours[mac]
+		if lastSeen.IsZero() || !hour.After(p.Last) {
+			return
+		}
+		p.Hours[lastSeen.Local().Hour()]++
+		p.Last = hour
+		presenceHours[mac] = p
+		changed = true
+	}
+	for _, ap := range aps {
+		count(ap.MAC, ap.LastSeen)
+	}
+	for _, c := range clients {
+		count(c.MAC, c.LastSeen)
+	}
+	if changed && time.Since(presenceHoursSaved) >= metaSaveInterval {
+		presenceHoursSaved = time.Now()
+		check(saveJSON("hours.json", presenceHours), "Cannot save presence hours:")
+	}
+}
+
+func riskWeight(factor string) int {
+	if points, ok := config.Risk.Weights[factor]; ok {
+		return points
+	}
+	return riskWeights[factor]
+}
+
+// the SSIDs of the organization, the configured ones or else our own
+func corporateSSIDs(aps []AccessPoint) map[string]bool {
+	ssids := make(map[string]bool)
+	if len(config.Risk.CorporateSSIDs) > 0 {
+		for _, ssid := range config.Risk.CorporateSSIDs {
+			ssids[ssid] = true
+		}
+		return ssids
+	}
+	for _, ssid := range config.MySSIDs {
+		ssids[ssid] = true
+	}
+	for _, ap := range aps {
+		if isMine(ap) && strings.TrimSpace(ap.Name) != "" {
+			ssids[strings.TrimSpace(ap.Name)] = true
+		}
+	}
+	return ssids
+}
+
+// check if a device was only ever seen at odd hours
+func onlyAtOddHours(mac string) bool {
+	start, end, err := parseQuietHours(orDefault(config.Risk.OddHours, "22:00-06:00"))
+	if err != nil {
+		return false
+	}
+	presenceHoursMutex.RLock()
+	p := presenceHours[mac]
+	presenceHoursMutex.RUnlock()
+	seen := 0
+	for hour, n := range p.Hours {
+		if n == 0 {
+			continue
+		}
+		// the hour counts as odd if it starts within them
+		minute := hour * 60
+		odd := minute >= start && minute < end
+		if start > end {
+			odd = minute >= start || minute < end
+		}
+		if !odd {
+			return false
+		}
+		seen += n
+	}
+	return seen >= minOddHoursSightings
+}
+
+// open and not enhanced open
+func isOpen(ap AccessPoint) bool {
+	return encryption(ap) == "OPN" && !containsString(strings.Fields(ap.Authentication), "OWE")
+}
+
+func (s *RiskScore) add(factor, reason string) {
+	points := riskWeight(factor)
+	if points <= 0 {
+		return
+	}
+	s.Factors = append(s.Factors, RiskFactor{Factor: factor, Points: points, Reason: reason})
+	s.Score += points
+	if s.Score > 100 {
+		s.Score = 100
+	}
+}
+
+// score the risk of every access point and client from what is known about them, the ones without any factor
+// score 0
+func riskScores(aps []AccessPoint, clients []Client) []RiskScore {
+	corporate := corporateSSIDs(aps)
+	byMAC := make(map[string]AccessPoint, len(aps))
+	for _, ap := range aps {
+		byMAC[ap.MAC] = ap
+	}
+	rogues := make(map[string]Rogue)
+	for _, rogue := range findRogues(aps, clients) {
+		rogues[rogue.MAC] = rogue
+	}
+	unknownVendor := func(s *RiskScore, mac string) {
+		if s.Organization == "" && !isLocalMAC(mac) {
+			s.add(RiskUnknownVendor, "no vendor is known for the MAC address")
+		}
+	}
+	oddHours := func(s *RiskScore, mac string) {
+		if onlyAtOddHours(mac) {
+			s.add(RiskOddHours, "only seen between "+orDefault(config.Risk.OddHours, "22:00-06:00"))
+		}
+	}
+	scores := []RiskScore{}
+	for _, ap := range aps {
+		s := RiskScore{MAC: formatMAC(ap.MAC), Kind: "ap", Name: ap.Name, Organization: lookupOrganization(ap.MAC),
+			LastSeen: ap.LastSeen, Factors: []RiskFactor{}}
+		unknownVendor(&s, ap.MAC)
+		if isOpen(ap) && !isMine(ap) {
+			s.add(RiskOpenHotspot, "open access point")
+		}
+		if rogue, ok := rogues[s.MAC]; ok {
+			s.add(RiskRogue, strings.Join(rogue.Reasons, ", "))
+		}
+		oddHours(&s, ap.MAC)
+		scores = append(scores, s)
+	}
+	for _, c := range clients {
+		s := RiskScore{MAC: formatMAC(c.MAC), Kind: "client", Name: c.Alias, Organization: c.Organization,
+			LastSeen: c.LastSeen, Factors: []RiskFactor{}}
+		unknownVendor(&s, c.MAC)
+		ap, associated := byMAC[c.BSSID]
+		associated = associated && c.Associated
+		if !associated || !isMine(ap) {
+			var probed []string
+			for _, record := range getProbeHistory(c.MAC) {
+				if corporate[record.SSID] && !containsString(probed, record.SSID) {
+					probed = append(probed, record.SSID)
+				}
+			}
+			for _, ssid := range probedSSIDs(c) {
+				if corporate[ssid] && !containsString(probed, ssid) {
+					probed = append(probed, ssid)
+				}
+			}
+			if len(probed) > 0 {
+				s.add(RiskCorporateProbe, "probes for "+strings.Join(probed, ", ")+" but isn't on one of our access points")
+			}
+		}
+		if associated && isOpen(ap) && !isMine(ap) {
+			s.add(RiskOpenHotspot, "on open access point "+orDefault(ap.Name, formatMAC(ap.MAC)))
+		}
+		oddHours(&s, c.MAC)
+		scores = append(scores, s)
+	}
+	return scores
+}
+
+// risk scores of the devices seen in the last 60 minutes or ?last=minutes at /risk, riskiest first or sorted
+// by ?sort=mac or last_seen, only of ?kind=ap or client and scoring at least ?min=
+func riskHandler(w http.ResponseWriter, r *http.Request) {
+	last := 60
+	if lastParam := r.URL.Query().Get("last"); lastParam != "" {
+		n, err := strconv.Atoi(lastParam)
+		if err != nil {
+			http.Error(w, "Invalid last parameter", http.StatusBadRequest)
+			return
+		}
+		last = n
+	}
+	min := 0
+	if minParam := r.URL.Query().Get("min"); minParam != "" {
+		n, err := strconv.Atoi(minParam)
+		if err != nil {
+			http.Error(w, "Invalid min parameter", http.StatusBadRequest)
+			return
+		}
+		min = n
+	}
+	kind := r.URL.Query().Get("kind")
+	if kind != "" && kind != "ap" && kind != "client" {
+		http.Error(w, "Invalid kind parameter, use ap or client", http.StatusBadRequest)
+		return
+	}
+	by := r.URL.Query().Get("sort")
+	if by != "" && by != "score" && by != "mac" && by != "last_seen" {
+		http.Error(w, "Invalid sort parameter, use score, mac or last_seen", http.StatusBadRequest)
+		return
+	}
+	since := time.Now().Add(-time.Duration(last) * time.Minute)
+	scores := []RiskScore{}
+	for _, s := range riskScores(store.Snapshot()) {
+		if s.LastSeen.After(since) && s.Score >= min && (kind == "" || s.Kind == kind) {
+			scores = append(scores, s)
+		}
+	}
+	sort.SliceStable(scores, func(i, j int) bool {
+		switch by {
+		case "mac":
+			return scores[i].MAC < scores[j].MAC
+		case "last_seen":
+			return scores[i].LastSeen.After(scores[j].LastSeen)
+		}
+		if scores[i].Score != scores[j].Score {
+			return scores[i].Score > scores[j].Score
+		}
+		return scores[i].MAC < scores[j].MAC
+	})
+	writeJSON(w, scores)
+}
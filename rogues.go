@@ -0,0 +1,93 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// KarmaConfig tunes the detection of Karma attacks, where an AP answers to whatever SSID clients probe for
+type KarmaConfig struct {
+	MaxSSIDs int `json:"max_ssids"` // an AP that broadcast more SSIDs than this is suspicious, defaults to 3
+}
+
+// Rogue is an access point that is probably up to no good
+type Rogue struct {
+	MAC     string   `json:"mac"`
+	Name    string   `json:"name"`
+	SSIDs   []string `json:"ssids"` // every SSID it has broadcast
+	Reasons []string `json:"reasons"`
+	Karma   bool     `json:"karma"` // probably a Karma attack, ie a Wi-Fi Pineapple
+}
+
+// split the probes of a client into SSIDs
+func probedSSIDs(c Client) (ssids []string) {
+	for _, probe := range strings.Split(c.Probes, ",") {
+		if probe = strings.TrimSpace(probe); probe != "" {
+			ssids = append(ssids, probe)
+		}
+	}
+	return
+}
+
+// find the rogue access points: the ones spoofing our SSIDs, broadcasting many SSIDs or showing up
+// with an SSID that clients were probing for but no other AP broadcast
+func findRogues(aps []AccessPoint, clients []Client) []Rogue {
+	maxSSIDs := config.Karma.MaxSSIDs
+	if maxSSIDs <= 0 {
+		maxSSIDs = 3
+	}
+	// when each SSID was first probed for
+	probed := make(map[string]time.Time)
+	for _, c := range clients {
+		for _, ssid := range probedSSIDs(c) {
+			if t, ok := probed[ssid]; !ok || c.FirstSeen.Before(t) {
+				probed[ssid] = c.FirstSeen
+			}
+		}
+	}
+	// the access points broadcasting each SSID
+	broadcasters := make(map[string]int)
+	history := make(map[string][]SSIDRecord)
+	for _, ap := range aps {
+		history[ap.MAC] = getSSIDHistory(ap.MAC)
+		for _, record := range history[ap.MAC] {
+			broadcasters[record.SSID]++
+		}
+	}
+	rogues := []Rogue{}
+	for _, ap := range aps {
+		if ap.Ownership == OwnershipMine || ap.Ownership == OwnershipNeighbor {
+			continue
+		}
+		r := Rogue{MAC: formatMAC(ap.MAC), Name: ap.Name, SSIDs: []string{}}
+		for _, record := range history[ap.MAC] {
+			r.SSIDs = append(r.SSIDs, record.SSID)
+		}
+		if isRogue(ap, aps) {
+			r.Reasons = append(r.Reasons, "broadcasts one of our SSIDs")
+		}
+		if len(r.SSIDs) > maxSSIDs {
+			r.Karma = true
+			r.Reasons = append(r.Reasons, "broadcast many different SSIDs")
+		}
+		for _, record := range history[ap.MAC] {
+			t, ok := probed[record.SSID]
+			if ok && broadcasters[record.SSID] == 1 && t.Before(record.FirstSeen) {
+				r.Karma = true
+				r.Reasons = append(r.Reasons, "broadcasts "+record.SSID+" which was only probed for before")
+			}
+		}
+		if len(r.Reasons) > 0 {
+			rogues = append(rogues, r)
+		}
+	}
+	sort.Slice(rogues, func(i, j int) bool { return rogues[i].MAC < rogues[j].MAC })
+	return rogues
+}
+
+// probable rogue access points at /rogues
+func roguesHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, findRogues(store.Snapshot()))
+}
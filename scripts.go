@@ -0,0 +1,228 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// EventScript is the type of the alerts raised by user scripts
+const EventScript = "script"
+
+// scriptEnricher runs the user scripts in the scripts directory on every parse.
+//
+// Scripts are Lua files (*.lua) run by the interpreter in lua.go, a subset of Lua 5.1 without metatables or
+// coroutines. A script can define two functions:
+//
+//	enrich(aps, clients) changes the access points and clients of the parse, the lists of tables it is given or
+//	new ones it returns
+//	alert(device, kind) is called for every access point (kind "ap") and client ("client") of the parse, a
+//	message it returns raises a script event, once until it returns nil or false again
+//
+// The fields of the tables are those of the JSON API. A script is loaded again when it changes, and dropped
+// when it is removed, without restarting netnet.
+type scriptEnricher struct {
+	dir     string
+	scripts map[string]*script
+}
+
+type script struct {
+	modified time.Time
+	state    *luaState // nil if it couldn't be loaded
+	alerting map[string]bool
+}
+
+func (s *scriptEnricher) Name() string {
+	return "scripts"
+}
+
+// Enrich runs the scripts in name order, each script gets the output of the one before
+func (s *scriptEnricher) Enrich(aps []AccessPoint, clients []Client) ([]AccessPoint, []Client, error) {
+	s.load()
+	names := make([]string, 0, len(s.scripts))
+	for path := range s.scripts {
+		names = append(names, path)
+	}
+	sort.Strings(names)
+	var events []Event
+	for _, path := range names {
+		sc := s.scripts[path]
+		if sc.state == nil {
+			continue
+		}
+		a, c, e, err := sc.run(aps, clients)
+		if err != nil {
+			fmt.Println("Script", path, "failed:", err)
+			continue
+		}
+		aps, clients = a, c
+		events = append(events, e...)
+	}
+	emit(events)
+	return aps, clients, nil
+}
+
+// load the scripts that are new or changed since the last parse and forget the removed ones
+func (s *scriptEnricher) load() {
+	if s.scripts == nil {
+		s.scripts = make(map[string]*script)
+	}
+	files, _ := ioutil.ReadDir(s.dir)
+	found := make(map[string]bool)
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), ".lua") {
+			continue
+		}
+		path := filepath.Join(s.dir, file.Name())
+		found[path] = true
+		if sc, ok := s.scripts[path]; ok && sc.modified.Equal(file.ModTime()) {
+			continue
+		}
+		// a script that can't be loaded isn't tried again until it changes
+		sc := &script{modified: file.ModTime(), alerting: make(map[string]bool)}
+		s.scripts[path] = sc
+		source, err := ioutil.ReadFile(path)
+		if err != nil {
+			fmt.Println("Cannot read script", path, ":", err)
+			continue
+		}
+		state, err := loadScript(file.Name(), string(source))
+		if err != nil {
+			fmt.Println("Cannot load script", path, ":", err)
+			continue
+		}
+		sc.state = state
+		fmt.Println("Loaded script", path)
+	}
+	for path := range s.scripts {
+		if !found[path] {
+			delete(s.scripts, path)
+			fmt.Println("Removed script", path)
+		}
+	}
+}
+
+// parse a script and run it to define its functions
+func loadScript(name, source string) (*luaState, error) {
+	chunk, err := luaParse(name, source)
+	if err != nil {
+		return nil, err
+	}
+	L := newLuaState(name)
+	_, err = L.pcall(&luaClosure{chunk, nil})
+	return L, err
+}
+
+// run the enrich and alert functions of a script on the devices
+func (sc *script) run(aps []AccessPoint, clients []Client) ([]AccessPoint, []Client, []Event, error) {
+	L := sc.state
+	L.steps = 0
+	enrich, alert := L.globals.get("enrich"), L.globals.get("alert")
+	if enrich == nil && alert == nil {
+		return aps, clients, nil, nil
+	}
+	apsTable, err := scriptTable(aps)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	clientsTable, err := scriptTable(clients)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if enrich != nil {
+		results, err := L.pcall(enrich, apsTable, clientsTable)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		if len(results) > 0 && results[0] != nil {
+			if apsTable, _ = results[0].(*luaTable); apsTable == nil {
+				return nil, nil, nil, fmt.Errorf("enrich returned a %s instead of the access points", luaType(results[0]))
+			}
+		}
+		if len(results) > 1 && results[1] != nil {
+			if clientsTable, _ = results[1].(*luaTable); clientsTable == nil {
+				return nil, nil, nil, fmt.Errorf("enrich returned a %s instead of the clients", luaType(results[1]))
+			}
+		}
+		// decoding into new slices, the ones given would keep fields of other devices in the elements reused
+		var newAPs []AccessPoint
+		var newClients []Client
+		if err = fromScriptTable(apsTable, &newAPs); err != nil {
+			return nil, nil, nil, fmt.Errorf("cannot read the access points of enrich: %v", err)
+		}
+		if err = fromScriptTable(clientsTable, &newClients); err != nil {
+			return nil, nil, nil, fmt.Errorf("cannot read the clients of enrich: %v", err)
+		}
+		aps, clients = newAPs, newClients
+	}
+	var events []Event
+	if alert != nil {
+		now := time.Now()
+		for _, list := range []struct {
+			kind  string
+			table *luaTable
+		}{{"ap", apsTable}, {"client", clientsTable}} {
+			for _, device := range list.table.array {
+				t, ok := device.(*luaTable)
+				if !ok {
+					continue
+				}
+				mac, _ := t.get("mac").(string)
+				if mac == "" {
+					continue
+				}
+				results, err := L.pcall(alert, t, list.kind)
+				if err != nil {
+					return nil, nil, nil, err
+				}
+				if len(results) == 0 || !luaTruthy(results[0]) {
+					delete(sc.alerting, mac)
+					continue
+				}
+				if sc.alerting[mac] {
+					continue
+				}
+				sc.alerting[mac] = true
+				message, ok := luaToStringCoerce(results[0])
+				if !ok {
+					message = "Alert of script " + L.name
+				}
+				events = append(events, Event{Type: EventScript, Time: now, MAC: mac, Message: message,
+					Data: map[string]string{"script": L.name, "kind": list.kind}})
+			}
+		}
+	}
+	return aps, clients, events, nil
+}
+
+// devices as a list of tables with the fields of the JSON API
+func scriptTable(devices interface{}) (*luaTable, error) {
+	data, err := json.Marshal(devices)
+	if err != nil {
+		return nil, err
+	}
+	var list []interface{}
+	if err = json.Unmarshal(data, &list); err != nil {
+		return nil, err
+	}
+	return luaFromJSON(list).(*luaTable), nil
+}
+
+func fromScriptTable(t *luaTable, devices interface{}) error {
+	v, err := luaToJSON(t, 0)
+	if err != nil {
+		return err
+	}
+	if _, ok := v.([]interface{}); !ok && v != nil {
+		return fmt.Errorf("not a list")
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, devices)
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"crypto/pbkdf2"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// how long a login lasts
+const sessionDuration = 24 * time.Hour
+
+const pbkdf2Iterations = 100000
+
+// User can log into the web UI, with the same scopes as API keys
+type User struct {
+	Name     string   `json:"name"`
+	Password string   `json:"password,omitempty"` // pbkdf2$iterations$salt$hash
+	Scopes   []string `json:"scopes"`
+}
+
+// WebSession is a logged in user
+type WebSession struct {
+	User    string
+	Scopes  []string
+	CSRF    string
+	Expires time.Time
+}
+
+var users []User
+var usersMutex sync.RWMutex
+
+var webSessions = make(map[string]*WebSession)
+var webSessionsMutex sync.Mutex
+
+// load the users, and if there are none create an admin user so someone can log in
+func loadUsers() {
+	usersMutex.Lock()
+	defer usersMutex.Unlock()
+	users = nil
+	check(loadJSON("users.json", &users), "Cannot load users:")
+	if len(users) == 0 && *authEnabled {
+		password := randomHex(8)
+		users = append(users, User{Name: "admin", Password: hashPassword(password), Scopes: []string{ScopeAdmin}})
+		check(saveJSON("users.json", users), "Cannot save users:")
+		fmt.Println("Created user admin with password (it won't be shown again):", password)
+	}
+}
+
+func hashPassword(password string) string {
+	salt := randomHex(16)
+	key, err := pbkdf2.Key(sha256.New, password, []byte(salt), pbkdf2Iterations, 32)
+	if err != nil {
+		panic(err)
+	}
+	return "pbkdf2$" + strconv.Itoa(pbkdf2Iterations) + "$" + salt + "$" + hex.EncodeToString(key)
+}
+
+func checkPassword(hash, password string) bool {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 4 || parts[0] != "pbkdf2" {
+		return false
+	}
+	iterations, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return false
+	}
+	key, err := pbkdf2.Key(sha256.New, password, []byte(parts[2]), iterations, 32)
+	if err != nil {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(hex.EncodeToString(key)), []byte(parts[3])) == 1
+}
+
+// find the user with the name and password
+func findUser(name, password string) *User {
+	usersMutex.RLock()
+	defer usersMutex.RUnlock()
+	for _, user := range users {
+		if user.Name == name && checkPassword(user.Password, password) {
+			u := user
+			return &u
+		}
+	}
+	return nil
+}
+
+// the logged in session of the request, if any
+func requestSession(r *http.Request) *WebSession {
+	cookie, err := r.Cookie("netnet_session")
+	if err != nil {
+		return nil
+	}
+	webSessionsMutex.Lock()
+	defer webSessionsMutex.Unlock()
+	session, ok := webSessions[cookie.Value]
+	if !ok {
+		return nil
+	}
+	if time.Now().After(session.Expires) {
+		delete(webSessions, cookie.Value)
+		return nil
+	}
+	return session
+}
+
+// mutating requests made with a session cookie must send back the CSRF token
+// in the X-CSRF-Token header or the csrf_token form field
+func (s *WebSession) checkCSRF(r *http.Request) bool {
+	if r.Method == http.MethodGet || r.Method == http.MethodHead || r.Method == http.MethodOptions {
+		return true
+	}
+	token := r.Header.Get("X-CSRF-Token")
+	if token == "" {
+		token = r.PostFormValue("csrf_token")
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(s.CSRF)) == 1
+}
+
+// login page and form
+func login(w http.ResponseWriter, r *http.Request) {
+	t, _ := parseTemplate("login.html")
+	if r.Method != http.MethodPost {
+		t.Execute(w, nil)
+		return
+	}
+	user := findUser(r.PostFormValue("username"), r.PostFormValue("password"))
+	if user == nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		t.Execute(w, "Wrong username or password")
+		return
+	}
+	token := randomHex(32)
+	session := &WebSession{
+		User:    user.Name,
+		Scopes:  user.Scopes,
+		CSRF:    randomHex(32),
+		Expires: time.Now().Add(sessionDuration),
+	}
+	webSessionsMutex.Lock()
+	webSessions[token] = session
+	webSessionsMutex.Unlock()
+	secure := r.TLS != nil
+	http.SetCookie(w, &http.Cookie{Name: "netnet_session", Value: token, Path: "/", Expires: session.Expires,
+		HttpOnly: true, Secure: secure, SameSite: http.SameSiteLaxMode})
+	// the dashboard reads the CSRF token from this cookie to send it back in the X-CSRF-Token header
+	http.SetCookie(w, &http.Cookie{Name: "netnet_csrf", Value: session.CSRF, Path: "/", Expires: session.Expires,
+		Secure: secure, SameSite: http.SameSiteStrictMode})
+	http.Redirect(w, r, localRedirect(r.URL.Query().Get("next")), http.StatusSeeOther)
+}
+
+// the page to go to after logging in if it is on this server, / if not, browsers take /\evil.example and
+// //evil.example as another host
+func localRedirect(next string) string {
+	u, err := url.Parse(next)
+	if err != nil || u.Scheme != "" || u.Host != "" || u.User != nil || !strings.HasPrefix(next, "/") ||
+		strings.HasPrefix(next, "//") || strings.Contains(next, `\`) {
+		return "/"
+	}
+	return next
+}
+
+// log out and remove the session
+func logout(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if cookie, err := r.Cookie("netnet_session"); err == nil {
+		webSessionsMutex.Lock()
+		delete(webSessions, cookie.Value)
+		webSessionsMutex.Unlock()
+	}
+	http.SetCookie(w, &http.Cookie{Name: "netnet_session", Value: "", Path: "/", MaxAge: -1})
+	http.SetCookie(w, &http.Cookie{Name: "netnet_csrf", Value: "", Path: "/", MaxAge: -1})
+	http.Redirect(w, r, "/login", http.StatusSeeOther)
+}
+
+// manage users at /admin/users and /admin/users/{name}
+func adminUsers(w http.ResponseWriter, r *http.Request) {
+	name := strings.Trim(strings.TrimPrefix(r.URL.Path, "/admin/users"), "/")
+	usersMutex.Lock()
+	defer usersMutex.Unlock()
+	switch {
+	case r.Method == http.MethodGet && name == "":
+		list := make([]User, len(users))
+		for i, user := range users {
+			user.Password = ""
+			list[i] = user
+		}
+		writeJSON(w, list)
+	case r.Method == http.MethodPost && name == "":
+		var user User
+		err := json.NewDecoder(r.Body).Decode(&user)
+		if err != nil || user.Name == "" || user.Password == "" {
+			http.Error(w, "A user needs a name and a password", http.StatusBadRequest)
+			return
+		}
+		for _, u := range users {
+			if u.Name == user.Name {
+				http.Error(w, "User "+user.Name+" already exists", http.StatusConflict)
+				return
+			}
+		}
+		for _, scope := range user.Scopes {
+			if !validScope(scope) {
+				http.Error(w, "Unknown scope "+scope, http.StatusBadRequest)
+				return
+			}
+		}
+		if len(user.Scopes) == 0 {
+			user.Scopes = []string{ScopeRead}
+		}
+		user.Password = hashPassword(user.Password)
+		users = append(users, user)
+		err = saveJSON("users.json", users)
+		if err != nil {
+			http.Error(w, "Cannot save users: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	case r.Method == http.MethodDelete && name != "":
+		for i, user := range users {
+			if user.Name == name {
+				users = append(users[:i], users[i+1:]...)
+				webSessionsMutex.Lock()
+				for token, session := range webSessions {
+					if session.User == name {
+						delete(webSessions, token)
+					}
+				}
+				webSessionsMutex.Unlock()
+				err := saveJSON("users.json", users)
+				if err != nil {
+					http.Error(w, "Cannot save users: "+err.Error(), http.StatusInternalServerError)
+					return
+				}
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+		}
+		http.NotFound(w, r)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
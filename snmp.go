@@ -0,0 +1,384 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// https://datatracker.ietf.org/doc/html/rfc3416, only SNMPv2c GetBulkRequest to walk the station table of an
+// access point or controller
+
+// BER tags of the SNMP messages used
+const (
+	berNull             = 0x05
+	berOID              = 0x06
+	snmpNoSuchObject    = 0x80
+	snmpNoSuchInstance  = 0x81
+	snmpEndOfMibView    = 0x82
+	snmpGetResponse     = 0xA2
+	snmpGetBulkRequest  = 0xA5
+	snmpVersion2c       = 1
+	snmpMaxRepetitions  = 25
+	snmpTimeout         = 5 * time.Second
+	snmpRetries         = 2
+	snmpDefaultInterval = time.Minute
+)
+
+// SNMPStationTable is the columns of a table of associated stations, the rows are matched by their index
+type SNMPStationTable struct {
+	MAC    string `json:"mac"`    // OID of the column of station MAC addresses, the row index is the MAC address if empty
+	BSSID  string `json:"bssid"`  // OID of the column of the MAC addresses of the access points the stations are on
+	SSID   string `json:"ssid"`   // OID of the column of the SSIDs the stations are on
+	Signal string `json:"signal"` // OID of the column of the signal strength of the stations in dBm
+}
+
+// station tables of the controllers netnet knows, by the name used in the source's mib
+var snmpPresets = map[string]SNMPStationTable{
+	// AIRESPACE-WIRELESS-MIB of Cisco AireOS wireless LAN controllers, bsnMobileStationTable is indexed by the station
+	// MAC address, bsnMobileStationAPMacAddr is the base radio MAC address of the access point
+	"cisco-wlc": {
+		BSSID:  "1.3.6.1.4.1.14179.2.1.4.1.4",
+		SSID:   "1.3.6.1.4.1.14179.2.1.4.1.7",
+		Signal: "1.3.6.1.4.1.14179.2.1.6.1.1",
+	},
+}
+
+// a variable in an SNMP response
+type snmpVar struct {
+	oid   []int
+	tag   byte
+	value []byte
+}
+
+func parseOID(s string) ([]int, error) {
+	var oid []int
+	for _, part := range strings.Split(strings.Trim(s, "."), ".") {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid OID %s", s)
+		}
+		oid = append(oid, n)
+	}
+	if len(oid) < 2 {
+		return nil, fmt.Errorf("invalid OID %s", s)
+	}
+	return oid, nil
+}
+
+func encodeOID(oid []int) []byte {
+	content := []byte{byte(oid[0]*40 + oid[1])}
+	for _, n := range oid[2:] {
+		// base 128, most significant first, the high bit set on all but the last
+		b := []byte{byte(n & 0x7f)}
+		for n >>= 7; n > 0; n >>= 7 {
+			b = append([]byte{byte(n&0x7f | 0x80)}, b...)
+		}
+		content = append(content, b...)
+	}
+	return berEncode(berOID, content)
+}
+
+func decodeOID(content []byte) []int {
+	if len(content) == 0 {
+		return nil
+	}
+	oid := []int{int(content[0]) / 40, int(content[0]) % 40}
+	n := 0
+	for _, b := range content[1:] {
+		n = n<<7 | int(b&0x7f)
+		if b&0x80 == 0 {
+			oid = append(oid, n)
+			n = 0
+		}
+	}
+	return oid
+}
+
+// the arcs of oid after prefix, false if it isn't under it
+func oidSuffix(oid, prefix []int) ([]int, bool) {
+	if len(oid) <= len(prefix) {
+		return nil, false
+	}
+	for i := range prefix {
+		if oid[i] != prefix[i] {
+			return nil, false
+		}
+	}
+	return oid[len(prefix):], true
+}
+
+// an INTEGER, Counter32, Gauge32 or TimeTicks, INTEGER is signed
+func snmpNumber(v snmpVar) int {
+	n := 0
+	for _, b := range v.value {
+		n = n<<8 | int(b)
+	}
+	if v.tag == berInteger && len(v.value) > 0 && len(v.value) < 8 && v.value[0]&0x80 != 0 {
+		n -= 1 << (8 * uint(len(v.value)))
+	}
+	return n
+}
+
+// send a GetBulkRequest for what comes after oid and read the variables in the response
+func snmpGetBulk(conn net.Conn, community string, oid []int) ([]snmpVar, error) {
+	id := rand.Intn(1 << 30)
+	request := berConcat(berSequence,
+		berInt(berInteger, snmpVersion2c),
+		berString(community),
+		berConcat(snmpGetBulkRequest,
+			berInt(berInteger, id),
+			berInt(berInteger, 0), // non-repeaters
+			berInt(berInteger, snmpMaxRepetitions),
+			berConcat(berSequence, berConcat(berSequence, encodeOID(oid), berEncode(berNull, nil)))))
+	buf := make([]byte, 65536)
+	var err error
+	for try := 0; try <= snmpRetries; try++ {
+		conn.SetDeadline(time.Now().Add(snmpTimeout))
+		if _, err = conn.Write(request); err != nil {
+			return nil, err
+		}
+		for {
+			var n int
+			n, err = conn.Read(buf)
+			if err != nil {
+				break
+			}
+			vars, responseID, err := parseSNMPResponse(buf[:n])
+			if err != nil {
+				return nil, err
+			}
+			// an answer to an earlier try
+			if responseID != id {
+				continue
+			}
+			return vars, nil
+		}
+		if ne, ok := err.(net.Error); !ok || !ne.Timeout() {
+			return nil, err
+		}
+	}
+	return nil, errors.New("no answer, check the address and community")
+}
+
+func parseSNMPResponse(data []byte) ([]snmpVar, int, error) {
+	msg, err := berChildren(data)
+	if err != nil || len(msg) != 1 {
+		return nil, 0, errors.New("malformed SNMP message")
+	}
+	parts, err := berChildren(msg[0].content)
+	if err != nil || len(parts) < 3 || parts[2].tag != snmpGetResponse {
+		return nil, 0, errors.New("malformed SNMP message")
+	}
+	pdu, err := berChildren(parts[2].content)
+	if err != nil || len(pdu) < 4 {
+		return nil, 0, errors.New("malformed SNMP response")
+	}
+	id := snmpNumber(snmpVar{tag: berInteger, value: pdu[0].content})
+	if status := snmpNumber(snmpVar{tag: berInteger, value: pdu[1].content}); status != 0 {
+		return nil, id, fmt.Errorf("SNMP error %d", status)
+	}
+	bindings, err := berChildren(pdu[3].content)
+	if err != nil {
+		return nil, id, err
+	}
+	var vars []snmpVar
+	for _, b := range bindings {
+		pair, err := berChildren(b.content)
+		if err != nil || len(pair) < 2 {
+			return nil, id, errors.New("malformed SNMP variable")
+		}
+		vars = append(vars, snmpVar{decodeOID(pair[0].content), pair[1].tag, pair[1].content})
+	}
+	return vars, id, nil
+}
+
+// every variable under a column, by the index of its row
+func snmpWalk(conn net.Conn, community, column string) (map[string]snmpVar, error) {
+	prefix, err := parseOID(column)
+	if err != nil {
+		return nil, err
+	}
+	rows := make(map[string]snmpVar)
+	next := prefix
+	for {
+		vars, err := snmpGetBulk(conn, community, next)
+		if err != nil {
+			return nil, err
+		}
+		if len(vars) == 0 {
+			return rows, nil
+		}
+		for _, v := range vars {
+			suffix, ok := oidSuffix(v.oid, prefix)
+			if !ok || v.tag == snmpEndOfMibView || v.tag == snmpNoSuchObject || v.tag == snmpNoSuchInstance {
+				return rows, nil
+			}
+			index := make([]string, len(suffix))
+			for i, n := range suffix {
+				index[i] = strconv.Itoa(n)
+			}
+			rows[strings.Join(index, ".")] = v
+		}
+		next = vars[len(vars)-1].oid
+	}
+}
+
+// a MAC address in an OCTET STRING, or in the last six arcs of a row index
+func snmpMAC(v snmpVar, index string) (string, bool) {
+	if v.value != nil {
+		if len(v.value) == 6 {
+			return macString(v.value), true
+		}
+		return parseMAC(string(v.value))
+	}
+	arcs := strings.Split(index, ".")
+	if len(arcs) < 6 {
+		return "", false
+	}
+	b := make([]byte, 6)
+	for i, arc := range arcs[len(arcs)-6:] {
+		n, err := strconv.Atoi(arc)
+		if err != nil || n > 255 {
+			return "", false
+		}
+		b[i] = byte(n)
+	}
+	return macString(b), true
+}
+
+// snmpSource polls the station table of an access point or controller, what it says about associations is
+// authoritative
+type snmpSource struct {
+	name      string
+	address   string
+	community string
+	table     SNMPStationTable
+	interval  time.Duration
+	firstSeen map[string]time.Time
+	mutex     *sync.Mutex
+}
+
+func newSNMPSource(c SourceConfig) (DataSource, error) {
+	table := c.Stations
+	if c.MIB != "" {
+		preset, ok := snmpPresets[c.MIB]
+		if !ok {
+			return nil, fmt.Errorf("unknown MIB %s", c.MIB)
+		}
+		table = preset
+	}
+	if table.BSSID == "" {
+		return nil, errors.New("no column of access point MAC addresses, set mib or stations")
+	}
+	address := c.Address
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		address = net.JoinHostPort(address, "161")
+	}
+	interval := time.Duration(c.Interval) * time.Second
+	if interval <= 0 {
+		interval = snmpDefaultInterval
+	}
+	name := c.Name
+	if name == "" {
+		name = c.Address
+	}
+	return snmpSource{name: name, address: address, community: orDefault(c.Community, "public"), table: table,
+		interval: interval, firstSeen: make(map[string]time.Time), mutex: &sync.Mutex{}}, nil
+}
+
+func (s snmpSource) Name() string {
+	return s.name
+}
+
+func (s snmpSource) Poll() (Batch, error) {
+	b := Batch{Source: s.name, Written: time.Now(), Authoritative: true}
+	conn, err := net.Dial("udp", s.address)
+	if err != nil {
+		return b, err
+	}
+	defer conn.Close()
+	columns := make(map[string]map[string]snmpVar)
+	for _, column := range []string{s.table.MAC, s.table.BSSID, s.table.SSID, s.table.Signal} {
+		if column == "" {
+			continue
+		}
+		if columns[column], err = snmpWalk(conn, s.community, column); err != nil {
+			return b, err
+		}
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	first := func(mac string) time.Time {
+		if _, ok := s.firstSeen[mac]; !ok {
+			s.firstSeen[mac] = b.Written
+		}
+		return s.firstSeen[mac]
+	}
+	aps := make(map[string]AccessPoint)
+	for index, v := range columns[s.table.BSSID] {
+		bssid, ok := snmpMAC(v, "")
+		if !ok {
+			continue
+		}
+		var mac string
+		if s.table.MAC != "" {
+			mac, ok = snmpMAC(columns[s.table.MAC][index], "")
+		} else {
+			mac, ok = snmpMAC(snmpVar{}, index)
+		}
+		if !ok {
+			continue
+		}
+		c := Client{MAC: mac, FirstSeen: first(mac), LastSeen: b.Written, Power: -1, BSSID: bssid, Associated: true}
+		if signal, ok := columns[s.table.Signal][index]; ok {
+			c.Power = snmpNumber(signal)
+		}
+		b.Clients = append(b.Clients, c)
+		ap, ok := aps[bssid]
+		if !ok {
+			ap = AccessPoint{MAC: bssid, FirstSeen: first(bssid), LastSeen: b.Written, Power: -1}
+		}
+		if ssid, ok := columns[s.table.SSID][index]; ok && ap.Name == "" {
+			ap.Name = string(ssid.value)
+		}
+		aps[bssid] = ap
+	}
+	for _, ap := range aps {
+		b.APs = append(b.APs, ap)
+	}
+	return b, nil
+}
+
+// poll every interval, the station table doesn't need to be walked on every parse
+func (s snmpSource) Watch(stop <-chan struct{}) <-chan Batch {
+	batches := make(chan Batch)
+	go func() {
+		defer close(batches)
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+		for {
+			batch, err := s.Poll()
+			if err != nil {
+				fmt.Println("Cannot poll", s.name+":", err)
+				batch = Batch{Source: s.name, Written: time.Now(), Problem: err.Error(), Authoritative: true}
+			}
+			select {
+			case batches <- batch:
+			case <-stop:
+				return
+			}
+			select {
+			case <-ticker.C:
+			case <-stop:
+				return
+			}
+		}
+	}()
+	return batches
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/hex"
+	"reflect"
+	"testing"
+)
+
+// a GetResponse of an SNMPv2c agent with community public, request ID 42 and sysName.0 "ap1"
+const snmpSysName = "3029020101040670756" + "26c6963a21c02012a020100020100301130" + "0f06082b06010201010500040361" + "7031"
+
+func TestSNMPResponse(t *testing.T) {
+	data, _ := hex.DecodeString(snmpSysName)
+	vars, id, err := parseSNMPResponse(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != 42 || len(vars) != 1 || !reflect.DeepEqual(vars[0].oid, []int{1, 3, 6, 1, 2, 1, 1, 5, 0}) ||
+		vars[0].tag != berOctetString || string(vars[0].value) != "ap1" {
+		t.Errorf("response %d %v", id, vars)
+	}
+	// an error status gives the request ID, so the answer isn't taken for one to another request
+	pdu := berConcat(snmpGetResponse, berInt(berInteger, 7), berInt(berInteger, 2), berInt(berInteger, 1),
+		berConcat(berSequence))
+	msg := berConcat(berSequence, berInt(berInteger, 1), berString("public"), pdu)
+	if _, id, err = parseSNMPResponse(msg); err == nil || id != 7 {
+		t.Errorf("error status gave %d %v", id, err)
+	}
+	for _, test := range []struct {
+		v snmpVar
+		n int
+	}{
+		{snmpVar{tag: berInteger, value: []byte{0xff}}, -1},
+		{snmpVar{tag: berInteger, value: []byte{0x00, 0x80}}, 128},
+		{snmpVar{tag: 0x42, value: []byte{0x80, 0x00}}, 0x8000}, // Gauge32 is unsigned
+		{snmpVar{tag: berInteger}, 0},
+	} {
+		if n := snmpNumber(test.v); n != test.n {
+			t.Errorf("%x %x is %d, want %d", test.v.tag, test.v.value, n, test.n)
+		}
+	}
+}
+
+// a malformed answer from the network has to give an error, never a panic
+func TestSNMPMalformed(t *testing.T) {
+	data, _ := hex.DecodeString(snmpSysName)
+	for size := 0; size < len(data); size++ {
+		if _, _, err := parseSNMPResponse(data[:size]); err == nil {
+			t.Errorf("cut to %d bytes, no error", size)
+		}
+	}
+	for i := range data {
+		for _, b := range []byte{0x00, 0x80, 0xff, data[i] ^ 0x01} {
+			corrupted := append([]byte{}, data...)
+			corrupted[i] = b
+			parseSNMPResponse(corrupted)
+		}
+	}
+	for _, pdu := range [][]byte{
+		berConcat(0xa0, berInt(berInteger, 1), berInt(berInteger, 0), berInt(berInteger, 0), berConcat(berSequence)),
+		berConcat(snmpGetResponse, berInt(berInteger, 1), berInt(berInteger, 0), berInt(berInteger, 0)),
+		berConcat(snmpGetResponse, berInt(berInteger, 1), berInt(berInteger, 0), berInt(berInteger, 0),
+			berConcat(berSequence, berConcat(berSequence, encodeOID([]int{1, 3, 6})))), // variable without a value
+		berConcat(snmpGetResponse, berInt(berInteger, 1), berInt(berInteger, 0), berInt(berInteger, 0),
+			berConcat(berSequence, berString("x"))), // variable not a sequence
+	} {
+		msg := berConcat(berSequence, berInt(berInteger, 1), berString("public"), pdu)
+		if vars, _, err := parseSNMPResponse(msg); err == nil {
+			t.Errorf("PDU %x read as %v", pdu, vars)
+		}
+	}
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// how often a file source checks if its file was written
+const sourceWatchInterval = time.Second
+
+// Batch is what a data source found at one point in time
+type Batch struct {
+	Source  string    `json:"source"`            // the sensor ID the devices are marked with
+	Written time.Time `json:"written"`           // when the source wrote the data, by the server clock
+	Problem string    `json:"problem,omitempty"` // why the source isn't capturing, ie file missing
+	// the source knows which access points the clients are on, ie a controller, rather than guessing from frames
+	Authoritative bool          `json:"authoritative,omitempty"`
+	Sent          time.Time     `json:"sent,omitempty"`    // when a remote sensor sent the batch, by the sensor clock
+	Version       string        `json:"version,omitempty"` // netnet version of a remote sensor
+	APs           []AccessPoint `json:"aps"`
+	Clients       []Client      `json:"clients"`
+}
+
+// DataSource is somewhere the access points and clients come from, ie an airodump-ng CSV file, a live capture,
+// an upload or a remote sensor. Sources can be added and removed while netnet runs, their batches are merged with
+// what the collector found in every parse.
+type DataSource interface {
+	Name() string
+	// Poll gets what the source has now, it is called in every parse unless the source is watched
+	Poll() (Batch, error)
+	// Watch sends a batch every time the source has new data until stop is closed, the newest batch is used
+	// in the next parse which starts right away, sources that can't tell when they have new data return nil
+	Watch(stop <-chan struct{}) <-chan Batch
+}
+
+// SourceConfig is a data source in the configuration
+type SourceConfig struct {
+	Name    string `json:"name"` // also the sensor ID, defaults to the file name
+	Type    string `json:"type"` // airodump, the default, for an airodump-ng CSV file, fake for made-up devices, snmp or unifi
+	File    string `json:"file"`
+	Profile string `json:"profile"` // CSV profile of the columns of the file, airodump-ng's if empty
+	Format  string `json:"format"`  // csv, netxml or kismetdb, by the extension of the file if empty
+	APs     int    `json:"aps"`     // how many access points and clients a fake source makes up
+	Clients int    `json:"clients"`
+
+	Address   string           `json:"address"`   // host or host:port of the access point or controller an snmp source polls
+	Community string           `json:"community"` // SNMPv2c community, defaults to public
+	MIB       string           `json:"mib"`       // station table of a known controller, cisco-wlc
+	Stations  SNMPStationTable `json:"stations"`  // station table if there is no mib
+	Interval  int              `json:"interval"`  // seconds between polls, defaults to 60
+
+	URL      string `json:"url"` // of the UniFi Network controller or UniFi OS console a unifi source polls
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Site     string `json:"site"`     // defaults to default
+	CA       string `json:"ca"`       // CA certificate to check the controller's certificate with
+	Insecure bool   `json:"insecure"` // don't check the controller's certificate, they come with a self-signed one
+}
+
+type watchedSource struct {
+	source DataSource
+	stop   chan struct{}
+	latest *Batch // newest batch sent by Watch, nil if none yet
+}
+
+var dataSources []*watchedSource
+var dataSourcesMutex sync.Mutex
+
+// RegisterDataSource adds a source, replacing any source with the same name
+func RegisterDataSource(s DataSource) {
+	UnregisterDataSource(s.Name())
+	w := &watchedSource{source: s, stop: make(chan struct{})}
+	dataSourcesMutex.Lock()
+	dataSources = append(dataSources, w)
+	dataSourcesMutex.Unlock()
+	if batches := s.Watch(w.stop); batches != nil {
+		go func() {
+			for batch := range batches {
+				b := batch
+				dataSourcesMutex.Lock()
+				w.latest = &b
+				dataSourcesMutex.Unlock()
+				requestRefresh()
+			}
+		}()
+	}
+}
+
+// UnregisterDataSource removes a source and stops watching it, the devices it found age out as usual
+func UnregisterDataSource(name string) {
+	dataSourcesMutex.Lock()
+	defer dataSourcesMutex.Unlock()
+	for i, w := range dataSources {
+		if w.source.Name() == name {
+			close(w.stop)
+			dataSources = append(dataSources[:i], dataSources[i+1:]...)
+			return
+		}
+	}
+}
+
+// add the data sources in the configuration
+func registerSources() {
+	for _, c := range config.Sources {
+		name := c.Name
+		if name == "" {
+			name = filepath.Base(c.File)
+		}
+		switch c.Type {
+		case "", "airodump":
+			file := c.File
+			RegisterDataSource(airodumpSource(name, c.Format, c.Profile, func() string { return file }))
+		case "snmp":
+			s, err := newSNMPSource(c)
+			if err != nil {
+				fmt.Println("Cannot add SNMP source", c.Address+":", err)
+				continue
+			}
+			RegisterDataSource(s)
+		case "unifi":
+			s, err := newUniFiSource(c)
+			if err != nil {
+				fmt.Println("Cannot add UniFi source", c.URL+":", err)
+				continue
+			}
+			RegisterDataSource(s)
+		case "fake":
+			if name == "." {
+				name = "fake"
+			}
+			RegisterDataSource(fakeSource{name: name, world: newFakeWorld(c.APs, c.Clients, time.Now().UnixNano())})
+		default:
+			fmt.Println("Unknown data source type:", c.Type)
+		}
+	}
+}
+
+// the newest batch of every source, polling the ones that aren't watched
+func pollSources() (batches []Batch) {
+	dataSourcesMutex.Lock()
+	list := append([]*watchedSource{}, dataSources...)
+	dataSourcesMutex.Unlock()
+	for _, w := range list {
+		dataSourcesMutex.Lock()
+		latest := w.latest
+		dataSourcesMutex.Unlock()
+		if latest != nil {
+			batches = append(batches, *latest)
+			continue
+		}
+		batch, err := w.source.Poll()
+		if err != nil {
+			fmt.Println("Cannot poll data source", w.source.Name()+":", err)
+			batch = Batch{Source: w.source.Name(), Written: time.Now(), Problem: err.Error()}
+		}
+		batches = append(batches, batch)
+	}
+	return
+}
+
+// add what the sources found to what the collector found, problem is the collector's
+func collectSources(aps []AccessPoint, clients []Client, problem string) ([]AccessPoint, []Client) {
+	batches := pollSources()
+	if len(batches) == 0 {
+		return aps, clients
+	}
+	var problems []string
+	if problem != "" {
+		problems = append(problems, problem)
+	}
+	associations := make(map[string]string)
+	for _, b := range batches {
+		observedBy(b.Source, b.Written, b.Problem, b.APs, b.Clients)
+		if b.Problem != "" {
+			problems = append(problems, b.Source+" "+b.Problem)
+		}
+		aps, clients = append(aps, b.APs...), append(clients, b.Clients...)
+		if b.Authoritative {
+			for _, c := range b.Clients {
+				associations[c.MAC] = c.BSSID
+			}
+		}
+	}
+	// the sensor problem of the whole parse
+	setSensorProblem("", strings.Join(problems, ", "))
+	clients = mergeClients(clients)
+	for i := range clients {
+		if bssid, ok := associations[clients[i].MAC]; ok {
+			clients[i].BSSID, clients[i].Associated = bssid, true
+		}
+	}
+	return mergeAPs(aps), clients
+}
+
+// csvSource is an airodump-ng CSV file or a Kismet log, file gives the name of the file which can change, ie when
+// airodump-ng restarts, profile is the CSV profile of the columns, airodump-ng's if empty
+type csvSource struct {
+	name    string
+	format  string
+	profile string
+	file    func() string
+}
+
+func airodumpSource(name, format, profile string, file func() string) DataSource {
+	return csvSource{name: name, format: format, profile: profile, file: file}
+}
+
+func (s csvSource) Name() string {
+	return s.name
+}
+
+func (s csvSource) Poll() (Batch, error) {
+	file := s.file()
+	b := Batch{Source: s.name}
+	b.Written, b.Problem = fileProblem(file)
+	if file == "" {
+		return b, nil
+	}
+	var problem string
+	switch fileFormat(s.format, file) {
+	case FormatCSV:
+		layout, ok := csvLayoutFor(s.profile)
+		if !ok {
+			b.Problem = "unknown CSV profile " + s.profile
+			return b, nil
+		}
+		b.APs, b.Clients, problem = parseAirodumpCsv(file, layout)
+	case FormatNetxml:
+		b.APs, b.Clients, problem = parseNetxml(file)
+	case FormatKismetDB:
+		b.APs, b.Clients, problem = parseKismetDB(file)
+	default:
+		problem = "unknown format " + s.format
+	}
+	if b.Problem == "" {
+		b.Problem = problem
+	}
+	return b, nil
+}
+
+// send a batch every time airodump-ng writes the file, every 5 seconds by default
+func (s csvSource) Watch(stop <-chan struct{}) <-chan Batch {
+	batches := make(chan Batch)
+	go func() {
+		defer close(batches)
+		var written time.Time
+		ticker := time.NewTicker(sourceWatchInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+			info, err := os.Stat(s.file())
+			if err != nil || !info.ModTime().After(written) {
+				continue
+			}
+			written = info.ModTime()
+			batch, err := s.Poll()
+			if err != nil {
+				continue
+			}
+			select {
+			case batches <- batch:
+			case <-stop:
+				return
+			}
+		}
+	}()
+	return batches
+}
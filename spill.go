@@ -0,0 +1,520 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// where the devices that don't fit in memory are kept
+const spillFile = "spilled.jsonl"
+
+// spilling goes down to this part of the cap, so it doesn't happen again in the next parse
+const spillTarget = 0.9
+
+// SpilledDevice is an access point or client moved out of memory
+type SpilledDevice struct {
+	Time        time.Time                  `json:"time"` // when it was spilled
+	AccessPoint *AccessPoint               `json:"ap,omitempty"`
+	Client      *Client                    `json:"client,omitempty"`
+	State       map[string]json.RawMessage `json:"state,omitempty"` // what is kept about it outside the store
+}
+
+// what is kept about a device outside the store, it goes to disk with the device and comes back with it
+type spilledState struct {
+	name    string
+	get     func(mac string) interface{} // nil if there is nothing
+	forget  func(mac string)
+	restore func(mac string, data json.RawMessage) // only if nothing was kept since
+}
+
+// the collector caches are only forgotten, a device collected again is rebased on the spilled one, they are
+// only used by a parse so they have no lock
+var spilledStates = []spilledState{
+	{"history", func(mac string) interface{} {
+		historyMutex.RLock()
+		defer historyMutex.RUnlock()
+		if samples, ok := history[mac]; ok {
+			return samples
+		}
+		return nil
+	}, func(mac string) {
+		historyMutex.Lock()
+		defer historyMutex.Unlock()
+		delete(history, mac)
+	}, func(mac string, data json.RawMessage) {
+		historyMutex.Lock()
+		defer historyMutex.Unlock()
+		var samples []Sample
+		if _, ok := history[mac]; !ok && json.Unmarshal(data, &samples) == nil {
+			history[mac] = samples
+		}
+	}},
+	{"probes", func(mac string) interface{} {
+		probeHistoryMutex.RLock()
+		defer probeHistoryMutex.RUnlock()
+		if records, ok := probeHistory[mac]; ok {
+			return records
+		}
+		return nil
+	}, func(mac string) {
+		probeHistoryMutex.Lock()
+		defer probeHistoryMutex.Unlock()
+		delete(probeHistory, mac)
+	}, func(mac string, data json.RawMessage) {
+		probeHistoryMutex.Lock()
+		defer probeHistoryMutex.Unlock()
+		var records []ProbeRecord
+		if _, ok := probeHistory[mac]; !ok && json.Unmarshal(data, &records) == nil {
+			probeHistory[mac] = records
+		}
+	}},
+	{"flux", func(mac string) interface{} {
+		fluxMutex.RLock()
+		defer fluxMutex.RUnlock()
+		if seenMACs[mac] {
+			return true
+		}
+		return nil
+	}, func(mac string) {
+		fluxMutex.Lock()
+		defer fluxMutex.Unlock()
+		if seenMACs[mac] {
+			delete(seenMACs, mac)
+			seenSpilled++
+		}
+	}, func(mac string, data json.RawMessage) {
+		fluxMutex.Lock()
+		defer fluxMutex.Unlock()
+		if !seenMACs[mac] {
+			seenMACs[mac] = true
+			seenSpilled--
+		}
+	}},
+	{"observed", func(mac string) interface{} {
+		observationsMutex.Lock()
+		defer observationsMutex.Unlock()
+		if t, ok := lastObserved[mac]; ok {
+			return t
+		}
+		return nil
+	}, func(mac string) {
+		observationsMutex.Lock()
+		defer observationsMutex.Unlock()
+		delete(lastObserved, mac)
+	}, func(mac string, data json.RawMessage) {
+		observationsMutex.Lock()
+		defer observationsMutex.Unlock()
+		var t time.Time
+		if _, ok := lastObserved[mac]; !ok && json.Unmarshal(data, &t) == nil {
+			lastObserved[mac] = t
+		}
+	}},
+	{"sighting", func(mac string) interface{} {
+		gpsMutex.RLock()
+		defer gpsMutex.RUnlock()
+		if s, ok := lastSighting[mac]; ok {
+			return s
+		}
+		return nil
+	}, func(mac string) {
+		gpsMutex.Lock()
+		defer gpsMutex.Unlock()
+		delete(lastSighting, mac)
+	}, func(mac string, data json.RawMessage) {
+		gpsMutex.Lock()
+		defer gpsMutex.Unlock()
+		var s Sighting
+		if _, ok := lastSighting[mac]; !ok && json.Unmarshal(data, &s) == nil {
+			lastSighting[mac] = s
+		}
+	}},
+	{"hours", func(mac string) interface{} {
+		presenceHoursMutex.RLock()
+		defer presenceHoursMutex.RUnlock()
+		if p, ok := presenceHours[mac]; ok {
+			return p
+		}
+		return nil
+	}, func(mac string) {
+		presenceHoursMutex.Lock()
+		defer presenceHoursMutex.Unlock()
+		delete(presenceHours, mac)
+	}, func(mac string, data json.RawMessage) {
+		presenceHoursMutex.Lock()
+		defer presenceHoursMutex.Unlock()
+		var p PresenceHours
+		if _, ok := presenceHours[mac]; !ok && json.Unmarshal(data, &p) == nil {
+			presenceHours[mac] = p
+		}
+	}},
+	{"fingerprint", func(mac string) interface{} {
+		fingerprintsMutex.RLock()
+		defer fingerprintsMutex.RUnlock()
+		if record, ok := fingerprints[mac]; ok {
+			return *record
+		}
+		return nil
+	}, func(mac string) {
+		fingerprintsMutex.Lock()
+		defer fingerprintsMutex.Unlock()
+		delete(fingerprints, mac)
+	}, func(mac string, data json.RawMessage) {
+		fingerprintsMutex.Lock()
+		defer fingerprintsMutex.Unlock()
+		var record IERecord
+		if _, ok := fingerprints[mac]; !ok && json.Unmarshal(data, &record) == nil {
+			fingerprints[mac] = &record
+		}
+	}},
+	{"beacon", func(mac string) interface{} {
+		beaconsMutex.RLock()
+		defer beaconsMutex.RUnlock()
+		if record, ok := beacons[mac]; ok {
+			return *record
+		}
+		return nil
+	}, func(mac string) {
+		beaconsMutex.Lock()
+		defer beaconsMutex.Unlock()
+		delete(beacons, mac)
+	}, func(mac string, data json.RawMessage) {
+		beaconsMutex.Lock()
+		defer beaconsMutex.Unlock()
+		var record BeaconRecord
+		if _, ok := beacons[mac]; !ok && json.Unmarshal(data, &record) == nil {
+			beacons[mac] = &record
+		}
+	}},
+	{"ssids", func(mac string) interface{} {
+		ssidHistoryMutex.RLock()
+		defer ssidHistoryMutex.RUnlock()
+		if records, ok := ssidHistory[mac]; ok {
+			return records
+		}
+		return nil
+	}, func(mac string) {
+		ssidHistoryMutex.Lock()
+		defer ssidHistoryMutex.Unlock()
+		delete(ssidHistory, mac)
+	}, func(mac string, data json.RawMessage) {
+		ssidHistoryMutex.Lock()
+		defer ssidHistoryMutex.Unlock()
+		var records []SSIDRecord
+		if _, ok := ssidHistory[mac]; !ok && json.Unmarshal(data, &records) == nil {
+			ssidHistory[mac] = records
+		}
+	}},
+	{"meta", func(mac string) interface{} {
+		deviceMetaMutex.Lock()
+		defer deviceMetaMutex.Unlock()
+		if m, ok := deviceMeta[mac]; ok {
+			return m
+		}
+		return nil
+	}, func(mac string) {
+		deviceMetaMutex.Lock()
+		defer deviceMetaMutex.Unlock()
+		delete(deviceMeta, mac)
+	}, func(mac string, data json.RawMessage) {
+		deviceMetaMutex.Lock()
+		defer deviceMetaMutex.Unlock()
+		var m DeviceMeta
+		if _, ok := deviceMeta[mac]; !ok && json.Unmarshal(data, &m) == nil {
+			deviceMeta[mac] = m
+		}
+	}},
+	{"collected", func(mac string) interface{} {
+		return nil
+	}, func(mac string) {
+		delete(firstSeen, mac)
+		delete(frameAPs, mac)
+		delete(frameClients, mac)
+	}, func(mac string, data json.RawMessage) {}},
+}
+
+// what is kept about a device outside the store
+func deviceStateOf(mac string) map[string]json.RawMessage {
+	state := make(map[string]json.RawMessage)
+	for _, s := range spilledStates {
+		if v := s.get(mac); v != nil {
+			if data, err := json.Marshal(v); err == nil {
+				state[s.name] = data
+			}
+		}
+	}
+	return state
+}
+
+func forgetDeviceState(mac string) {
+	for _, s := range spilledStates {
+		s.forget(mac)
+	}
+}
+
+func restoreDeviceState(mac string, state map[string]json.RawMessage) {
+	for _, s := range spilledStates {
+		if data, ok := state[s.name]; ok {
+			s.restore(mac, data)
+		}
+	}
+}
+
+// MACs of the devices in the spill file and when they were spilled, the file has older copies of some
+var spilled = make(map[string]time.Time)
+var spilledLines int
+var spillMutex sync.Mutex
+
+func loadSpilled() {
+	spillMutex.Lock()
+	defer spillMutex.Unlock()
+	spilled, spilledLines = make(map[string]time.Time), 0
+	check(scanJSONLines(spillFile, func(line []byte) {
+		var d SpilledDevice
+		if json.Unmarshal(line, &d) != nil {
+			return
+		}
+		spilledLines++
+		if mac := d.mac(); d.Time.After(spilled[mac]) {
+			spilled[mac] = d.Time
+		}
+	}), "Cannot load spilled devices:")
+}
+
+func (d SpilledDevice) mac() string {
+	if d.AccessPoint != nil {
+		return d.AccessPoint.MAC
+	}
+	if d.Client != nil {
+		return d.Client.MAC
+	}
+	return ""
+}
+
+// move the devices seen least recently to disk when there are more than the cap, the ones seen in this parse
+// stay as they would only come back in the next
+func spillDevices(aps []AccessPoint, clients []Client, seen map[string]bool, max int) ([]AccessPoint, []Client) {
+	if max <= 0 || len(aps)+len(clients) <= max {
+		return aps, clients
+	}
+	type candidate struct {
+		mac      string
+		lastSeen time.Time
+	}
+	var candidates []candidate
+	for _, ap := range aps {
+		if !seen[ap.MAC] {
+			candidates = append(candidates, candidate{ap.MAC, ap.LastSeen})
+		}
+	}
+	for _, c := range clients {
+		if !seen[c.MAC] {
+			candidates = append(candidates, candidate{c.MAC, c.LastSeen})
+		}
+	}
+	n := len(aps) + len(clients) - int(float64(max)*spillTarget)
+	if n > len(candidates) {
+		n = len(candidates)
+	}
+	if n <= 0 {
+		return aps, clients
+	}
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].lastSeen.Before(candidates[j].lastSeen) })
+	spill := make(map[string]bool, n)
+	for _, c := range candidates[:n] {
+		spill[c.mac] = true
+	}
+
+	now := time.Now()
+	var out []interface{}
+	keptAPs := make([]AccessPoint, 0, len(aps))
+	for i := range aps {
+		if spill[aps[i].MAC] {
+			out = append(out, SpilledDevice{Time: now, AccessPoint: &aps[i], State: deviceStateOf(aps[i].MAC)})
+		} else {
+			keptAPs = append(keptAPs, aps[i])
+		}
+	}
+	keptClients := make([]Client, 0, len(clients))
+	for i := range clients {
+		if spill[clients[i].MAC] {
+			out = append(out, SpilledDevice{Time: now, Client: &clients[i], State: deviceStateOf(clients[i].MAC)})
+		} else {
+			keptClients = append(keptClients, clients[i])
+		}
+	}
+
+	spillMutex.Lock()
+	defer spillMutex.Unlock()
+	err := appendJSONLines(spillFile, out...)
+	if err != nil {
+		// better to go over the cap than to lose the devices
+		fmt.Println("Cannot spill devices:", err)
+		return aps, clients
+	}
+	for _, v := range out {
+		mac := v.(SpilledDevice).mac()
+		spilled[mac] = now
+		forgetDeviceState(mac)
+	}
+	spilledLines += len(out)
+	fmt.Println("Spilled", len(out), "devices to disk,", len(spilled), "on disk")
+	if spilledLines > 2*len(spilled) {
+		check(compactSpilled(), "Cannot compact spilled devices:")
+	}
+	return keptAPs, keptClients
+}
+
+// read the spilled devices with the MACs, the newest copy of each with what was kept about them, and forget
+// they were spilled
+func restoreSpilled(macs map[string]bool) (aps []AccessPoint, clients []Client) {
+	spillMutex.Lock()
+	defer spillMutex.Unlock()
+	wanted := make(map[string]time.Time)
+	for mac := range macs {
+		if t, ok := spilled[mac]; ok {
+			wanted[mac] = t
+		}
+	}
+	if len(wanted) == 0 {
+		return
+	}
+	fmt.Println("Restoring", len(wanted), "spilled devices")
+	check(scanJSONLines(spillFile, func(line []byte) {
+		var d SpilledDevice
+		if json.Unmarshal(line, &d) != nil {
+			return
+		}
+		if t, ok := wanted[d.mac()]; !ok || !d.Time.Equal(t) {
+			return
+		}
+		if d.AccessPoint != nil {
+			aps = append(aps, *d.AccessPoint)
+		} else {
+			clients = append(clients, *d.Client)
+		}
+		restoreDeviceState(d.mac(), d.State)
+	}), "Cannot restore spilled devices:")
+	for mac := range wanted {
+		delete(spilled, mac)
+	}
+	return
+}
+
+// find a spilled device without restoring it
+func findSpilled(mac string) (client *Client, ap *AccessPoint) {
+	spillMutex.Lock()
+	defer spillMutex.Unlock()
+	t, ok := spilled[mac]
+	if !ok {
+		return
+	}
+	check(scanJSONLines(spillFile, func(line []byte) {
+		var d SpilledDevice
+		if json.Unmarshal(line, &d) != nil || d.mac() != mac || !d.Time.Equal(t) {
+			return
+		}
+		client, ap = d.Client, d.AccessPoint
+	}), "Cannot read spilled devices:")
+	return
+}
+
+// rewrite the spill file with only the newest copy of the devices still spilled, the caller holds the lock
+func compactSpilled() error {
+	var keep []interface{}
+	err := scanJSONLines(spillFile, func(line []byte) {
+		var d SpilledDevice
+		if json.Unmarshal(line, &d) == nil && d.Time.Equal(spilled[d.mac()]) {
+			keep = append(keep, d)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	file := filepath.Join(*dataDir, spillFile)
+	os.Remove(file + ".tmp")
+	if err = appendJSONLines(spillFile+".tmp", keep...); err != nil {
+		return err
+	}
+	spilledLines = len(keep)
+	return os.Rename(file+".tmp", file)
+}
+
+// the spilled devices seen again in this parse go back to the known ones so they aren't new
+func restoreStage(in *Ingest) {
+	aps, clients := restoreSpilled(in.seen())
+	if len(aps)+len(clients) == 0 {
+		return
+	}
+	in.OldAPs = append(append([]AccessPoint{}, in.OldAPs...), aps...)
+	in.OldClients = append(append([]Client{}, in.OldClients...), clients...)
+	rebaseCollected(in, aps, clients)
+}
+
+// the collectors forgot the spilled devices, so the ones collected again start over from when they were seen
+// again, they go on from the spilled ones instead
+func rebaseCollected(in *Ingest, aps []AccessPoint, clients []Client) {
+	spilledAPs := make(map[string]AccessPoint, len(aps))
+	for _, ap := range aps {
+		spilledAPs[ap.MAC] = ap
+		if t, ok := firstSeen[ap.MAC]; ok && ap.FirstSeen.Before(t) {
+			firstSeen[ap.MAC] = ap.FirstSeen
+		}
+		if a := frameAPs[ap.MAC]; a != nil {
+			rebaseAP(a, ap)
+		}
+	}
+	for i := range in.APs {
+		if ap, ok := spilledAPs[in.APs[i].MAC]; ok {
+			rebaseAP(&in.APs[i], ap)
+		}
+	}
+	spilledClients := make(map[string]Client, len(clients))
+	for _, c := range clients {
+		spilledClients[c.MAC] = c
+		if fc := frameClients[c.MAC]; fc != nil {
+			rebaseClient(fc, c)
+		}
+	}
+	for i := range in.Clients {
+		if c, ok := spilledClients[in.Clients[i].MAC]; ok {
+			rebaseClient(&in.Clients[i], c)
+		}
+	}
+}
+
+// only the frame collector counts from the start, the others report the counts of the tool they read
+func rebaseAP(ap *AccessPoint, old AccessPoint) {
+	if old.FirstSeen.Before(ap.FirstSeen) {
+		ap.FirstSeen = old.FirstSeen
+	}
+	if frameAPs[ap.MAC] != nil {
+		ap.Beacons += old.Beacons
+		ap.DataPackets += old.DataPackets
+	}
+}
+
+func rebaseClient(c *Client, old Client) {
+	if old.FirstSeen.Before(c.FirstSeen) {
+		c.FirstSeen = old.FirstSeen
+	}
+	if frameClients[c.MAC] != nil {
+		c.Packets += old.Packets
+	}
+}
+
+// the MACs of the devices found in this parse
+func (in *Ingest) seen() map[string]bool {
+	macs := make(map[string]bool, len(in.APs)+len(in.Clients))
+	for _, ap := range in.APs {
+		macs[ap.MAC] = true
+	}
+	for _, c := range in.Clients {
+		macs[c.MAC] = true
+	}
+	return macs
+}
@@ -0,0 +1,321 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+)
+
+// https://www.sqlite.org/fileformat.html, only enough to read every row of a table of a database file, for
+// Kismet's kismetdb logs, what is still in the journal or WAL file of a database being written isn't read
+
+// b-tree page types
+const (
+	sqliteInteriorTable = 0x05
+	sqliteLeafTable     = 0x0d
+)
+
+type sqliteDB struct {
+	file     *os.File
+	pageSize int
+	usable   int // page size without the reserved bytes at the end of every page
+	pages    int
+}
+
+func openSQLite(name string) (*sqliteDB, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	header := make([]byte, 100)
+	if _, err = file.ReadAt(header, 0); err != nil || string(header[:16]) != "SQLite format 3\x00" {
+		file.Close()
+		return nil, errors.New("not an SQLite database")
+	}
+	db := &sqliteDB{file: file, pageSize: int(binary.BigEndian.Uint16(header[16:]))}
+	if db.pageSize == 1 {
+		db.pageSize = 65536
+	}
+	if db.pageSize < 512 || db.pageSize&(db.pageSize-1) != 0 {
+		file.Close()
+		return nil, fmt.Errorf("invalid page size %d", db.pageSize)
+	}
+	if encoding := binary.BigEndian.Uint32(header[56:]); encoding > 1 {
+		file.Close()
+		return nil, errors.New("only UTF-8 databases can be read")
+	}
+	db.usable = db.pageSize - int(header[20])
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	db.pages = int(info.Size() / int64(db.pageSize))
+	return db, nil
+}
+
+func (db *sqliteDB) close() {
+	db.file.Close()
+}
+
+func (db *sqliteDB) page(n int) ([]byte, error) {
+	if n < 1 || n > db.pages {
+		return nil, fmt.Errorf("page %d out of range", n)
+	}
+	page := make([]byte, db.pageSize)
+	_, err := db.file.ReadAt(page, int64(n-1)*int64(db.pageSize))
+	return page, err
+}
+
+// a variable length integer and its length, 0 if it is cut short
+func sqliteVarint(b []byte) (int64, int) {
+	var v uint64
+	for i := 0; i < len(b); i++ {
+		if i == 8 {
+			return int64(v<<8 | uint64(b[i])), 9
+		}
+		v = v<<7 | uint64(b[i]&0x7f)
+		if b[i]&0x80 == 0 {
+			return int64(v), i + 1
+		}
+	}
+	return 0, 0
+}
+
+// call fn with the record of every row of the table b-tree rooted at a page, in rowid order
+func (db *sqliteDB) walk(root int, fn func(record []byte) error) error {
+	return db.walkPage(root, 0, make(map[int]bool), fn)
+}
+
+func (db *sqliteDB) walkPage(n, depth int, visited map[int]bool, fn func(record []byte) error) error {
+	// a b-tree can't be deeper than this, a page twice means the pages point in a loop
+	if depth > 40 || visited[n] {
+		return errors.New("malformed database, b-tree pages in a loop")
+	}
+	visited[n] = true
+	page, err := db.page(n)
+	if err != nil {
+		return err
+	}
+	header := 0
+	if n == 1 {
+		header = 100
+	}
+	kind := page[header]
+	count := int(binary.BigEndian.Uint16(page[header+3:]))
+	cells := header + 8
+	if kind == sqliteInteriorTable {
+		cells = header + 12
+	} else if kind != sqliteLeafTable {
+		return fmt.Errorf("malformed database, page %d is not a table page", n)
+	}
+	if cells+2*count > db.usable {
+		return fmt.Errorf("malformed database, too many cells on page %d", n)
+	}
+	for i := 0; i < count; i++ {
+		offset := int(binary.BigEndian.Uint16(page[cells+2*i:]))
+		if offset+4 > db.usable {
+			return fmt.Errorf("malformed database, cell out of page %d", n)
+		}
+		cell := page[offset:db.usable]
+		if kind == sqliteInteriorTable {
+			if err = db.walkPage(int(binary.BigEndian.Uint32(cell)), depth+1, visited, fn); err != nil {
+				return err
+			}
+			continue
+		}
+		size, a := sqliteVarint(cell)
+		_, b := sqliteVarint(cell[a:])
+		if a == 0 || b == 0 {
+			return fmt.Errorf("malformed database, cell cut short on page %d", n)
+		}
+		record, err := db.payload(cell[a+b:], int(size))
+		if err != nil {
+			return err
+		}
+		if err = fn(record); err != nil {
+			return err
+		}
+	}
+	if kind == sqliteInteriorTable {
+		return db.walkPage(int(binary.BigEndian.Uint32(page[header+8:])), depth+1, visited, fn)
+	}
+	return nil
+}
+
+// the payload of a table leaf cell, the part that doesn't fit on the page is on a chain of overflow pages
+func (db *sqliteDB) payload(cell []byte, size int) ([]byte, error) {
+	if size < 0 || size > db.pages*db.pageSize {
+		return nil, errors.New("malformed database, invalid payload size")
+	}
+	local, max := size, db.usable-35
+	if size > max {
+		min := (db.usable-12)*32/255 - 23
+		if local = min + (size-min)%(db.usable-4); local > max {
+			local = min
+		}
+	}
+	if len(cell) < local {
+		return nil, errors.New("malformed database, payload cut short")
+	}
+	if local == size {
+		return cell[:size], nil
+	}
+	if len(cell) < local+4 {
+		return nil, errors.New("malformed database, payload cut short")
+	}
+	payload := append(make([]byte, 0, size), cell[:local]...)
+	next := int(binary.BigEndian.Uint32(cell[local:]))
+	for followed := 0; len(payload) < size; followed++ {
+		if next == 0 || followed > db.pages {
+			return nil, errors.New("malformed database, overflow pages cut short")
+		}
+		page, err := db.page(next)
+		if err != nil {
+			return nil, err
+		}
+		next = int(binary.BigEndian.Uint32(page))
+		n := size - len(payload)
+		if n > db.usable-4 {
+			n = db.usable - 4
+		}
+		payload = append(payload, page[4:4+n]...)
+	}
+	return payload, nil
+}
+
+// the values of a record, each nil, int64, float64, string or []byte
+func sqliteRecord(record []byte) ([]interface{}, error) {
+	headerSize, n := sqliteVarint(record)
+	if n == 0 || headerSize < int64(n) || headerSize > int64(len(record)) {
+		return nil, errors.New("malformed database, invalid record header")
+	}
+	var types []int64
+	for pos := n; pos < int(headerSize); {
+		t, m := sqliteVarint(record[pos:headerSize])
+		if m == 0 {
+			return nil, errors.New("malformed database, invalid record header")
+		}
+		types = append(types, t)
+		pos += m
+	}
+	body := record[headerSize:]
+	values := make([]interface{}, len(types))
+	for i, t := range types {
+		var size int64
+		switch {
+		case t >= 1 && t <= 6:
+			size = []int64{0, 1, 2, 3, 4, 6, 8}[t]
+		case t == 7:
+			size = 8
+		case t >= 12:
+			size = (t - 12) / 2
+		case t < 0 || t == 10 || t == 11:
+			return nil, errors.New("malformed database, invalid serial type")
+		}
+		if size > int64(len(body)) {
+			return nil, errors.New("malformed database, record cut short")
+		}
+		v := body[:size]
+		body = body[size:]
+		switch {
+		case t == 0:
+			values[i] = nil
+		case t == 8 || t == 9:
+			values[i] = t - 8
+		case t <= 6:
+			// big-endian two's complement
+			n := int64(int8(v[0]))
+			for _, b := range v[1:] {
+				n = n<<8 | int64(b)
+			}
+			values[i] = n
+		case t == 7:
+			values[i] = math.Float64frombits(binary.BigEndian.Uint64(v))
+		case t%2 == 0:
+			values[i] = v
+		default:
+			values[i] = string(v)
+		}
+	}
+	return values, nil
+}
+
+// the column names of a CREATE TABLE statement in order, without the table constraints
+func sqliteColumns(sql string) (columns []string) {
+	start, end := strings.Index(sql, "("), strings.LastIndex(sql, ")")
+	if start < 0 || end < start {
+		return
+	}
+	var definitions []string
+	depth, from := 0, start+1
+	for i := start + 1; i < end; i++ {
+		switch sql[i] {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				definitions = append(definitions, sql[from:i])
+				from = i + 1
+			}
+		}
+	}
+	definitions = append(definitions, sql[from:end])
+	for _, d := range definitions {
+		fields := strings.Fields(d)
+		if len(fields) == 0 {
+			continue
+		}
+		switch strings.ToUpper(fields[0]) {
+		case "CONSTRAINT", "PRIMARY", "UNIQUE", "CHECK", "FOREIGN":
+			continue
+		}
+		columns = append(columns, strings.Trim(fields[0], "\"`[]"))
+	}
+	return
+}
+
+// call fn with every row of a table by column name, columns added after a row was written are missing from it
+func (db *sqliteDB) table(name string, fn func(row map[string]interface{})) error {
+	root, columns := 0, []string(nil)
+	// the schema table is on page 1, its columns are type, name, tbl_name, rootpage and sql
+	err := db.walk(1, func(record []byte) error {
+		values, err := sqliteRecord(record)
+		if err != nil || len(values) < 5 {
+			return err
+		}
+		kind, _ := values[0].(string)
+		table, _ := values[1].(string)
+		page, _ := values[3].(int64)
+		sql, _ := values[4].(string)
+		if kind == "table" && strings.EqualFold(table, name) {
+			root, columns = int(page), sqliteColumns(sql)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if root == 0 {
+		return fmt.Errorf("no %s table", name)
+	}
+	return db.walk(root, func(record []byte) error {
+		values, err := sqliteRecord(record)
+		if err != nil {
+			return err
+		}
+		row := make(map[string]interface{}, len(columns))
+		for i, column := range columns {
+			if i < len(values) {
+				row[column] = values[i]
+			}
+		}
+		fn(row)
+		return nil
+	})
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+// testdata/small.kismet is a kismetdb log with 1 KB pages, an access point with a device record spilling onto
+// overflow pages and enough clients for the devices table to need interior pages
+const smallKismet = "testdata/small.kismet"
+
+func readDevices(file string) (rows []map[string]interface{}, err error) {
+	db, err := openSQLite(file)
+	if err != nil {
+		return nil, err
+	}
+	defer db.close()
+	err = db.table("devices", func(row map[string]interface{}) {
+		rows = append(rows, row)
+	})
+	return
+}
+
+func TestSQLiteKismetDB(t *testing.T) {
+	rows, err := readDevices(smallKismet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 61 {
+		t.Fatalf("read %d devices, want 61", len(rows))
+	}
+	ap := rows[0]
+	if ap["devmac"] != "AA:BB:CC:00:00:01" || ap["phyname"] != "IEEE802.11" || ap["first_time"] != int64(1700000000) {
+		t.Errorf("access point row %v %v %v", ap["devmac"], ap["phyname"], ap["first_time"])
+	}
+	if ap["avg_lat"] != 1.3521 {
+		t.Errorf("avg_lat %v, want 1.3521", ap["avg_lat"])
+	}
+	blob, _ := ap["device"].([]byte)
+	var d kismetDevice
+	if err = json.Unmarshal(blob, &d); err != nil || d.Type != "Wi-Fi AP" || d.Crypt != "WPA2-PSK" {
+		t.Errorf("device record of %d bytes on overflow pages: %v %+v", len(blob), err, d)
+	}
+	if last := rows[60]; last["devmac"] != "AA:BB:CC:10:00:3B" || last["bytes_data"] != int64(5900) {
+		t.Errorf("last client row %v %v", last["devmac"], last["bytes_data"])
+	}
+}
+
+func TestSQLiteTruncated(t *testing.T) {
+	data, err := ioutil.ReadFile(smallKismet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(t.TempDir(), "truncated.kismet")
+	// without the header or the schema on the first page nothing can be read, cut further on it has to stop
+	// reading without a panic
+	for _, size := range []int{0, 50, 100, 1023, 1500, len(data) / 2, len(data) - 1024} {
+		if err = ioutil.WriteFile(file, data[:size], 0600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := readDevices(file); err == nil && size < 1024 {
+			t.Errorf("cut to %d bytes, no error", size)
+		}
+	}
+}
+
+// corrupting any byte has to give an error or wrong rows, never a panic or a hang
+func TestSQLiteCorrupted(t *testing.T) {
+	data, err := ioutil.ReadFile(smallKismet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(t.TempDir(), "corrupted.kismet")
+	for i := 0; i < len(data); i += 13 {
+		for _, b := range []byte{0x00, 0xff, data[i] ^ 0x80} {
+			corrupted := append([]byte{}, data...)
+			corrupted[i] = b
+			if err = ioutil.WriteFile(file, corrupted, 0600); err != nil {
+				t.Fatal(err)
+			}
+			readDevices(file)
+		}
+	}
+}
+
+func TestSQLiteRecordSerialTypes(t *testing.T) {
+	for _, record := range [][]byte{
+		{10, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, // 9 byte varint, a negative serial type
+		{2, 10},                        // reserved
+		{2, 0x81, 0x00},                // header cut short
+		{3, 0x8f, 0x7f, 'a'},           // text longer than the record
+		{0xff, 0xff, 0xff, 0xff, 0xff}, // header size cut short
+		{2, 6, 1, 2, 3},                // integer cut short
+		{2, 1},                         // no body
+	} {
+		if _, err := sqliteRecord(record); err == nil {
+			t.Errorf("record %v gave no error", record)
+		}
+	}
+	values, err := sqliteRecord([]byte{5, 1, 8, 9, 17, 0xfe, 'a', 'b'})
+	if err != nil || len(values) != 4 || values[0] != int64(-2) || values[1] != int64(0) || values[2] != int64(1) ||
+		values[3] != "ab" {
+		t.Errorf("values %v %v", values, err)
+	}
+}
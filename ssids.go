@@ -0,0 +1,258 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// EventSSIDChange is raised when an access point broadcasts an SSID it didn't before
+const EventSSIDChange = "ssid_change"
+
+// SSIDRecord is an SSID broadcast by an access point
+type SSIDRecord struct {
+	SSID      string    `json:"ssid"`
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+	Privacy   []string  `json:"privacy,omitempty"` // every security mode it was broadcast with
+}
+
+var ssidHistory = make(map[string][]SSIDRecord)
+var ssidHistoryMutex sync.RWMutex
+var ssidHistorySaved time.Time
+
+func loadSSIDHistory() {
+	ssidHistoryMutex.Lock()
+	defer ssidHistoryMutex.Unlock()
+	ssidHistory = make(map[string][]SSIDRecord)
+	check(loadJSON("ssid_history.json", &ssidHistory), "Cannot load SSID history:")
+}
+
+// record the SSIDs of the access points, with an event for every new SSID of an AP already known
+func recordSSIDs(aps []AccessPoint) (events []Event) {
+	ssidHistoryMutex.Lock()
+	defer ssidHistoryMutex.Unlock()
+	changed := false
+	for _, ap := range aps {
+		ssid := strings.TrimSpace(ap.Name)
+		if ssid == "" {
+			continue
+		}
+		records := ssidHistory[ap.MAC]
+		found := false
+		for i := range records {
+			if records[i].SSID == ssid {
+				found = true
+				if ap.LastSeen.After(records[i].LastSeen) {
+					records[i].LastSeen = ap.LastSeen
+				}
+				if ap.Privacy != "" && !containsString(records[i].Privacy, ap.Privacy) {
+					records[i].Privacy = append(records[i].Privacy, ap.Privacy)
+					changed = true
+				}
+			}
+		}
+		if found {
+			continue
+		}
+		firstSeen := ap.FirstSeen
+		if len(records) > 0 {
+			// the AP was already seen with another SSID, so this one is new since then
+			firstSeen = ap.LastSeen
+			previous := records[len(records)-1].SSID
+			events = append(events, Event{Type: EventSSIDChange, Time: time.Now(), MAC: ap.MAC, Message: "Access point " + formatMAC(ap.MAC) + " changed SSID from " + previous + " to " + ssid, Data: ap})
+		}
+		record := SSIDRecord{SSID: ssid, FirstSeen: firstSeen, LastSeen: ap.LastSeen}
+		if ap.Privacy != "" {
+			record.Privacy = []string{ap.Privacy}
+		}
+		ssidHistory[ap.MAC] = append(records, record)
+		changed = true
+	}
+	if changed || time.Since(ssidHistorySaved) >= metaSaveInterval {
+		ssidHistorySaved = time.Now()
+		check(saveJSON("ssid_history.json", ssidHistory), "Cannot save SSID history:")
+	}
+	return
+}
+
+// add the SSIDs an access point broadcast that another tool recorded, ie Kismet, to its history
+func importSSIDHistory(mac string, imported []SSIDRecord) {
+	ssidHistoryMutex.Lock()
+	defer ssidHistoryMutex.Unlock()
+	records := ssidHistory[mac]
+	for _, in := range imported {
+		found := false
+		for i := range records {
+			if records[i].SSID != in.SSID {
+				continue
+			}
+			found = true
+			if in.FirstSeen.Before(records[i].FirstSeen) {
+				records[i].FirstSeen = in.FirstSeen
+			}
+			if in.LastSeen.After(records[i].LastSeen) {
+				records[i].LastSeen = in.LastSeen
+			}
+			for _, privacy := range in.Privacy {
+				if !containsString(records[i].Privacy, privacy) {
+					records[i].Privacy = append(records[i].Privacy, privacy)
+				}
+			}
+		}
+		if !found {
+			records = append(records, in)
+		}
+	}
+	sort.SliceStable(records, func(i, j int) bool { return records[i].FirstSeen.Before(records[j].FirstSeen) })
+	ssidHistory[mac] = records
+}
+
+// get a copy of the SSIDs an access point has broadcast
+func getSSIDHistory(mac string) []SSIDRecord {
+	ssidHistoryMutex.RLock()
+	defer ssidHistoryMutex.RUnlock()
+	return append([]SSIDRecord{}, ssidHistory[mac]...)
+}
+
+// every SSID an access point has broadcast at /aps/{mac}/ssids
+func apSSIDs(w http.ResponseWriter, r *http.Request, mac string) {
+	writeJSON(w, getSSIDHistory(mac))
+}
+
+// Prober is a client that probed for an SSID
+type Prober struct {
+	MAC          string    `json:"mac"`
+	Organization string    `json:"organization"`
+	FirstProbed  time.Time `json:"first_probed"`
+	LastProbed   time.Time `json:"last_probed"`
+	Probes       int       `json:"probes"` // probe requests seen, 0 if it only came from the CSV file
+	LastSeen     time.Time `json:"last_seen,omitempty"`
+	BSSID        string    `json:"bssid,omitempty"` // access point it is associated with now
+}
+
+// the clients that ever probed for an SSID, the ones that probed for it last first
+func findProbers(ssid string, clients []Client) []Prober {
+	seen := make(map[string]Client)
+	for _, c := range clients {
+		seen[c.MAC] = c
+	}
+	probers := []Prober{}
+	probeHistoryMutex.RLock()
+	for mac, records := range probeHistory {
+		p := Prober{MAC: mac}
+		for _, record := range records {
+			if record.SSID != ssid {
+				continue
+			}
+			if p.FirstProbed.IsZero() || record.Time.Before(p.FirstProbed) {
+				p.FirstProbed = record.Time
+			}
+			if record.Last.After(p.LastProbed) {
+				p.LastProbed = record.Last
+			}
+			p.Probes += record.Count
+		}
+		if p.FirstProbed.IsZero() {
+			continue
+		}
+		p.Organization = lookupOrganization(mac)
+		if c, ok := seen[mac]; ok {
+			p.LastSeen, p.BSSID = c.LastSeen, formatMAC(c.BSSID)
+		}
+		p.MAC = formatMAC(mac)
+		probers = append(probers, p)
+	}
+	probeHistoryMutex.RUnlock()
+	sort.Slice(probers, func(i, j int) bool { return probers[i].LastProbed.After(probers[j].LastProbed) })
+	return probers
+}
+
+// SSIDSummary is an SSID that was broadcast or probed for
+type SSIDSummary struct {
+	SSID      string    `json:"ssid"`
+	BSSIDs    int       `json:"bssids"`  // access points that broadcast it
+	Probers   int       `json:"probers"` // clients that probed for it
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+	Privacy   []string  `json:"privacy"` // security modes it was broadcast with
+}
+
+// every SSID broadcast or probed for, the last seen first
+func ssidCatalog() []SSIDSummary {
+	summaries := make(map[string]*SSIDSummary)
+	summary := func(ssid string, first, last time.Time) *SSIDSummary {
+		s, ok := summaries[ssid]
+		if !ok {
+			s = &SSIDSummary{SSID: ssid, FirstSeen: first, LastSeen: last, Privacy: []string{}}
+			summaries[ssid] = s
+		}
+		if first.Before(s.FirstSeen) {
+			s.FirstSeen = first
+		}
+		if last.After(s.LastSeen) {
+			s.LastSeen = last
+		}
+		return s
+	}
+	ssidHistoryMutex.RLock()
+	for _, records := range ssidHistory {
+		for _, record := range records {
+			s := summary(record.SSID, record.FirstSeen, record.LastSeen)
+			s.BSSIDs++
+			for _, privacy := range record.Privacy {
+				if !containsString(s.Privacy, privacy) {
+					s.Privacy = append(s.Privacy, privacy)
+				}
+			}
+		}
+	}
+	ssidHistoryMutex.RUnlock()
+	probeHistoryMutex.RLock()
+	for _, records := range probeHistory {
+		probed := make(map[string]bool)
+		for _, record := range records {
+			summary(record.SSID, record.Time, record.Last)
+			probed[record.SSID] = true
+		}
+		for ssid := range probed {
+			summaries[ssid].Probers++
+		}
+	}
+	probeHistoryMutex.RUnlock()
+	catalog := []SSIDSummary{}
+	for _, s := range summaries {
+		sort.Strings(s.Privacy)
+		catalog = append(catalog, *s)
+	}
+	sort.Slice(catalog, func(i, j int) bool { return catalog[i].LastSeen.After(catalog[j].LastSeen) })
+	return catalog
+}
+
+// every SSID broadcast or probed for at /ssids
+func ssidsHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, ssidCatalog())
+}
+
+// routes under /ssids/{essid}, the SSID escaped if it has a slash in it
+func ssidRoutes(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.EscapedPath(), "/ssids/"), "/"), "/")
+	ssid, err := url.PathUnescape(parts[0])
+	if err != nil || ssid == "" {
+		http.NotFound(w, r)
+		return
+	}
+	action := ""
+	if len(parts) > 1 {
+		action = parts[1]
+	}
+	switch action {
+	case "probers":
+		writeJSON(w, findProbers(ssid, store.Clients()))
+	default:
+		http.NotFound(w, r)
+	}
+}
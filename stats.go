@@ -0,0 +1,439 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// VendorStats are the clients of one manufacturer
+type VendorStats struct {
+	Organization string    `json:"organization"`
+	Devices      int       `json:"devices"`
+	AveragePower float64   `json:"average_power"` // only of the devices with a power reading
+	FirstSeen    time.Time `json:"first_seen"`
+	LastSeen     time.Time `json:"last_seen"`
+	URL          string    `json:"url"` // the devices themselves
+}
+
+// group the clients by manufacturer, the ones with the most devices first
+func vendorStats(clients []Client, last int) []VendorStats {
+	stats := make(map[string]*VendorStats)
+	powered := make(map[string]int)
+	for _, client := range clients {
+		org := client.Organization
+		if org == "" {
+			org = "UNKNOWN"
+		}
+		s, ok := stats[org]
+		if !ok {
+			s = &VendorStats{
+				Organization: org,
+				FirstSeen:    client.FirstSeen,
+				LastSeen:     client.LastSeen,
+				URL:          "/clients?last=" + strconv.Itoa(last) + "&organization=" + url.QueryEscape(org),
+			}
+			stats[org] = s
+		}
+		s.Devices++
+		if client.FirstSeen.Before(s.FirstSeen) {
+			s.FirstSeen = client.FirstSeen
+		}
+		if client.LastSeen.After(s.LastSeen) {
+			s.LastSeen = client.LastSeen
+		}
+		// airodump-ng reports -1 when it has no power reading
+		if client.Power < 0 && client.Power != -1 {
+			s.AveragePower += float64(client.Power)
+			powered[org]++
+		}
+	}
+	list := []VendorStats{}
+	for org, s := range stats {
+		if powered[org] > 0 {
+			s.AveragePower /= float64(powered[org])
+		}
+		list = append(list, *s)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Devices != list[j].Devices {
+			return list[i].Devices > list[j].Devices
+		}
+		return list[i].Organization < list[j].Organization
+	})
+	return list
+}
+
+// client counts per manufacturer at /stats/vendors, for clients seen in the last 60 minutes or ?last=minutes
+func statsVendors(w http.ResponseWriter, r *http.Request) {
+	last := 60
+	if lastParam := r.URL.Query().Get("last"); lastParam != "" {
+		n, err := strconv.Atoi(lastParam)
+		if err != nil {
+			http.Error(w, "Invalid last parameter", http.StatusBadRequest)
+			return
+		}
+		last = n
+	}
+	writeJSON(w, vendorStats(filterByLastSeen(store.Clients(), last), last))
+}
+
+// SecurityStats is the breakdown of the access points by encryption
+type SecurityStats struct {
+	Total      int            `json:"total"`
+	Encryption map[string]int `json:"encryption"` // OPN, WEP, WPA, WPA2 and WPA3, by the strongest one supported
+	WPS        int            `json:"wps"`
+	Grades     map[string]int `json:"grades"` // by security grade, A to F
+	Weak       []WeakAP       `json:"weak"`
+}
+
+// WeakAP is an access point that is easy to break into
+type WeakAP struct {
+	MAC            string   `json:"mac"`
+	Name           string   `json:"name"`
+	Privacy        string   `json:"privacy"`
+	Authentication string   `json:"authentication"`
+	Grade          string   `json:"grade"`
+	Reasons        []string `json:"reasons"`
+}
+
+// the strongest encryption in an airodump-ng privacy field like "WPA2 WPA"
+func encryption(ap AccessPoint) string {
+	fields := strings.Fields(ap.Privacy)
+	for _, e := range []string{"WPA3", "WPA2", "WPA", "WEP"} {
+		if containsString(fields, e) {
+			return e
+		}
+	}
+	if containsString(strings.Fields(ap.Authentication), "SAE") {
+		return "WPA3"
+	}
+	if len(fields) == 0 || containsString(fields, "OPN") {
+		return "OPN"
+	}
+	return "UNKNOWN"
+}
+
+// the grades of security scores, the lowest score of each grade
+var securityGrades = []struct {
+	grade string
+	score int
+}{{"A", 90}, {"B", 75}, {"C", 60}, {"D", 40}, {"E", 20}, {"F", 0}}
+
+// how hard an access point is to break into from 0 to 100 and as a grade from A to F, by its strongest
+// encryption with WPS taking it down
+func securityScore(ap AccessPoint) (score int, grade string) {
+	auth := strings.Fields(ap.Authentication)
+	switch encryption(ap) {
+	case "WPA3":
+		score = 100
+		// transition mode lets clients be downgraded to WPA2
+		if containsString(auth, "PSK") {
+			score = 85
+		}
+	case "WPA2":
+		score = 80
+		if containsString(auth, "MGT") {
+			score = 90
+		}
+	case "WPA":
+		score = 45
+	case "WEP":
+		score = 20
+	case "OPN":
+		// enhanced open encrypts without a password
+		if containsString(auth, "OWE") {
+			score = 60
+		}
+	default:
+		score = 50
+	}
+	if ap.WPS && score > 0 {
+		score -= 25
+		if score < 0 {
+			score = 0
+		}
+	}
+	for _, g := range securityGrades {
+		if score >= g.score {
+			return score, g.grade
+		}
+	}
+	return score, "F"
+}
+
+// score every access point, after the plugins so the WPS they find counts
+func scoreAPs(aps []AccessPoint) {
+	for i := range aps {
+		aps[i].SecurityScore, aps[i].SecurityGrade = securityScore(aps[i])
+	}
+}
+
+func securityStats(aps []AccessPoint) SecurityStats {
+	stats := SecurityStats{
+		Total:      len(aps),
+		Encryption: map[string]int{"OPN": 0, "WEP": 0, "WPA": 0, "WPA2": 0, "WPA3": 0},
+		Grades:     map[string]int{},
+		Weak:       []WeakAP{},
+	}
+	for _, g := range securityGrades {
+		stats.Grades[g.grade] = 0
+	}
+	for _, ap := range aps {
+		_, grade := securityScore(ap)
+		stats.Grades[grade]++
+		e := encryption(ap)
+		stats.Encryption[e]++
+		var reasons []string
+		switch e {
+		case "OPN":
+			reasons = append(reasons, "open network")
+		case "WEP":
+			reasons = append(reasons, "WEP can be cracked in minutes")
+		case "WPA":
+			reasons = append(reasons, "WPA (TKIP) is deprecated")
+		}
+		if ap.WPS {
+			stats.WPS++
+			reasons = append(reasons, "WPS PIN can be brute forced")
+		}
+		if len(reasons) > 0 {
+			stats.Weak = append(stats.Weak, WeakAP{
+				MAC:            formatMAC(ap.MAC),
+				Name:           ap.Name,
+				Privacy:        ap.Privacy,
+				Authentication: ap.Authentication,
+				Grade:          grade,
+				Reasons:        reasons,
+			})
+		}
+	}
+	return stats
+}
+
+// access points by encryption at /stats/security, with the weak ones listed
+func statsSecurity(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, securityStats(store.APs()))
+}
+
+// APActivity is how busy an access point is in /stats/activity
+type APActivity struct {
+	MAC         string    `json:"mac"`
+	Name        string    `json:"name"`
+	Beacons     int       `json:"beacons"`
+	DataPackets int       `json:"data_packets"`
+	DataRate    float64   `json:"data_per_minute"`
+	LastData    time.Time `json:"last_data"`
+}
+
+// ActivityStats are the access points sending data and the idle ones that only beacon
+type ActivityStats struct {
+	Total  int          `json:"total"`
+	Active int          `json:"active"`
+	Idle   int          `json:"idle"`
+	APs    []APActivity `json:"aps"` // the active ones, busiest first
+}
+
+func activityStats(aps []AccessPoint) ActivityStats {
+	stats := ActivityStats{Total: len(aps), APs: []APActivity{}}
+	for _, ap := range aps {
+		if !ap.Active {
+			stats.Idle++
+			continue
+		}
+		stats.Active++
+		stats.APs = append(stats.APs, APActivity{MAC: formatMAC(ap.MAC), Name: ap.Name, Beacons: ap.Beacons,
+			DataPackets: ap.DataPackets, DataRate: ap.DataRate, LastData: ap.LastData})
+	}
+	sort.Slice(stats.APs, func(i, j int) bool { return stats.APs[i].DataRate > stats.APs[j].DataRate })
+	return stats
+}
+
+// active and idle access points at /stats/activity, an access point is idle when it hasn't sent data for 5 minutes
+func statsActivity(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, activityStats(store.APs()))
+}
+
+// width of the buckets of the power histogram in dBm
+const powerBucket = 5
+
+// PowerStats is the spread of the power readings of a device
+type PowerStats struct {
+	MAC     string `json:"mac"`
+	Samples int    `json:"samples"`
+	Min     int    `json:"min"`
+	Max     int    `json:"max"`
+	Median  int    `json:"median"`
+	P10     int    `json:"p10"`
+	P90     int    `json:"p90"`
+}
+
+// PowerBucket counts the power readings from Power up to Power+5 dBm
+type PowerBucket struct {
+	Power int `json:"power"`
+	Count int `json:"count"`
+}
+
+// PowerDistribution is the power of every device and the histogram of all readings
+type PowerDistribution struct {
+	Window    int           `json:"window"`
+	Devices   []PowerStats  `json:"devices"`
+	Histogram []PowerBucket `json:"histogram"`
+}
+
+// nearest-rank percentile of sorted values
+func percentile(sorted []int, p float64) int {
+	i := int(math.Ceil(p/100*float64(len(sorted)))) - 1
+	if i < 0 {
+		i = 0
+	}
+	return sorted[i]
+}
+
+func powerDistribution(samples map[string][]Sample, window int) PowerDistribution {
+	d := PowerDistribution{Window: window, Devices: []PowerStats{}, Histogram: []PowerBucket{}}
+	buckets := make(map[int]int)
+	for mac, list := range samples {
+		var powers []int
+		for _, sample := range list {
+			// airodump-ng reports -1 when it has no power reading
+			if sample.Power < 0 && sample.Power != -1 {
+				powers = append(powers, sample.Power)
+				buckets[int(math.Floor(float64(sample.Power)/powerBucket))*powerBucket]++
+			}
+		}
+		if len(powers) == 0 {
+			continue
+		}
+		sort.Ints(powers)
+		d.Devices = append(d.Devices, PowerStats{
+			MAC:     formatMAC(mac),
+			Samples: len(powers),
+			Min:     powers[0],
+			Max:     powers[len(powers)-1],
+			Median:  percentile(powers, 50),
+			P10:     percentile(powers, 10),
+			P90:     percentile(powers, 90),
+		})
+	}
+	sort.Slice(d.Devices, func(i, j int) bool { return d.Devices[i].MAC < d.Devices[j].MAC })
+	for power, count := range buckets {
+		d.Histogram = append(d.Histogram, PowerBucket{Power: power, Count: count})
+	}
+	sort.Slice(d.Histogram, func(i, j int) bool { return d.Histogram[i].Power < d.Histogram[j].Power })
+	return d
+}
+
+// power percentiles per device and the power histogram at /stats/power over the last 60 minutes or ?window=minutes,
+// ?mac= limits it to one device
+func statsPower(w http.ResponseWriter, r *http.Request) {
+	window := 60
+	if n, err := strconv.Atoi(r.URL.Query().Get("window")); err == nil && n > 0 {
+		window = n
+	}
+	samples := getHistorySince(time.Now().Add(-time.Duration(window) * time.Minute))
+	if mac := r.URL.Query().Get("mac"); mac != "" {
+		mac = normalizeMAC(mac)
+		samples = map[string][]Sample{mac: samples[mac]}
+	}
+	writeJSON(w, powerDistribution(samples, window))
+}
+
+// OrganizationCount is the number of clients of one manufacturer
+type OrganizationCount struct {
+	Organization string `json:"organization"`
+	Clients      int    `json:"clients"`
+}
+
+// APVendorStats are the clients associated with an access point, or with every access point of an SSID,
+// by manufacturer
+type APVendorStats struct {
+	MAC           string              `json:"mac,omitempty"` // empty when grouped by SSID
+	Name          string              `json:"name"`
+	BSSIDs        int                 `json:"bssids"`
+	Clients       int                 `json:"clients"`
+	Top           float64             `json:"top"` // percent of the clients made by the commonest manufacturer
+	Organizations []OrganizationCount `json:"organizations"`
+}
+
+// group the associated clients by access point, or by SSID if bySSID, and then by manufacturer,
+// the access points with the most clients first
+func apVendorStats(aps []AccessPoint, clients []Client, bySSID bool) []APVendorStats {
+	names := make(map[string]string)
+	for _, ap := range aps {
+		names[ap.MAC] = ap.Name
+	}
+	stats := make(map[string]*APVendorStats)
+	counts := make(map[string]map[string]int)
+	bssids := make(map[string]map[string]bool)
+	for _, client := range clients {
+		if !client.Associated {
+			continue
+		}
+		key := client.BSSID
+		s := APVendorStats{MAC: formatMAC(client.BSSID), Name: names[client.BSSID]}
+		if bySSID {
+			key, s.MAC = names[client.BSSID], ""
+		}
+		if _, ok := stats[key]; !ok {
+			stats[key] = &s
+			counts[key] = make(map[string]int)
+			bssids[key] = make(map[string]bool)
+		}
+		org := client.Organization
+		if org == "" {
+			org = "UNKNOWN"
+		}
+		stats[key].Clients++
+		counts[key][org]++
+		bssids[key][client.BSSID] = true
+	}
+	list := []APVendorStats{}
+	for key, s := range stats {
+		s.BSSIDs = len(bssids[key])
+		s.Organizations = []OrganizationCount{}
+		for org, n := range counts[key] {
+			s.Organizations = append(s.Organizations, OrganizationCount{Organization: org, Clients: n})
+		}
+		sort.Slice(s.Organizations, func(i, j int) bool {
+			if s.Organizations[i].Clients != s.Organizations[j].Clients {
+				return s.Organizations[i].Clients > s.Organizations[j].Clients
+			}
+			return s.Organizations[i].Organization < s.Organizations[j].Organization
+		})
+		s.Top = 100 * float64(s.Organizations[0].Clients) / float64(s.Clients)
+		list = append(list, *s)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Clients != list[j].Clients {
+			return list[i].Clients > list[j].Clients
+		}
+		return list[i].MAC+list[i].Name < list[j].MAC+list[j].Name
+	})
+	return list
+}
+
+// associated clients per manufacturer per access point at /stats/ap-vendors, or per SSID with ?by=ssid,
+// for clients seen in the last 60 minutes or ?last=minutes
+func statsAPVendors(w http.ResponseWriter, r *http.Request) {
+	last := 60
+	if lastParam := r.URL.Query().Get("last"); lastParam != "" {
+		n, err := strconv.Atoi(lastParam)
+		if err != nil {
+			http.Error(w, "Invalid last parameter", http.StatusBadRequest)
+			return
+		}
+		last = n
+	}
+	by := r.URL.Query().Get("by")
+	if by != "" && by != "ap" && by != "ssid" {
+		http.Error(w, "Invalid by parameter, use ap or ssid", http.StatusBadRequest)
+		return
+	}
+	aps, clients := store.Snapshot()
+	writeJSON(w, apVendorStats(aps, filterByLastSeen(clients, last), by == "ssid"))
+}
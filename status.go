@@ -0,0 +1,169 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var startTime = time.Now()
+
+// ingestion is paused, ie while swapping capture files
+var paused atomic.Bool
+
+// why -strict stopped ingestion, empty while ingesting
+var haltReason string
+var haltMutex sync.Mutex
+
+// stop ingesting until POST /admin/resume, the first reason is kept
+func haltIngestion(reason string) {
+	haltMutex.Lock()
+	defer haltMutex.Unlock()
+	if haltReason == "" {
+		fmt.Println("Ingestion halted:", reason)
+		haltReason = reason
+	}
+}
+
+func halted() string {
+	haltMutex.Lock()
+	defer haltMutex.Unlock()
+	return haltReason
+}
+
+// wakes up the parse loop for an immediate refresh
+var refreshNow = make(chan struct{}, 1)
+
+// Status is the state of netnet at /status
+type Status struct {
+	Version    string    `json:"version"`
+	Collector  string    `json:"collector"`
+	Paused     bool      `json:"paused"`
+	Halted     string    `json:"halted,omitempty"` // why -strict stopped ingestion
+	LastParsed time.Time `json:"last_parsed"`
+	Healthy    bool      `json:"healthy"`
+	Problem    string    `json:"problem,omitempty"` // why the sensor isn't capturing, ie not an airodump-ng CSV file
+	Started    time.Time `json:"started"`
+	APs        int       `json:"aps"`
+	Clients    int       `json:"clients"`
+	Ghosts     int64     `json:"ghosts"` // ghost clients pruned from the last parse
+	Flux       Flux      `json:"flux"`
+
+	Capture []CaptureStatus `json:"capture,omitempty"` // the airodump-ng instances netnet runs
+	Forward *ForwardStatus  `json:"forward,omitempty"` // sending to the central server, when netnet is a sensor
+	NetBox  *NetBoxStatus   `json:"netbox,omitempty"`  // syncing with NetBox, when there is one
+	RADIUS  *RADIUSStatus   `json:"radius,omitempty"`  // RADIUS accounting coming in, when it is configured
+
+	Databases []DatabaseStatus `json:"databases,omitempty"` // the vendor databases loaded
+	Warnings  []string         `json:"warnings,omitempty"`  // ie stale vendor databases
+}
+
+// parsing is alive if it happened recently, or if it was paused on purpose or halted by -strict, which waits for
+// someone to look at the data and would be forgotten if netnet was restarted for it
+func parsingHealthy() bool {
+	lastParsedMutex.RLock()
+	defer lastParsedMutex.RUnlock()
+	return halted() != "" || paused.Load() || time.Since(lastParsed) < 3*refreshInterval()
+}
+
+// raise an event when parsing stops or starts again
+func watchSensor() {
+	healthy := true
+	for {
+		time.Sleep(refreshInterval())
+		reason := halted()
+		now := parsingHealthy() && reason == ""
+		if now == healthy {
+			continue
+		}
+		healthy = now
+		switch {
+		case healthy:
+			emit([]Event{{Type: EventSensorUp, Time: time.Now(), Message: "Parsing " + *collector + " data again"}})
+		case reason != "":
+			emit([]Event{{Type: EventSensorDown, Time: time.Now(), Message: "Ingestion halted: " + reason}})
+		default:
+			emit([]Event{{Type: EventSensorDown, Time: time.Now(), Message: "No " + *collector + " data parsed since " + lastParsedTime().Format(time.RFC3339)}})
+		}
+	}
+}
+
+// wait for the next refresh, or until someone asks for it in which case it returns true
+func waitForRefresh() bool {
+	select {
+	case <-time.After(refreshInterval()):
+		return false
+	case <-refreshNow:
+		return true
+	}
+}
+
+func currentStatus() Status {
+	aps, clients := store.Snapshot()
+	return Status{
+		Version:    version,
+		Collector:  *collector,
+		Paused:     paused.Load(),
+		Halted:     halted(),
+		LastParsed: lastParsedTime(),
+		Healthy:    parsingHealthy() && halted() == "",
+		Problem:    sensorProblem(),
+		Started:    startTime,
+		APs:        len(aps),
+		Clients:    len(clients),
+		Ghosts:     ghostClients.Load(),
+		Flux:       getFlux(),
+		Capture:    getCaptureStatus(),
+		Forward:    getForwardStatus(),
+		NetBox:     getNetBoxStatus(),
+		RADIUS:     getRADIUSStatus(),
+		Databases:  getDatabaseStatus(),
+		Warnings:   databaseWarnings(),
+	}
+}
+
+func status(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, currentStatus())
+}
+
+// POST /admin/refresh parses the data right away, even when paused
+func adminRefresh(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	requestRefresh()
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// ask the parse loop for a refresh, unless one is already pending
+func requestRefresh() {
+	select {
+	case refreshNow <- struct{}{}:
+	default:
+	}
+}
+
+// POST /admin/pause stops parsing until POST /admin/resume
+func adminPause(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	paused.Store(true)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func adminResume(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	paused.Store(false)
+	haltMutex.Lock()
+	haltReason = ""
+	haltMutex.Unlock()
+	adminRefresh(w, r)
+}
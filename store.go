@@ -0,0 +1,103 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// Store holds the access points and clients found, the parser swaps in new slices on every parse and the handlers
+// read them, a slice from the store is never changed after it is put in so it can be read without holding the lock
+type Store struct {
+	mutex   sync.RWMutex
+	aps     []AccessPoint
+	clients []Client
+}
+
+// the devices found, read by the handlers and updated by the parser
+var store Store
+
+// the access points found
+func (s *Store) APs() []AccessPoint {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.aps
+}
+
+// the clients found
+func (s *Store) Clients() []Client {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.clients
+}
+
+// the access points and clients found in the same parse
+func (s *Store) Snapshot() ([]AccessPoint, []Client) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.aps, s.clients
+}
+
+// replace the devices found, the slices must not be changed afterwards
+func (s *Store) Update(aps []AccessPoint, clients []Client) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.aps, s.clients = aps, clients
+}
+
+// update the access points seen in this parse and keep the ones that weren't, instead of replacing the list
+func upsertAPs(known, observed []AccessPoint) []AccessPoint {
+	aps := make([]AccessPoint, 0, len(known)+len(observed))
+	seen := make(map[string]bool, len(observed))
+	lastSeen := make(map[string]time.Time, len(known))
+	for _, ap := range known {
+		lastSeen[ap.MAC] = ap.LastSeen
+	}
+	for _, ap := range observed {
+		ap.DeviceMeta = observeMeta(ap.MAC, ap.FirstSeen, ap.LastSeen.After(lastSeen[ap.MAC]))
+		seen[ap.MAC] = true
+		aps = append(aps, ap)
+	}
+	// the ones not seen in this parse pick up what was PATCHed meanwhile
+	deviceMetaMutex.Lock()
+	defer deviceMetaMutex.Unlock()
+	for _, ap := range known {
+		if !seen[ap.MAC] {
+			if m, ok := deviceMeta[ap.MAC]; ok {
+				ap.DeviceMeta = m
+			}
+			aps = append(aps, ap)
+		}
+	}
+	return aps
+}
+
+// update the clients seen in this parse and keep the ones that weren't
+func upsertClients(known, observed []Client) []Client {
+	clients := make([]Client, 0, len(known)+len(observed))
+	seen := make(map[string]bool, len(observed))
+	lastSeen := make(map[string]time.Time, len(known))
+	for _, c := range known {
+		lastSeen[c.MAC] = c.LastSeen
+	}
+	for _, c := range observed {
+		last, ok := lastSeen[c.MAC]
+		c.DeviceMeta = observeMeta(c.MAC, c.FirstSeen, c.LastSeen.After(last))
+		// the packets of a new client count in full, after that only what changed
+		if !ok {
+			addPackets(&c, c.Packets)
+		}
+		seen[c.MAC] = true
+		clients = append(clients, c)
+	}
+	deviceMetaMutex.Lock()
+	defer deviceMetaMutex.Unlock()
+	for _, c := range known {
+		if !seen[c.MAC] {
+			if m, ok := deviceMeta[c.MAC]; ok {
+				c.DeviceMeta = m
+			}
+			clients = append(clients, c)
+		}
+	}
+	return clients
+}
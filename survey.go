@@ -0,0 +1,260 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// default size of the areas the survey is graded in, in meters
+const surveyCell = 25.0
+
+// meters in a degree of latitude
+const metersPerDegree = 111320.0
+
+// the weakest signal for each grade, the usual thresholds for Wi-Fi design:
+// A is excellent, B is good enough for voice and video, C for reliable data, D for basic connectivity,
+// anything weaker or no signal at all is F
+var surveyGrades = []struct {
+	Grade string
+	Power int
+}{{"A", -60}, {"B", -67}, {"C", -70}, {"D", -80}}
+
+// SurveyCell is an area the sensor went through, graded by the strongest signal of the selected SSIDs in it
+type SurveyCell struct {
+	Lat       float64    `json:"lat"` // center of the area
+	Lon       float64    `json:"lon"`
+	Bounds    [4]float64 `json:"bounds"` // west, south, east, north
+	Grade     string     `json:"grade"`
+	Power     int        `json:"power"`     // strongest signal, 0 if none
+	BSSIDs    []string   `json:"bssids"`    // access points of the SSIDs heard there
+	Sightings int        `json:"sightings"` // of the SSIDs
+}
+
+// SurveyAP is an access point of the selected SSIDs seen in the survey
+type SurveyAP struct {
+	MAC       string `json:"mac"`
+	Name      string `json:"name"`
+	Power     int    `json:"power"` // strongest signal
+	Sightings int    `json:"sightings"`
+	Cells     int    `json:"cells"` // areas it was heard in
+}
+
+// SurveyReport is the coverage of the selected SSIDs over the areas the sensor went through
+type SurveyReport struct {
+	SSIDs    []string       `json:"ssids"`
+	Cell     float64        `json:"cell"` // size of an area in meters
+	From     time.Time      `json:"from"`
+	To       time.Time      `json:"to"`
+	Distance float64        `json:"distance"` // meters travelled
+	Areas    int            `json:"areas"`
+	Grades   map[string]int `json:"grades"`   // number of areas with each grade
+	Coverage float64        `json:"coverage"` // percent of the areas graded C or better
+	Cells    []SurveyCell   `json:"cells"`
+	APs      []SurveyAP     `json:"aps"`
+}
+
+// grade a signal, 0 is no signal
+func surveyGrade(power int) string {
+	if power == 0 || power == -1 {
+		return "F"
+	}
+	for _, g := range surveyGrades {
+		if power >= g.Power {
+			return g.Grade
+		}
+	}
+	return "F"
+}
+
+// grade the areas the track and sightings went through by the signal of the SSIDs in them
+func survey(ssids []string, cell float64, track []GPSFix, sightings []Sighting) SurveyReport {
+	report := SurveyReport{SSIDs: ssids, Cell: cell, Grades: make(map[string]int), Cells: []SurveyCell{}, APs: []SurveyAP{}}
+	for _, g := range surveyGrades {
+		report.Grades[g.Grade] = 0
+	}
+	report.Grades["F"] = 0
+	if len(track) == 0 && len(sightings) == 0 {
+		return report
+	}
+
+	// the areas are a grid of cell meters, narrower in degrees of longitude away from the equator
+	var lat float64
+	for _, fix := range track {
+		lat += fix.Lat
+	}
+	for _, s := range sightings {
+		lat += s.Lat
+	}
+	lat /= float64(len(track) + len(sightings))
+	cellLat := cell / metersPerDegree
+	cellLon := cell / (metersPerDegree * math.Max(math.Cos(lat*math.Pi/180), 0.01))
+	key := func(lat, lon float64) [2]int {
+		return [2]int{int(math.Floor(lat / cellLat)), int(math.Floor(lon / cellLon))}
+	}
+	type area struct {
+		power     int
+		bssids    map[string]bool
+		sightings int
+	}
+	areas := make(map[[2]int]*area)
+	visit := func(lat, lon float64) *area {
+		k := key(lat, lon)
+		a, ok := areas[k]
+		if !ok {
+			a = &area{power: -1, bssids: make(map[string]bool)}
+			areas[k] = a
+		}
+		return a
+	}
+	extend := func(t time.Time) {
+		if report.From.IsZero() || t.Before(report.From) {
+			report.From = t
+		}
+		if t.After(report.To) {
+			report.To = t
+		}
+	}
+
+	// the areas along the track, filling in between points unless there was a gap
+	for i, fix := range track {
+		visit(fix.Lat, fix.Lon)
+		extend(fix.Time)
+		if i == 0 || fix.Time.Sub(track[i-1].Time) > sessionGap {
+			continue
+		}
+		prev := track[i-1]
+		d := distance(prev.Lat, prev.Lon, fix.Lat, fix.Lon)
+		report.Distance += d
+		for step := 1; float64(step)*cell/2 < d; step++ {
+			f := float64(step) * cell / 2 / d
+			visit(prev.Lat+(fix.Lat-prev.Lat)*f, prev.Lon+(fix.Lon-prev.Lon)*f)
+		}
+	}
+
+	// the signal of the SSIDs in the areas
+	aps := make(map[string]*SurveyAP)
+	apCells := make(map[string]map[[2]int]bool)
+	for _, s := range sightings {
+		a := visit(s.Lat, s.Lon)
+		extend(s.Time)
+		if !containsString(ssids, s.Name) {
+			continue
+		}
+		a.power = bestPower(a.power, s.Power)
+		a.bssids[s.MAC] = true
+		a.sightings++
+		ap, ok := aps[s.MAC]
+		if !ok {
+			ap = &SurveyAP{MAC: formatMAC(s.MAC), Name: s.Name, Power: s.Power}
+			aps[s.MAC] = ap
+			apCells[s.MAC] = make(map[[2]int]bool)
+		}
+		ap.Power = bestPower(ap.Power, s.Power)
+		ap.Sightings++
+		apCells[s.MAC][key(s.Lat, s.Lon)] = true
+	}
+
+	good := 0
+	for k, a := range areas {
+		south, west := float64(k[0])*cellLat, float64(k[1])*cellLon
+		c := SurveyCell{
+			Lat:       south + cellLat/2,
+			Lon:       west + cellLon/2,
+			Bounds:    [4]float64{west, south, west + cellLon, south + cellLat},
+			BSSIDs:    []string{},
+			Sightings: a.sightings,
+		}
+		if a.power != -1 {
+			c.Power = a.power
+		}
+		c.Grade = surveyGrade(c.Power)
+		for bssid := range a.bssids {
+			c.BSSIDs = append(c.BSSIDs, formatMAC(bssid))
+		}
+		sort.Strings(c.BSSIDs)
+		report.Grades[c.Grade]++
+		if c.Grade == "A" || c.Grade == "B" || c.Grade == "C" {
+			good++
+		}
+		report.Cells = append(report.Cells, c)
+	}
+	sort.Slice(report.Cells, func(i, j int) bool {
+		return report.Cells[i].Lat > report.Cells[j].Lat || (report.Cells[i].Lat == report.Cells[j].Lat && report.Cells[i].Lon < report.Cells[j].Lon)
+	})
+	report.Areas = len(areas)
+	report.Coverage = 100 * float64(good) / float64(len(areas))
+	for mac, ap := range aps {
+		ap.Cells = len(apCells[mac])
+		report.APs = append(report.APs, *ap)
+	}
+	sort.Slice(report.APs, func(i, j int) bool { return report.APs[i].Cells > report.APs[j].Cells })
+	return report
+}
+
+// the cells of the report as GeoJSON squares, to draw the coverage on a map
+func surveyGeoJSON(report SurveyReport) map[string]interface{} {
+	colors := map[string]string{"A": "#1a9850", "B": "#91cf60", "C": "#fee08b", "D": "#fc8d59", "F": "#d73027"}
+	features := []interface{}{}
+	for _, c := range report.Cells {
+		w, s, e, n := c.Bounds[0], c.Bounds[1], c.Bounds[2], c.Bounds[3]
+		features = append(features, map[string]interface{}{
+			"type":       "Feature",
+			"geometry":   map[string]interface{}{"type": "Polygon", "coordinates": [][][2]float64{{{w, s}, {e, s}, {e, n}, {w, n}, {w, s}}}},
+			"properties": map[string]interface{}{"grade": c.Grade, "power": c.Power, "bssids": c.BSSIDs, "sightings": c.Sightings, "color": colors[c.Grade]},
+		})
+	}
+	return map[string]interface{}{"type": "FeatureCollection", "features": features}
+}
+
+// the site survey report at /survey, for ?ssid=a,b or the SSIDs in my_ssids, graded in areas of ?cell= meters,
+// over the track between ?from=&to= (RFC 3339 times), as GeoJSON with ?format=geojson
+func surveyHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	ssids := config.MySSIDs
+	if s := q.Get("ssid"); s != "" {
+		ssids = strings.Split(s, ",")
+	}
+	if len(ssids) == 0 {
+		http.Error(w, "No SSIDs to grade, use ?ssid= or set my_ssids in the configuration", http.StatusBadRequest)
+		return
+	}
+	cell := surveyCell
+	if s := q.Get("cell"); s != "" {
+		n, err := strconv.ParseFloat(s, 64)
+		if err != nil || n < 1 || n > 10000 {
+			http.Error(w, "Invalid cell size, use 1 to 10000 meters", http.StatusBadRequest)
+			return
+		}
+		cell = n
+	}
+	var from, to time.Time
+	var err error
+	if s := q.Get("from"); s != "" {
+		from, err = time.Parse(time.RFC3339, s)
+		if err != nil {
+			http.Error(w, "Invalid from time: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if s := q.Get("to"); s != "" {
+		to, err = time.Parse(time.RFC3339, s)
+		if err != nil {
+			http.Error(w, "Invalid to time: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	track, sightings := gpsBetween(from, to)
+	report := survey(ssids, cell, track, sightings)
+	switch q.Get("format") {
+	case "", "json":
+		writeJSON(w, report)
+	case "geojson":
+		writeJSON(w, surveyGeoJSON(report))
+	default:
+		http.Error(w, "Unknown format, use json or geojson", http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var lastParsed time.Time
+var lastParsedMutex sync.RWMutex
+
+// remember when the last parse was, the watchdog only pings systemd while parsing goes on
+func markParsed() {
+	lastParsedMutex.Lock()
+	lastParsed = time.Now()
+	lastParsedMutex.Unlock()
+}
+
+func lastParsedTime() time.Time {
+	lastParsedMutex.RLock()
+	defer lastParsedMutex.RUnlock()
+	return lastParsed
+}
+
+// send a state like READY=1 to systemd, does nothing if not started by systemd with Type=notify
+func sdNotify(state string) {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return
+	}
+	// abstract sockets start with @ in the environment variable
+	if strings.HasPrefix(socket, "@") {
+		socket = "\x00" + socket[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		fmt.Println("Cannot notify systemd:", err)
+		return
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte(state))
+	check(err, "Cannot notify systemd:")
+}
+
+// the socket systemd passes in with socket activation, the first of LISTEN_FDS starting at file descriptor 3
+func sdListener() (net.Listener, error) {
+	if pid := os.Getenv("LISTEN_PID"); pid != strconv.Itoa(os.Getpid()) {
+		return nil, fmt.Errorf("no socket from systemd, is there a netnet.socket unit?")
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n < 1 {
+		return nil, fmt.Errorf("no socket from systemd, LISTEN_FDS is %q", os.Getenv("LISTEN_FDS"))
+	}
+	// so the commands netnet runs don't think the sockets are theirs
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+	file := os.NewFile(3, "systemd socket")
+	defer file.Close()
+	return net.FileListener(file)
+}
+
+// ping the systemd watchdog at half the configured interval, as long as the data is being parsed
+func sdWatchdog() {
+	usec, err := strconv.Atoi(os.Getenv("WATCHDOG_USEC"))
+	if err != nil || usec <= 0 {
+		return
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return
+	}
+	interval := time.Duration(usec) * time.Microsecond / 2
+	for {
+		time.Sleep(interval)
+		if parsingHealthy() {
+			sdNotify("WATCHDOG=1")
+		}
+	}
+}
+
+// the install-service subcommand writes a systemd unit for netnet with the flags it was given,
+// to the file in the argument or to stdout
+func installService(args []string) {
+	exe, err := os.Executable()
+	if err != nil {
+		fmt.Println("Cannot find netnet executable:", err)
+		os.Exit(1)
+	}
+	exe, _ = filepath.Abs(exe)
+	command := []string{exe}
+	flag.Visit(func(f *flag.Flag) {
+		command = append(command, "-"+f.Name+"="+f.Value.String())
+	})
+	// with socket activation systemd opens the port and starts netnet on the first connection
+	requires := ""
+	if *listenAddr == "systemd" {
+		requires = "Requires=netnet.socket\n"
+	}
+	unit := `[Unit]
+Description=netnet Wi-Fi client discovery
+After=network.target
+` + requires + `
+[Service]
+Type=notify
+ExecStart=` + strings.Join(command, " ") + `
+WorkingDirectory=` + filepath.Dir(exe) + `
+Restart=on-failure
+RestartSec=5
+WatchdogSec=60
+
+[Install]
+WantedBy=multi-user.target
+`
+	socket := `[Unit]
+Description=netnet Wi-Fi client discovery socket
+
+[Socket]
+ListenStream=` + strconv.Itoa(*port) + `
+
+[Install]
+WantedBy=sockets.target
+`
+	if len(args) == 0 {
+		fmt.Print(unit)
+		if requires != "" {
+			fmt.Print("\n# netnet.socket\n" + socket)
+		}
+		return
+	}
+	err = ioutil.WriteFile(args[0], []byte(unit), 0644)
+	if err != nil {
+		fmt.Println("Cannot write service file:", err)
+		os.Exit(1)
+	}
+	if requires == "" {
+		fmt.Println("Wrote", args[0], "- run 'systemctl daemon-reload && systemctl enable --now netnet' to start it")
+		return
+	}
+	socketFile := filepath.Join(filepath.Dir(args[0]), "netnet.socket")
+	err = ioutil.WriteFile(socketFile, []byte(socket), 0644)
+	if err != nil {
+		fmt.Println("Cannot write socket file:", err)
+		os.Exit(1)
+	}
+	fmt.Println("Wrote", args[0], "and", socketFile, "- run 'systemctl daemon-reload && systemctl enable --now netnet.socket' to start it")
+}
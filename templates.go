@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"strings"
+	"time"
+)
+
+// helpers every page can use, so how things are shown is decided in one place
+var templateFuncs = template.FuncMap{
+	"ago":      ago,
+	"duration": humanDuration,
+	"span":     func(start, end time.Time) string { return humanDuration(end.Sub(start)) },
+	"bars":     powerBars,
+	"signal":   signal,
+	"vendor":   shortVendor,
+	"mac":      formatMAC,
+}
+
+// how long ago a time was, ie 3m ago
+func ago(t time.Time) string {
+	if t.IsZero() {
+		return "never"
+	}
+	d := time.Since(t)
+	// the sensor clock can be a bit ahead
+	if d < 10*time.Second {
+		return "just now"
+	}
+	return humanDuration(d) + " ago"
+}
+
+// a duration in its biggest unit, and the next one for the short ones, ie 45s, 3m, 1h 20m or 5d
+func humanDuration(d time.Duration) string {
+	if d < 0 {
+		d = -d
+	}
+	switch {
+	case d < time.Minute:
+		return fmt.Sprintf("%ds", int(d.Seconds()))
+	case d < time.Hour:
+		return fmt.Sprintf("%dm", int(d.Minutes()))
+	case d < 24*time.Hour:
+		if m := int(d.Minutes()) % 60; m != 0 {
+			return fmt.Sprintf("%dh %dm", int(d.Hours()), m)
+		}
+		return fmt.Sprintf("%dh", int(d.Hours()))
+	}
+	return fmt.Sprintf("%dd", int(d.Hours()/24))
+}
+
+// the signal bars a phone would show for a power reading, 0 to 4, 0 if there is no reading
+func powerBars(power int) int {
+	switch {
+	case power == -1 || power == 0:
+		return 0
+	case power >= -55:
+		return 4
+	case power >= -67:
+		return 3
+	case power >= -75:
+		return 2
+	case power >= -85:
+		return 1
+	}
+	return 0
+}
+
+// the signal bars of a power reading as text, ie ▮▮▮▯
+func signal(power int) string {
+	bars := powerBars(power)
+	return strings.Repeat("▮", bars) + strings.Repeat("▯", 4-bars)
+}
+
+// words that vendor names end with that say nothing about who they are
+var vendorSuffixes = []string{
+	"inc", "incorporated", "corp", "corporation", "corporate", "co", "company", "ltd", "limited", "llc", "gmbh", "ag",
+	"sa", "s.a", "bv", "b.v", "nv", "oy", "ab", "as", "srl", "spa", "pte", "pty", "plc", "kk",
+	"technologies", "technology", "tech", "electronics", "electronic", "international", "industrial", "industries",
+	"ind", "trading", "holdings", "group", "manufacturing", "mfg",
+}
+
+// the short name of a vendor as people call it, ie Apple for Apple, Inc. and TP-LINK for TP-LINK TECHNOLOGIES CO.,LTD.
+func shortVendor(org string) string {
+	if i := strings.Index(org, ","); i > 0 {
+		org = org[:i]
+	}
+	words := strings.Fields(org)
+	for len(words) > 1 {
+		last := strings.ToLower(strings.Trim(words[len(words)-1], ".,()"))
+		if !containsString(vendorSuffixes, last) {
+			break
+		}
+		words = words[:len(words)-1]
+	}
+	return strings.Join(words, " ")
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/cookiejar"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// how long a request to a UniFi controller can take
+const unifiTimeout = 30 * time.Second
+
+// what each UniFi controller knows, by the name of its source, to find the devices on the air it doesn't
+var unifiKnown = make(map[string]unifiKnowledge)
+var unifiKnownMutex sync.RWMutex
+
+type unifiKnowledge struct {
+	clients map[string]bool
+	bssids  map[string]bool
+	ssids   map[string]bool
+	updated time.Time
+}
+
+// unifiSource pulls the wireless clients and access points of a site from a UniFi Network controller, either a
+// self-hosted one or on a UniFi OS console, what it says about associations is authoritative
+type unifiSource struct {
+	name     string
+	url      string
+	username string
+	password string
+	site     string
+	interval time.Duration
+	client   *http.Client
+	mutex    *sync.Mutex
+	session  *unifiSession
+}
+
+type unifiSession struct {
+	loggedIn bool
+	prefix   string // /proxy/network on UniFi OS, found when logging in
+}
+
+func newUniFiSource(c SourceConfig) (DataSource, error) {
+	if c.URL == "" || c.Username == "" {
+		return nil, errors.New("a unifi source needs a url, username and password")
+	}
+	tlsConfig := &tls.Config{InsecureSkipVerify: c.Insecure}
+	if c.CA != "" {
+		pool, err := loadCertPool(c.CA)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = pool
+	}
+	jar, _ := cookiejar.New(nil)
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	interval := time.Duration(c.Interval) * time.Second
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	return unifiSource{name: orDefault(c.Name, "unifi"), url: strings.TrimSuffix(c.URL, "/"), username: c.Username,
+		password: c.Password, site: orDefault(c.Site, "default"), interval: interval, mutex: &sync.Mutex{}, session: &unifiSession{},
+		client: &http.Client{Timeout: unifiTimeout, Transport: transport, Jar: jar}}, nil
+}
+
+func (s unifiSource) Name() string {
+	return s.name
+}
+
+// send a request to the controller and decode the data of its answer into v
+func (s unifiSource) request(method, path string, body, v interface{}) (int, error) {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return 0, err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, s.url+path, reader)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, 64<<20))
+	if err != nil {
+		return resp.StatusCode, err
+	}
+	if resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("%s %s: %s", method, path, resp.Status)
+	}
+	if v == nil {
+		return resp.StatusCode, nil
+	}
+	var envelope struct {
+		Meta struct {
+			RC  string `json:"rc"`
+			Msg string `json:"msg"`
+		} `json:"meta"`
+		Data json.RawMessage `json:"data"`
+	}
+	if err = json.Unmarshal(data, &envelope); err != nil {
+		return resp.StatusCode, err
+	}
+	if envelope.Meta.RC != "" && envelope.Meta.RC != "ok" {
+		return resp.StatusCode, fmt.Errorf("%s %s: %s", method, path, envelope.Meta.Msg)
+	}
+	return resp.StatusCode, json.Unmarshal(envelope.Data, v)
+}
+
+// log in, on UniFi OS first and then on a self-hosted controller
+func (s unifiSource) login() error {
+	credentials := map[string]string{"username": s.username, "password": s.password}
+	status, err := s.request(http.MethodPost, "/api/auth/login", credentials, nil)
+	if err == nil {
+		s.session.loggedIn, s.session.prefix = true, "/proxy/network"
+		return nil
+	}
+	if status != http.StatusNotFound {
+		return err
+	}
+	if _, err = s.request(http.MethodPost, "/api/login", credentials, nil); err != nil {
+		return err
+	}
+	s.session.loggedIn, s.session.prefix = true, ""
+	return nil
+}
+
+// get a list of the site, logging in again if the session expired
+func (s unifiSource) get(path string, v interface{}) error {
+	if !s.session.loggedIn {
+		if err := s.login(); err != nil {
+			return err
+		}
+	}
+	status, err := s.request(http.MethodGet, s.session.prefix+"/api/s/"+s.site+path, nil, v)
+	if status == http.StatusUnauthorized {
+		if err = s.login(); err != nil {
+			return err
+		}
+		_, err = s.request(http.MethodGet, s.session.prefix+"/api/s/"+s.site+path, nil, v)
+	}
+	return err
+}
+
+func (s unifiSource) Poll() (Batch, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	b := Batch{Source: s.name, Written: time.Now(), Authoritative: true}
+	var devices []struct {
+		Type     string `json:"type"`
+		VAPTable []struct {
+			BSSID   string `json:"bssid"`
+			ESSID   string `json:"essid"`
+			Channel int    `json:"channel"`
+		} `json:"vap_table"`
+	}
+	if err := s.get("/stat/device", &devices); err != nil {
+		return b, err
+	}
+	var stations []struct {
+		MAC       string `json:"mac"`
+		BSSID     string `json:"bssid"`
+		Signal    int    `json:"signal"`
+		FirstSeen int64  `json:"first_seen"`
+		LastSeen  int64  `json:"last_seen"`
+		IsWired   bool   `json:"is_wired"`
+		TxPackets int    `json:"tx_packets"`
+		RxPackets int    `json:"rx_packets"`
+	}
+	if err := s.get("/stat/sta", &stations); err != nil {
+		return b, err
+	}
+	known := unifiKnowledge{clients: make(map[string]bool), bssids: make(map[string]bool), ssids: make(map[string]bool),
+		updated: b.Written}
+	for _, d := range devices {
+		if d.Type != "uap" {
+			continue
+		}
+		for _, vap := range d.VAPTable {
+			bssid, ok := parseMAC(vap.BSSID)
+			if !ok {
+				continue
+			}
+			known.bssids[bssid], known.ssids[vap.ESSID] = true, true
+			b.APs = append(b.APs, AccessPoint{MAC: bssid, Name: vap.ESSID, Channel: vap.Channel, FirstSeen: b.Written,
+				LastSeen: b.Written, Power: -1})
+		}
+	}
+	for _, sta := range stations {
+		mac, ok := parseMAC(sta.MAC)
+		if !ok || sta.IsWired {
+			continue
+		}
+		known.clients[mac] = true
+		c := Client{MAC: mac, FirstSeen: time.Unix(sta.FirstSeen, 0), LastSeen: time.Unix(sta.LastSeen, 0), Power: -1,
+			Packets: sta.TxPackets + sta.RxPackets}
+		if sta.Signal != 0 {
+			c.Power = sta.Signal
+		}
+		if bssid, ok := parseMAC(sta.BSSID); ok {
+			c.BSSID, c.Associated = bssid, true
+		}
+		b.Clients = append(b.Clients, c)
+	}
+	unifiKnownMutex.Lock()
+	unifiKnown[s.name] = known
+	unifiKnownMutex.Unlock()
+	return b, nil
+}
+
+// poll every interval, the controller is asked for the whole site every time
+func (s unifiSource) Watch(stop <-chan struct{}) <-chan Batch {
+	batches := make(chan Batch)
+	go func() {
+		defer close(batches)
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+		for {
+			batch, err := s.Poll()
+			if err != nil {
+				fmt.Println("Cannot poll", s.name+":", err)
+				batch = Batch{Source: s.name, Written: time.Now(), Problem: err.Error(), Authoritative: true}
+			}
+			select {
+			case batches <- batch:
+			case <-stop:
+				return
+			}
+			select {
+			case <-ticker.C:
+			case <-stop:
+				return
+			}
+		}
+	}()
+	return batches
+}
+
+// UniFiReport is what is on the air that a UniFi controller doesn't know about
+type UniFiReport struct {
+	Source  string        `json:"source"`
+	Updated time.Time     `json:"updated"`         // when the controller was last polled
+	Known   int           `json:"known_clients"`   // wireless clients the controller has
+	BSSIDs  int           `json:"bssids"`          // of the controller's access points
+	Clients []Client      `json:"unknown_clients"` // present on the controller's access points but not in its clients
+	APs     []AccessPoint `json:"unknown_aps"`     // present with one of the controller's SSIDs but not one of its BSSIDs
+}
+
+// cross-reference what the UniFi controllers know with the devices present
+func unifiReports(aps []AccessPoint, clients []Client) []UniFiReport {
+	unifiKnownMutex.RLock()
+	defer unifiKnownMutex.RUnlock()
+	reports := []UniFiReport{}
+	for name, known := range unifiKnown {
+		r := UniFiReport{Source: name, Updated: known.updated, Clients: []Client{}, APs: []AccessPoint{},
+			Known: len(known.clients), BSSIDs: len(known.bssids)}
+		for _, ap := range aps {
+			if isActive(ap.LastSeen) && ap.Name != "" && known.ssids[ap.Name] && !known.bssids[ap.MAC] {
+				r.APs = append(r.APs, ap)
+			}
+		}
+		for _, c := range clients {
+			if isActive(c.LastSeen) && c.Associated && known.bssids[c.BSSID] && !known.clients[c.MAC] {
+				r.Clients = append(r.Clients, c)
+			}
+		}
+		reports = append(reports, r)
+	}
+	sort.Slice(reports, func(i, j int) bool { return reports[i].Source < reports[j].Source })
+	return reports
+}
+
+// the devices on the air the UniFi controllers don't know about at /unifi
+func unifiHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, unifiReports(store.Snapshot()))
+}
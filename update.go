@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// Release is a GitHub release
+type Release struct {
+	TagName string `json:"tag_name"`
+	Assets  []struct {
+		Name string `json:"name"`
+		URL  string `json:"browser_download_url"`
+	} `json:"assets"`
+}
+
+// the update subcommand replaces the running binary with the latest GitHub release.
+//
+// Releases must have a netnet_<os>_<arch> binary (with .exe on Windows) and a checksums.txt
+// with the SHA-256 of the binaries, in the format of sha256sum. checksums.txt must also be signed
+// with the -update-key Ed25519 key in checksums.txt.sig, unless -update-unsigned is given. Only a
+// release newer than the running version is installed.
+func selfUpdate() {
+	err := update()
+	if err != nil {
+		fmt.Println("Cannot update:", err)
+		os.Exit(1)
+	}
+}
+
+func update() error {
+	// anyone who can change the release, or what the download resolves to, could replace netnet otherwise
+	if *updateKey == "" && !*updateUnsigned {
+		return errors.New("no -update-key to check the release with, -update-unsigned updates without one")
+	}
+	resp, err := http.Get("https://api.github.com/repos/" + *updateRepo + "/releases/latest")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return errors.New("cannot get latest release: " + resp.Status)
+	}
+	var release Release
+	err = json.NewDecoder(resp.Body).Decode(&release)
+	if err != nil {
+		return err
+	}
+	newer, err := newerVersion(release.TagName, version)
+	if err != nil {
+		return err
+	}
+	if !newer {
+		fmt.Println("Already at the latest version", version, "- the latest release is", release.TagName)
+		return nil
+	}
+
+	name := "netnet_" + runtime.GOOS + "_" + runtime.GOARCH
+	if runtime.GOOS == "windows" {
+		name += ".exe"
+	}
+	assets := make(map[string]string)
+	for _, asset := range release.Assets {
+		assets[asset.Name] = asset.URL
+	}
+	if assets[name] == "" || assets["checksums.txt"] == "" {
+		return errors.New("release " + release.TagName + " has no " + name + " or checksums.txt")
+	}
+	checksums, err := fetch(assets["checksums.txt"])
+	if err != nil {
+		return err
+	}
+	if !*updateUnsigned {
+		if assets["checksums.txt.sig"] == "" {
+			return errors.New("release " + release.TagName + " is not signed")
+		}
+		signature, err := fetch(assets["checksums.txt.sig"])
+		if err != nil {
+			return err
+		}
+		err = verifySignature(checksums, signature)
+		if err != nil {
+			return err
+		}
+	}
+	expected := ""
+	scanner := bufio.NewScanner(bytes.NewReader(checksums))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && strings.TrimPrefix(fields[1], "*") == name {
+			expected = fields[0]
+		}
+	}
+	if expected == "" {
+		return errors.New("no checksum for " + name)
+	}
+	binary, err := fetch(assets[name])
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(binary)
+	if hex.EncodeToString(sum[:]) != strings.ToLower(expected) {
+		return errors.New("checksum of " + name + " doesn't match")
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	exe, err = filepath.EvalSymlinks(exe)
+	if err != nil {
+		return err
+	}
+	err = ioutil.WriteFile(exe+".new", binary, 0755)
+	if err != nil {
+		return err
+	}
+	// Windows can't replace a running executable but can rename it
+	os.Remove(exe + ".old")
+	err = os.Rename(exe, exe+".old")
+	if err != nil {
+		return err
+	}
+	err = os.Rename(exe+".new", exe)
+	if err != nil {
+		os.Rename(exe+".old", exe)
+		return err
+	}
+	os.Remove(exe + ".old")
+	fmt.Println("Updated netnet from", version, "to", release.TagName, "- restart netnet to use it")
+	return nil
+}
+
+func fetch(url string) ([]byte, error) {
+	resp, err := http.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New("cannot download " + url + ": " + resp.Status)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+// verify the base64 Ed25519 signature of the data with the -update-key public key
+func verifySignature(data, signature []byte) error {
+	key, err := base64.StdEncoding.DecodeString(*updateKey)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return errors.New("-update-key is not a base64 Ed25519 public key")
+	}
+	sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(signature)))
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(ed25519.PublicKey(key), data, sig) {
+		return errors.New("signature of checksums.txt doesn't match")
+	}
+	return nil
+}
+
+// the numbers of a version like v1.2.3 or 1.2.3-rc1 and what comes after the dash
+func parseVersion(v string) ([]int, string, error) {
+	v = strings.TrimPrefix(v, "v")
+	pre := ""
+	if i := strings.Index(v, "-"); i >= 0 {
+		v, pre = v[:i], v[i+1:]
+	}
+	var numbers []int
+	for _, part := range strings.Split(v, ".") {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return nil, "", errors.New("invalid version " + v)
+		}
+		numbers = append(numbers, n)
+	}
+	return numbers, pre, nil
+}
+
+// check if a release is newer than the running version, any release is newer than a dev build
+func newerVersion(release, current string) (bool, error) {
+	r, rPre, err := parseVersion(release)
+	if err != nil {
+		return false, err
+	}
+	if current == "dev" {
+		return true, nil
+	}
+	c, cPre, err := parseVersion(current)
+	if err != nil {
+		return false, err
+	}
+	for i := 0; i < len(r) || i < len(c); i++ {
+		var a, b int
+		if i < len(r) {
+			a = r[i]
+		}
+		if i < len(c) {
+			b = c[i]
+		}
+		if a != b {
+			return a > b, nil
+		}
+	}
+	// 1.2.3-rc1 comes before 1.2.3
+	if rPre == "" || cPre == "" {
+		return rPre == "" && cPre != "", nil
+	}
+	return rPre > cPre, nil
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"runtime"
+)
+
+// set at build time with
+// go build -ldflags "-X main.version=1.0.0 -X main.commit=$(git rev-parse --short HEAD) -X main.buildDate=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+var (
+	version   = "dev"
+	commit    = "unknown"
+	buildDate = "unknown"
+)
+
+// BuildInfo identifies the netnet binary that is running
+type BuildInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	BuildDate string `json:"build_date"`
+	GoVersion string `json:"go_version"`
+	OS        string `json:"os"`
+	Arch      string `json:"arch"`
+}
+
+func buildInfo() BuildInfo {
+	return BuildInfo{
+		Version:   version,
+		Commit:    commit,
+		BuildDate: buildDate,
+		GoVersion: runtime.Version(),
+		OS:        runtime.GOOS,
+		Arch:      runtime.GOARCH,
+	}
+}
+
+func (b BuildInfo) String() string {
+	return fmt.Sprintf("netnet %s (commit %s, built %s, %s %s/%s)", b.Version, b.Commit, b.BuildDate, b.GoVersion, b.OS, b.Arch)
+}
+
+// version of netnet at /version
+func versionInfo(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, buildInfo())
+}
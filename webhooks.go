@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// deliveries that failed every attempt are appended to this file in the data directory
+const deadLetterFile = "dead_letters.jsonl"
+
+// wait before the first retry, doubled for every one after
+const webhookBackoff = time.Second
+
+// Webhook posts events as JSON to a URL, signed with the secret
+//
+// The request has the headers X-Netnet-Event (event type), X-Netnet-Delivery (unique ID of the delivery,
+// the same for every retry), X-Netnet-Timestamp (unix seconds) and X-Netnet-Signature, which is
+// sha256= followed by the hex HMAC-SHA256 of the timestamp, a dot and the body.
+type Webhook struct {
+	URL         string `json:"url"`
+	Secret      string `json:"secret"`
+	Event       string `json:"event"`        // event type, or * for all events
+	MaxAttempts int    `json:"max_attempts"` // defaults to 5
+}
+
+// DeadLetter is a webhook delivery that failed every attempt
+type DeadLetter struct {
+	Delivery string          `json:"delivery"`
+	URL      string          `json:"url"`
+	Time     time.Time       `json:"time"`
+	Attempts int             `json:"attempts"`
+	Error    string          `json:"error"`
+	Payload  json.RawMessage `json:"payload"`
+}
+
+var deadLetterMutex sync.Mutex
+
+var webhookClient = &http.Client{Timeout: hookTimeout}
+
+func (hook *Webhook) Name() string {
+	return hook.URL
+}
+
+// sign the body with the secret
+func webhookSignature(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Notify posts the event to the webhook, retrying with exponential backoff
+func (hook *Webhook) Notify(e Event) error {
+	if hook.Event != "*" && hook.Event != e.Type {
+		return nil
+	}
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	attempts := hook.MaxAttempts
+	if attempts <= 0 {
+		attempts = 5
+	}
+	delivery := randomHex(16)
+	backoff := webhookBackoff
+	for attempt := 1; ; attempt++ {
+		err = hook.deliver(delivery, e.Type, body)
+		if err == nil {
+			return nil
+		}
+		if attempt == attempts {
+			deadLetter(DeadLetter{Delivery: delivery, URL: hook.URL, Time: time.Now(), Attempts: attempt, Error: err.Error(), Payload: body})
+			return fmt.Errorf("delivery %s failed after %d attempts: %v", delivery, attempt, err)
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+func (hook *Webhook) deliver(delivery, eventType string, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "netnet/"+version)
+	req.Header.Set("X-Netnet-Event", eventType)
+	req.Header.Set("X-Netnet-Delivery", delivery)
+	req.Header.Set("X-Netnet-Timestamp", timestamp)
+	if hook.Secret != "" {
+		req.Header.Set("X-Netnet-Signature", webhookSignature(hook.Secret, timestamp, body))
+	}
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%s returned %s", hook.URL, resp.Status)
+	}
+	return nil
+}
+
+// keep a delivery that failed so it can be looked at and sent again by hand
+func deadLetter(d DeadLetter) {
+	deadLetterMutex.Lock()
+	defer deadLetterMutex.Unlock()
+	err := os.MkdirAll(*dataDir, 0700)
+	if err != nil {
+		check(err, "Cannot write dead letter:")
+		return
+	}
+	file, err := os.OpenFile(filepath.Join(*dataDir, deadLetterFile), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		check(err, "Cannot write dead letter:")
+		return
+	}
+	defer file.Close()
+	check(json.NewEncoder(file).Encode(d), "Cannot write dead letter:")
+}
+
+// GET /admin/dead-letters lists the webhook deliveries that failed, DELETE clears them
+func adminDeadLetters(w http.ResponseWriter, r *http.Request) {
+	deadLetterMutex.Lock()
+	defer deadLetterMutex.Unlock()
+	file := filepath.Join(*dataDir, deadLetterFile)
+	switch r.Method {
+	case http.MethodGet:
+		data, err := ioutil.ReadFile(file)
+		if err != nil && !os.IsNotExist(err) {
+			http.Error(w, "Cannot read dead letters: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		letters := []DeadLetter{}
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		for decoder.More() {
+			var d DeadLetter
+			if decoder.Decode(&d) != nil {
+				break
+			}
+			letters = append(letters, d)
+		}
+		writeJSON(w, letters)
+	case http.MethodDelete:
+		err := os.Remove(file)
+		if err != nil && !os.IsNotExist(err) {
+			http.Error(w, "Cannot clear dead letters: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
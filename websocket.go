@@ -0,0 +1,298 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// https://datatracker.ietf.org/doc/html/rfc6455, only what pushing messages to browsers needs
+const wsGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+const (
+	wsText  = 0x1
+	wsClose = 0x8
+	wsPing  = 0x9
+	wsPong  = 0xA
+)
+
+// messages waiting to be sent to a connection, a connection that falls this far behind is dropped
+const wsSendBuffer = 64
+
+// a connection that takes longer than this to take a message is dropped
+const wsWriteTimeout = 10 * time.Second
+
+// pings keep the connection open through proxies and find the browsers that went away
+const wsPingInterval = 30 * time.Second
+
+// browsers only send control frames, anything bigger is not a browser talking to netnet
+const wsMaxFrame = 4096
+
+// a connection of a hub, the frames are written by its own goroutine from the send buffer
+type wsConn struct {
+	conn   net.Conn
+	rw     *bufio.ReadWriter
+	send   chan []byte
+	closed chan struct{}
+	once   sync.Once
+}
+
+// wsHub sends messages to every connection without waiting for any of them, the ones that can't keep up
+// are disconnected so a stalled browser tab doesn't hold up the others
+type wsHub struct {
+	name    string
+	conns   map[*wsConn]bool
+	mutex   sync.Mutex
+	dropped atomic.Int64 // connections disconnected for falling behind
+}
+
+var eventsHub = newHub("events")
+
+var hubs []*wsHub
+
+func newHub(name string) *wsHub {
+	h := &wsHub{name: name, conns: make(map[*wsConn]bool)}
+	hubs = append(hubs, h)
+	return h
+}
+
+// send a message to every connection as JSON
+func (h *wsHub) broadcastJSON(v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		fmt.Println("Cannot encode message for", h.name, "hub:", err)
+		return
+	}
+	frame := wsFrame(wsText, data)
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	for c := range h.conns {
+		select {
+		case c.send <- frame:
+		default:
+			h.dropped.Add(1)
+			delete(h.conns, c)
+			c.close()
+		}
+	}
+}
+
+func (h *wsHub) count() int {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return len(h.conns)
+}
+
+func (h *wsHub) remove(c *wsConn) {
+	h.mutex.Lock()
+	delete(h.conns, c)
+	h.mutex.Unlock()
+	c.close()
+}
+
+// upgrade the request to a WebSocket and send it the hub's messages until either side closes it
+func (h *wsHub) serve(w http.ResponseWriter, r *http.Request) {
+	conn, rw, err := wsUpgrade(w, r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	c := &wsConn{conn: conn, rw: rw, send: make(chan []byte, wsSendBuffer), closed: make(chan struct{})}
+	h.mutex.Lock()
+	h.conns[c] = true
+	h.mutex.Unlock()
+	go c.writeLoop()
+	c.readLoop()
+	h.remove(c)
+}
+
+func (c *wsConn) close() {
+	c.once.Do(func() {
+		close(c.closed)
+		c.conn.Close()
+	})
+}
+
+func (c *wsConn) writeLoop() {
+	ticker := time.NewTicker(wsPingInterval)
+	defer ticker.Stop()
+	defer c.close()
+	for {
+		var frame []byte
+		select {
+		case frame = <-c.send:
+		case <-ticker.C:
+			frame = wsFrame(wsPing, nil)
+		case <-c.closed:
+			return
+		}
+		c.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+		if _, err := c.rw.Write(frame); err != nil {
+			return
+		}
+		if err := c.rw.Flush(); err != nil {
+			return
+		}
+		if frame[0]&0x0F == wsClose {
+			return
+		}
+	}
+}
+
+// read the frames from the browser, answering pings and closes, until the connection ends
+func (c *wsConn) readLoop() {
+	for {
+		opcode, payload, err := wsReadFrame(c.rw.Reader)
+		if err != nil {
+			return
+		}
+		var reply []byte
+		switch opcode {
+		case wsClose:
+			reply = wsFrame(wsClose, payload)
+		case wsPing:
+			reply = wsFrame(wsPong, payload)
+		default:
+			continue
+		}
+		select {
+		case c.send <- reply:
+		default:
+			return
+		}
+		if opcode == wsClose {
+			// give the writer a moment to send the close back
+			select {
+			case <-c.closed:
+			case <-time.After(time.Second):
+			}
+			return
+		}
+	}
+}
+
+// answer the WebSocket handshake and take over the connection
+func wsUpgrade(w http.ResponseWriter, r *http.Request) (net.Conn, *bufio.ReadWriter, error) {
+	if r.Method != http.MethodGet || !headerHasToken(r.Header, "Connection", "upgrade") || !headerHasToken(r.Header, "Upgrade", "websocket") {
+		return nil, nil, errors.New("not a WebSocket handshake")
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		return nil, nil, errors.New("unsupported WebSocket version")
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if key == "" {
+		return nil, nil, errors.New("missing Sec-WebSocket-Key")
+	}
+	// browsers send cookies with WebSockets from any site, so only pages of netnet itself can connect
+	if origin := r.Header.Get("Origin"); origin != "" {
+		u, err := url.Parse(origin)
+		if err != nil || !strings.EqualFold(u.Host, r.Host) {
+			return nil, nil, errors.New("WebSocket from another origin")
+		}
+	}
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("connection can't be upgraded")
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+	// the server's read and write timeouts are for requests, not for connections that stay open
+	conn.SetDeadline(time.Time{})
+	sum := sha1.Sum([]byte(key + wsGUID))
+	rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(sum[:]) + "\r\n\r\n")
+	if err = rw.Flush(); err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	return conn, rw, nil
+}
+
+// check if a comma separated header has a token, ie Connection: keep-alive, Upgrade
+func headerHasToken(header http.Header, name, token string) bool {
+	for _, value := range header.Values(name) {
+		for _, t := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// a whole unmasked frame, servers don't mask
+func wsFrame(opcode byte, payload []byte) []byte {
+	frame := []byte{0x80 | opcode}
+	switch n := len(payload); {
+	case n < 126:
+		frame = append(frame, byte(n))
+	case n <= 0xFFFF:
+		frame = append(frame, 126, byte(n>>8), byte(n))
+	default:
+		frame = append(frame, 127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(n))
+	}
+	return append(frame, payload...)
+}
+
+// read a frame from the browser, which must be masked
+func wsReadFrame(r *bufio.Reader) (opcode byte, payload []byte, err error) {
+	var header [2]byte
+	if _, err = io.ReadFull(r, header[:]); err != nil {
+		return
+	}
+	opcode = header[0] & 0x0F
+	if header[1]&0x80 == 0 {
+		return 0, nil, errors.New("unmasked frame from client")
+	}
+	n := uint64(header[1] & 0x7F)
+	switch n {
+	case 126:
+		var ext [2]byte
+		if _, err = io.ReadFull(r, ext[:]); err != nil {
+			return
+		}
+		n = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err = io.ReadFull(r, ext[:]); err != nil {
+			return
+		}
+		n = binary.BigEndian.Uint64(ext[:])
+	}
+	if n > wsMaxFrame {
+		return 0, nil, errors.New("frame too big")
+	}
+	var mask [4]byte
+	if _, err = io.ReadFull(r, mask[:]); err != nil {
+		return
+	}
+	payload = make([]byte, n)
+	if _, err = io.ReadFull(r, payload); err != nil {
+		return
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return
+}
+
+// the events as they happen at /events/ws
+func eventsWebSocket(w http.ResponseWriter, r *http.Request) {
+	eventsHub.serve(w, r)
+}
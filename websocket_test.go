@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"testing"
+)
+
+// the masked "Hello" of RFC 6455 section 5.7
+var wsHello = []byte{0x81, 0x85, 0x37, 0xfa, 0x21, 0x3d, 0x7f, 0x9f, 0x4d, 0x51, 0x58}
+
+func readFrame(data []byte) (byte, []byte, error) {
+	return wsReadFrame(bufio.NewReader(bytes.NewReader(data)))
+}
+
+func TestWebSocketFrame(t *testing.T) {
+	opcode, payload, err := readFrame(wsHello)
+	if err != nil || opcode != 1 || string(payload) != "Hello" {
+		t.Errorf("hello read as %d %q %v", opcode, payload, err)
+	}
+	// 256 bytes with a 16 bit length, masked with zeros
+	long := append([]byte{0x82, 0xfe, 0x01, 0x00, 0, 0, 0, 0}, bytes.Repeat([]byte{7}, 256)...)
+	if opcode, payload, err = readFrame(long); err != nil || opcode != 2 || !bytes.Equal(payload, long[8:]) {
+		t.Errorf("256 bytes read as %d %d %v", opcode, len(payload), err)
+	}
+	// a close frame with no payload and a 64 bit length
+	if opcode, payload, err = readFrame([]byte{0x88, 0xff, 0, 0, 0, 0, 0, 0, 0, 0, 1, 2, 3, 4}); err != nil ||
+		opcode != 8 || len(payload) != 0 {
+		t.Errorf("close read as %d %q %v", opcode, payload, err)
+	}
+	// the unmasked "Hello" of the server
+	frame := wsFrame(1, []byte("Hello"))
+	if !bytes.Equal(frame, []byte{0x81, 0x05, 'H', 'e', 'l', 'l', 'o'}) {
+		t.Errorf("hello written as %x", frame)
+	}
+	if frame = wsFrame(1, make([]byte, 70000)); !bytes.Equal(frame[:10], []byte{0x81, 127, 0, 0, 0, 0, 0, 1, 0x11, 0x70}) {
+		t.Errorf("70000 bytes written with header %x", frame[:10])
+	}
+}
+
+// frames cut short, unmasked or too big have to give an error, never a panic or a huge allocation
+func TestWebSocketMalformed(t *testing.T) {
+	for size := 0; size < len(wsHello); size++ {
+		if _, _, err := readFrame(wsHello[:size]); err == nil {
+			t.Errorf("cut to %d bytes, no error", size)
+		}
+	}
+	for _, data := range [][]byte{
+		{0x81, 0x05, 'H', 'e', 'l', 'l', 'o'},                        // unmasked
+		{0x81, 0xfe, 0x10, 0x01, 0, 0, 0, 0},                         // 4097 bytes
+		{0x81, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, // 2^64-1 bytes
+		{0x81, 0xff, 0x80, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},          // 2^63 bytes, negative as an int64
+		{0x81, 0xfe, 0x00},       // length cut short
+		{0x81, 0xff, 0, 0, 0, 0}, // 64 bit length cut short
+	} {
+		if opcode, payload, err := readFrame(data); err == nil {
+			t.Errorf("%x read as %d %q", data, opcode, payload)
+		}
+	}
+}
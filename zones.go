@@ -0,0 +1,187 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// largest floorplan image that can be uploaded
+const maxFloorplanSize = 10 << 20
+
+// Zone is a named area of the floorplan, in meters from the top left corner
+type Zone struct {
+	Name    string   `json:"name"`
+	X       float64  `json:"x"`
+	Y       float64  `json:"y"`
+	Width   float64  `json:"width"`
+	Height  float64  `json:"height"`
+	Sensors []string `json:"sensors,omitempty"` // capture points covering the zone, all of them if empty
+}
+
+// ZonePresence is a zone with the number of devices estimated to be in it
+type ZonePresence struct {
+	Zone
+	Devices float64 `json:"devices"`
+}
+
+var zones = make(map[string]Zone)
+var zonesMutex sync.RWMutex
+
+func loadZones() {
+	zonesMutex.Lock()
+	defer zonesMutex.Unlock()
+	zones = make(map[string]Zone)
+	check(loadJSON("zones.json", &zones), "Cannot load zones:")
+}
+
+// check if a point is in the zone
+func (z Zone) contains(x, y float64) bool {
+	return x >= z.X && x < z.X+z.Width && y >= z.Y && y < z.Y+z.Height
+}
+
+// add up the heatmap cells whose centers are in the zone, using only the sensors of the zone
+func zonePresence(z Zone, clients []Client, settings HeatmapConfig) ZonePresence {
+	if len(z.Sensors) > 0 {
+		var points []CapturePoint
+		for _, p := range settings.CapturePoints {
+			if containsString(z.Sensors, p.Name) {
+				points = append(points, p)
+			}
+		}
+		settings.CapturePoints = points
+	}
+	p := ZonePresence{Zone: z}
+	h := buildHeatmap(clients, settings)
+	for row := range h.Occupancy {
+		for col, occupancy := range h.Occupancy[row] {
+			if z.contains((float64(col)+0.5)*h.Cell, (float64(row)+0.5)*h.Cell) {
+				p.Devices += occupancy
+			}
+		}
+	}
+	return p
+}
+
+// GET /zones lists the zones with their presence, PUT and DELETE /zones/{name} change them
+func zoneRoutes(w http.ResponseWriter, r *http.Request) {
+	name := strings.Trim(strings.TrimPrefix(r.URL.Path, "/zones"), "/")
+	if name == "" {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		listZones(w, r)
+		return
+	}
+	zonesMutex.Lock()
+	defer zonesMutex.Unlock()
+	switch r.Method {
+	case http.MethodGet:
+		z, ok := zones[name]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		writeJSON(w, zonePresence(z, filterByLastSeen(store.Clients(), occupancySettings().Window), heatmapSettings()))
+		return
+	case http.MethodPut, http.MethodPost:
+		var z Zone
+		err := json.NewDecoder(r.Body).Decode(&z)
+		if err != nil {
+			http.Error(w, "Cannot parse zone: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if z.Width <= 0 || z.Height <= 0 {
+			http.Error(w, "Zone needs a width and height", http.StatusBadRequest)
+			return
+		}
+		z.Name = name
+		zones[name] = z
+	case http.MethodDelete:
+		delete(zones, name)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	err := saveJSON("zones.json", zones)
+	if err != nil {
+		http.Error(w, "Cannot save zones: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func listZones(w http.ResponseWriter, r *http.Request) {
+	clients := filterByLastSeen(store.Clients(), occupancySettings().Window)
+	settings := heatmapSettings()
+	zonesMutex.RLock()
+	defer zonesMutex.RUnlock()
+	list := []ZonePresence{}
+	for _, z := range zones {
+		list = append(list, zonePresence(z, clients, settings))
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	writeJSON(w, list)
+}
+
+// GET /floorplan is the uploaded floorplan image, PUT /floorplan replaces it
+func floorplan(w http.ResponseWriter, r *http.Request) {
+	file := filepath.Join(*dataDir, "floorplan")
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		f, err := os.Open(file)
+		if os.IsNotExist(err) {
+			http.Error(w, "No floorplan uploaded", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Cannot open floorplan: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer f.Close()
+		http.ServeContent(w, r, "floorplan", time.Time{}, f)
+	case http.MethodPut, http.MethodPost:
+		data, err := ioutil.ReadAll(io.LimitReader(r.Body, maxFloorplanSize+1))
+		if err != nil {
+			http.Error(w, "Cannot read floorplan: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if len(data) > maxFloorplanSize {
+			http.Error(w, "Floorplan is too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		if !strings.HasPrefix(http.DetectContentType(data), "image/") {
+			http.Error(w, "Floorplan must be an image", http.StatusUnsupportedMediaType)
+			return
+		}
+		err = os.MkdirAll(*dataDir, 0700)
+		if err == nil {
+			err = ioutil.WriteFile(file+".tmp", data, 0600)
+		}
+		if err == nil {
+			err = os.Rename(file+".tmp", file)
+		}
+		if err != nil {
+			http.Error(w, "Cannot save floorplan: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodDelete:
+		err := os.Remove(file)
+		if err != nil && !os.IsNotExist(err) {
+			http.Error(w, "Cannot remove floorplan: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}